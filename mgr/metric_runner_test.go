@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/metric"
 	"github.com/qiniu/logkit/metric/curl"
 	"github.com/qiniu/logkit/metric/system"
 	. "github.com/qiniu/logkit/utils/models"
@@ -41,6 +42,17 @@ func getMetricRunnerConfig(name string, mc []MetricConfig, senderPath string) ([
 	return jsoniter.Marshal(runnerConf)
 }
 
+// appendBatchLine 文件中每行一条数据，同一次采集发送的数据 timestamp 相同，按 timestamp 统计每次发送的行数
+func appendBatchLine(batches []int, last *interface{}, result map[string]interface{}) []int {
+	ts := result[metric.Timestamp]
+	if len(batches) == 0 || ts != *last {
+		batches = append(batches, 0)
+		*last = ts
+	}
+	batches[len(batches)-1]++
+	return batches
+}
+
 func TestMetricRunner(t *testing.T) {
 	pwd, err := os.Getwd()
 	if err != nil {
@@ -147,24 +159,31 @@ func metricRunTest(p *testParam) {
 	f, err := os.Open(resvPath1)
 	assert.NoError(t, err)
 	br := bufio.NewReaderSize(f, bufSize)
+	var batches []int
+	var lastTs interface{}
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
 			f.Close()
 			break
 		}
-		result := make([]map[string]interface{}, 0)
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal([]byte(str), &result)
 		if err != nil {
 			log.Fatalf("metricRunTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
 		curLine++
-		if curLine == 1 {
-			assert.Equal(t, len(cpuAttr)/2+1, len(result[0]))
-		} else {
-			assert.Equal(t, len(cpuAttr)/2+1, len(result[0]))
-			assert.Equal(t, 2, len(result))
+		assert.Equal(t, len(cpuAttr)/2+1, len(result))
+		batches = appendBatchLine(batches, &lastTs, result)
+	}
+	// 第一次采集只有一条数据，之后每次采集两条数据
+	assert.NotEmpty(t, batches)
+	if len(batches) > 0 {
+		assert.Equal(t, 1, batches[0])
+		for _, lines := range batches[1:] {
+			assert.Equal(t, 2, lines)
 		}
+		assert.Equal(t, int64(2*len(batches)-1), curLine)
 	}
 
 	// 更新 metrc, 同时更新配置
@@ -204,21 +223,28 @@ func metricRunTest(p *testParam) {
 	f, err = os.Open(resvPath2)
 	assert.NoError(t, err)
 	br = bufio.NewReaderSize(f, bufSize)
+	batches, lastTs = nil, nil
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
 			f.Close()
 			break
 		}
-		result := make([]map[string]interface{}, 0)
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal([]byte(str), &result)
 		if err != nil {
 			log.Fatalf("metricRunTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
-		assert.Equal(t, 1, len(result))
-		assert.Equal(t, len(cpuAttr)/2, len(result[0]))
+		assert.Equal(t, len(cpuAttr)/2, len(result))
+		batches = appendBatchLine(batches, &lastTs, result)
 		curLine++
 	}
+	// 不采集 cpu time 时每次采集只有一条数据
+	assert.NotEmpty(t, batches)
+	for _, lines := range batches {
+		assert.Equal(t, 1, lines)
+	}
+	assert.Equal(t, int64(len(batches)), curLine)
 }
 
 func metricNetTest(p *testParam) {
@@ -267,7 +293,9 @@ func metricNetTest(p *testParam) {
 	f, err := os.Open(resvPath)
 	assert.NoError(t, err)
 	br := bufio.NewReaderSize(f, bufSize)
-	result := make([]map[string]interface{}, 0)
+	var batches []int
+	var lastTs interface{}
+	var fields int
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
@@ -275,12 +303,25 @@ func metricNetTest(p *testParam) {
 			break
 		}
 		curLine++
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal([]byte(str), &result)
 		if err != nil {
 			log.Fatalf("metricNetTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
-		assert.Equal(t, 1, len(result))
+		// 没有匹配的网卡，只有汇总的协议统计数据
+		assert.Equal(t, "all", result["net__interface"], string(str))
+		if fields == 0 {
+			fields = len(result)
+		}
+		assert.Equal(t, fields, len(result), string(str))
+		batches = appendBatchLine(batches, &lastTs, result)
 	}
+	// 每次采集只有一条数据
+	assert.NotEmpty(t, batches)
+	for _, lines := range batches {
+		assert.Equal(t, 1, lines)
+	}
+	assert.Equal(t, int64(len(batches)), curLine)
 }
 
 func metricDiskioTest(p *testParam) {
@@ -332,7 +373,6 @@ func metricDiskioTest(p *testParam) {
 	f, err := os.Open(resvPath1)
 	assert.NoError(t, err)
 	br := bufio.NewReaderSize(f, bufSize)
-	result := make([]map[string]interface{}, 0)
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
@@ -340,11 +380,12 @@ func metricDiskioTest(p *testParam) {
 			break
 		}
 		curLine++
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal([]byte(str), &result)
 		if err != nil {
 			log.Fatalf("metricDiskioTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
-		assert.Equal(t, len(diskIoAttr)+2, len(result[0]), string(str))
+		assert.Equal(t, len(diskIoAttr)+2, len(result), string(str))
 	}
 	mc = []MetricConfig{
 		{
@@ -380,7 +421,6 @@ func metricDiskioTest(p *testParam) {
 	f, err = os.Open(resvPath2)
 	assert.NoError(t, err)
 	br = bufio.NewReaderSize(f, bufSize)
-	result = make([]map[string]interface{}, 0)
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
@@ -388,11 +428,12 @@ func metricDiskioTest(p *testParam) {
 			break
 		}
 		curLine++
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal([]byte(str), &result)
 		if err != nil {
 			log.Fatalf("metricDiskioTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
-		assert.Equal(t, len(diskIoAttr), len(result[0]), string(str))
+		assert.Equal(t, len(diskIoAttr), len(result), string(str))
 	}
 }
 
@@ -474,17 +515,15 @@ func metricRunEnvTagTest(p *testParam) {
 		if c == io.EOF {
 			break
 		}
-		result := make([]map[string]interface{}, 0)
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal([]byte(str), &result)
 		if err != nil {
 			log.Fatalf("metricRunEnvTagTest error unmarshal %v %v", string(str), err)
 		}
-		for _, d := range result {
-			if v, ok := d[runnerName]; !ok {
-				t.Fatalf("metricRunEnvTagTest error, exp got Test_RunForEnvTag:env_value, but not found")
-			} else {
-				assert.Equal(t, "env_value", v)
-			}
+		if v, ok := result[runnerName]; !ok {
+			t.Fatalf("metricRunEnvTagTest error, exp got Test_RunForEnvTag:env_value, but not found")
+		} else {
+			assert.Equal(t, "env_value", v)
 		}
 	}
 }
@@ -557,7 +596,6 @@ func metricHttpTest(p *testParam) {
 	f, err := os.Open(resvPath1)
 	assert.NoError(t, err)
 	br := bufio.NewReaderSize(f, bufSize)
-	result := make([]map[string]interface{}, 0)
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
@@ -565,17 +603,18 @@ func metricHttpTest(p *testParam) {
 			break
 		}
 		curLine++
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal(str, &result)
 		if err != nil {
 			t.Fatalf("metricHttpTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
 
-		assert.Equal(t, len(httpAttr)+2, len(result[0]), string(str))
-		assert.Equal(t, float64(200), result[0]["http__status_code_1"])
-		assert.Equal(t, "https://www.qiniu.com", result[0]["http__target_1"])
-		assert.Equal(t, float64(1), result[0]["http__err_state_total"])
-		assert.Equal(t, "", result[0]["http__err_msg_total"])
-		assert.Equal(t, float64(1), result[0]["http__err_state_total"])
+		assert.Equal(t, len(httpAttr)+2, len(result), string(str))
+		assert.Equal(t, float64(200), result["http__status_code_1"])
+		assert.Equal(t, "https://www.qiniu.com", result["http__target_1"])
+		assert.Equal(t, float64(1), result["http__err_state_total"])
+		assert.Equal(t, "", result["http__err_msg_total"])
+		assert.Equal(t, float64(1), result["http__err_state_total"])
 	}
 
 	mc2 := []MetricConfig{
@@ -628,7 +667,6 @@ func metricHttpTest(p *testParam) {
 	f, err = os.Open(resvPath2)
 	assert.NoError(t, err)
 	br = bufio.NewReaderSize(f, bufSize)
-	result = make([]map[string]interface{}, 0)
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
@@ -636,18 +674,19 @@ func metricHttpTest(p *testParam) {
 			break
 		}
 		curLine++
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal(str, &result)
 		if err != nil {
 			t.Fatalf("metricHttpTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
 
-		assert.Equal(t, float64(200), result[0]["http__status_code_1"])
-		assert.Equal(t, "https://www.qiniu.com", result[0]["http__target_1"])
-		assert.Equal(t, float64(1), result[0]["http__err_state_1"])
-		assert.Equal(t, float64(-1), result[0]["http__status_code_2"])
-		assert.Equal(t, "https://www.logkit-pandora.com", result[0]["http__target_2"])
-		assert.Equal(t, float64(0), result[0]["http__err_state_2"])
-		assert.Equal(t, float64(0), result[0]["http__err_state_total"])
+		assert.Equal(t, float64(200), result["http__status_code_1"])
+		assert.Equal(t, "https://www.qiniu.com", result["http__target_1"])
+		assert.Equal(t, float64(1), result["http__err_state_1"])
+		assert.Equal(t, float64(-1), result["http__status_code_2"])
+		assert.Equal(t, "https://www.logkit-pandora.com", result["http__target_2"])
+		assert.Equal(t, float64(0), result["http__err_state_2"])
+		assert.Equal(t, float64(0), result["http__err_state_total"])
 	}
 
 	mc3 := []MetricConfig{
@@ -701,7 +740,6 @@ func metricHttpTest(p *testParam) {
 	f, err = os.Open(resvPath3)
 	assert.NoError(t, err)
 	br = bufio.NewReaderSize(f, bufSize)
-	result = make([]map[string]interface{}, 0)
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
@@ -709,16 +747,17 @@ func metricHttpTest(p *testParam) {
 			break
 		}
 		curLine++
+		result := make(map[string]interface{})
 		err = jsoniter.Unmarshal(str, &result)
 		if err != nil {
 			t.Fatalf("metricHttpTest error unmarshal %v curLine = %v %v", string(str), curLine, err)
 		}
 
-		assert.Equal(t, float64(200), result[0]["http__status_code_1"])
-		assert.Equal(t, "https://www.qiniu.com", result[0]["http__target_1"])
-		assert.Equal(t, float64(0), result[0]["http__err_state_1"])
-		assert.Equal(t, float64(0), result[0]["http__err_state_total"])
-		assert.Equal(t, "don't contain: 潘多拉", result[0]["http__err_msg_total"])
+		assert.Equal(t, float64(200), result["http__status_code_1"])
+		assert.Equal(t, "https://www.qiniu.com", result["http__target_1"])
+		assert.Equal(t, float64(0), result["http__err_state_1"])
+		assert.Equal(t, float64(0), result["http__err_state_total"])
+		assert.Equal(t, "don't contain: 潘多拉", result["http__err_msg_total"])
 	}
 }
 
@@ -803,17 +803,17 @@ func runnerDataIntegrityTest(p *testParam) {
 	assert.NoError(t, err)
 	defer f.Close()
 	br := bufio.NewReader(f)
-	result := make([]interface{}, 0)
 	for {
 		str, _, c := br.ReadLine()
 		if c == io.EOF {
 			break
 		}
+		var result map[string]interface{}
 		err = jsoniter.Unmarshal([]byte(str), &result)
 		if err != nil {
 			log.Fatalf("Test_Run error unmarshal result curLine = %v %v", curLine, err)
 		}
-		curLine += int64(len(result))
+		curLine++
 	}
 	assert.Equal(t, dataLine*writeCnt, curLine)
 	assert.Equal(t, dataLine*writeCnt, rss[runnerName].ReadDataCount)
@@ -1018,7 +1018,7 @@ func senderRouterTest(p *testParam) {
 	assert.NoError(t, err)
 	defer f1.Close()
 	br := bufio.NewReader(f1)
-	result := make([]map[string]interface{}, 0)
+	var result map[string]interface{}
 	dataCnt := 0
 	for {
 		str, _, c := br.ReadLine()
@@ -1029,7 +1029,7 @@ func senderRouterTest(p *testParam) {
 		if err != nil {
 			log.Fatalf("TestSenderRouter error unmarshal result curLine = %v %v", dataCnt, err)
 		}
-		dataCnt += len(result)
+		dataCnt++
 	}
 	assert.Equal(t, 6, dataCnt)
 
@@ -1037,7 +1037,6 @@ func senderRouterTest(p *testParam) {
 	assert.NoError(t, err)
 	defer f2.Close()
 	br = bufio.NewReader(f2)
-	result = make([]map[string]interface{}, 0)
 	dataCnt = 0
 	for {
 		str, _, c := br.ReadLine()
@@ -1048,7 +1047,7 @@ func senderRouterTest(p *testParam) {
 		if err != nil {
 			log.Fatalf("TestSenderRouter error unmarshal result curLine = %v %v", dataCnt, err)
 		}
-		dataCnt += len(result)
+		dataCnt++
 	}
 	assert.Equal(t, 2, dataCnt)
 
@@ -1056,7 +1055,6 @@ func senderRouterTest(p *testParam) {
 	assert.NoError(t, err)
 	defer f3.Close()
 	br = bufio.NewReader(f3)
-	result = make([]map[string]interface{}, 0)
 	dataCnt = 0
 	for {
 		str, _, c := br.ReadLine()
@@ -1067,7 +1065,7 @@ func senderRouterTest(p *testParam) {
 		if err != nil {
 			log.Fatalf("TestSenderRouter error unmarshal result curLine = %v %v", dataCnt, err)
 		}
-		dataCnt += len(result)
+		dataCnt++
 	}
 	assert.Equal(t, 4, dataCnt)
 }
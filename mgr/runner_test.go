@@ -693,7 +693,7 @@ func Test_QiniulogRun(t *testing.T) {
 	}
 	ls, err := r.LagStats()
 	assert.NoError(t, err)
-	assert.Equal(t, &LagInfo{0, "bytes", 0}, ls)
+	assert.Equal(t, &LagInfo{Size: 0, SizeUnit: "bytes", Ftlags: 0}, ls)
 }
 
 func TestCreateTransforms(t *testing.T) {
@@ -1251,21 +1251,19 @@ func TestAddDatatags(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 	data, err := ioutil.ReadFile("./TestAddDatatags/filesend.json")
-	var res []Data
+	var res Data
 	err = jsoniter.Unmarshal(data, &res)
 	if err != nil {
 		t.Error(err)
 	}
-	exp := []Data{
-		{
-			"f1":     "2",
-			"f2":     "1",
-			"f3":     "3",
-			"Title":  "tags",
-			"Author": []interface{}{"john", "ada", "alice"},
-			"IsTrue": bool(true),
-			"Host":   float64(99),
-		},
+	exp := Data{
+		"f1":     "2",
+		"f2":     "1",
+		"f3":     "3",
+		"Title":  "tags",
+		"Author": []interface{}{"john", "ada", "alice"},
+		"IsTrue": bool(true),
+		"Host":   float64(99),
 	}
 	assert.Equal(t, exp, res)
 }
@@ -1312,12 +1310,12 @@ func TestRunWithExtra(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 	data, err := ioutil.ReadFile("./TestRunWithExtra/filesend.json")
-	var res []Data
-	err = jsoniter.Unmarshal(data, &res)
+	var res Data
+	err = jsoniter.Unmarshal([]byte(strings.SplitN(string(data), "\n", 2)[0]), &res)
 	if err != nil {
 		t.Error(err)
 	}
-	assert.Equal(t, 7, len(res[0]))
+	assert.Equal(t, 7, len(res))
 }
 
 func TestClassifySenderData(t *testing.T) {
@@ -239,7 +239,7 @@ func Test_BuffReaderMultiLine(t *testing.T) {
 	rest := make(map[string]int)
 	num := 0
 	for {
-		line, err := r.ReadLine()
+		line, _ := r.ReadLine()
 		rest[line]++
 		num++
 
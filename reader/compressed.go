@@ -0,0 +1,174 @@
+package reader
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/qiniu/logkit/rateio"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// KeyReadCompressed 开启后，dir/tailx/file 模式会把 logrotate 压缩过的 .gz/.bz2/.zst 文件解压后读取
+const KeyReadCompressed = "read_compressed"
+
+var compressedFileSuffixes = []string{".gz", ".bz2", ".zst"}
+
+// compressedFileEnd 作为压缩文件的 offset 时表示整个文件已经读完。压缩文件不会再追加内容，
+// read_from 为 newest 时直接跳过，不需要为了计算解压后的大小解压整个文件
+const compressedFileEnd int64 = -1
+
+const (
+	// fileHeadSize 是用于识别同一份日志内容的文件开头的字节数
+	fileHeadSize = 1024
+	// maxReadFileHeads 是最多记录的已读取文件数，logrotate 一般只会保留少量压缩文件
+	maxReadFileHeads = 64
+)
+
+// compressedSuffix 返回文件的压缩后缀，归档文件(.tar.gz)以及非压缩文件返回空
+func compressedSuffix(path string) string {
+	if strings.HasSuffix(path, ".tar.gz") {
+		return ""
+	}
+	for _, suffix := range compressedFileSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// newDecompressReader 从文件开头创建解压的数据流
+func newDecompressReader(f *os.File, suffix string) (io.ReadCloser, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	switch suffix {
+	case ".gz":
+		return gzip.NewReader(f)
+	case ".bz2":
+		return ioutil.NopCloser(bzip2.NewReader(f)), nil
+	case ".zst":
+		dec, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{dec}, nil
+	}
+	return nil, fmt.Errorf("unsupported compressed file suffix %v", suffix)
+}
+
+// openDecompressed 打开压缩文件并跳过已经读取的 offset，offset 为解压后的字节数
+func openDecompressed(f *os.File, suffix string, offset int64) (io.ReadCloser, error) {
+	rc, err := newDecompressReader(f, suffix)
+	if err != nil {
+		return nil, err
+	}
+	if offset <= 0 {
+		return rc, nil
+	}
+	n, err := io.CopyN(ioutil.Discard, rc, offset)
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, fmt.Errorf("skip %v decompressed bytes of %v error %v", offset, f.Name(), err)
+	}
+	if n < offset {
+		rc.Close()
+		return nil, fmt.Errorf("decompressed size %v of %v is less than offset %v", n, f.Name(), offset)
+	}
+	return rc, nil
+}
+
+// decompressReadCloser 关闭时同时关闭解压流与限速流
+type decompressReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decompressReadCloser) Close() (err error) {
+	for _, c := range d.closers {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// newFileRateReader 构建文件的限速读取流，开启 read_compressed 时压缩文件解压后从 offset 开始读取，其余文件直接 seek 到 offset
+func newFileRateReader(meta *Meta, f *os.File, offset int64) (io.ReadCloser, error) {
+	if suffix := compressedSuffix(f.Name()); meta.readCompressed && suffix != "" {
+		if offset == compressedFileEnd {
+			return ioutil.NopCloser(bytes.NewReader(nil)), nil
+		}
+		rc, err := openDecompressed(f, suffix, offset)
+		if err != nil {
+			return nil, err
+		}
+		rr := rateio.NewRateReader(rc, meta.readlimit)
+		return &decompressReadCloser{Reader: rr, closers: []io.Closer{rr, rc}}, nil
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return rateio.NewRateReader(f, meta.readlimit), nil
+}
+
+// readPosition 返回计算 Lag 使用的已读取字节数。压缩文件的 offset 是解压后的字节数，无法与文件大小比较，
+// 这里换算为已经读取的压缩数据的字节数，解压流会预读，因此略大于实际解压的位置
+func readPosition(meta *Meta, f *os.File, offset int64) int64 {
+	if f == nil || !meta.readCompressed || compressedSuffix(f.Name()) == "" {
+		return offset
+	}
+	if offset == compressedFileEnd {
+		st, err := f.Stat()
+		if err != nil {
+			return 0
+		}
+		return st.Size()
+	}
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0
+	}
+	return pos
+}
+
+// readHead 读取数据流开头至多 fileHeadSize 个字节
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, fileHeadSize)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return head[:n], err
+}
+
+// compressedFileHead 返回压缩文件解压后开头的内容，只解压开头的部分数据
+func compressedFileHead(path, suffix string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rc, err := newDecompressReader(f, suffix)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readHead(rc)
+}
@@ -0,0 +1,545 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// docker reader 的配置项
+const (
+	KeyDockerHost             = "docker_host"
+	KeyDockerLabelFilters     = "docker_label_filters"
+	KeyDockerDiscoverInterval = "docker_discover_interval"
+)
+
+const (
+	DockerDefaultHost = "unix:///var/run/docker.sock"
+
+	dockerDefaultDiscoverInterval = 10 * time.Second
+	// docker logs 接口多路复用时每一帧的头部长度，依次为 stream 类型(1字节)、3字节填充以及大端的帧长度(4字节)
+	dockerFrameHeaderLen = 8
+)
+
+// dockerContainerLog 是一行容器日志对应的输出
+type dockerContainerLog struct {
+	Log           string            `json:"log"`
+	Stream        string            `json:"stream,omitempty"`
+	Time          string            `json:"time,omitempty"`
+	ContainerID   string            `json:"docker_container_id"`
+	ContainerName string            `json:"docker_container_name,omitempty"`
+	Image         string            `json:"docker_image,omitempty"`
+	Labels        map[string]string `json:"docker_labels,omitempty"`
+}
+
+// dockerContainer 是 /containers/json 接口返回的容器信息
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+	State  string            `json:"State"`
+}
+
+func (c *dockerContainer) name() string {
+	if len(c.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// dockerLine 是读取容器日志的协程与 ReadLine 之间传递的数据，since 为读取该行之后下次应从哪个时间点开始读取
+type dockerLine struct {
+	data        []byte
+	containerID string
+	source      string
+	since       string
+}
+
+// DockerReader 通过 Docker API 读取匹配 label 过滤条件的运行中容器的 stdout 和 stderr，
+// 定期发现新启动的容器，每个容器已经读取到的时间点记录在 meta 中
+type DockerReader struct {
+	meta             *Meta
+	host             string
+	baseURL          string
+	client           *http.Client
+	filters          []string
+	whence           string
+	discoverInterval time.Duration
+
+	readChan chan dockerLine
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	status  int32
+	mux     sync.Mutex
+	started bool
+
+	// tailing 记录正在读取的容器以及已经发送的位置，容器日志读取结束后从该位置继续，避免重复发送
+	tailLock  sync.Mutex
+	tailing   map[string]bool
+	positions map[string]string
+	// since 记录已经被 ReadLine 取走的位置，用于写入 meta
+	sinceLock sync.Mutex
+	since     map[string]string
+	source    string
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewDockerReader(meta *Meta, c conf.MapConf) (Reader, error) {
+	host, _ := c.GetStringOr(KeyDockerHost, DockerDefaultHost)
+	filters, _ := c.GetStringListOr(KeyDockerLabelFilters, []string{})
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	intervalStr, _ := c.GetStringOr(KeyDockerDiscoverInterval, "")
+	interval := dockerDefaultDiscoverInterval
+	if intervalStr != "" {
+		var err error
+		if interval, err = time.ParseDuration(intervalStr); err != nil {
+			return nil, fmt.Errorf("parse %v %v error %v", KeyDockerDiscoverInterval, intervalStr, err)
+		}
+	}
+	client, baseURL, err := newDockerClient(host)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	dr := &DockerReader{
+		meta:             meta,
+		host:             host,
+		baseURL:          baseURL,
+		client:           client,
+		filters:          filters,
+		whence:           whence,
+		discoverInterval: interval,
+		readChan:         make(chan dockerLine),
+		ctx:              ctx,
+		cancel:           cancel,
+		status:           StatusInit,
+		tailing:          make(map[string]bool),
+		positions:        make(map[string]string),
+		since:            make(map[string]string),
+	}
+
+	_, _, bufsize, err := meta.ReadBufMeta()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, dr.Name(), err)
+		}
+		return dr, nil
+	}
+	buf := make([]byte, bufsize)
+	if _, err = meta.ReadBuf(buf); err != nil {
+		log.Warnf("Runner[%v] %v read buf error %v, ignore...", meta.RunnerName, dr.Name(), err)
+		return dr, nil
+	}
+	if err = jsoniter.Unmarshal(buf, &dr.since); err != nil {
+		log.Warnf("Runner[%v] %v Unmarshal read buf error %v, ignore...", meta.RunnerName, dr.Name(), err)
+		dr.since = make(map[string]string)
+	}
+	return dr, nil
+}
+
+// newDockerClient 根据 docker_host 创建 http client，支持 unix://、tcp://、http:// 以及 https:// 形式的地址
+func newDockerClient(host string) (*http.Client, string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("parse %v %v error %v", KeyDockerHost, host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://docker", nil
+	case "tcp":
+		return &http.Client{}, "http://" + u.Host, nil
+	case "http", "https":
+		return &http.Client{}, strings.TrimSuffix(host, "/"), nil
+	}
+	return nil, "", fmt.Errorf("unsupported %v %v", KeyDockerHost, host)
+}
+
+func (dr *DockerReader) Name() string {
+	return "DockerReader:" + dr.host
+}
+
+// Source 返回最近一条日志所在的容器
+func (dr *DockerReader) Source() string {
+	dr.sinceLock.Lock()
+	defer dr.sinceLock.Unlock()
+	if dr.source == "" {
+		return dr.host
+	}
+	return dr.source
+}
+
+func (dr *DockerReader) SetMode(mode string, v interface{}) error {
+	return errors.New("DockerReader not support read mode")
+}
+
+func (dr *DockerReader) Status() StatsInfo {
+	dr.statsLock.RLock()
+	defer dr.statsLock.RUnlock()
+	return dr.stats
+}
+
+func (dr *DockerReader) setStatsError(err string) {
+	dr.statsLock.Lock()
+	defer dr.statsLock.Unlock()
+	dr.stats.Errors++
+	dr.stats.LastError = err
+}
+
+//Start 仅调用一次，借用ReadLine启动，不能在new实例的时候启动，会有并发问题
+func (dr *DockerReader) Start() {
+	dr.mux.Lock()
+	defer dr.mux.Unlock()
+	if dr.started {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&dr.status, StatusInit, StatusRunning) {
+		return
+	}
+	dr.wg.Add(1)
+	go dr.run()
+	dr.started = true
+	log.Infof("Runner[%v] %v pull data deamon started", dr.meta.RunnerName, dr.Name())
+}
+
+func (dr *DockerReader) ReadLine() (data string, err error) {
+	if !dr.started {
+		dr.Start()
+	}
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case line := <-dr.readChan:
+		dr.sinceLock.Lock()
+		dr.since[line.containerID] = line.since
+		dr.source = line.source
+		dr.sinceLock.Unlock()
+		return string(line.data), nil
+	case <-timer.C:
+	}
+	return "", nil
+}
+
+//SyncMeta 记录每个容器已经读取到的时间点
+func (dr *DockerReader) SyncMeta() {
+	dr.sinceLock.Lock()
+	buf, err := jsoniter.Marshal(dr.since)
+	dr.sinceLock.Unlock()
+	if err != nil {
+		log.Errorf("Runner[%v] %v sync meta error %v", dr.meta.RunnerName, dr.Name(), err)
+		return
+	}
+	if err = dr.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		log.Errorf("Runner[%v] %v sync meta WriteBuf error %v, buf %v", dr.meta.RunnerName, dr.Name(), err, string(buf))
+	}
+}
+
+func (dr *DockerReader) Close() error {
+	if !atomic.CompareAndSwapInt32(&dr.status, StatusRunning, StatusStopping) {
+		atomic.StoreInt32(&dr.status, StatusStopped)
+		dr.cancel()
+		return nil
+	}
+	log.Infof("Runner[%v] %v stopping", dr.meta.RunnerName, dr.Name())
+	dr.cancel()
+	dr.wg.Wait()
+	atomic.StoreInt32(&dr.status, StatusStopped)
+	return nil
+}
+
+// run 定期列出容器，为新启动的容器开始读取日志
+func (dr *DockerReader) run() {
+	defer dr.wg.Done()
+	first := true
+	for {
+		if err := dr.discover(first); err != nil {
+			log.Errorf("Runner[%v] %v discover containers error %v", dr.meta.RunnerName, dr.Name(), err)
+			dr.setStatsError(err.Error())
+		} else {
+			first = false
+		}
+		select {
+		case <-dr.ctx.Done():
+			log.Warnf("Runner[%v] %v stopped from running", dr.meta.RunnerName, dr.Name())
+			return
+		case <-time.After(dr.discoverInterval):
+		}
+	}
+}
+
+func (dr *DockerReader) get(path string, query url.Values, v interface{}) error {
+	ctx, cancel := context.WithTimeout(dr.ctx, 30*time.Second)
+	defer cancel()
+	resp, err := dr.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return jsoniter.NewDecoder(resp.Body).Decode(v)
+}
+
+func (dr *DockerReader) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := dr.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dr.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("get %v status %v: %s", path, resp.Status, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
+
+// discover 列出所有匹配过滤条件的容器，已经删除的容器不再保留读取位置，first 表示本次运行中第一次发现容器
+func (dr *DockerReader) discover(first bool) error {
+	filters := map[string][]string{}
+	if len(dr.filters) > 0 {
+		filters["label"] = dr.filters
+	}
+	query := url.Values{"all": []string{"1"}}
+	if len(filters) > 0 {
+		data, err := jsoniter.Marshal(filters)
+		if err != nil {
+			return err
+		}
+		query.Set("filters", string(data))
+	}
+	var containers []dockerContainer
+	if err := dr.get("/containers/json", query, &containers); err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		exists[c.ID] = true
+	}
+	dr.sinceLock.Lock()
+	for id := range dr.since {
+		if !exists[id] {
+			delete(dr.since, id)
+		}
+	}
+	saved := make(map[string]string, len(dr.since))
+	for id, since := range dr.since {
+		saved[id] = since
+	}
+	dr.sinceLock.Unlock()
+
+	dr.tailLock.Lock()
+	defer dr.tailLock.Unlock()
+	for id := range dr.positions {
+		if !exists[id] {
+			delete(dr.positions, id)
+		}
+	}
+	for i := range containers {
+		c := containers[i]
+		if c.State != "running" || dr.tailing[c.ID] {
+			continue
+		}
+		since, ok := dr.positions[c.ID]
+		if !ok {
+			since, ok = saved[c.ID]
+		}
+		// 首次运行时已经在运行的容器按 read_from 决定从哪里开始，之后新启动的容器从头读取
+		if !ok && first && dr.whence == WhenceNewest {
+			since = time.Now().UTC().Format(time.RFC3339Nano)
+		}
+		dr.tailing[c.ID] = true
+		dr.wg.Add(1)
+		go dr.tail(c, since)
+	}
+	return nil
+}
+
+// tail 持续读取一个容器的日志，直到容器停止或 reader 被关闭
+func (dr *DockerReader) tail(c dockerContainer, since string) {
+	defer dr.wg.Done()
+	defer func() {
+		dr.tailLock.Lock()
+		delete(dr.tailing, c.ID)
+		dr.tailLock.Unlock()
+	}()
+	log.Infof("Runner[%v] %v start reading container %v(%v) since %q", dr.meta.RunnerName, dr.Name(), c.name(), c.ID, since)
+	if err := dr.readLogs(c, since); err != nil && dr.ctx.Err() == nil {
+		log.Errorf("Runner[%v] %v read logs of container %v error %v", dr.meta.RunnerName, dr.Name(), c.name(), err)
+		dr.setStatsError(err.Error())
+	}
+}
+
+func (dr *DockerReader) readLogs(c dockerContainer, since string) error {
+	var inspect struct {
+		Config struct {
+			Tty bool `json:"Tty"`
+		} `json:"Config"`
+	}
+	if err := dr.get("/containers/"+c.ID+"/json", nil, &inspect); err != nil {
+		return err
+	}
+	query := url.Values{
+		"follow":     []string{"1"},
+		"stdout":     []string{"1"},
+		"stderr":     []string{"1"},
+		"timestamps": []string{"1"},
+	}
+	if since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return fmt.Errorf("invalid since %v: %v", since, err)
+		}
+		query.Set("since", fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond()))
+	}
+	resp, err := dr.do(dr.ctx, "/containers/"+c.ID+"/logs", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	send := func(stream string, line []byte) error {
+		return dr.sendLine(&c, stream, line)
+	}
+	// 使用 tty 的容器 stdout 和 stderr 合并在一起且没有多路复用的帧头
+	if inspect.Config.Tty {
+		return readDockerLines(resp.Body, func(line []byte) error {
+			return send("stdout", line)
+		})
+	}
+	return demuxDockerLogs(resp.Body, send)
+}
+
+// sendLine 解析带时间戳的一行日志并发送，发送后记录下次应从哪个时间点读取
+func (dr *DockerReader) sendLine(c *dockerContainer, stream string, line []byte) error {
+	record := dockerContainerLog{
+		Log:           string(line),
+		Stream:        stream,
+		ContainerID:   c.ID,
+		ContainerName: c.name(),
+		Image:         c.Image,
+		Labels:        c.Labels,
+	}
+	var since string
+	if idx := bytes.IndexByte(line, ' '); idx > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, string(line[:idx])); err == nil {
+			record.Time, record.Log = string(line[:idx]), string(line[idx+1:])
+			// since 包含等于该时间的日志，需要跳过当前这一行
+			since = t.Add(time.Nanosecond).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	data, err := jsoniter.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if since == "" {
+		dr.tailLock.Lock()
+		since = dr.positions[c.ID]
+		dr.tailLock.Unlock()
+	}
+	select {
+	case dr.readChan <- dockerLine{data: data, containerID: c.ID, source: c.name(), since: since}:
+	case <-dr.ctx.Done():
+		return dr.ctx.Err()
+	}
+	dr.tailLock.Lock()
+	dr.positions[c.ID] = since
+	dr.tailLock.Unlock()
+	return nil
+}
+
+// demuxDockerLogs 解析 docker logs 接口多路复用的输出，每个 stream 分别按行切分
+func demuxDockerLogs(r io.Reader, send func(stream string, line []byte) error) error {
+	header := make([]byte, dockerFrameHeaderLen)
+	pending := map[string]*bytes.Buffer{}
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var stream string
+		switch header[0] {
+		case 0, 1:
+			stream = "stdout"
+		case 2:
+			stream = "stderr"
+		default:
+			return fmt.Errorf("unknown stream type %v in docker logs", header[0])
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return err
+		}
+		buf, ok := pending[stream]
+		if !ok {
+			buf = &bytes.Buffer{}
+			pending[stream] = buf
+		}
+		buf.Write(frame)
+		for {
+			idx := bytes.IndexByte(buf.Bytes(), '\n')
+			if idx < 0 {
+				break
+			}
+			line := bytes.TrimRight(buf.Next(idx+1), "\r\n")
+			if err := send(stream, line); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func readDockerLines(r io.Reader, send func(line []byte) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			if serr := send(line); serr != nil {
+				return serr
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
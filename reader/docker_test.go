@@ -0,0 +1,198 @@
+package reader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDockerLog struct {
+	stream byte
+	time   time.Time
+	msg    string
+}
+
+// fakeDocker 模拟 Docker API，logs 接口输出 since 之后的全部日志后结束
+type fakeDocker struct {
+	mux        sync.Mutex
+	containers []dockerContainer
+	tty        map[string]bool
+	logs       map[string][]fakeDockerLog
+	since      map[string][]string
+	filters    []string
+}
+
+func (f *fakeDocker) addLog(id string, stream byte, sec int, msg string) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.logs[id] = append(f.logs[id], fakeDockerLog{stream: stream, time: time.Unix(int64(sec), 1).UTC(), msg: msg})
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if r.URL.Path == "/containers/json" {
+		f.filters = append(f.filters, r.URL.Query().Get("filters"))
+		jsoniter.NewEncoder(w).Encode(f.containers)
+		return
+	}
+	splits := strings.Split(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+	id := splits[0]
+	if splits[1] == "json" {
+		fmt.Fprintf(w, `{"Id":%q,"Config":{"Tty":%v}}`, id, f.tty[id])
+		return
+	}
+	since := r.URL.Query().Get("since")
+	f.since[id] = append(f.since[id], since)
+	var sinceTime time.Time
+	if since != "" {
+		splits := strings.Split(since, ".")
+		sec, _ := strconv.ParseInt(splits[0], 10, 64)
+		nsec, _ := strconv.ParseInt(splits[1], 10, 64)
+		sinceTime = time.Unix(sec, nsec)
+	}
+	for _, l := range f.logs[id] {
+		if l.time.Before(sinceTime) {
+			continue
+		}
+		line := []byte(l.time.Format(time.RFC3339Nano) + " " + l.msg + "\n")
+		if f.tty[id] {
+			w.Write(line)
+			continue
+		}
+		// 将每一行拆成两帧，验证跨帧的行可以被正确拼接
+		for _, part := range [][]byte{line[:len(line)/2], line[len(line)/2:]} {
+			header := make([]byte, dockerFrameHeaderLen)
+			header[0] = l.stream
+			binary.BigEndian.PutUint32(header[4:], uint32(len(part)))
+			w.Write(append(header, part...))
+		}
+	}
+}
+
+func (f *fakeDocker) sinceParams(id string) []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]string{}, f.since[id]...)
+}
+
+func TestDockerReader(t *testing.T) {
+	dir, err := filepath.Abs("TestDockerReader")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+	ln, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	fake := &fakeDocker{
+		containers: []dockerContainer{
+			{ID: "c1", Names: []string{"/web"}, Image: "nginx", Labels: map[string]string{"app": "web"}, State: "running"},
+			{ID: "c2", Names: []string{"/old"}, Image: "nginx", Labels: map[string]string{"app": "web"}, State: "exited"},
+		},
+		tty:   map[string]bool{"c3": true},
+		logs:  map[string][]fakeDockerLog{},
+		since: map[string][]string{},
+	}
+	srv := &http.Server{Handler: fake}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	fake.addLog("c1", 1, 1500000000, "GET /index")
+	fake.addLog("c1", 2, 1500000001, "error")
+	fake.addLog("c2", 1, 1500000000, "never read")
+
+	readerConf := conf.MapConf{
+		KeyMetaPath:               filepath.Join(dir, "meta"),
+		KeyFileDone:               filepath.Join(dir, "meta"),
+		KeyMode:                   ModeDocker,
+		KeyDockerHost:             "unix://" + socket,
+		KeyDockerLabelFilters:     "app=web",
+		KeyDockerDiscoverInterval: "100ms",
+	}
+	readRecords := func(r Reader, n int) []dockerContainerLog {
+		var records []dockerContainerLog
+		for i := 0; i < 20 && len(records) < n; i++ {
+			line, err := r.ReadLine()
+			assert.NoError(t, err)
+			if line == "" {
+				continue
+			}
+			var record dockerContainerLog
+			assert.NoError(t, jsoniter.Unmarshal([]byte(line), &record))
+			records = append(records, record)
+		}
+		return records
+	}
+
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewDockerReader(meta, readerConf)
+	require.NoError(t, err)
+	labels := map[string]string{"app": "web"}
+	assert.Equal(t, []dockerContainerLog{
+		{Log: "GET /index", Stream: "stdout", Time: "2017-07-14T02:40:00.000000001Z", ContainerID: "c1", ContainerName: "web", Image: "nginx", Labels: labels},
+		{Log: "error", Stream: "stderr", Time: "2017-07-14T02:40:01.000000001Z", ContainerID: "c1", ContainerName: "web", Image: "nginx", Labels: labels},
+	}, readRecords(r, 2))
+	assert.Equal(t, "web", r.Source())
+
+	// 新启动的容器会被发现并从头读取
+	fake.mux.Lock()
+	fake.containers = append(fake.containers, dockerContainer{ID: "c3", Names: []string{"/tty"}, Image: "busybox", State: "running"})
+	fake.mux.Unlock()
+	fake.addLog("c3", 1, 1500000002, "hello")
+	assert.Equal(t, []dockerContainerLog{
+		{Log: "hello", Stream: "stdout", Time: "2017-07-14T02:40:02.000000001Z", ContainerID: "c3", ContainerName: "tty", Image: "busybox"},
+	}, readRecords(r, 1))
+	// 没有新日志时，重新读取的位置为已发送的最后一行之后
+	assert.Contains(t, fake.sinceParams("c1"), "1500000001.000000002")
+	assert.Empty(t, fake.sinceParams("c2"))
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	assert.Equal(t, `{"label":["app=web"]}`, fake.filters[0])
+
+	// 重启后从 meta 记录的位置继续读取
+	requests := len(fake.sinceParams("c1"))
+	fake.addLog("c1", 1, 1500000003, "after restart")
+	meta, err = NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err = NewDockerReader(meta, readerConf)
+	require.NoError(t, err)
+	records := readRecords(r, 1)
+	assert.NoError(t, r.Close())
+	require.Len(t, records, 1)
+	assert.Equal(t, "after restart", records[0].Log)
+	assert.Equal(t, "1500000001.000000002", fake.sinceParams("c1")[requests])
+}
+
+func TestDemuxDockerLogs(t *testing.T) {
+	frame := func(stream byte, data string) string {
+		header := make([]byte, dockerFrameHeaderLen)
+		header[0] = stream
+		binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+		return string(header) + data
+	}
+	var lines []string
+	err := demuxDockerLogs(strings.NewReader(frame(1, "a\nb")+frame(2, "c\n")+frame(1, "d\n")), func(stream string, line []byte) error {
+		lines = append(lines, stream+":"+string(line))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stdout:a", "stderr:c", "stdout:bd"}, lines)
+
+	err = demuxDockerLogs(strings.NewReader(frame(5, "x\n")), func(string, []byte) error { return nil })
+	assert.Error(t, err)
+}
@@ -1,9 +1,12 @@
 package reader
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -26,6 +29,15 @@ var (
 	ElasticVersion6 = "6.x"
 )
 
+// es 的读取方式，scroll 一次性读完整个索引，incremental 按时间戳排序并通过 search_after 持续增量读取
+const (
+	ESReadModeScroll      = "scroll"
+	ESReadModeIncremental = "incremental"
+)
+
+// esSearchAfterPrefix 用于区分 meta 中记录的 search_after 排序值与 scroll id
+const esSearchAfterPrefix = "search_after:"
+
 type ElasticReader struct {
 	esindex   string //es索引
 	estype    string //es type
@@ -33,10 +45,18 @@ type ElasticReader struct {
 	readBatch int    // 每次读取的数据量
 	keepAlive string //scrollID 保留时间
 	esVersion string //ElasticSearch version
-	readChan  chan json.RawMessage
+	readChan  chan esHit
 
-	meta   *Meta  // 记录offset的元数据
-	offset string // 当前处理es的offset
+	readMode        string        // scroll 或 incremental
+	query           string        // incremental 模式下的查询语句，为空时读取全部数据
+	timestampField  string        // incremental 模式下排序用的时间戳字段
+	tiebreakerField string        // 时间戳相同时用于排序的字段，保证 search_after 不重复不遗漏
+	pollInterval    time.Duration // 读到最新数据后再次查询的间隔
+	sortValues      []interface{} // 最后一条数据的排序值
+
+	meta       *Meta  // 记录offset的元数据
+	offset     string // 当前处理es的offset
+	offsetLock sync.RWMutex
 
 	stats     StatsInfo
 	statsLock sync.RWMutex
@@ -49,8 +69,12 @@ type ElasticReader struct {
 func NewESReader(meta *Meta, conf conf.MapConf) (er Reader, err error) {
 
 	readBatch, _ := conf.GetIntOr(KeyESReadBatch, 100)
+	readMode, _ := conf.GetStringOr(KeyESReadMode, ESReadModeScroll)
+	if readMode != ESReadModeScroll && readMode != ESReadModeIncremental {
+		return nil, fmt.Errorf("unsupported %v %v", KeyESReadMode, readMode)
+	}
 	estype, err := conf.GetString(KeyESType)
-	if err != nil {
+	if err != nil && readMode != ESReadModeIncremental {
 		return nil, err
 	}
 	esindex, err := conf.GetString(KeyESIndex)
@@ -63,30 +87,112 @@ func NewESReader(meta *Meta, conf conf.MapConf) (er Reader, err error) {
 	}
 	esVersion, _ := conf.GetStringOr(KeyESVersion, ElasticVersion3)
 	keepAlive, _ := conf.GetStringOr(KeyESKeepAlive, "6h")
+	query, _ := conf.GetStringOr(KeyESQuery, "")
+	if query != "" {
+		var q map[string]interface{}
+		if err = json.Unmarshal([]byte(query), &q); err != nil {
+			return nil, fmt.Errorf("%v is not a valid json object: %v", KeyESQuery, err)
+		}
+	}
+	timestampField, _ := conf.GetStringOr(KeyESTimestampField, "@timestamp")
+	tiebreakerField, _ := conf.GetStringOr(KeyESTiebreakerField, "")
+	if tiebreakerField == "" {
+		// 5.x 中 _id 不能用于排序，需要使用 _uid
+		tiebreakerField = "_id"
+		if esVersion == ElasticVersion5 {
+			tiebreakerField = "_uid"
+		}
+	}
+	pollInterval, _ := conf.GetStringOr(KeyESPollInterval, "10s")
+	interval, err := time.ParseDuration(pollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %v error %v", KeyESPollInterval, pollInterval, err)
+	}
+	if readMode == ESReadModeIncremental && esVersion != ElasticVersion5 && esVersion != ElasticVersion6 {
+		return nil, fmt.Errorf("%v %v requires es_version %v or %v", KeyESReadMode, readMode, ElasticVersion5, ElasticVersion6)
+	}
 
 	offset, _, err := meta.ReadOffset()
 	if err != nil {
 		log.Errorf("Runner[%v] %v -meta data is corrupted err:%v, omit meta data", meta.RunnerName, meta.MetaFile(), err)
 	}
+	var sortValues []interface{}
+	if readMode == ESReadModeIncremental {
+		if sortValues, err = decodeSearchAfter(offset); err != nil {
+			log.Errorf("Runner[%v] %v -meta data %v is not a valid search_after offset: %v, omit meta data", meta.RunnerName, meta.MetaFile(), offset, err)
+			offset = ""
+		}
+	} else if strings.HasPrefix(offset, esSearchAfterPrefix) {
+		offset = ""
+	}
 	er = &ElasticReader{
-		esindex:   esindex,
-		estype:    estype,
-		eshost:    eshost,
-		esVersion: esVersion,
-		readBatch: readBatch,
-		keepAlive: keepAlive,
-		meta:      meta,
-		status:    StatusInit,
-		offset:    offset,
-		readChan:  make(chan json.RawMessage),
-		mux:       sync.Mutex{},
-		statsLock: sync.RWMutex{},
-		started:   false,
+		esindex:         esindex,
+		estype:          estype,
+		eshost:          eshost,
+		esVersion:       esVersion,
+		readBatch:       readBatch,
+		keepAlive:       keepAlive,
+		readMode:        readMode,
+		query:           query,
+		timestampField:  timestampField,
+		tiebreakerField: tiebreakerField,
+		pollInterval:    interval,
+		sortValues:      sortValues,
+		meta:            meta,
+		status:          StatusInit,
+		offset:          offset,
+		readChan:        make(chan esHit),
+		mux:             sync.Mutex{},
+		statsLock:       sync.RWMutex{},
+		started:         false,
 	}
 
 	return er, nil
 }
 
+// esHit 是发送给 ReadLine 的一条数据，offset 不为空时表示读取该数据后应记录的 search_after 排序值
+type esHit struct {
+	data   json.RawMessage
+	offset string
+}
+
+// encodeSearchAfter 将排序值编码为不含空白字符的 offset，便于写入 meta
+func encodeSearchAfter(sortValues []interface{}) (string, error) {
+	data, err := json.Marshal(sortValues)
+	if err != nil {
+		return "", err
+	}
+	return esSearchAfterPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeSearchAfter 解析 meta 中的排序值，数值使用 json.Number 保持精度，offset 为空时从头读取
+func decodeSearchAfter(offset string) ([]interface{}, error) {
+	if offset == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(offset, esSearchAfterPrefix) {
+		return nil, errors.New("missing " + esSearchAfterPrefix + " prefix")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(offset, esSearchAfterPrefix))
+	if err != nil {
+		return nil, err
+	}
+	var sortValues []interface{}
+	if err = (esNumberDecoder{}).Decode(data, &sortValues); err != nil {
+		return nil, err
+	}
+	return sortValues, nil
+}
+
+// esNumberDecoder 解析 es 返回结果时保留数值原样，避免 long 类型的排序值转为 float64 后丢失精度
+type esNumberDecoder struct{}
+
+func (esNumberDecoder) Decode(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
 func (er *ElasticReader) Name() string {
 	return "ESReader:" + er.Source()
 }
@@ -135,8 +241,11 @@ func (er *ElasticReader) ReadLine() (data string, err error) {
 	}
 	timer := time.NewTimer(time.Second)
 	select {
-	case dat := <-er.readChan:
-		data = string(dat)
+	case hit := <-er.readChan:
+		data = string(hit.data)
+		if hit.offset != "" {
+			er.setOffset(hit.offset)
+		}
 	case <-timer.C:
 	}
 	timer.Stop()
@@ -182,6 +291,9 @@ func (er *ElasticReader) run() (err error) {
 }
 
 func (er *ElasticReader) exec() (err error) {
+	if er.readMode == ESReadModeIncremental {
+		return er.execIncremental()
+	}
 	// Create a client
 	switch er.esVersion {
 	case ElasticVersion6:
@@ -203,9 +315,9 @@ func (er *ElasticReader) exec() (err error) {
 
 			// Send the hits to the hits channel
 			for _, hit := range results.Hits.Hits {
-				er.readChan <- *hit.Source
+				er.readChan <- esHit{data: *hit.Source}
 			}
-			er.offset = results.ScrollId
+			er.setOffset(results.ScrollId)
 			if atomic.LoadInt32(&er.status) == StatusStopping {
 				log.Warnf("Runner[%v] %v stopped from running", er.meta.RunnerName, er.Name())
 				return nil
@@ -230,9 +342,9 @@ func (er *ElasticReader) exec() (err error) {
 
 			// Send the hits to the hits channel
 			for _, hit := range results.Hits.Hits {
-				er.readChan <- *hit.Source
+				er.readChan <- esHit{data: *hit.Source}
 			}
-			er.offset = results.ScrollId
+			er.setOffset(results.ScrollId)
 			if atomic.LoadInt32(&er.status) == StatusStopping {
 				log.Warnf("Runner[%v] %v stopped from running", er.meta.RunnerName, er.Name())
 				return nil
@@ -256,9 +368,9 @@ func (er *ElasticReader) exec() (err error) {
 
 			// Send the hits to the hits channel
 			for _, hit := range results.Hits.Hits {
-				er.readChan <- *hit.Source
+				er.readChan <- esHit{data: *hit.Source}
 			}
-			er.offset = results.ScrollId
+			er.setOffset(results.ScrollId)
 			if atomic.LoadInt32(&er.status) == StatusStopping {
 				log.Warnf("Runner[%v] %v stopped from running", er.meta.RunnerName, er.Name())
 				return nil
@@ -268,9 +380,137 @@ func (er *ElasticReader) exec() (err error) {
 	}
 }
 
+// execIncremental 按照时间戳和 tiebreaker 字段升序查询，每次从上一批最后一条数据的排序值之后继续读取，
+// 读到最新数据后等待 pollInterval 再次查询，直到 reader 被关闭
+func (er *ElasticReader) execIncremental() (err error) {
+	switch er.esVersion {
+	case ElasticVersion6:
+		var client *elasticV6.Client
+		client, err = elasticV6.NewClient(elasticV6.SetURL(er.eshost), elasticV6.SetDecoder(esNumberDecoder{}))
+		if err != nil {
+			return
+		}
+		var query elasticV6.Query = elasticV6.NewMatchAllQuery()
+		if er.query != "" {
+			query = elasticV6.NewRawStringQuery(er.query)
+		}
+		for {
+			search := client.Search(er.esindex).Query(query).Size(er.readBatch).
+				SortBy(elasticV6.NewFieldSort(er.timestampField).Asc(), elasticV6.NewFieldSort(er.tiebreakerField).Asc())
+			if er.estype != "" {
+				search = search.Type(er.estype)
+			}
+			if len(er.sortValues) > 0 {
+				search = search.SearchAfter(er.sortValues...)
+			}
+			results, err := search.Do(context.Background())
+			if err != nil {
+				return err
+			}
+			var hits int
+			if results.Hits != nil {
+				hits = len(results.Hits.Hits)
+				for _, hit := range results.Hits.Hits {
+					if err = er.sendHit(hit.Source, hit.Sort); err != nil {
+						return err
+					}
+				}
+			}
+			if !er.waitNextPoll(hits) {
+				return nil
+			}
+		}
+	case ElasticVersion5:
+		var client *elasticV5.Client
+		client, err = elasticV5.NewClient(elasticV5.SetURL(er.eshost), elasticV5.SetDecoder(esNumberDecoder{}))
+		if err != nil {
+			return
+		}
+		var query elasticV5.Query = elasticV5.NewMatchAllQuery()
+		if er.query != "" {
+			query = elasticV5.NewRawStringQuery(er.query)
+		}
+		for {
+			search := client.Search(er.esindex).Query(query).Size(er.readBatch).
+				SortBy(elasticV5.NewFieldSort(er.timestampField).Asc(), elasticV5.NewFieldSort(er.tiebreakerField).Asc())
+			if er.estype != "" {
+				search = search.Type(er.estype)
+			}
+			if len(er.sortValues) > 0 {
+				search = search.SearchAfter(er.sortValues...)
+			}
+			results, err := search.Do(context.Background())
+			if err != nil {
+				return err
+			}
+			var hits int
+			if results.Hits != nil {
+				hits = len(results.Hits.Hits)
+				for _, hit := range results.Hits.Hits {
+					if err = er.sendHit(hit.Source, hit.Sort); err != nil {
+						return err
+					}
+				}
+			}
+			if !er.waitNextPoll(hits) {
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("%v %v is not supported by es_version %v", KeyESReadMode, er.readMode, er.esVersion)
+	}
+}
+
+// sendHit 发送一条数据并记录其排序值，没有 _source 的数据只更新排序值
+func (er *ElasticReader) sendHit(source *json.RawMessage, sortValues []interface{}) error {
+	if len(sortValues) == 0 {
+		return errors.New("search hit has no sort values")
+	}
+	offset, err := encodeSearchAfter(sortValues)
+	if err != nil {
+		return err
+	}
+	if source != nil {
+		er.readChan <- esHit{data: *source, offset: offset}
+	} else {
+		er.setOffset(offset)
+	}
+	er.sortValues = sortValues
+	return nil
+}
+
+func (er *ElasticReader) setOffset(offset string) {
+	er.offsetLock.Lock()
+	defer er.offsetLock.Unlock()
+	er.offset = offset
+}
+
+// waitNextPoll 在一批数据不满 readBatch 时等待 pollInterval，返回 false 表示 reader 正在关闭
+func (er *ElasticReader) waitNextPoll(hits int) bool {
+	var waited time.Duration
+	for {
+		if atomic.LoadInt32(&er.status) == StatusStopping {
+			log.Warnf("Runner[%v] %v stopped from running", er.meta.RunnerName, er.Name())
+			return false
+		}
+		if hits >= er.readBatch || waited >= er.pollInterval {
+			return true
+		}
+		step := er.pollInterval - waited
+		if step > time.Second {
+			step = time.Second
+		}
+		time.Sleep(step)
+		waited += step
+	}
+}
+
 //SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复。
 func (er *ElasticReader) SyncMeta() {
-	if err := er.meta.WriteOffset(er.offset, 0); err != nil {
+	er.offsetLock.RLock()
+	offset := er.offset
+	er.offsetLock.RUnlock()
+	if err := er.meta.WriteOffset(offset, 0); err != nil {
 		log.Errorf("Runner[%v] %v SyncMeta error %v", er.meta.RunnerName, er.Name(), err)
 	}
 	return
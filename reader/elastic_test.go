@@ -2,7 +2,12 @@ package reader
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/qiniu/logkit/conf"
@@ -28,7 +33,7 @@ func TestElasticReader(t *testing.T) {
 		readBatch: 100,
 		status:    StatusInit,
 		offset:    "TestElasticReader",
-		readChan:  make(chan json.RawMessage),
+		readChan:  make(chan esHit),
 	}
 	assert.EqualValues(t, "ESReader:127.0.0.1:9200_app_type", er.Name())
 	er.SyncMeta()
@@ -39,3 +44,124 @@ func TestElasticReader(t *testing.T) {
 	sts := er.Status()
 	assert.Equal(t, StatsInfo{}, sts)
 }
+
+type esStubDoc struct {
+	id string
+	ts int64
+}
+
+// newESStub 模拟 es 的 search_after 查询，排序值使用超过 float64 精度的时间戳
+func newESStub(t *testing.T, docs *[]esStubDoc, mux *sync.Mutex, searchAfters *[]string) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_nodes/http":
+			fmt.Fprintf(w, `{"nodes":{"node1":{"http":{"publish_address":"%v"}}}}`, strings.TrimPrefix(srv.URL, "http://"))
+		case r.URL.Path == "/logs-*/_search":
+			var req struct {
+				Size        int               `json:"size"`
+				Query       json.RawMessage   `json:"query"`
+				Sort        []json.RawMessage `json:"sort"`
+				SearchAfter []interface{}     `json:"search_after"`
+			}
+			dec := json.NewDecoder(r.Body)
+			dec.UseNumber()
+			if !assert.NoError(t, dec.Decode(&req)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			assert.JSONEq(t, `{"term":{"level":"error"}}`, string(req.Query))
+			assert.Equal(t, []json.RawMessage{json.RawMessage(`{"ts":{"order":"asc"}}`), json.RawMessage(`{"_id":{"order":"asc"}}`)}, req.Sort)
+			mux.Lock()
+			defer mux.Unlock()
+			start := 0
+			if len(req.SearchAfter) == 2 {
+				number, _ := req.SearchAfter[0].(json.Number)
+				*searchAfters = append(*searchAfters, string(number))
+				ts, err := number.Int64()
+				assert.NoError(t, err)
+				id, _ := req.SearchAfter[1].(string)
+				for start < len(*docs) && ((*docs)[start].ts < ts || ((*docs)[start].ts == ts && (*docs)[start].id <= id)) {
+					start++
+				}
+			}
+			var hits []string
+			for i := start; i < len(*docs) && len(hits) < req.Size; i++ {
+				doc := (*docs)[i]
+				hits = append(hits, fmt.Sprintf(`{"_index":"logs-1","_type":"doc","_id":"%v","_source":{"id":"%v"},"sort":[%v,"%v"]}`, doc.id, doc.id, doc.ts, doc.id))
+			}
+			fmt.Fprintf(w, `{"took":1,"hits":{"total":%v,"hits":[%v]}}`, len(*docs), strings.Join(hits, ","))
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	return srv
+}
+
+func TestElasticReaderIncremental(t *testing.T) {
+	const base int64 = 1 << 53
+	docs := []esStubDoc{{"a", base + 1}, {"b", base + 1}, {"c", base + 3}}
+	var mux sync.Mutex
+	var searchAfters []string
+	srv := newESStub(t, &docs, &mux, &searchAfters)
+	defer srv.Close()
+
+	metaDir := "TestElasticReaderIncremental"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:         metaDir,
+		KeyFileDone:         metaDir,
+		KeyMode:             ModeElastic,
+		KeyRunnerName:       "TestElasticReaderIncremental",
+		KeyESHost:           srv.URL,
+		KeyESIndex:          "logs-*",
+		KeyESVersion:        ElasticVersion6,
+		KeyESReadMode:       ESReadModeIncremental,
+		KeyESQuery:          `{"term":{"level":"error"}}`,
+		KeyESTimestampField: "ts",
+		KeyESReadBatch:      "2",
+		KeyESPollInterval:   "100ms",
+	}
+	readLines := func(r Reader, n int) []string {
+		var lines []string
+		for i := 0; i < 20 && len(lines) < n; i++ {
+			line, err := r.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	meta, err := NewMetaWithConf(readerConf)
+	assert.NoError(t, err)
+	r, err := NewESReader(meta, readerConf)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"id":"a"}`, `{"id":"b"}`, `{"id":"c"}`}, readLines(r, 3))
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	offset, _, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	sortValues, err := decodeSearchAfter(offset)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{json.Number("9007199254740995"), "c"}, sortValues)
+
+	mux.Lock()
+	assert.Contains(t, searchAfters, "9007199254740993")
+	docs = append(docs, esStubDoc{"d", base + 3}, esStubDoc{"e", base + 5})
+	mux.Unlock()
+
+	// 重启后从 meta 中记录的排序值继续读取
+	meta, err = NewMetaWithConf(readerConf)
+	assert.NoError(t, err)
+	r, err = NewESReader(meta, readerConf)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"id":"d"}`, `{"id":"e"}`}, readLines(r, 2))
+	assert.NoError(t, r.Close())
+
+	readerConf[KeyESVersion] = ElasticVersion3
+	_, err = NewESReader(meta, readerConf)
+	assert.Error(t, err)
+}
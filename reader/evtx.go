@@ -0,0 +1,1216 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	KeyEvtxPollInterval = "evtx_poll_interval"
+)
+
+// Windows EVTX 文件格式，参见 https://github.com/libyal/libevtx/blob/main/documentation/Windows%20XML%20Event%20Log%20(EVTX).asciidoc
+const (
+	evtxFileSignature  = "ElfFile\x00"
+	evtxChunkSignature = "ElfChnk\x00"
+	evtxRecordMagic    = 0x00002a2a
+
+	evtxFileHeaderSize  = 4096
+	evtxChunkSize       = 65536
+	evtxChunkHeaderSize = 512
+	evtxRecordHeaderLen = 24
+
+	evtxDefaultPollInterval = 10 * time.Second
+
+	// 元素与模板实例的最大嵌套深度，防止损坏或构造的数据导致无限递归
+	evtxMaxBinXMLDepth = 64
+)
+
+// BinXML 的 token 类型，0x40 位表示元素带有属性或后面还有属性
+const (
+	binXMLTokenEOF             = 0x00
+	binXMLTokenOpenStart       = 0x01
+	binXMLTokenCloseStart      = 0x02
+	binXMLTokenCloseEmpty      = 0x03
+	binXMLTokenEnd             = 0x04
+	binXMLTokenValue           = 0x05
+	binXMLTokenAttribute       = 0x06
+	binXMLTokenCDATA           = 0x07
+	binXMLTokenCharRef         = 0x08
+	binXMLTokenEntityRef       = 0x09
+	binXMLTokenPITarget        = 0x0a
+	binXMLTokenPIData          = 0x0b
+	binXMLTokenTemplate        = 0x0c
+	binXMLTokenSubstitution    = 0x0d
+	binXMLTokenOptionalSubst   = 0x0e
+	binXMLTokenFragmentHeader  = 0x0f
+	binXMLTokenHasMoreDataFlag = 0x40
+)
+
+// BinXML 中值的类型，0x80 位表示数组
+const (
+	evtxTypeNull       = 0x00
+	evtxTypeWString    = 0x01
+	evtxTypeString     = 0x02
+	evtxTypeInt8       = 0x03
+	evtxTypeUint8      = 0x04
+	evtxTypeInt16      = 0x05
+	evtxTypeUint16     = 0x06
+	evtxTypeInt32      = 0x07
+	evtxTypeUint32     = 0x08
+	evtxTypeInt64      = 0x09
+	evtxTypeUint64     = 0x0a
+	evtxTypeReal32     = 0x0b
+	evtxTypeReal64     = 0x0c
+	evtxTypeBool       = 0x0d
+	evtxTypeBinary     = 0x0e
+	evtxTypeGUID       = 0x0f
+	evtxTypeSizeT      = 0x10
+	evtxTypeFileTime   = 0x11
+	evtxTypeSystemTime = 0x12
+	evtxTypeSID        = 0x13
+	evtxTypeHexInt32   = 0x14
+	evtxTypeHexInt64   = 0x15
+	evtxTypeBinXML     = 0x21
+	evtxTypeArray      = 0x80
+)
+
+// evtxContent 是元素的内容，为子元素、文本或模板中的替换项之一
+type evtxContent struct {
+	elem     *evtxElement
+	text     string
+	subst    int
+	optional bool
+}
+
+type evtxAttr struct {
+	name  string
+	value []evtxContent
+}
+
+type evtxElement struct {
+	name     string
+	attrs    []evtxAttr
+	children []evtxContent
+}
+
+// evtxValue 是模板实例中的替换值，BinXML 类型的值解析为 fragment
+type evtxValue struct {
+	typ      byte
+	text     string
+	fragment []evtxContent
+}
+
+// evtxChunk 是 EVTX 文件中的一个 64K 的块，块内的字符串和模板通过相对于块起始位置的偏移引用
+type evtxChunk struct {
+	data         []byte
+	lastRecord   uint64
+	freeSpace    uint32
+	names        map[uint32]evtxName
+	templates    map[uint32][]evtxContent
+	nextRecordAt uint32
+}
+
+func parseEvtxChunk(data []byte) (*evtxChunk, error) {
+	if len(data) < evtxChunkSize || string(data[:8]) != evtxChunkSignature {
+		return nil, errors.New("invalid evtx chunk signature")
+	}
+	c := &evtxChunk{
+		data:         data,
+		lastRecord:   binary.LittleEndian.Uint64(data[16:]),
+		freeSpace:    binary.LittleEndian.Uint32(data[48:]),
+		names:        make(map[uint32]evtxName),
+		templates:    make(map[uint32][]evtxContent),
+		nextRecordAt: evtxChunkHeaderSize,
+	}
+	if c.freeSpace > evtxChunkSize || c.freeSpace < evtxChunkHeaderSize {
+		c.freeSpace = evtxChunkSize
+	}
+	return c, nil
+}
+
+// evtxName 是块的字符串表中的名字，end 为名字结构结束的位置
+type evtxName struct {
+	name string
+	end  int
+}
+
+// evtxRecord 是一条事件记录
+type evtxRecord struct {
+	id    uint64
+	event *evtxElement
+}
+
+// nextRecord 返回块中的下一条记录，没有更多记录时返回 io.EOF
+func (c *evtxChunk) nextRecord() (*evtxRecord, error) {
+	pos := c.nextRecordAt
+	if pos+evtxRecordHeaderLen > c.freeSpace || binary.LittleEndian.Uint32(c.data[pos:]) != evtxRecordMagic {
+		return nil, io.EOF
+	}
+	size := binary.LittleEndian.Uint32(c.data[pos+4:])
+	if size < evtxRecordHeaderLen+4 || pos+size > c.freeSpace {
+		return nil, fmt.Errorf("invalid evtx record size %v at chunk offset %v", size, pos)
+	}
+	c.nextRecordAt = pos + size
+	r := &evtxRecord{id: binary.LittleEndian.Uint64(c.data[pos+8:])}
+	p := &binXMLParser{chunk: c, pos: int(pos + evtxRecordHeaderLen), end: int(pos + size - 4)}
+	contents, err := p.parseFragment()
+	if err != nil {
+		return nil, fmt.Errorf("parse evtx record %v error %v", r.id, err)
+	}
+	for _, content := range contents {
+		if content.elem != nil {
+			r.event = content.elem
+			break
+		}
+	}
+	if r.event == nil {
+		return nil, fmt.Errorf("evtx record %v has no event element", r.id)
+	}
+	return r, nil
+}
+
+// binXMLParser 解析块中 [pos, end) 范围内的 BinXML
+type binXMLParser struct {
+	chunk *evtxChunk
+	pos   int
+	end   int
+	depth int
+}
+
+var (
+	errBinXMLTruncated = errors.New("binxml data truncated")
+	errBinXMLTooDeep   = fmt.Errorf("binxml nesting exceeds %v levels", evtxMaxBinXMLDepth)
+)
+
+// enter 进入一层嵌套，与 leave 成对使用
+func (p *binXMLParser) enter() error {
+	if p.depth >= evtxMaxBinXMLDepth {
+		return errBinXMLTooDeep
+	}
+	p.depth++
+	return nil
+}
+
+func (p *binXMLParser) leave() {
+	p.depth--
+}
+
+func (p *binXMLParser) read(n int) ([]byte, error) {
+	if n < 0 || p.pos+n > p.end {
+		return nil, errBinXMLTruncated
+	}
+	b := p.chunk.data[p.pos : p.pos+n]
+	p.pos += n
+	return b, nil
+}
+
+func (p *binXMLParser) u8() (byte, error) {
+	b, err := p.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (p *binXMLParser) u16() (uint16, error) {
+	b, err := p.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (p *binXMLParser) u32() (uint32, error) {
+	b, err := p.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (p *binXMLParser) peek() (byte, error) {
+	if p.pos >= p.end {
+		return 0, errBinXMLTruncated
+	}
+	return p.chunk.data[p.pos], nil
+}
+
+// utf16String 读取 count 个 UTF-16 字符
+func (p *binXMLParser) utf16String(count int) (string, error) {
+	b, err := p.read(count * 2)
+	if err != nil {
+		return "", err
+	}
+	return decodeUTF16(b), nil
+}
+
+// name 读取元素或属性的名字，名字保存在块的字符串表中，第一次出现时紧跟在引用之后
+func (p *binXMLParser) name(offset uint32) (string, error) {
+	name, ok := p.chunk.names[offset]
+	if !ok {
+		np := &binXMLParser{chunk: p.chunk, pos: int(offset), end: len(p.chunk.data)}
+		// next string offset 以及 hash
+		if _, err := np.read(6); err != nil {
+			return "", err
+		}
+		count, err := np.u16()
+		if err != nil {
+			return "", err
+		}
+		if name.name, err = np.utf16String(int(count)); err != nil {
+			return "", err
+		}
+		if _, err = np.read(2); err != nil {
+			return "", err
+		}
+		name.end = np.pos
+		p.chunk.names[offset] = name
+	}
+	if int(offset) == p.pos {
+		p.pos = name.end
+	}
+	return name.name, nil
+}
+
+// parseFragment 解析到 EOF token 或数据结束为止
+func (p *binXMLParser) parseFragment() ([]evtxContent, error) {
+	var contents []evtxContent
+	for p.pos < p.end {
+		token, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		switch token &^ binXMLTokenHasMoreDataFlag {
+		case binXMLTokenEOF:
+			p.pos++
+			return contents, nil
+		case binXMLTokenFragmentHeader:
+			if _, err = p.read(4); err != nil {
+				return nil, err
+			}
+		case binXMLTokenTemplate:
+			instance, err := p.parseTemplateInstance()
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, instance...)
+		case binXMLTokenOpenStart:
+			elem, err := p.parseElement()
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, evtxContent{elem: elem, subst: -1})
+		default:
+			content, err := p.parseContent()
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, content)
+		}
+	}
+	return contents, nil
+}
+
+func (p *binXMLParser) parseElement() (*evtxElement, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	token, err := p.u8()
+	if err != nil {
+		return nil, err
+	}
+	// dependency identifier 以及元素数据的长度
+	if _, err = p.read(6); err != nil {
+		return nil, err
+	}
+	nameOffset, err := p.u32()
+	if err != nil {
+		return nil, err
+	}
+	hasAttrs := token&binXMLTokenHasMoreDataFlag != 0
+	// 内联的名字可能在属性列表长度之前或之后
+	if hasAttrs && int(nameOffset) == p.pos+4 {
+		if _, err = p.read(4); err != nil {
+			return nil, err
+		}
+		hasAttrs = false
+	}
+	elem := &evtxElement{}
+	if elem.name, err = p.name(nameOffset); err != nil {
+		return nil, err
+	}
+	if hasAttrs {
+		if _, err = p.read(4); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		token, err = p.u8()
+		if err != nil {
+			return nil, err
+		}
+		switch token &^ binXMLTokenHasMoreDataFlag {
+		case binXMLTokenAttribute:
+			attr, err := p.parseAttribute()
+			if err != nil {
+				return nil, err
+			}
+			elem.attrs = append(elem.attrs, attr)
+		case binXMLTokenCloseEmpty:
+			return elem, nil
+		case binXMLTokenCloseStart:
+			for {
+				next, err := p.peek()
+				if err != nil {
+					return nil, err
+				}
+				switch next &^ binXMLTokenHasMoreDataFlag {
+				case binXMLTokenEnd:
+					p.pos++
+					return elem, nil
+				case binXMLTokenOpenStart:
+					child, err := p.parseElement()
+					if err != nil {
+						return nil, err
+					}
+					elem.children = append(elem.children, evtxContent{elem: child, subst: -1})
+				case binXMLTokenTemplate:
+					instance, err := p.parseTemplateInstance()
+					if err != nil {
+						return nil, err
+					}
+					elem.children = append(elem.children, instance...)
+				default:
+					content, err := p.parseContent()
+					if err != nil {
+						return nil, err
+					}
+					elem.children = append(elem.children, content)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unexpected binxml token 0x%02x in element %v", token, elem.name)
+		}
+	}
+}
+
+func (p *binXMLParser) parseAttribute() (evtxAttr, error) {
+	nameOffset, err := p.u32()
+	if err != nil {
+		return evtxAttr{}, err
+	}
+	attr := evtxAttr{}
+	if attr.name, err = p.name(nameOffset); err != nil {
+		return attr, err
+	}
+	for {
+		next, err := p.peek()
+		if err != nil {
+			return attr, err
+		}
+		switch next &^ binXMLTokenHasMoreDataFlag {
+		case binXMLTokenValue, binXMLTokenSubstitution, binXMLTokenOptionalSubst, binXMLTokenCharRef, binXMLTokenEntityRef:
+			content, err := p.parseContent()
+			if err != nil {
+				return attr, err
+			}
+			attr.value = append(attr.value, content)
+		default:
+			return attr, nil
+		}
+	}
+}
+
+// parseContent 解析元素或属性中的文本类内容
+func (p *binXMLParser) parseContent() (evtxContent, error) {
+	content := evtxContent{subst: -1}
+	token, err := p.u8()
+	if err != nil {
+		return content, err
+	}
+	switch token &^ binXMLTokenHasMoreDataFlag {
+	case binXMLTokenValue:
+		typ, err := p.u8()
+		if err != nil {
+			return content, err
+		}
+		if typ != evtxTypeWString {
+			return content, fmt.Errorf("unsupported binxml value type 0x%02x", typ)
+		}
+		count, err := p.u16()
+		if err != nil {
+			return content, err
+		}
+		content.text, err = p.utf16String(int(count))
+		return content, err
+	case binXMLTokenSubstitution, binXMLTokenOptionalSubst:
+		index, err := p.u16()
+		if err != nil {
+			return content, err
+		}
+		if _, err = p.u8(); err != nil {
+			return content, err
+		}
+		content.subst = int(index)
+		content.optional = token == binXMLTokenOptionalSubst
+		return content, nil
+	case binXMLTokenCDATA, binXMLTokenPIData:
+		count, err := p.u16()
+		if err != nil {
+			return content, err
+		}
+		content.text, err = p.utf16String(int(count))
+		return content, err
+	case binXMLTokenCharRef:
+		r, err := p.u16()
+		if err != nil {
+			return content, err
+		}
+		content.text = string(rune(r))
+		return content, nil
+	case binXMLTokenEntityRef:
+		offset, err := p.u32()
+		if err != nil {
+			return content, err
+		}
+		name, err := p.name(offset)
+		if err != nil {
+			return content, err
+		}
+		content.text = xmlEntity(name)
+		return content, nil
+	case binXMLTokenPITarget:
+		offset, err := p.u32()
+		if err != nil {
+			return content, err
+		}
+		_, err = p.name(offset)
+		return content, err
+	}
+	return content, fmt.Errorf("unexpected binxml token 0x%02x", token)
+}
+
+func xmlEntity(name string) string {
+	switch name {
+	case "lt":
+		return "<"
+	case "gt":
+		return ">"
+	case "amp":
+		return "&"
+	case "quot":
+		return "\""
+	case "apos":
+		return "'"
+	}
+	return "&" + name + ";"
+}
+
+// parseTemplateInstance 解析模板实例，模板定义第一次出现时紧跟在实例之后，之后的实例通过偏移引用
+func (p *binXMLParser) parseTemplateInstance() ([]evtxContent, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	// token、未知的 1 字节以及模板 id
+	if _, err := p.read(6); err != nil {
+		return nil, err
+	}
+	defOffset, err := p.u32()
+	if err != nil {
+		return nil, err
+	}
+	template, ok := p.chunk.templates[defOffset]
+	if !ok || int(defOffset) == p.pos {
+		tp := &binXMLParser{chunk: p.chunk, pos: int(defOffset), end: len(p.chunk.data), depth: p.depth}
+		// next template offset 以及 guid
+		if _, err = tp.read(20); err != nil {
+			return nil, err
+		}
+		size, err := tp.u32()
+		if err != nil {
+			return nil, err
+		}
+		tp.end = tp.pos + int(size)
+		if tp.end > len(p.chunk.data) {
+			return nil, errBinXMLTruncated
+		}
+		if !ok {
+			if template, err = tp.parseFragment(); err != nil {
+				return nil, fmt.Errorf("parse template at %v error %v", defOffset, err)
+			}
+			p.chunk.templates[defOffset] = template
+		}
+		if int(defOffset) == p.pos {
+			p.pos = tp.end
+		}
+	}
+
+	count, err := p.u32()
+	if err != nil {
+		return nil, err
+	}
+	// 每个描述符占 4 字节，先校验剩余数据，避免按损坏的数量分配内存
+	if uint64(count)*4 > uint64(p.end-p.pos) {
+		return nil, errBinXMLTruncated
+	}
+	type descriptor struct {
+		size uint16
+		typ  byte
+	}
+	descriptors := make([]descriptor, count)
+	for i := range descriptors {
+		b, err := p.read(4)
+		if err != nil {
+			return nil, err
+		}
+		descriptors[i] = descriptor{size: binary.LittleEndian.Uint16(b), typ: b[2]}
+	}
+	values := make([]evtxValue, count)
+	for i, d := range descriptors {
+		start := p.pos
+		data, err := p.read(int(d.size))
+		if err != nil {
+			return nil, err
+		}
+		values[i].typ = d.typ
+		if d.typ == evtxTypeBinXML {
+			vp := &binXMLParser{chunk: p.chunk, pos: start, end: start + int(d.size), depth: p.depth}
+			if values[i].fragment, err = vp.parseFragment(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		values[i].text = formatEvtxValue(d.typ, data)
+	}
+	return instantiateEvtx(template, values), nil
+}
+
+// instantiateEvtx 使用替换值生成模板的实例，可选的替换项在值为空时被忽略
+func instantiateEvtx(template []evtxContent, values []evtxValue) []evtxContent {
+	var contents []evtxContent
+	for _, content := range template {
+		if content.elem != nil {
+			elem := &evtxElement{name: content.elem.name}
+			for _, attr := range content.elem.attrs {
+				value := instantiateEvtx(attr.value, values)
+				if len(value) == 0 && hasEvtxSubst(attr.value) {
+					continue
+				}
+				elem.attrs = append(elem.attrs, evtxAttr{name: attr.name, value: value})
+			}
+			elem.children = instantiateEvtx(content.elem.children, values)
+			contents = append(contents, evtxContent{elem: elem, subst: -1})
+			continue
+		}
+		if content.subst < 0 {
+			contents = append(contents, content)
+			continue
+		}
+		if content.subst >= len(values) {
+			continue
+		}
+		value := values[content.subst]
+		if value.typ == evtxTypeBinXML {
+			contents = append(contents, value.fragment...)
+			continue
+		}
+		if content.optional && (value.typ == evtxTypeNull || value.text == "") {
+			continue
+		}
+		contents = append(contents, evtxContent{text: value.text, subst: -1})
+	}
+	return contents
+}
+
+func hasEvtxSubst(contents []evtxContent) bool {
+	for _, content := range contents {
+		if content.subst >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// fileTimeToTime 将 Windows FILETIME(自 1601-01-01 起的 100 纳秒数) 转换为时间
+func fileTimeToTime(ft uint64) time.Time {
+	const epochDiff = 116444736000000000
+	if ft < epochDiff {
+		return time.Time{}
+	}
+	ft -= epochDiff
+	return time.Unix(int64(ft/1e7), int64(ft%1e7)*100).UTC()
+}
+
+func formatEvtxGUID(b []byte) string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]),
+		binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:16])
+}
+
+func formatEvtxSID(b []byte) string {
+	if len(b) < 8 {
+		return hex.EncodeToString(b)
+	}
+	var authority uint64
+	for _, v := range b[2:8] {
+		authority = authority<<8 | uint64(v)
+	}
+	sid := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 0; i < int(b[1]) && 8+i*4+4 <= len(b); i++ {
+		sid += "-" + strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b[8+i*4:])), 10)
+	}
+	return sid
+}
+
+// formatEvtxValue 将替换值格式化为与 Windows 事件查看器 XML 视图一致的文本
+func formatEvtxValue(typ byte, b []byte) string {
+	if typ&evtxTypeArray != 0 {
+		elemType := typ &^ evtxTypeArray
+		var items []string
+		switch elemType {
+		case evtxTypeWString:
+			items = strings.Split(strings.TrimRight(decodeUTF16(b), "\x00"), "\x00")
+		case evtxTypeString:
+			items = strings.Split(strings.TrimRight(string(b), "\x00"), "\x00")
+		default:
+			size := evtxFixedSize(elemType)
+			if size == 0 {
+				return strings.ToUpper(hex.EncodeToString(b))
+			}
+			for i := 0; i+size <= len(b); i += size {
+				items = append(items, formatEvtxValue(elemType, b[i:i+size]))
+			}
+		}
+		return strings.Join(items, ",")
+	}
+	if size := evtxFixedSize(typ); size > 0 && len(b) < size {
+		return strings.ToUpper(hex.EncodeToString(b))
+	}
+	switch typ {
+	case evtxTypeNull:
+		return ""
+	case evtxTypeWString:
+		return strings.TrimRight(decodeUTF16(b), "\x00")
+	case evtxTypeString:
+		return strings.TrimRight(string(b), "\x00")
+	case evtxTypeInt8:
+		return strconv.Itoa(int(int8(b[0])))
+	case evtxTypeUint8:
+		return strconv.Itoa(int(b[0]))
+	case evtxTypeInt16:
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b))))
+	case evtxTypeUint16:
+		return strconv.Itoa(int(binary.LittleEndian.Uint16(b)))
+	case evtxTypeInt32:
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10)
+	case evtxTypeUint32:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10)
+	case evtxTypeInt64:
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10)
+	case evtxTypeUint64:
+		return strconv.FormatUint(binary.LittleEndian.Uint64(b), 10)
+	case evtxTypeReal32:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32)
+	case evtxTypeReal64:
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+	case evtxTypeBool:
+		return strconv.FormatBool(binary.LittleEndian.Uint32(b) != 0)
+	case evtxTypeGUID:
+		return formatEvtxGUID(b)
+	case evtxTypeSizeT, evtxTypeHexInt32, evtxTypeHexInt64:
+		if len(b) == 4 {
+			return fmt.Sprintf("0x%x", binary.LittleEndian.Uint32(b))
+		}
+		if len(b) == 8 {
+			return fmt.Sprintf("0x%x", binary.LittleEndian.Uint64(b))
+		}
+	case evtxTypeFileTime:
+		return fileTimeToTime(binary.LittleEndian.Uint64(b)).Format(time.RFC3339Nano)
+	case evtxTypeSystemTime:
+		u := func(i int) int { return int(binary.LittleEndian.Uint16(b[i*2:])) }
+		return time.Date(u(0), time.Month(u(1)), u(3), u(4), u(5), u(6), u(7)*int(time.Millisecond), time.UTC).Format(time.RFC3339Nano)
+	case evtxTypeSID:
+		return formatEvtxSID(b)
+	}
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func evtxFixedSize(typ byte) int {
+	switch typ {
+	case evtxTypeInt8, evtxTypeUint8:
+		return 1
+	case evtxTypeInt16, evtxTypeUint16:
+		return 2
+	case evtxTypeInt32, evtxTypeUint32, evtxTypeReal32, evtxTypeBool, evtxTypeHexInt32:
+		return 4
+	case evtxTypeInt64, evtxTypeUint64, evtxTypeReal64, evtxTypeFileTime, evtxTypeHexInt64:
+		return 8
+	case evtxTypeGUID, evtxTypeSystemTime:
+		return 16
+	}
+	return 0
+}
+
+func (e *evtxElement) text() string {
+	var buf bytes.Buffer
+	for _, child := range e.children {
+		if child.elem == nil {
+			buf.WriteString(child.text)
+		}
+	}
+	return buf.String()
+}
+
+func (e *evtxElement) attr(name string) (string, bool) {
+	for _, attr := range e.attrs {
+		if attr.name == name {
+			var buf bytes.Buffer
+			for _, v := range attr.value {
+				buf.WriteString(v.text)
+			}
+			return buf.String(), true
+		}
+	}
+	return "", false
+}
+
+// toJSON 将元素转换为 json 对象，属性和子元素作为字段，同名子元素合并为数组，
+// 只有文本的元素直接转换为文本，同时有属性和文本时文本放在 #text 字段中
+func (e *evtxElement) toJSON() interface{} {
+	if e.name == "EventData" {
+		return e.eventDataJSON()
+	}
+	m := make(map[string]interface{})
+	for _, attr := range e.attrs {
+		m[attr.name], _ = e.attr(attr.name)
+	}
+	for _, child := range e.children {
+		if child.elem == nil {
+			continue
+		}
+		addEvtxField(m, child.elem.name, child.elem.toJSON())
+	}
+	text := strings.TrimSpace(e.text())
+	if len(m) == 0 {
+		return text
+	}
+	if text != "" {
+		m["#text"] = text
+	}
+	return m
+}
+
+// eventDataJSON 将 <Data Name="key">value</Data> 转换为 key: value，没有 Name 的 Data 合并到 Data 字段中
+func (e *evtxElement) eventDataJSON() interface{} {
+	m := make(map[string]interface{})
+	for _, child := range e.children {
+		if child.elem == nil {
+			continue
+		}
+		if name, ok := child.elem.attr("Name"); ok && child.elem.name == "Data" {
+			m[name] = child.elem.text()
+			continue
+		}
+		addEvtxField(m, child.elem.name, child.elem.toJSON())
+	}
+	return m
+}
+
+func addEvtxField(m map[string]interface{}, key string, value interface{}) {
+	old, ok := m[key]
+	if !ok {
+		m[key] = value
+		return
+	}
+	if list, ok := old.([]interface{}); ok {
+		m[key] = append(list, value)
+		return
+	}
+	m[key] = []interface{}{old, value}
+}
+
+// evtxFile 按记录号的顺序读取一个 EVTX 文件，跳过记录号不大于 after 的记录
+type evtxFile struct {
+	path string
+	f    *os.File
+	size int64
+	// chunks 为待读取的块在文件中的位置，按块中第一条记录的记录号排序
+	chunks []int64
+	chunk  *evtxChunk
+	after  uint64
+}
+
+func openEvtxFile(path string, after uint64) (*evtxFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	header := make([]byte, 8)
+	if _, err = io.ReadFull(f, header); err != nil || string(header) != evtxFileSignature {
+		f.Close()
+		return nil, fmt.Errorf("%v is not an evtx file", path)
+	}
+	chunks, err := evtxChunkOffsets(f, info.Size(), after)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &evtxFile{path: path, f: f, size: info.Size(), chunks: chunks, after: after}, nil
+}
+
+// evtxChunkOffsets 返回包含记录号大于 after 的块的位置，日志写满后会从头覆盖最旧的块，
+// 文件中靠前的块不一定是较早的记录，因此按块中第一条记录的记录号排序
+func evtxChunkOffsets(f *os.File, size int64, after uint64) ([]int64, error) {
+	type chunkInfo struct {
+		offset int64
+		first  uint64
+	}
+	var infos []chunkInfo
+	header := make([]byte, 24)
+	for offset := int64(evtxFileHeaderSize); offset+evtxChunkSize <= size; offset += evtxChunkSize {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return nil, err
+		}
+		// 未使用的块全部为 0
+		if string(header[:8]) != evtxChunkSignature {
+			continue
+		}
+		first, last := binary.LittleEndian.Uint64(header[8:]), binary.LittleEndian.Uint64(header[16:])
+		if last != 0 && last <= after {
+			continue
+		}
+		infos = append(infos, chunkInfo{offset: offset, first: first})
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].first < infos[j].first })
+	offsets := make([]int64, len(infos))
+	for i, info := range infos {
+		offsets[i] = info.offset
+	}
+	return offsets, nil
+}
+
+// next 返回下一条记录，文件读完时返回 io.EOF
+func (ef *evtxFile) next() (*evtxRecord, error) {
+	for {
+		if ef.chunk == nil {
+			if len(ef.chunks) == 0 {
+				return nil, io.EOF
+			}
+			offset := ef.chunks[0]
+			ef.chunks = ef.chunks[1:]
+			data := make([]byte, evtxChunkSize)
+			if _, err := ef.f.ReadAt(data, offset); err != nil {
+				return nil, err
+			}
+			chunk, err := parseEvtxChunk(data)
+			if err != nil {
+				continue
+			}
+			ef.chunk = chunk
+		}
+		record, err := ef.chunk.nextRecord()
+		if err == io.EOF {
+			ef.chunk = nil
+			continue
+		}
+		if err != nil {
+			// 块中剩余的记录无法定位，跳到下一个块
+			ef.chunk = nil
+			return nil, err
+		}
+		if record.id <= ef.after {
+			continue
+		}
+		ef.after = record.id
+		return record, nil
+	}
+}
+
+func (ef *evtxFile) Close() error {
+	return ef.f.Close()
+}
+
+type evtxFileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// EvtxReader 读取 Windows 导出的 EVTX 文件，每个文件已经读取的记录号保存在 meta 中
+type EvtxReader struct {
+	meta         *Meta
+	path         string
+	pollInterval time.Duration
+
+	current *evtxFile
+	// records 记录每个文件已经读取的最大记录号，scanned 记录读完时文件的状态，文件没有变化时不再重复读取
+	records    map[string]uint64
+	scanned    map[string]evtxFileState
+	lastSource string
+
+	status    int32
+	mux       sync.Mutex
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewEvtxReader(meta *Meta, conf conf.MapConf) (reader Reader, err error) {
+	path, err := conf.GetString(KeyLogPath)
+	if err != nil {
+		return nil, err
+	}
+	pollInterval, _ := conf.GetStringOr(KeyEvtxPollInterval, "")
+	interval := evtxDefaultPollInterval
+	if pollInterval != "" {
+		if interval, err = time.ParseDuration(pollInterval); err != nil {
+			return nil, fmt.Errorf("parse %v error %v", KeyEvtxPollInterval, err)
+		}
+	}
+	er := &EvtxReader{
+		meta:         meta,
+		path:         path,
+		pollInterval: interval,
+		records:      make(map[string]uint64),
+		scanned:      make(map[string]evtxFileState),
+		status:       StatusInit,
+	}
+	_, _, bufsize, err := meta.ReadBufMeta()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, er.Name(), err)
+		}
+		return er, nil
+	}
+	buf := make([]byte, bufsize)
+	if _, err = meta.ReadBuf(buf); err != nil {
+		log.Warnf("Runner[%v] %v read buf error %v, ignore...", meta.RunnerName, er.Name(), err)
+		return er, nil
+	}
+	if err = jsoniter.Unmarshal(buf, &er.records); err != nil {
+		log.Warnf("Runner[%v] %v Unmarshal read buf error %v, ignore...", meta.RunnerName, er.Name(), err)
+		er.records = make(map[string]uint64)
+	}
+	return er, nil
+}
+
+func (er *EvtxReader) Name() string {
+	return "EvtxReader:" + er.path
+}
+
+// Source 返回最近一条记录所在的文件
+func (er *EvtxReader) Source() string {
+	er.mux.Lock()
+	defer er.mux.Unlock()
+	if er.lastSource == "" {
+		return er.path
+	}
+	return er.lastSource
+}
+
+func (er *EvtxReader) SetMode(mode string, v interface{}) error {
+	return errors.New("EvtxReader not support read mode")
+}
+
+func (er *EvtxReader) Status() StatsInfo {
+	er.statsLock.RLock()
+	defer er.statsLock.RUnlock()
+	return er.stats
+}
+
+func (er *EvtxReader) setStatsError(err string) {
+	er.statsLock.Lock()
+	defer er.statsLock.Unlock()
+	er.stats.Errors++
+	er.stats.LastError = err
+}
+
+func (er *EvtxReader) ReadLine() (data string, err error) {
+	if atomic.LoadInt32(&er.status) == StatusStopped {
+		return "", nil
+	}
+	atomic.CompareAndSwapInt32(&er.status, StatusInit, StatusRunning)
+	er.mux.Lock()
+	for {
+		if atomic.LoadInt32(&er.status) == StatusStopped {
+			er.mux.Unlock()
+			return "", nil
+		}
+		if er.current == nil {
+			er.current = er.nextFile()
+		}
+		if er.current == nil {
+			er.mux.Unlock()
+			time.Sleep(er.pollInterval)
+			return "", nil
+		}
+		record, rerr := er.current.next()
+		if rerr == nil {
+			data, err = formatEvtxRecord(record)
+			er.records[er.current.path] = record.id
+			er.lastSource = er.current.path
+			er.mux.Unlock()
+			return data, err
+		}
+		if rerr != io.EOF {
+			log.Errorf("Runner[%v] %v read evtx file %v error %v", er.meta.RunnerName, er.Name(), er.current.path, rerr)
+			er.setStatsError("Runner[" + er.meta.RunnerName + "] " + er.Name() + " read evtx file error " + rerr.Error())
+			continue
+		}
+		er.scanned[er.current.path] = evtxFileState{size: er.current.size, modTime: er.modTime(er.current.path)}
+		er.current.Close()
+		er.current = nil
+	}
+}
+
+func (er *EvtxReader) modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// nextFile 按修改时间顺序返回第一个有变化的文件，已经删除的文件不再保留记录号
+func (er *EvtxReader) nextFile() *evtxFile {
+	paths, err := evtxFiles(er.path)
+	if err != nil {
+		log.Errorf("Runner[%v] %v scan evtx files error %v", er.meta.RunnerName, er.Name(), err)
+		er.setStatsError("Runner[" + er.meta.RunnerName + "] " + er.Name() + " scan evtx files error " + err.Error())
+		return nil
+	}
+	exists := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		exists[path] = true
+	}
+	for path := range er.records {
+		if !exists[path] {
+			delete(er.records, path)
+			delete(er.scanned, path)
+		}
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if state, ok := er.scanned[path]; ok && state.size == info.Size() && state.modTime.Equal(info.ModTime()) {
+			continue
+		}
+		ef, err := openEvtxFile(path, er.records[path])
+		if err != nil {
+			log.Errorf("Runner[%v] %v open evtx file error %v", er.meta.RunnerName, er.Name(), err)
+			er.setStatsError("Runner[" + er.meta.RunnerName + "] " + er.Name() + " open evtx file error " + err.Error())
+			er.scanned[path] = evtxFileState{size: info.Size(), modTime: info.ModTime()}
+			continue
+		}
+		return ef
+	}
+	return nil
+}
+
+// evtxFiles 返回 path 下按修改时间排序的 .evtx 文件，path 也可以是单个文件
+func evtxFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, fi := range fis {
+		if !fi.IsDir() && strings.EqualFold(filepath.Ext(fi.Name()), ".evtx") {
+			files = append(files, fi)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].ModTime().Equal(files[j].ModTime()) {
+			return files[i].Name() < files[j].Name()
+		}
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	paths := make([]string, len(files))
+	for i, fi := range files {
+		paths[i] = filepath.Join(path, fi.Name())
+	}
+	return paths, nil
+}
+
+// formatEvtxRecord 将 Event 下的 System、EventData 等元素转换为 json
+func formatEvtxRecord(record *evtxRecord) (string, error) {
+	m := make(map[string]interface{})
+	for _, child := range record.event.children {
+		if child.elem != nil {
+			addEvtxField(m, child.elem.name, child.elem.toJSON())
+		}
+	}
+	data, err := jsoniter.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SyncMeta 保存每个文件已经读取的记录号
+func (er *EvtxReader) SyncMeta() {
+	er.mux.Lock()
+	buf, err := jsoniter.Marshal(er.records)
+	er.mux.Unlock()
+	if err != nil {
+		log.Errorf("Runner[%v] %v sync meta error %v", er.meta.RunnerName, er.Name(), err)
+		return
+	}
+	if err = er.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		log.Errorf("Runner[%v] %v sync meta WriteBuf error %v, buf %v", er.meta.RunnerName, er.Name(), err, string(buf))
+	}
+}
+
+func (er *EvtxReader) Close() error {
+	atomic.StoreInt32(&er.status, StatusStopped)
+	er.mux.Lock()
+	defer er.mux.Unlock()
+	if er.current != nil {
+		er.current.Close()
+		er.current = nil
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// evtxTestChunk 按 EVTX 格式构造一个块，块内的名字和模板只在第一次出现时内联
+type evtxTestChunk struct {
+	buf      bytes.Buffer
+	names    map[string]uint32
+	template uint32
+	records  []uint64
+}
+
+func newEvtxTestChunk() *evtxTestChunk {
+	c := &evtxTestChunk{names: make(map[string]uint32)}
+	c.buf.Write(make([]byte, evtxChunkHeaderSize))
+	return c
+}
+
+func (c *evtxTestChunk) u8(v byte) { c.buf.WriteByte(v) }
+func (c *evtxTestChunk) u16(v uint16) {
+	binary.Write(&c.buf, binary.LittleEndian, v)
+}
+func (c *evtxTestChunk) u32(v uint32) {
+	binary.Write(&c.buf, binary.LittleEndian, v)
+}
+func (c *evtxTestChunk) u64(v uint64) {
+	binary.Write(&c.buf, binary.LittleEndian, v)
+}
+func (c *evtxTestChunk) putU32(pos int, v uint32) {
+	binary.LittleEndian.PutUint32(c.buf.Bytes()[pos:], v)
+}
+
+func evtxUTF16(s string) []byte {
+	var buf bytes.Buffer
+	for _, v := range utf16.Encode([]rune(s)) {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func (c *evtxTestChunk) name(s string) {
+	if offset, ok := c.names[s]; ok {
+		c.u32(offset)
+		return
+	}
+	offset := uint32(c.buf.Len() + 4)
+	c.names[s] = offset
+	c.u32(offset)
+	c.u32(0)
+	c.u16(0)
+	c.u16(uint16(len(utf16.Encode([]rune(s)))))
+	c.buf.Write(evtxUTF16(s))
+	c.u16(0)
+}
+
+// element 写入一个元素，attrs 中的每一项写入一个属性值，content 为 nil 时为空元素
+func (c *evtxTestChunk) element(name string, attrs map[string]func(), content func()) {
+	token := byte(binXMLTokenOpenStart)
+	if len(attrs) > 0 {
+		token |= binXMLTokenHasMoreDataFlag
+	}
+	c.u8(token)
+	c.u16(0xffff)
+	c.u32(0)
+	c.name(name)
+	if len(attrs) > 0 {
+		c.u32(0)
+		i := 0
+		for attrName, value := range attrs {
+			i++
+			token := byte(binXMLTokenAttribute)
+			if i < len(attrs) {
+				token |= binXMLTokenHasMoreDataFlag
+			}
+			c.u8(token)
+			c.name(attrName)
+			value()
+		}
+	}
+	if content == nil {
+		c.u8(binXMLTokenCloseEmpty)
+		return
+	}
+	c.u8(binXMLTokenCloseStart)
+	content()
+	c.u8(binXMLTokenEnd)
+}
+
+func (c *evtxTestChunk) subst(index uint16, typ byte, optional bool) func() {
+	return func() {
+		if optional {
+			c.u8(binXMLTokenOptionalSubst)
+		} else {
+			c.u8(binXMLTokenSubstitution)
+		}
+		c.u16(index)
+		c.u8(typ)
+	}
+}
+
+func (c *evtxTestChunk) value(s string) func() {
+	return func() {
+		c.u8(binXMLTokenValue)
+		c.u8(evtxTypeWString)
+		c.u16(uint16(len(s)))
+		c.buf.Write(evtxUTF16(s))
+	}
+}
+
+func (c *evtxTestChunk) writeTemplate() {
+	c.buf.Write([]byte{binXMLTokenFragmentHeader, 1, 1, 0})
+	c.element("Event", map[string]func(){"xmlns": c.value("http://schemas.microsoft.com/win/2004/08/events/event")}, func() {
+		c.element("System", nil, func() {
+			c.element("Provider", map[string]func(){"Name": c.subst(0, evtxTypeWString, false)}, nil)
+			c.element("EventID", nil, c.subst(1, evtxTypeUint16, false))
+			c.element("TimeCreated", map[string]func(){"SystemTime": c.subst(2, evtxTypeFileTime, false)}, nil)
+			c.element("EventRecordID", nil, c.subst(3, evtxTypeUint64, false))
+			c.element("Computer", nil, c.value("WIN-1"))
+			c.element("Security", map[string]func(){"UserID": c.subst(4, evtxTypeSID, true)}, nil)
+		})
+		c.element("EventData", nil, func() {
+			c.element("Data", map[string]func(){"Name": c.value("TargetUserName")}, c.subst(5, evtxTypeWString, false))
+			c.element("Data", map[string]func(){"Name": c.value("LogonType")}, c.subst(6, evtxTypeUint32, false))
+		})
+		c.subst(7, evtxTypeBinXML, true)()
+	})
+	c.u8(binXMLTokenEOF)
+}
+
+type evtxTestValue struct {
+	typ  byte
+	data []byte
+}
+
+func evtxTestFileTime(id uint64) uint64 {
+	return uint64(time.Date(2018, 1, 1, 0, 0, int(id), 0, time.UTC).Unix())*1e7 + 116444736000000000
+}
+
+// record 写入一条使用模板的记录，块中的第一条记录内联模板定义
+func (c *evtxTestChunk) record(id uint64, user string, logonType uint32, sid []byte, userData string) {
+	start := c.buf.Len()
+	c.u32(evtxRecordMagic)
+	c.u32(0)
+	c.u64(id)
+	c.u64(evtxTestFileTime(id))
+	c.buf.Write([]byte{binXMLTokenFragmentHeader, 1, 1, 0})
+	c.u8(binXMLTokenTemplate)
+	c.u8(1)
+	c.u32(1)
+	if c.template == 0 {
+		c.template = uint32(c.buf.Len() + 4)
+		c.u32(c.template)
+		c.u32(0)
+		c.buf.Write(make([]byte, 16))
+		sizePos := c.buf.Len()
+		c.u32(0)
+		c.writeTemplate()
+		c.putU32(sizePos, uint32(c.buf.Len()-sizePos-4))
+	} else {
+		c.u32(c.template)
+	}
+
+	fileTime := make([]byte, 8)
+	binary.LittleEndian.PutUint64(fileTime, evtxTestFileTime(id))
+	eventID := make([]byte, 2)
+	binary.LittleEndian.PutUint16(eventID, 4624)
+	recordID := make([]byte, 8)
+	binary.LittleEndian.PutUint64(recordID, id)
+	logon := make([]byte, 4)
+	binary.LittleEndian.PutUint32(logon, logonType)
+	values := []evtxTestValue{
+		{evtxTypeWString, evtxUTF16("Microsoft-Windows-Security-Auditing\x00")},
+		{evtxTypeUint16, eventID},
+		{evtxTypeFileTime, fileTime},
+		{evtxTypeUint64, recordID},
+		{evtxTypeSID, sid},
+		{evtxTypeWString, evtxUTF16(user)},
+		{evtxTypeUint32, logon},
+		{evtxTypeNull, nil},
+	}
+	if sid == nil {
+		values[4].typ = evtxTypeNull
+	}
+	if userData != "" {
+		values[7].typ = evtxTypeBinXML
+	}
+	c.u32(uint32(len(values)))
+	descPos := c.buf.Len()
+	for _, v := range values {
+		c.u16(uint16(len(v.data)))
+		c.u8(v.typ)
+		c.u8(0)
+	}
+	for i, v := range values {
+		valueStart := c.buf.Len()
+		if i == 7 && userData != "" {
+			// BinXML 类型的值是一个嵌套的 fragment
+			c.buf.Write([]byte{binXMLTokenFragmentHeader, 1, 1, 0})
+			c.element("UserData", nil, func() {
+				c.element("Info", nil, c.value(userData))
+			})
+			c.u8(binXMLTokenEOF)
+			binary.LittleEndian.PutUint16(c.buf.Bytes()[descPos+i*4:], uint16(c.buf.Len()-valueStart))
+			continue
+		}
+		c.buf.Write(v.data)
+	}
+	c.u8(binXMLTokenEOF)
+	size := uint32(c.buf.Len() - start + 4)
+	c.u32(size)
+	c.putU32(start+4, size)
+	c.records = append(c.records, id)
+}
+
+func (c *evtxTestChunk) bytes() []byte {
+	data := make([]byte, evtxChunkSize)
+	copy(data, c.buf.Bytes())
+	copy(data, evtxChunkSignature)
+	binary.LittleEndian.PutUint64(data[8:], c.records[0])
+	binary.LittleEndian.PutUint64(data[16:], c.records[len(c.records)-1])
+	binary.LittleEndian.PutUint32(data[48:], uint32(c.buf.Len()))
+	return data
+}
+
+func writeEvtxTestFile(t *testing.T, path string, chunks ...*evtxTestChunk) {
+	data := make([]byte, evtxFileHeaderSize)
+	copy(data, evtxFileSignature)
+	for _, c := range chunks {
+		data = append(data, c.bytes()...)
+	}
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+}
+
+func TestEvtxReader(t *testing.T) {
+	dir := "TestEvtxReader"
+	logDir := filepath.Join(dir, "logs")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+	defer os.RemoveAll(dir)
+
+	sid := []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+	chunk1 := newEvtxTestChunk()
+	chunk1.record(1, "alice", 2, sid, "")
+	chunk1.record(2, "bob", 3, nil, "extra")
+	path := filepath.Join(logDir, "Security.evtx")
+	writeEvtxTestFile(t, path, chunk1)
+	createTestFile(filepath.Join(logDir, "readme.txt"), "not evtx")
+
+	readerConf := conf.MapConf{
+		KeyMetaPath:         filepath.Join(dir, "meta"),
+		KeyFileDone:         filepath.Join(dir, "meta"),
+		KeyMode:             ModeEvtx,
+		KeyLogPath:          logDir,
+		KeyEvtxPollInterval: "10ms",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewEvtxReader(meta, readerConf)
+	require.NoError(t, err)
+
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"System": {
+			"Provider": {"Name": "Microsoft-Windows-Security-Auditing"},
+			"EventID": "4624",
+			"TimeCreated": {"SystemTime": "2018-01-01T00:00:01Z"},
+			"EventRecordID": "1",
+			"Computer": "WIN-1",
+			"Security": {"UserID": "S-1-5-18"}
+		},
+		"EventData": {"TargetUserName": "alice", "LogonType": "2"}
+	}`, line)
+	assert.Equal(t, path, r.Source())
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"System": {
+			"Provider": {"Name": "Microsoft-Windows-Security-Auditing"},
+			"EventID": "4624",
+			"TimeCreated": {"SystemTime": "2018-01-01T00:00:02Z"},
+			"EventRecordID": "2",
+			"Computer": "WIN-1",
+			"Security": ""
+		},
+		"EventData": {"TargetUserName": "bob", "LogonType": "3"},
+		"UserData": {"Info": "extra"}
+	}`, line)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 文件追加了新的块，重启后只读取新的记录
+	chunk2 := newEvtxTestChunk()
+	chunk2.record(3, "carol", 10, sid, "")
+	writeEvtxTestFile(t, path, chunk1, chunk2)
+	meta, err = NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err = NewEvtxReader(meta, readerConf)
+	require.NoError(t, err)
+	defer r.Close()
+	var lines []string
+	for i := 0; i < 3; i++ {
+		line, err = r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"TargetUserName":"carol"`)
+}
+
+func TestEvtxFileWrapped(t *testing.T) {
+	dir := "TestEvtxFileWrapped"
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+
+	// 日志写满后从头覆盖，第一个块中是最新的记录
+	var chunks []*evtxTestChunk
+	for _, ids := range [][]uint64{{5, 6}, {1, 2}, {3, 4}} {
+		c := newEvtxTestChunk()
+		for _, id := range ids {
+			c.record(id, "alice", 2, nil, "")
+		}
+		chunks = append(chunks, c)
+	}
+	path := filepath.Join(dir, "Security.evtx")
+	writeEvtxTestFile(t, path, chunks...)
+
+	readIDs := func(after uint64) []uint64 {
+		ef, err := openEvtxFile(path, after)
+		require.NoError(t, err)
+		defer ef.Close()
+		var ids []uint64
+		for {
+			record, err := ef.next()
+			if err == io.EOF {
+				return ids
+			}
+			require.NoError(t, err)
+			ids = append(ids, record.id)
+		}
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6}, readIDs(0))
+	assert.Equal(t, []uint64{4, 5, 6}, readIDs(3))
+	assert.Empty(t, readIDs(6))
+}
+
+func TestFormatEvtxValue(t *testing.T) {
+	guid := []byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78}
+	assert.Equal(t, "{12345678-1234-5678-9ABC-DEF012345678}", formatEvtxValue(evtxTypeGUID, guid))
+	assert.Equal(t, "S-1-5-21-1-2", formatEvtxValue(evtxTypeSID, []byte{1, 3, 0, 0, 0, 0, 0, 5, 21, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}))
+	assert.Equal(t, "0x1f", formatEvtxValue(evtxTypeHexInt32, []byte{0x1f, 0, 0, 0}))
+	assert.Equal(t, "-1", formatEvtxValue(evtxTypeInt16, []byte{0xff, 0xff}))
+	assert.Equal(t, "true", formatEvtxValue(evtxTypeBool, []byte{1, 0, 0, 0}))
+	assert.Equal(t, "A0FF", formatEvtxValue(evtxTypeBinary, []byte{0xa0, 0xff}))
+	assert.Equal(t, "a,b", formatEvtxValue(evtxTypeArray|evtxTypeWString, evtxUTF16("a\x00b\x00")))
+	assert.Equal(t, "1,2", formatEvtxValue(evtxTypeArray|evtxTypeUint16, []byte{1, 0, 2, 0}))
+	assert.Equal(t, "2018-01-02T03:04:05.006Z", formatEvtxValue(evtxTypeSystemTime, []byte{0xe2, 0x07, 1, 0, 2, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0}))
+}
+
+func newEvtxTestParser(c *evtxTestChunk) *binXMLParser {
+	chunk := &evtxChunk{
+		data:      c.buf.Bytes(),
+		names:     make(map[uint32]evtxName),
+		templates: make(map[uint32][]evtxContent),
+	}
+	return &binXMLParser{chunk: chunk, pos: evtxChunkHeaderSize, end: c.buf.Len()}
+}
+
+func TestEvtxTemplateInstanceCorruptCount(t *testing.T) {
+	c := newEvtxTestChunk()
+	c.u8(binXMLTokenTemplate)
+	c.u8(1)
+	c.u32(1)
+	// 引用一个已经缓存的模板，替换值的数量远大于剩余数据
+	c.u32(0)
+	c.u32(0xffffffff)
+	c.u32(0)
+	p := newEvtxTestParser(c)
+	p.chunk.templates[0] = []evtxContent{}
+	_, err := p.parseTemplateInstance()
+	assert.Equal(t, errBinXMLTruncated, err)
+}
+
+func TestEvtxNestingDepth(t *testing.T) {
+	var nest func(c *evtxTestChunk, depth int)
+	nest = func(c *evtxTestChunk, depth int) {
+		if depth == 1 {
+			c.element("Data", nil, nil)
+			return
+		}
+		c.element("Data", nil, func() { nest(c, depth-1) })
+	}
+
+	c := newEvtxTestChunk()
+	nest(c, evtxMaxBinXMLDepth)
+	c.u8(binXMLTokenEOF)
+	contents, err := newEvtxTestParser(c).parseFragment()
+	require.NoError(t, err)
+	require.Len(t, contents, 1)
+
+	c = newEvtxTestChunk()
+	nest(c, evtxMaxBinXMLDepth+1)
+	c.u8(binXMLTokenEOF)
+	_, err = newEvtxTestParser(c).parseFragment()
+	assert.Equal(t, errBinXMLTooDeep, err)
+}
@@ -0,0 +1,414 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/protobuf"
+
+	"github.com/golang/snappy"
+	"github.com/labstack/echo"
+)
+
+// esBulkDoc 是 _bulk 请求中需要写入的一个文档，item 为该文档在响应中的结果
+type esBulkDoc struct {
+	index string
+	doc   []byte
+	item  map[string]interface{}
+}
+
+func (h *HttpReader) registerESBulk(r *echo.Echo) {
+	r.GET("/", h.guard(h.esInfo))
+	r.HEAD("/", h.guard(h.esInfo))
+	// Filebeat 启动时会检查并写入索引模板，这里直接返回成功
+	r.GET("/_template/:name", h.guard(h.esAcknowledged))
+	r.HEAD("/_template/:name", h.guard(h.esAcknowledged))
+	r.PUT("/_template/:name", h.guard(h.esAcknowledged))
+	r.POST("/_bulk", h.guard(h.esBulkHandler))
+	r.PUT("/_bulk", h.guard(h.esBulkHandler))
+	r.POST("/:index/_bulk", h.guard(h.esBulkHandler))
+	r.PUT("/:index/_bulk", h.guard(h.esBulkHandler))
+}
+
+func (h *HttpReader) esInfo(c echo.Context) error {
+	c.Response().Header().Set("X-Elastic-Product", "Elasticsearch")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"name":         "logkit",
+		"cluster_name": "logkit",
+		"version": map[string]interface{}{
+			"number":       h.esVersion,
+			"build_flavor": "default",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+func (h *HttpReader) esAcknowledged(c echo.Context) error {
+	c.Response().Header().Set("X-Elastic-Product", "Elasticsearch")
+	return c.JSON(http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+func esError(c echo.Context, err error) error {
+	return c.JSON(http.StatusBadRequest, map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":   "illegal_argument_exception",
+			"reason": err.Error(),
+		},
+		"status": http.StatusBadRequest,
+	})
+}
+
+func (h *HttpReader) esBulkHandler(c echo.Context) error {
+	start := time.Now()
+	req := c.Request()
+	defer req.Body.Close()
+	body, _, err := requestBody(req)
+	if err != nil {
+		return esError(c, err)
+	}
+	docs, items, err := parseESBulk(bufio.NewReader(io.LimitReader(body, DefaultMaxBodySize)), c.Param("index"))
+	if err != nil {
+		return esError(c, err)
+	}
+	// 写入失败的文档在响应中标记为 500，Filebeat 等客户端只会重试这些文档
+	hasErrors := false
+	for _, doc := range docs {
+		if err = h.putData(doc.doc, doc.index); err != nil {
+			hasErrors = true
+			esItemError(doc.item, err)
+		}
+	}
+	c.Response().Header().Set("X-Elastic-Product", "Elasticsearch")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"took":   time.Since(start).Nanoseconds() / int64(time.Millisecond),
+		"errors": hasErrors,
+		"items":  items,
+	})
+}
+
+func esItemError(item map[string]interface{}, err error) {
+	delete(item, "result")
+	item["status"] = http.StatusInternalServerError
+	item["error"] = map[string]interface{}{
+		"type":   "logkit_buffer_queue_exception",
+		"reason": err.Error(),
+	}
+	item["_shards"] = map[string]int{"total": 1, "successful": 0, "failed": 1}
+}
+
+// parseESBulk 解析 _bulk 请求的 action/source 行，index 和 create 的文档原样写入，update 写入其中的 doc，delete 忽略
+func parseESBulk(r *bufio.Reader, defaultIndex string) (docs []esBulkDoc, items []map[string]interface{}, err error) {
+	readLine := func() ([]byte, error) {
+		for {
+			line, err := r.ReadBytes('\n')
+			line = bytes.TrimSpace(line)
+			if len(line) > 0 {
+				return line, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for {
+		line, rerr := readLine()
+		if rerr == io.EOF {
+			return docs, items, nil
+		}
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err = json.Unmarshal(line, &action); err != nil || len(action) != 1 {
+			return nil, nil, fmt.Errorf("malformed action/metadata line [%s]", line)
+		}
+		for op, meta := range action {
+			index := meta.Index
+			if index == "" {
+				index = defaultIndex
+			}
+			if index == "" {
+				return nil, nil, fmt.Errorf("index is missing in action [%s]", line)
+			}
+			item := map[string]interface{}{
+				"_index":  index,
+				"_id":     meta.ID,
+				"_shards": map[string]int{"total": 1, "successful": 1, "failed": 0},
+			}
+			status, result := http.StatusCreated, "created"
+			switch op {
+			case "index", "create", "update":
+				source, serr := readLine()
+				if serr != nil {
+					return nil, nil, fmt.Errorf("source is missing for action [%s]", line)
+				}
+				if op == "update" {
+					var update struct {
+						Doc json.RawMessage `json:"doc"`
+					}
+					if err = json.Unmarshal(source, &update); err != nil {
+						return nil, nil, fmt.Errorf("malformed update source [%s]", source)
+					}
+					source = update.Doc
+					status, result = http.StatusOK, "updated"
+				}
+				if len(source) == 0 {
+					break
+				}
+				var buf bytes.Buffer
+				if err = json.Compact(&buf, source); err != nil {
+					return nil, nil, fmt.Errorf("malformed source [%s]: %v", source, err)
+				}
+				docs = append(docs, esBulkDoc{index: index, doc: buf.Bytes(), item: item})
+			case "delete":
+				status, result = http.StatusOK, "deleted"
+			default:
+				return nil, nil, fmt.Errorf("unknown bulk action %v", op)
+			}
+			item["status"] = status
+			item["result"] = result
+			items = append(items, map[string]interface{}{op: item})
+		}
+	}
+}
+
+func (h *HttpReader) registerLokiPush(r *echo.Echo) {
+	r.POST("/loki/api/v1/push", h.guard(h.lokiPushHandler))
+	r.POST("/api/prom/push", h.guard(h.lokiPushHandler))
+}
+
+// lokiEntry 是 Loki push 请求中的一条日志
+type lokiEntry struct {
+	Labels    map[string]string `json:"labels"`
+	Timestamp string            `json:"timestamp"`
+	Line      string            `json:"line"`
+}
+
+type lokiStream struct {
+	labels  string
+	entries []lokiEntry
+}
+
+func (h *HttpReader) lokiPushHandler(c echo.Context) error {
+	req := c.Request()
+	defer req.Body.Close()
+	body, contentType, err := requestBody(req)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, DefaultMaxBodySize))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	var streams []lokiStream
+	if contentType == ApplicationJson {
+		streams, err = parseLokiJson(data)
+	} else {
+		streams, err = parseLokiProto(data)
+	}
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	for _, stream := range streams {
+		for _, entry := range stream.entries {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+			// 写入缓存队列失败时返回 500，Promtail 会重试整个请求
+			if err = h.putData(line, stream.labels); err != nil {
+				return c.String(http.StatusInternalServerError, err.Error())
+			}
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func formatLokiTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseLokiJson 解析 json 格式的 push 请求，同时兼容旧版本的 labels/entries 格式
+func parseLokiJson(data []byte) ([]lokiStream, error) {
+	var req struct {
+		Streams []struct {
+			Stream  map[string]string   `json:"stream"`
+			Values  [][]json.RawMessage `json:"values"`
+			Labels  string              `json:"labels"`
+			Entries []struct {
+				Ts   time.Time `json:"ts"`
+				Line string    `json:"line"`
+			} `json:"entries"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("decode loki push request error %v", err)
+	}
+	var streams []lokiStream
+	for _, s := range req.Streams {
+		labels := s.Stream
+		if s.Labels != "" {
+			parsed, err := parseLokiLabels(s.Labels)
+			if err != nil {
+				return nil, err
+			}
+			labels = parsed
+		}
+		stream := lokiStream{labels: formatLokiLabels(labels)}
+		for _, v := range s.Values {
+			if len(v) < 2 {
+				return nil, fmt.Errorf("invalid loki stream value, expect [timestamp, line]")
+			}
+			var ts, line string
+			if err := json.Unmarshal(v[0], &ts); err != nil {
+				return nil, fmt.Errorf("invalid loki timestamp %s", v[0])
+			}
+			if err := json.Unmarshal(v[1], &line); err != nil {
+				return nil, fmt.Errorf("invalid loki line %s", v[1])
+			}
+			nsec, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid loki timestamp %v", ts)
+			}
+			stream.entries = append(stream.entries, lokiEntry{Labels: labels, Timestamp: formatLokiTimestamp(time.Unix(0, nsec)), Line: line})
+		}
+		for _, e := range s.Entries {
+			stream.entries = append(stream.entries, lokiEntry{Labels: labels, Timestamp: formatLokiTimestamp(e.Ts), Line: e.Line})
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// parseLokiProto 解析 snappy 压缩的 protobuf 格式 push 请求，消息定义参见 Loki 的 logproto.PushRequest
+func parseLokiProto(data []byte) ([]lokiStream, error) {
+	decoded, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("snappy decode loki push request error %v", err)
+	}
+	var streams []lokiStream
+	err = protobuf.Walk(decoded, func(num int, _ uint64, msg []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var labelsStr string
+		var entries []lokiEntry
+		err := protobuf.Walk(msg, func(num int, _ uint64, field []byte) error {
+			switch num {
+			case 1:
+				labelsStr = string(field)
+			case 2:
+				entry, err := parseLokiProtoEntry(field)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		labels, err := parseLokiLabels(labelsStr)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			entries[i].Labels = labels
+		}
+		streams = append(streams, lokiStream{labels: formatLokiLabels(labels), entries: entries})
+		return nil
+	})
+	return streams, err
+}
+
+func parseLokiProtoEntry(msg []byte) (entry lokiEntry, err error) {
+	var sec, nsec int64
+	err = protobuf.Walk(msg, func(num int, _ uint64, field []byte) error {
+		switch num {
+		case 1:
+			return protobuf.Walk(field, func(num int, v uint64, _ []byte) error {
+				switch num {
+				case 1:
+					sec = int64(v)
+				case 2:
+					nsec = int64(int32(v))
+				}
+				return nil
+			})
+		case 2:
+			entry.Line = string(field)
+		}
+		return nil
+	})
+	entry.Timestamp = formatLokiTimestamp(time.Unix(sec, nsec))
+	return
+}
+
+// parseLokiLabels 解析 {job="varlogs", filename="/var/log/a.log"} 格式的标签
+func parseLokiLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	str := strings.TrimSpace(s)
+	if !strings.HasPrefix(str, "{") || !strings.HasSuffix(str, "}") {
+		return nil, fmt.Errorf("invalid loki labels %v", s)
+	}
+	str = str[1 : len(str)-1]
+	for {
+		str = strings.TrimLeft(str, " ,")
+		if str == "" {
+			return labels, nil
+		}
+		eq := strings.Index(str, "=")
+		if eq <= 0 || len(str) < eq+2 || str[eq+1] != '"' {
+			return nil, fmt.Errorf("invalid loki labels %v", s)
+		}
+		name := strings.TrimSpace(str[:eq])
+		rest := str[eq+1:]
+		end := 1
+		for ; end < len(rest); end++ {
+			if rest[end] == '\\' {
+				end++
+				continue
+			}
+			if rest[end] == '"' {
+				break
+			}
+		}
+		if end >= len(rest) {
+			return nil, fmt.Errorf("invalid loki labels %v", s)
+		}
+		value, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid loki labels %v: %v", s, err)
+		}
+		labels[name] = value
+		str = rest[end+1:]
+	}
+}
+
+// formatLokiLabels 按标签名排序输出，作为数据的来源标签
+func formatLokiLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
@@ -0,0 +1,184 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/protobuf"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBulkHttpReader(t *testing.T, address string) *HttpReader {
+	httpReader := newUnstartedBulkHttpReader(t, address)
+	assert.NoError(t, httpReader.Start())
+	return httpReader
+}
+
+func newUnstartedBulkHttpReader(t *testing.T, address string) *HttpReader {
+	c := conf.MapConf{
+		KeyHttpServiceAddress: address,
+		KeyHttpESBulk:         "true",
+		KeyHttpLokiPush:       "true",
+	}
+	readConf := conf.MapConf{
+		KeyMetaPath:   metaDir,
+		KeyFileDone:   metaDir,
+		KeyMode:       ModeHttp,
+		KeyRunnerName: "TestHttpReaderBulk",
+	}
+	meta, err := NewMetaWithConf(readConf)
+	assert.NoError(t, err)
+	r, err := NewHttpReader(meta, c)
+	assert.NoError(t, err)
+	return r.(*HttpReader)
+}
+
+func assertHttpReaderLines(t *testing.T, r *HttpReader, exp []string, sources []string) {
+	for i, e := range exp {
+		got, err := r.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, e, got)
+		assert.Equal(t, sources[i], r.Source())
+	}
+}
+
+func TestHttpReaderESBulk(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	r := newBulkHttpReader(t, "127.0.0.1:7113")
+	defer r.Close()
+
+	resp, err := http.Get("http://127.0.0.1:7113/")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Elasticsearch", resp.Header.Get("X-Elastic-Product"))
+	assert.Contains(t, string(body), `"number":"`+DefaultESBulkVersion+`"`)
+
+	// filebeat 写入的 _bulk 请求
+	payload := `{"index":{"_index":"filebeat-7.10.2-2018.01.01"}}
+{"@timestamp":"2018-01-01T00:00:00.000Z","message":"GET /index.html 200", "log": {"file": {"path": "/var/log/nginx/access.log"}}}
+{"create":{"_id":"1"}}
+{"message":"created"}
+{"delete":{"_index":"old","_id":"2"}}
+{"update":{"_index":"app","_id":"3"}}
+{"doc":{"message":"updated"}}
+`
+	resp, err = http.Post("http://127.0.0.1:7113/default/_bulk", "application/x-ndjson", strings.NewReader(payload))
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"errors":false`)
+	assert.Equal(t, 4, strings.Count(string(body), `"status":`))
+
+	assertHttpReaderLines(t, r, []string{
+		`{"@timestamp":"2018-01-01T00:00:00.000Z","message":"GET /index.html 200","log":{"file":{"path":"/var/log/nginx/access.log"}}}`,
+		`{"message":"created"}`,
+		`{"message":"updated"}`,
+	}, []string{"filebeat-7.10.2-2018.01.01", "default", "app"})
+
+	resp, err = http.Post("http://127.0.0.1:7113/_bulk", "application/x-ndjson", strings.NewReader(`{"index":{}}`+"\n"+`{"a":1}`+"\n"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHttpReaderLokiPush(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	r := newBulkHttpReader(t, "127.0.0.1:7114")
+	defer r.Close()
+
+	payload := `{"streams":[{"stream":{"job":"varlogs","filename":"/var/log/syslog"},"values":[["1514764800000000000","line 1"],["1514764801500000000","line 2"]]}]}`
+	resp, err := http.Post("http://127.0.0.1:7114/loki/api/v1/push", ApplicationJson, strings.NewReader(payload))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	source := `{filename="/var/log/syslog", job="varlogs"}`
+	assertHttpReaderLines(t, r, []string{
+		`{"labels":{"filename":"/var/log/syslog","job":"varlogs"},"timestamp":"2018-01-01T00:00:00Z","line":"line 1"}`,
+		`{"labels":{"filename":"/var/log/syslog","job":"varlogs"},"timestamp":"2018-01-01T00:00:01.5Z","line":"line 2"}`,
+	}, []string{source, source})
+
+	// promtail 默认发送 snappy 压缩的 protobuf
+	var ts protobuf.Buffer
+	ts.Varint(1, 1514764800)
+	ts.Varint(2, 1000)
+	var entry protobuf.Buffer
+	entry.Bytes(1, ts)
+	entry.String(2, `proto "line"`)
+	var stream protobuf.Buffer
+	stream.String(1, `{job="promtail", msg="a \"quoted\", value"}`)
+	stream.Bytes(2, entry)
+	var pushReq protobuf.Buffer
+	pushReq.Bytes(1, stream)
+
+	resp, err = http.Post("http://127.0.0.1:7114/loki/api/v1/push", "application/x-protobuf", bytes.NewReader(snappy.Encode(nil, pushReq)))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assertHttpReaderLines(t, r, []string{
+		`{"labels":{"job":"promtail","msg":"a \"quoted\", value"},"timestamp":"2018-01-01T00:00:00.000001Z","line":"proto \"line\""}`,
+	}, []string{`{job="promtail", msg="a \"quoted\", value"}`})
+
+	resp, err = http.Post("http://127.0.0.1:7114/loki/api/v1/push", "application/x-protobuf", strings.NewReader("not snappy"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHttpReaderBulkQueueError(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	r := newUnstartedBulkHttpReader(t, "127.0.0.1:7116")
+	r.bufQueue = putErrorQueue{r.bufQueue}
+	assert.NoError(t, r.Start())
+	defer r.Close()
+
+	payload := `{"index":{"_index":"app"}}
+{"message":"a"}
+{"delete":{"_index":"app","_id":"1"}}
+`
+	resp, err := http.Post("http://127.0.0.1:7116/_bulk", "application/x-ndjson", strings.NewReader(payload))
+	assert.NoError(t, err)
+	var bulkResp struct {
+		Errors bool                                `json:"errors"`
+		Items  []map[string]map[string]interface{} `json:"items"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&bulkResp))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, bulkResp.Errors)
+	assert.Len(t, bulkResp.Items, 2)
+	assert.EqualValues(t, http.StatusInternalServerError, bulkResp.Items[0]["index"]["status"])
+	assert.NotNil(t, bulkResp.Items[0]["index"]["error"])
+	assert.EqualValues(t, http.StatusOK, bulkResp.Items[1]["delete"]["status"])
+	assert.Nil(t, bulkResp.Items[1]["delete"]["error"])
+
+	payload = `{"streams":[{"stream":{"job":"varlogs"},"values":[["1514764800000000000","line 1"]]}]}`
+	resp, err = http.Post("http://127.0.0.1:7116/loki/api/v1/push", ApplicationJson, strings.NewReader(payload))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestParseLokiLabels(t *testing.T) {
+	labels, err := parseLokiLabels(`{job="varlogs",filename="/var/log/a.log"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"job": "varlogs", "filename": "/var/log/a.log"}, labels)
+	labels, err = parseLokiLabels(`{}`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{}, labels)
+	_, err = parseLokiLabels(`job="varlogs"`)
+	assert.Error(t, err)
+	_, err = parseLokiLabels(`{job=varlogs}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,653 @@
+package reader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/robfig/cron"
+)
+
+// http_poll reader 的配置项
+const (
+	KeyHttpPollUrl            = "http_poll_url"
+	KeyHttpPollMethod         = "http_poll_method"
+	KeyHttpPollBody           = "http_poll_body"
+	KeyHttpPollHeaders        = "http_poll_headers"
+	KeyHttpPollAuthType       = "http_poll_auth_type"
+	KeyHttpPollUsername       = "http_poll_username"
+	KeyHttpPollPassword       = "http_poll_password"
+	KeyHttpPollToken          = "http_poll_token"
+	KeyHttpPollCron           = "http_poll_cron"
+	KeyHttpPollExecOnStart    = "http_poll_exec_onstart"
+	KeyHttpPollTimeout        = "http_poll_timeout"
+	KeyHttpPollRecordsPath    = "http_poll_records_path"
+	KeyHttpPollPagination     = "http_poll_pagination"
+	KeyHttpPollNextPath       = "http_poll_next_path"
+	KeyHttpPollCursorParam    = "http_poll_cursor_param"
+	KeyHttpPollMaxPages       = "http_poll_max_pages"
+	KeyHttpPollTimestampPath  = "http_poll_timestamp_path"
+	KeyHttpPollTimestampParam = "http_poll_timestamp_param"
+)
+
+// 认证方式
+const (
+	HttpPollAuthNone   = "none"
+	HttpPollAuthBasic  = "basic"
+	HttpPollAuthBearer = "bearer"
+)
+
+// 分页方式，link_header 使用响应头中 rel="next" 的链接，next_url 使用响应中的下一页地址，cursor 将响应中的游标作为请求参数
+const (
+	HttpPollPaginationNone       = "none"
+	HttpPollPaginationLinkHeader = "link_header"
+	HttpPollPaginationNextURL    = "next_url"
+	HttpPollPaginationCursor     = "cursor"
+)
+
+var linkNextRegexp = regexp.MustCompile(`<([^>]*)>\s*;[^,]*rel="?next"?`)
+
+// httpPollState 是写入 meta 的读取位置，next 为下次请求的下一页地址或游标，
+// timestamp 为已经读取的记录的最大时间，seen 为该时间的记录的摘要，用于去除边界上重复的记录。
+// 一轮读取因为 max_pages 没有读完时，已读取的最大时间记录在 pending 中，读到最后一页后才更新 timestamp
+type httpPollState struct {
+	Next             string   `json:"next,omitempty"`
+	Timestamp        string   `json:"timestamp,omitempty"`
+	Seen             []string `json:"seen,omitempty"`
+	PendingTimestamp string   `json:"pending_timestamp,omitempty"`
+	PendingSeen      []string `json:"pending_seen,omitempty"`
+}
+
+func (s httpPollState) copy() *httpPollState {
+	s.Seen = append([]string{}, s.Seen...)
+	s.PendingSeen = append([]string{}, s.PendingSeen...)
+	return &s
+}
+
+// httpPollLine 是拉取数据的协程与 ReadLine 之间传递的数据，state 为读取该数据后应记录的位置，data 为空时只更新位置
+type httpPollLine struct {
+	data  []byte
+	state *httpPollState
+}
+
+// HttpPollReader 定时请求 http 接口，从响应中取出记录，按分页方式读取后续页面
+type HttpPollReader struct {
+	meta           *Meta
+	url            string
+	method         string
+	body           string
+	headers        map[string]string
+	authType       string
+	username       string
+	password       string
+	token          string
+	recordsPath    []string
+	pagination     string
+	nextPath       []string
+	cursorParam    string
+	maxPages       int
+	timestampPath  []string
+	timestampParam string
+	client         *http.Client
+
+	Cron         *cron.Cron //定时任务
+	execOnStart  bool
+	loop         bool
+	loopDuration time.Duration
+
+	readChan chan httpPollLine
+	// stopChan 在 Close 时关闭，通知正在发送数据或者等待下一轮的协程退出
+	stopChan  chan struct{}
+	closeOnce sync.Once
+	status    int32
+	mux       sync.Mutex
+	started   bool
+
+	// state 为拉取数据的协程使用的位置，syncState 为已经被 ReadLine 取走的数据对应的位置
+	state     httpPollState
+	syncState httpPollState
+	syncLock  sync.Mutex
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewHttpPollReader(meta *Meta, c conf.MapConf) (Reader, error) {
+	rawURL, err := c.GetString(KeyHttpPollUrl)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = url.Parse(rawURL); err != nil {
+		return nil, fmt.Errorf("parse %v %v error %v", KeyHttpPollUrl, rawURL, err)
+	}
+	method, _ := c.GetStringOr(KeyHttpPollMethod, http.MethodGet)
+	body, _ := c.GetStringOr(KeyHttpPollBody, "")
+	headers := make(map[string]string)
+	if headerStr, _ := c.GetStringOr(KeyHttpPollHeaders, ""); headerStr != "" {
+		if err = jsoniter.Unmarshal([]byte(headerStr), &headers); err != nil {
+			return nil, fmt.Errorf("%v should be a json object of strings: %v", KeyHttpPollHeaders, err)
+		}
+	}
+	authType, _ := c.GetStringOr(KeyHttpPollAuthType, HttpPollAuthNone)
+	username, _ := c.GetStringOr(KeyHttpPollUsername, "")
+	password, _ := c.GetStringOr(KeyHttpPollPassword, "")
+	token, _ := c.GetStringOr(KeyHttpPollToken, "")
+	switch authType {
+	case HttpPollAuthNone:
+	case HttpPollAuthBasic:
+		if username == "" {
+			return nil, fmt.Errorf("%v is required when %v is %v", KeyHttpPollUsername, KeyHttpPollAuthType, authType)
+		}
+	case HttpPollAuthBearer:
+		if token == "" {
+			return nil, fmt.Errorf("%v is required when %v is %v", KeyHttpPollToken, KeyHttpPollAuthType, authType)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported %v %v", KeyHttpPollAuthType, authType)
+	}
+	timeoutStr, _ := c.GetStringOr(KeyHttpPollTimeout, "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %v error %v", KeyHttpPollTimeout, timeoutStr, err)
+	}
+	recordsPath, _ := c.GetStringOr(KeyHttpPollRecordsPath, "")
+	pagination, _ := c.GetStringOr(KeyHttpPollPagination, HttpPollPaginationNone)
+	nextPath, _ := c.GetStringOr(KeyHttpPollNextPath, "")
+	cursorParam, _ := c.GetStringOr(KeyHttpPollCursorParam, "cursor")
+	switch pagination {
+	case HttpPollPaginationNone, HttpPollPaginationLinkHeader:
+	case HttpPollPaginationNextURL, HttpPollPaginationCursor:
+		if nextPath == "" {
+			return nil, fmt.Errorf("%v is required when %v is %v", KeyHttpPollNextPath, KeyHttpPollPagination, pagination)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported %v %v", KeyHttpPollPagination, pagination)
+	}
+	maxPages, _ := c.GetIntOr(KeyHttpPollMaxPages, 100)
+	if maxPages <= 0 {
+		maxPages = 100
+	}
+	timestampPath, _ := c.GetStringOr(KeyHttpPollTimestampPath, "")
+	timestampParam, _ := c.GetStringOr(KeyHttpPollTimestampParam, "")
+	cronSchedule, _ := c.GetStringOr(KeyHttpPollCron, "")
+	execOnStart, _ := c.GetBoolOr(KeyHttpPollExecOnStart, true)
+
+	hr := &HttpPollReader{
+		meta:           meta,
+		url:            rawURL,
+		method:         strings.ToUpper(method),
+		body:           body,
+		headers:        headers,
+		authType:       authType,
+		username:       username,
+		password:       password,
+		token:          token,
+		recordsPath:    GetKeys(recordsPath),
+		pagination:     pagination,
+		nextPath:       GetKeys(nextPath),
+		cursorParam:    cursorParam,
+		maxPages:       maxPages,
+		timestampPath:  GetKeys(timestampPath),
+		timestampParam: timestampParam,
+		client:         &http.Client{Timeout: timeout},
+		Cron:           cron.New(),
+		execOnStart:    execOnStart,
+		readChan:       make(chan httpPollLine),
+		stopChan:       make(chan struct{}),
+		status:         StatusInit,
+	}
+	hr.restoreState()
+	hr.syncState = *hr.state.copy()
+
+	//schedule    string     //定时任务配置串
+	if len(cronSchedule) > 0 {
+		cronSchedule = strings.ToLower(cronSchedule)
+		if strings.HasPrefix(cronSchedule, Loop) {
+			hr.loop = true
+			hr.loopDuration, err = parseLoopDuration(cronSchedule)
+			if err != nil {
+				log.Errorf("Runner[%v] %v %v", hr.meta.RunnerName, hr.Name(), err)
+				err = nil
+			}
+		} else {
+			if err = hr.Cron.AddFunc(cronSchedule, hr.run); err != nil {
+				return nil, err
+			}
+			log.Infof("Runner[%v] %v Cron job added with schedule <%v>", hr.meta.RunnerName, hr.Name(), cronSchedule)
+		}
+	}
+	return hr, nil
+}
+
+func (hr *HttpPollReader) restoreState() {
+	_, _, bufsize, err := hr.meta.ReadBufMeta()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Runner[%v] %v recover from meta error %v, ignore...", hr.meta.RunnerName, hr.Name(), err)
+		}
+		return
+	}
+	buf := make([]byte, bufsize)
+	if _, err = hr.meta.ReadBuf(buf); err != nil {
+		log.Warnf("Runner[%v] %v read buf error %v, ignore...", hr.meta.RunnerName, hr.Name(), err)
+		return
+	}
+	if err = jsoniter.Unmarshal(buf, &hr.state); err != nil {
+		log.Warnf("Runner[%v] %v Unmarshal read buf error %v, ignore...", hr.meta.RunnerName, hr.Name(), err)
+		hr.state = httpPollState{}
+	}
+}
+
+func (hr *HttpPollReader) Name() string {
+	return "HttpPollReader:" + hr.url
+}
+
+func (hr *HttpPollReader) Source() string {
+	return hr.url
+}
+
+func (hr *HttpPollReader) SetMode(mode string, v interface{}) error {
+	return errors.New("HttpPollReader not support read mode")
+}
+
+func (hr *HttpPollReader) setStatsError(err string) {
+	hr.statsLock.Lock()
+	defer hr.statsLock.Unlock()
+	hr.stats.Errors++
+	hr.stats.LastError = err
+}
+
+func (hr *HttpPollReader) Status() StatsInfo {
+	hr.statsLock.RLock()
+	defer hr.statsLock.RUnlock()
+	return hr.stats
+}
+
+//Start 仅调用一次，借用ReadLine启动，不能在new实例的时候启动，会有并发问题
+func (hr *HttpPollReader) Start() {
+	hr.mux.Lock()
+	defer hr.mux.Unlock()
+	if hr.started {
+		return
+	}
+	if hr.loop {
+		go hr.LoopRun()
+	} else {
+		hr.Cron.Start()
+		if hr.execOnStart {
+			go hr.run()
+		}
+	}
+	hr.started = true
+	log.Infof("Runner[%v] %v pull data deamon started", hr.meta.RunnerName, hr.Name())
+}
+
+func (hr *HttpPollReader) ReadLine() (data string, err error) {
+	if !hr.started {
+		hr.Start()
+	}
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case line := <-hr.readChan:
+		if line.state != nil {
+			hr.syncLock.Lock()
+			hr.syncState = *line.state
+			hr.syncLock.Unlock()
+		}
+		data = string(line.data)
+	case <-timer.C:
+	}
+	return
+}
+
+//SyncMeta 记录下一页的地址或游标，以及已经读取的最大时间
+func (hr *HttpPollReader) SyncMeta() {
+	hr.syncLock.Lock()
+	buf, err := jsoniter.Marshal(hr.syncState)
+	hr.syncLock.Unlock()
+	if err != nil {
+		log.Errorf("Runner[%v] %v sync meta error %v", hr.meta.RunnerName, hr.Name(), err)
+		return
+	}
+	if err = hr.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		log.Errorf("Runner[%v] %v sync meta WriteBuf error %v, buf %v", hr.meta.RunnerName, hr.Name(), err, string(buf))
+	}
+}
+
+// Close 之后不再关闭 readChan，正在运行的协程可能还在发送数据，通过 stopChan 通知其退出
+func (hr *HttpPollReader) Close() (err error) {
+	hr.Cron.Stop()
+	if atomic.CompareAndSwapInt32(&hr.status, StatusRunning, StatusStopping) {
+		log.Infof("Runner[%v] %v stopping", hr.meta.RunnerName, hr.Name())
+	} else {
+		atomic.StoreInt32(&hr.status, StatusStopped)
+	}
+	hr.closeOnce.Do(func() { close(hr.stopChan) })
+	return
+}
+
+func (hr *HttpPollReader) LoopRun() {
+	for {
+		if atomic.LoadInt32(&hr.status) == StatusStopped {
+			return
+		}
+		//run 函数里面处理stopping的逻辑
+		hr.run()
+		select {
+		case <-hr.stopChan:
+			return
+		case <-time.After(hr.loopDuration):
+		}
+	}
+}
+
+func (hr *HttpPollReader) run() {
+	// 防止并发run
+	for {
+		if atomic.LoadInt32(&hr.status) == StatusStopped {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&hr.status, StatusInit, StatusRunning) {
+			break
+		}
+	}
+	// running时退出 状态改为Init，以便 cron 调度下次运行
+	// stopping时推出改为 stopped，不再运行
+	defer func() {
+		atomic.CompareAndSwapInt32(&hr.status, StatusRunning, StatusInit)
+		atomic.CompareAndSwapInt32(&hr.status, StatusStopping, StatusStopped)
+	}()
+	if err := hr.poll(); err != nil {
+		log.Errorf("Runner[%v] %v poll error %v", hr.meta.RunnerName, hr.Name(), err)
+		hr.setStatsError(err.Error())
+		return
+	}
+	log.Infof("Runner[%v] %v successfully finished", hr.meta.RunnerName, hr.Name())
+}
+
+// poll 从保存的位置开始依次读取各页，整轮读取完成后才更新最大时间，避免倒序返回的接口在中断后漏掉数据
+func (hr *HttpPollReader) poll() error {
+	baseline := hr.state.copy()
+	seen := make(map[string]bool, len(baseline.Seen))
+	for _, s := range baseline.Seen {
+		seen[s] = true
+	}
+	maxTimestamp, maxSeen := baseline.Timestamp, append([]string{}, baseline.Seen...)
+	// 继续上一轮没有读完的页面时，沿用上一轮已读取的最大时间
+	if baseline.Next != "" && baseline.PendingTimestamp != "" {
+		maxTimestamp, maxSeen = baseline.PendingTimestamp, append([]string{}, baseline.PendingSeen...)
+	}
+	position := func(next string) *httpPollState {
+		state := httpPollState{Next: next, Timestamp: baseline.Timestamp, Seen: baseline.Seen, PendingTimestamp: maxTimestamp, PendingSeen: maxSeen}
+		return state.copy()
+	}
+
+	next := hr.state.Next
+	finished := false
+	for page := 0; page < hr.maxPages; page++ {
+		if atomic.LoadInt32(&hr.status) == StatusStopping {
+			log.Warnf("Runner[%v] %v stopped from running", hr.meta.RunnerName, hr.Name())
+			return nil
+		}
+		reqURL, err := hr.pageURL(next, baseline.Timestamp)
+		if err != nil {
+			return err
+		}
+		header, resp, err := hr.request(reqURL)
+		if err != nil {
+			return err
+		}
+		records, err := hr.extractRecords(resp)
+		if err != nil {
+			return err
+		}
+		// 中断后从当前页重新读取
+		pageState := position(next)
+		for _, record := range records {
+			// 按 key 排序编码，保证同一条记录每次得到的内容和摘要一致
+			data, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if len(hr.timestampPath) > 0 {
+				ts, ok := hr.recordTimestamp(record)
+				if ok {
+					digest := md5.Sum(data)
+					sum := hex.EncodeToString(digest[:])
+					cmp := compareHttpPollTimestamp(ts, baseline.Timestamp)
+					if baseline.Timestamp != "" && (cmp < 0 || cmp == 0 && seen[sum]) {
+						continue
+					}
+					switch c := compareHttpPollTimestamp(ts, maxTimestamp); {
+					case maxTimestamp == "" || c > 0:
+						maxTimestamp, maxSeen = ts, []string{sum}
+					case c == 0:
+						maxSeen = append(maxSeen, sum)
+					}
+				}
+			}
+			if !hr.send(httpPollLine{data: data, state: pageState}) {
+				return nil
+			}
+		}
+
+		newNext, err := hr.nextPosition(header, resp)
+		if err != nil {
+			return err
+		}
+		// 没有下一页时下一轮从配置的地址重新开始，下一页没有变化或者为空页时结束本轮，下一轮从该页开始
+		if newNext == "" {
+			next = ""
+			finished = true
+			break
+		}
+		if newNext == next || len(records) == 0 {
+			next = newNext
+			finished = true
+			break
+		}
+		next = newNext
+		if !hr.send(httpPollLine{state: position(next)}) {
+			return nil
+		}
+	}
+	if finished {
+		hr.state = httpPollState{Next: next, Timestamp: maxTimestamp, Seen: maxSeen}
+	} else {
+		// 达到 max_pages 时本轮还没有读完，保留原来的时间，下一轮从下一页继续读取
+		hr.state = *position(next)
+	}
+	hr.send(httpPollLine{state: hr.state.copy()})
+	return nil
+}
+
+// send 在 reader 关闭时返回 false
+func (hr *HttpPollReader) send(line httpPollLine) bool {
+	select {
+	case hr.readChan <- line:
+		return true
+	case <-hr.stopChan:
+		return false
+	}
+}
+
+// pageURL 生成请求的地址，next 为空时请求配置的地址并带上时间参数
+func (hr *HttpPollReader) pageURL(next, timestamp string) (string, error) {
+	base, err := url.Parse(hr.url)
+	if err != nil {
+		return "", err
+	}
+	if next != "" && (hr.pagination == HttpPollPaginationLinkHeader || hr.pagination == HttpPollPaginationNextURL) {
+		u, err := base.Parse(next)
+		if err != nil {
+			return "", fmt.Errorf("invalid next page url %v: %v", next, err)
+		}
+		return u.String(), nil
+	}
+	query := base.Query()
+	if next != "" && hr.pagination == HttpPollPaginationCursor {
+		query.Set(hr.cursorParam, next)
+	}
+	if hr.timestampParam != "" && timestamp != "" {
+		query.Set(hr.timestampParam, timestamp)
+	}
+	base.RawQuery = query.Encode()
+	return base.String(), nil
+}
+
+func (hr *HttpPollReader) request(reqURL string) (http.Header, interface{}, error) {
+	var body io.Reader
+	if hr.body != "" {
+		body = strings.NewReader(hr.body)
+	}
+	req, err := http.NewRequest(hr.method, reqURL, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range hr.headers {
+		req.Header.Set(k, v)
+	}
+	switch hr.authType {
+	case HttpPollAuthBasic:
+		req.SetBasicAuth(hr.username, hr.password)
+	case HttpPollAuthBearer:
+		req.Header.Set("Authorization", "Bearer "+hr.token)
+	}
+	resp, err := hr.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("request %v status %v: %s", reqURL, resp.Status, bytes.TrimSpace(data))
+	}
+	var v interface{}
+	decoder := jsoniter.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&v); err != nil {
+		return nil, nil, fmt.Errorf("response of %v is not json: %v", reqURL, err)
+	}
+	return resp.Header, v, nil
+}
+
+// extractRecords 按 records_path 取出记录，路径为空时响应本身为记录或记录数组
+func (hr *HttpPollReader) extractRecords(resp interface{}) ([]interface{}, error) {
+	v := resp
+	if len(hr.recordsPath) > 0 {
+		m, ok := resp.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("response is not a json object, can not get %v", strings.Join(hr.recordsPath, "."))
+		}
+		var err error
+		if v, err = GetMapValue(m, hr.recordsPath...); err != nil {
+			return nil, err
+		}
+	}
+	switch records := v.(type) {
+	case []interface{}:
+		return records, nil
+	case nil:
+		return nil, nil
+	default:
+		return []interface{}{records}, nil
+	}
+}
+
+func (hr *HttpPollReader) nextPosition(header http.Header, resp interface{}) (string, error) {
+	switch hr.pagination {
+	case HttpPollPaginationLinkHeader:
+		for _, link := range header["Link"] {
+			if matches := linkNextRegexp.FindStringSubmatch(link); len(matches) > 1 {
+				return matches[1], nil
+			}
+		}
+	case HttpPollPaginationNextURL, HttpPollPaginationCursor:
+		m, ok := resp.(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		v, err := GetMapValue(m, hr.nextPath...)
+		if err != nil {
+			return "", nil
+		}
+		return httpPollString(v), nil
+	}
+	return "", nil
+}
+
+func (hr *HttpPollReader) recordTimestamp(record interface{}) (string, bool) {
+	m, ok := record.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	v, err := GetMapValue(m, hr.timestampPath...)
+	if err != nil {
+		return "", false
+	}
+	ts := httpPollString(v)
+	return ts, ts != ""
+}
+
+func httpPollString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case jsoniter.Number:
+		return value.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// compareHttpPollTimestamp 比较两个时间，都是数字时按数值比较，都是 RFC3339 格式时按时间比较
+// (小数秒的位数可能不同，不能按字符串比较)，否则按字符串比较
+func compareHttpPollTimestamp(a, b string) int {
+	fa, erra := strconv.ParseFloat(a, 64)
+	fb, errb := strconv.ParseFloat(b, 64)
+	if erra == nil && errb == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	ta, erra := time.Parse(time.RFC3339Nano, a)
+	tb, errb := time.Parse(time.RFC3339Nano, b)
+	if erra == nil && errb == nil {
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
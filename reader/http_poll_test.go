@@ -0,0 +1,305 @@
+package reader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpPollReaderCursor(t *testing.T) {
+	var (
+		mux     sync.Mutex
+		records = []string{`{"id":1,"ts":1}`, `{"id":2,"ts":2}`, `{"id":3,"ts":3}`}
+		since   []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "logkit", r.Header.Get("X-Client"))
+		query := r.URL.Query()
+		since = append(since, query.Get("since"))
+		minTs, _ := strconv.Atoi(query.Get("since"))
+		var matched []string
+		for i, record := range records {
+			if i+1 >= minTs {
+				matched = append(matched, record)
+			}
+		}
+		// 每页两条记录，cursor 为下一页的起始位置
+		start, _ := strconv.Atoi(query.Get("cursor"))
+		end := start + 2
+		next := "null"
+		if end < len(matched) {
+			next = strconv.Itoa(end)
+		} else {
+			end = len(matched)
+		}
+		fmt.Fprintf(w, `{"data":{"items":[%v]},"meta":{"next":%v}}`, joinStrings(matched[start:end]), next)
+	}))
+	defer srv.Close()
+
+	metaDir := "TestHttpPollReaderCursor"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:               metaDir,
+		KeyFileDone:               metaDir,
+		KeyMode:                   ModeHttpPoll,
+		KeyHttpPollUrl:            srv.URL + "/logs",
+		KeyHttpPollHeaders:        `{"X-Client":"logkit"}`,
+		KeyHttpPollAuthType:       HttpPollAuthBearer,
+		KeyHttpPollToken:          "secret",
+		KeyHttpPollRecordsPath:    "data.items",
+		KeyHttpPollPagination:     HttpPollPaginationCursor,
+		KeyHttpPollNextPath:       "meta.next",
+		KeyHttpPollTimestampPath:  "ts",
+		KeyHttpPollTimestampParam: "since",
+	}
+	readLines := func(r Reader, n int) []string {
+		var lines []string
+		for i := 0; i < 10 && len(lines) < n; i++ {
+			line, err := r.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewHttpPollReader(meta, readerConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"id":1,"ts":1}`, `{"id":2,"ts":2}`, `{"id":3,"ts":3}`}, readLines(r, 3))
+	// 读取最后只包含位置信息的一行
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	mux.Lock()
+	assert.Equal(t, []string{"", ""}, since)
+	since = nil
+	// 与已读取的最大时间相同的新记录仍然会被读取
+	records = append(records, `{"id":4,"ts":3}`, `{"id":5,"ts":4}`)
+	mux.Unlock()
+
+	meta, err = NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err = NewHttpPollReader(meta, readerConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"id":4,"ts":3}`, `{"id":5,"ts":4}`}, readLines(r, 2))
+	assert.NoError(t, r.Close())
+	mux.Lock()
+	assert.Equal(t, "3", since[0])
+	mux.Unlock()
+
+	readerConf[KeyHttpPollPagination] = "offset"
+	_, err = NewHttpPollReader(meta, readerConf)
+	assert.Error(t, err)
+}
+
+func joinStrings(ss []string) string {
+	var s string
+	for i, v := range ss {
+		if i > 0 {
+			s += ","
+		}
+		s += v
+	}
+	return s
+}
+
+func TestHttpPollReaderLinkHeader(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		assert.Equal(t, "admin:pass", user+":"+password)
+		switch r.URL.Query().Get("after") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%v/events?after=a>; rel="next", <%v/events?after=z>; rel="last"`, srv.URL, srv.URL))
+			fmt.Fprint(w, `[{"id":1},{"id":2}]`)
+		case "a":
+			w.Header().Set("Link", `</events?after=b>; rel="next"`)
+			fmt.Fprint(w, `[{"id":3}]`)
+		default:
+			// 没有新数据时仍然返回下一页的地址
+			w.Header().Set("Link", `</events?after=b>; rel="next"`)
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer srv.Close()
+
+	metaDir := "TestHttpPollReaderLinkHeader"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:           metaDir,
+		KeyFileDone:           metaDir,
+		KeyMode:               ModeHttpPoll,
+		KeyHttpPollUrl:        srv.URL + "/events",
+		KeyHttpPollAuthType:   HttpPollAuthBasic,
+		KeyHttpPollUsername:   "admin",
+		KeyHttpPollPassword:   "pass",
+		KeyHttpPollPagination: HttpPollPaginationLinkHeader,
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewHttpPollReader(meta, readerConf)
+	require.NoError(t, err)
+	var lines []string
+	for i := 0; i < 10 && len(lines) < 3; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`, `{"id":3}`}, lines)
+	r.ReadLine()
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	// 最后一页没有新数据，下次从该页继续拉取
+	assert.Equal(t, "/events?after=b", r.(*HttpPollReader).syncState.Next)
+}
+
+func TestHttpPollReaderMaxPagesNewestFirst(t *testing.T) {
+	var (
+		mux sync.Mutex
+		// 接口按时间倒序返回记录
+		records = []int{5, 4, 3, 2, 1}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		query := r.URL.Query()
+		since, _ := strconv.Atoi(query.Get("since"))
+		var matched []string
+		for _, ts := range records {
+			if ts >= since {
+				matched = append(matched, fmt.Sprintf(`{"ts":%v}`, ts))
+			}
+		}
+		start, _ := strconv.Atoi(query.Get("cursor"))
+		if start > len(matched) {
+			start = len(matched)
+		}
+		end := start + 2
+		next := "null"
+		if end < len(matched) {
+			next = strconv.Itoa(end)
+		} else {
+			end = len(matched)
+		}
+		fmt.Fprintf(w, `{"items":[%v],"next":%v}`, joinStrings(matched[start:end]), next)
+	}))
+	defer srv.Close()
+
+	metaDir := "TestHttpPollReaderMaxPagesNewestFirst"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:               metaDir,
+		KeyFileDone:               metaDir,
+		KeyMode:                   ModeHttpPoll,
+		KeyHttpPollUrl:            srv.URL + "/logs",
+		KeyHttpPollRecordsPath:    "items",
+		KeyHttpPollPagination:     HttpPollPaginationCursor,
+		KeyHttpPollNextPath:       "next",
+		KeyHttpPollTimestampPath:  "ts",
+		KeyHttpPollTimestampParam: "since",
+		KeyHttpPollMaxPages:       "1",
+		KeyHttpPollCron:           "loop 10ms",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewHttpPollReader(meta, readerConf)
+	require.NoError(t, err)
+	defer r.Close()
+	readLines := func(n int) []string {
+		var lines []string
+		for i := 0; i < 50 && len(lines) < n; i++ {
+			line, err := r.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	// 每轮只读取一页，后续几轮继续读取较早的记录，不能因为已经读到较新的时间而跳过
+	assert.Equal(t, []string{`{"ts":5}`, `{"ts":4}`, `{"ts":3}`, `{"ts":2}`, `{"ts":1}`}, readLines(5))
+	mux.Lock()
+	records = append([]int{6}, records...)
+	mux.Unlock()
+	assert.Equal(t, []string{`{"ts":6}`}, readLines(1))
+	// 已读取的记录不会重复读取
+	assert.Empty(t, readLines(1))
+}
+
+func TestHttpPollReaderCloseWhileIdle(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	defer srv.Close()
+
+	metaDir := "TestHttpPollReaderCloseWhileIdle"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:            metaDir,
+		KeyFileDone:            metaDir,
+		KeyMode:                ModeHttpPoll,
+		KeyHttpPollUrl:         srv.URL + "/logs",
+		KeyHttpPollRecordsPath: "items",
+		KeyHttpPollCron:        "loop 200ms",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewHttpPollReader(meta, readerConf)
+	require.NoError(t, err)
+	hr := r.(*HttpPollReader)
+
+	// 每轮结束时会发送一次读取位置，取走两轮之后 reader 处于两轮之间的等待状态
+	for i := 0; i < 2; i++ {
+		_, err = r.ReadLine()
+		assert.NoError(t, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(StatusInit), atomic.LoadInt32(&hr.status))
+	assert.NoError(t, r.Close())
+	assert.Equal(t, int32(StatusStopped), atomic.LoadInt32(&hr.status))
+
+	// 关闭之后不再发起新的请求
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestCompareHttpPollTimestamp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		exp  int
+	}{
+		{"2", "10", -1},
+		{"1.5", "1.50", 0},
+		{"2018-01-01T00:00:00.5Z", "2018-01-01T00:00:00Z", 1},
+		{"2018-01-01T00:00:00Z", "2018-01-01T00:00:00.000Z", 0},
+		{"2018-01-01T08:00:00+08:00", "2018-01-01T00:00:00.1Z", -1},
+		{"b", "a", 1},
+	}
+	for _, ti := range tests {
+		assert.Equal(t, ti.exp, compareHttpPollTimestamp(ti.a, ti.b), "%v %v", ti.a, ti.b)
+	}
+}
@@ -2,12 +2,19 @@ package reader
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -20,11 +27,32 @@ import (
 )
 
 const (
+	// http_service_path 支持逗号分隔的多个路径，路径前可以加 "标签=" 指定该路径的来源标签，如 nginx=/logkit/nginx
+	// 配置了多个路径或者标签时，datasource_tag 记录的来源为数据所在路径的标签，未指定标签时为路径本身
 	KeyHttpServiceAddress = "http_service_address"
 	KeyHttpServicePath    = "http_service_path"
 
+	// 认证方式，配置 token 时校验 Authorization: Bearer <token>，配置用户名密码时校验 basic auth，都配置时满足其一即可
+	KeyHttpAuthToken    = "http_auth_token"
+	KeyHttpAuthUsername = "http_auth_username"
+	KeyHttpAuthPassword = "http_auth_password"
+
+	// 磁盘缓存队列中的数据条数超过该值时返回 429，未配置或者不大于 0 时使用默认值，队列不会无限增长
+	KeyHttpMaxQueueDepth = "http_max_queue_depth"
+
+	// 模拟 Elasticsearch 的 _bulk 接口，Filebeat 等客户端可以直接把 logkit 当作 Elasticsearch 使用
+	// 每个文档作为一条数据，来源标签为文档所在的索引
+	KeyHttpESBulk = "http_es_bulk"
+	// 模拟 Elasticsearch 时返回的版本号，Filebeat 会检查版本是否兼容
+	KeyHttpESVersion = "http_es_version"
+	// 模拟 Loki 的 push 接口(/loki/api/v1/push)，支持 json 以及 snappy 压缩的 protobuf，Promtail 等客户端可以直接推送
+	// 每条日志转换为 {"labels":{...},"timestamp":"...","line":"..."}，来源标签为 stream 的标签
+	KeyHttpLokiPush = "http_loki_push"
+
 	DefaultHttpServiceAddress = ":4000"
 	DefaultHttpServicePath    = "/logkit/data"
+	DefaultHttpMaxQueueDepth  = 1000000
+	DefaultESBulkVersion      = "7.10.2"
 
 	DefaultSyncEvery       = 10
 	DefaultMaxBodySize     = 100 * 1024 * 1024
@@ -32,9 +60,53 @@ const (
 	DefaultWriteSpeedLimit = 10 * 1024 * 1024 // 默认写速限制为10MB
 )
 
+// httpTaggedItemPrefix 是缓存队列中带来源标签的数据的前缀，完整格式为 前缀+标签+"\x00"+数据，
+// 前缀中包含格式的版本号，不带前缀的数据(如升级前写入队列的数据)按原始数据读取
+const httpTaggedItemPrefix = "\x00logkit-tag-v1\x00"
+
+// httpRoute 是一个监听路径及其来源标签
+type httpRoute struct {
+	path string
+	tag  string
+}
+
+func parseHttpRoutes(paths string) (routes []httpRoute, tagged bool, err error) {
+	for _, p := range strings.Split(paths, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		route := httpRoute{path: p, tag: p}
+		if idx := strings.Index(p, "="); idx > 0 {
+			route.tag, route.path = strings.TrimSpace(p[:idx]), strings.TrimSpace(p[idx+1:])
+			tagged = true
+		}
+		if !strings.HasPrefix(route.path, "/") {
+			return nil, false, fmt.Errorf("invalid %v %v, path must start with /", KeyHttpServicePath, p)
+		}
+		routes = append(routes, route)
+	}
+	if len(routes) == 0 {
+		return nil, false, fmt.Errorf("%v is empty", KeyHttpServicePath)
+	}
+	return routes, tagged, nil
+}
+
 type HttpReader struct {
 	address string
 	path    string
+	routes  []httpRoute
+	// routed 为 true 时缓存队列中的每条数据会带上来源标签
+	routed bool
+
+	authToken     string
+	authUsername  string
+	authPassword  string
+	maxQueueDepth int64
+	lastSource    string
+	esBulk        bool
+	esVersion     string
+	lokiPush      bool
 
 	meta   *Meta
 	status int32
@@ -48,21 +120,47 @@ func NewHttpReader(meta *Meta, conf conf.MapConf) (Reader, error) {
 	address, _ := conf.GetStringOr(KeyHttpServiceAddress, DefaultHttpServiceAddress)
 	path, _ := conf.GetStringOr(KeyHttpServicePath, DefaultHttpServicePath)
 	address, _ = RemoveHttpProtocal(address)
+	routes, tagged, err := parseHttpRoutes(path)
+	if err != nil {
+		return nil, err
+	}
+	authToken, _ := conf.GetStringOr(KeyHttpAuthToken, "")
+	authUsername, _ := conf.GetStringOr(KeyHttpAuthUsername, "")
+	authPassword, _ := conf.GetStringOr(KeyHttpAuthPassword, "")
+	if authUsername == "" && authPassword != "" {
+		return nil, fmt.Errorf("%v is required when %v is set", KeyHttpAuthUsername, KeyHttpAuthPassword)
+	}
+	maxQueueDepth, _ := conf.GetInt64Or(KeyHttpMaxQueueDepth, DefaultHttpMaxQueueDepth)
+	if maxQueueDepth <= 0 {
+		maxQueueDepth = DefaultHttpMaxQueueDepth
+	}
+	esBulk, _ := conf.GetBoolOr(KeyHttpESBulk, false)
+	esVersion, _ := conf.GetStringOr(KeyHttpESVersion, DefaultESBulkVersion)
+	lokiPush, _ := conf.GetBoolOr(KeyHttpLokiPush, false)
 
 	bq := queue.NewDiskQueue(Hash("HttpReader<"+address+">_buffer"), meta.BufFile(), DefaultMaxBytesPerFile, 0,
 		DefaultMaxBytesPerFile, DefaultSyncEvery, DefaultSyncEvery, time.Second*2, DefaultWriteSpeedLimit, false, 0)
-	err := CreateDirIfNotExist(meta.BufFile())
+	err = CreateDirIfNotExist(meta.BufFile())
 	if err != nil {
 		return nil, err
 	}
 	readChan := bq.ReadChan()
 	return &HttpReader{
-		address:  address,
-		path:     path,
-		meta:     meta,
-		bufQueue: bq,
-		readChan: readChan,
-		status:   StatusInit,
+		address:       address,
+		path:          path,
+		routes:        routes,
+		routed:        tagged || len(routes) > 1 || esBulk || lokiPush,
+		authToken:     authToken,
+		authUsername:  authUsername,
+		authPassword:  authPassword,
+		maxQueueDepth: maxQueueDepth,
+		esBulk:        esBulk,
+		esVersion:     esVersion,
+		lokiPush:      lokiPush,
+		meta:          meta,
+		bufQueue:      bq,
+		readChan:      readChan,
+		status:        StatusInit,
 	}, nil
 }
 
@@ -70,7 +168,11 @@ func (h *HttpReader) Name() string {
 	return "HttpReader<" + h.address + ">"
 }
 
+// Source 配置了多个路径、标签或者开启了 bulk 接口时返回最近一条数据的来源标签
 func (h *HttpReader) Source() string {
+	if h.routed && h.lastSource != "" {
+		return h.lastSource
+	}
 	return h.address
 }
 
@@ -80,7 +182,15 @@ func (h *HttpReader) Start() error {
 	}
 	var err error
 	r := echo.New()
-	r.POST(h.path, h.postData())
+	for _, route := range h.routes {
+		r.POST(route.path, h.guard(h.postData(route.tag)))
+	}
+	if h.esBulk {
+		h.registerESBulk(r)
+	}
+	if h.lokiPush {
+		h.registerLokiPush(r)
+	}
 
 	if h.listener, err = net.Listen("tcp", h.address); err != nil {
 		return err
@@ -107,6 +217,10 @@ func (h *HttpReader) ReadLine() (data string, err error) {
 	timer := time.NewTimer(time.Second)
 	select {
 	case dat := <-h.readChan:
+		var tag string
+		if tag, dat = decodeHttpItem(dat); tag != "" {
+			h.lastSource = tag
+		}
 		data = string(dat)
 	case <-timer.C:
 	}
@@ -132,34 +246,130 @@ func (h *HttpReader) Close() error {
 
 func (h *HttpReader) SyncMeta() {}
 
-func (h *HttpReader) postData() echo.HandlerFunc {
+// guard 校验认证以及缓存队列的深度，不通过时直接返回 401 或 429
+func (h *HttpReader) guard(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !h.authorized(c.Request()) {
+			if h.authUsername != "" {
+				c.Response().Header().Set("WWW-Authenticate", `Basic realm="logkit"`)
+			}
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		if h.bufQueue.Depth() >= h.maxQueueDepth {
+			c.Response().Header().Set("Retry-After", "1")
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too many requests, the buffer queue depth is " + strconv.FormatInt(h.bufQueue.Depth(), 10)})
+		}
+		return next(c)
+	}
+}
+
+func (h *HttpReader) postData(tag string) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		if err := h.pickUpData(c.Request()); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		if err := h.pickUpData(c.Request(), tag); err != nil {
+			return c.JSON(httpErrorStatus(err), map[string]string{"error": err.Error()})
 		}
 		return c.JSON(http.StatusOK, nil)
 	}
 }
 
-func (h *HttpReader) pickUpData(req *http.Request) (err error) {
+// httpQueueError 表示数据写入缓存队列失败，属于服务端的错误
+type httpQueueError struct {
+	err error
+}
+
+func (e *httpQueueError) Error() string {
+	return "put data into buffer queue error " + e.err.Error()
+}
+
+// httpErrorStatus 写入缓存队列失败时返回 500，客户端可以重试，其余为请求本身的错误返回 400
+func httpErrorStatus(err error) int {
+	if _, ok := err.(*httpQueueError); ok {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// authorized 校验 bearer token 或 basic auth，均未配置时不校验
+func (h *HttpReader) authorized(req *http.Request) bool {
+	if h.authToken == "" && h.authUsername == "" {
+		return true
+	}
+	if h.authToken != "" {
+		auth := req.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(h.authToken)) == 1 {
+			return true
+		}
+	}
+	if h.authUsername != "" {
+		username, password, ok := req.BasicAuth()
+		if ok && subtle.ConstantTimeCompare([]byte(username), []byte(h.authUsername)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(h.authPassword)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody 返回解压后的请求体以及去掉参数的 Content-Type
+func requestBody(req *http.Request) (body io.Reader, contentType string, err error) {
 	if req.ContentLength > DefaultMaxBodySize {
-		return errors.New("the request body is too large")
+		return nil, "", errors.New("the request body is too large")
 	}
-	reqBody := req.Body
-	defer reqBody.Close()
+	body = req.Body
 	contentEncoding := req.Header.Get(ContentEncodingHeader)
-	contentType := req.Header.Get(ContentTypeHeader)
-	if contentEncoding == "gzip" || contentType == "application/gzip" {
-		reqBody, err = gzip.NewReader(req.Body)
+	contentType, _, _ = mime.ParseMediaType(req.Header.Get(ContentTypeHeader))
+	if contentEncoding == "gzip" || contentType == ApplicationGzip {
+		body, err = gzip.NewReader(req.Body)
 		if err != nil {
-			return fmt.Errorf("read gzip body error %v", err)
+			return nil, "", fmt.Errorf("read gzip body error %v", err)
 		}
 	}
+	return body, contentType, nil
+}
+
+func (h *HttpReader) pickUpData(req *http.Request, tag string) (err error) {
+	defer req.Body.Close()
+	reqBody, contentType, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	if contentType == ApplicationJson {
+		return h.storageJson(io.LimitReader(reqBody, DefaultMaxBodySize), tag)
+	}
+	// ndjson 以及其他格式都按行读取
 	r := bufio.NewReader(reqBody)
-	return h.storageData(r)
+	return h.storageData(r, tag)
 }
 
-func (h *HttpReader) storageData(r *bufio.Reader) (err error) {
+// putData 把数据放入缓存队列，routed 时带上来源标签
+func (h *HttpReader) putData(line []byte, tag string) error {
+	item := line
+	if h.routed {
+		item = make([]byte, 0, len(httpTaggedItemPrefix)+len(tag)+1+len(line))
+		item = append(append(append(append(item, httpTaggedItemPrefix...), tag...), 0), line...)
+	}
+	if err := h.bufQueue.Put(item); err != nil {
+		log.Errorf("runner[%v] Reader[%v] put data into buffer queue error %v", h.meta.RunnerName, h.Name(), err)
+		return &httpQueueError{err}
+	}
+	return nil
+}
+
+// decodeHttpItem 解析缓存队列中的数据，返回来源标签和数据，不带标签前缀的数据原样返回
+func decodeHttpItem(item []byte) (tag string, data []byte) {
+	if !bytes.HasPrefix(item, []byte(httpTaggedItemPrefix)) {
+		return "", item
+	}
+	item = item[len(httpTaggedItemPrefix):]
+	idx := bytes.IndexByte(item, 0)
+	if idx < 0 {
+		return "", item
+	}
+	return string(item[:idx]), item[idx+1:]
+}
+
+func (h *HttpReader) storageData(r *bufio.Reader, tag string) (err error) {
 	for {
 		line, err := h.readLine(r)
 		if err != nil {
@@ -171,11 +381,72 @@ func (h *HttpReader) storageData(r *bufio.Reader) (err error) {
 		if line == "" {
 			continue
 		}
-		h.bufQueue.Put([]byte(line))
+		if err = h.putData([]byte(line), tag); err != nil {
+			return err
+		}
 	}
 	return
 }
 
+// storageJson 处理 application/json 的请求体，json 数组的每个元素作为一条数据，
+// 以 { 开头的请求体按照连续的 json 值解析(兼容 ndjson 以及格式化过的单个对象)，其余情况按行读取
+func (h *HttpReader) storageJson(r io.Reader, tag string) error {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read json body error %v", err)
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	switch trimmed[0] {
+	case '[':
+		var docs []json.RawMessage
+		if err = json.Unmarshal(trimmed, &docs); err != nil {
+			return fmt.Errorf("decode json array error %v", err)
+		}
+		return h.storageJsonDocs(docs, tag)
+	case '{':
+		docs, err := decodeJsonStream(trimmed)
+		if err == nil {
+			return h.storageJsonDocs(docs, tag)
+		}
+	}
+	return h.storageData(bufio.NewReader(bytes.NewReader(body)), tag)
+}
+
+func decodeJsonStream(body []byte) ([]json.RawMessage, error) {
+	var docs []json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return docs, nil
+			}
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func (h *HttpReader) storageJsonDocs(docs []json.RawMessage, tag string) error {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		buf.Reset()
+		if err := json.Compact(&buf, doc); err != nil {
+			return fmt.Errorf("compact json error %v", err)
+		}
+		if buf.Len() == 0 || buf.String() == "null" {
+			continue
+		}
+		if err := h.putData(append([]byte(nil), buf.Bytes()...), tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (h *HttpReader) readLine(r *bufio.Reader) (str string, err error) {
 	isPrefix := true
 	var line, fragment []byte
@@ -3,12 +3,15 @@ package reader
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/queue"
 	. "github.com/qiniu/logkit/utils/models"
 
 	"github.com/stretchr/testify/assert"
@@ -76,3 +79,198 @@ func TestNewHttpReader(t *testing.T) {
 		assert.Equal(t, val, got)
 	}
 }
+
+func postHttpReader(t *testing.T, url, contentType, body string, setAuth func(req *http.Request)) int {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set(ContentTypeHeader, contentType)
+	}
+	if setAuth != nil {
+		setAuth(req)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHttpReaderAuthRoutesAndJson(t *testing.T) {
+	c := conf.MapConf{
+		KeyHttpServiceAddress: "127.0.0.1:7111",
+		KeyHttpServicePath:    "nginx=/logs/nginx, /logs/app",
+		KeyHttpAuthToken:      "secret-token",
+		KeyHttpAuthUsername:   "logkit",
+		KeyHttpAuthPassword:   "pass",
+	}
+	readConf := conf.MapConf{
+		KeyMetaPath:   metaDir,
+		KeyFileDone:   metaDir,
+		KeyMode:       ModeHttp,
+		KeyRunnerName: "TestHttpReaderAuthRoutesAndJson",
+	}
+	meta, err := NewMetaWithConf(readConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(metaDir)
+	r, err := NewHttpReader(meta, c)
+	assert.NoError(t, err)
+	httpReader := r.(*HttpReader)
+	assert.NoError(t, httpReader.Start())
+	defer httpReader.Close()
+
+	bearer := func(req *http.Request) { req.Header.Set("Authorization", "Bearer secret-token") }
+	basic := func(req *http.Request) { req.SetBasicAuth("logkit", "pass") }
+
+	assert.Equal(t, http.StatusUnauthorized, postHttpReader(t, "http://127.0.0.1:7111/logs/nginx", "", "no auth", nil))
+	assert.Equal(t, http.StatusUnauthorized, postHttpReader(t, "http://127.0.0.1:7111/logs/nginx", "", "bad token", func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer wrong")
+	}))
+
+	tests := []struct {
+		path        string
+		contentType string
+		body        string
+		auth        func(req *http.Request)
+		exp         []string
+		source      string
+	}{
+		{
+			path:        "/logs/nginx",
+			contentType: "application/json; charset=utf-8",
+			body:        `[{"a": 1}, {"b": "x y"}]`,
+			auth:        bearer,
+			exp:         []string{`{"a":1}`, `{"b":"x y"}`},
+			source:      "nginx",
+		},
+		{
+			path:        "/logs/app",
+			contentType: "application/x-ndjson",
+			body:        "{\"a\":1}\n\n{\"a\":2}\n",
+			auth:        basic,
+			exp:         []string{`{"a":1}`, `{"a":2}`},
+			source:      "/logs/app",
+		},
+		{
+			path:        "/logs/app",
+			contentType: ApplicationJson,
+			body:        "{\n  \"msg\": \"pretty\"\n}\n{\"msg\": \"next\"}",
+			auth:        bearer,
+			exp:         []string{`{"msg":"pretty"}`, `{"msg":"next"}`},
+			source:      "/logs/app",
+		},
+		{
+			// http sender 的 csv 格式同样使用 application/json
+			path:        "/logs/nginx",
+			contentType: ApplicationJson,
+			body:        "a\tb\nc\td",
+			auth:        basic,
+			exp:         []string{"a\tb", "c\td"},
+			source:      "nginx",
+		},
+	}
+	for _, ti := range tests {
+		assert.Equal(t, http.StatusOK, postHttpReader(t, "http://127.0.0.1:7111"+ti.path, ti.contentType, ti.body, ti.auth))
+		for _, exp := range ti.exp {
+			got, err := httpReader.ReadLine()
+			assert.NoError(t, err)
+			assert.Equal(t, exp, got)
+			assert.Equal(t, ti.source, httpReader.Source())
+		}
+	}
+	assert.Equal(t, http.StatusBadRequest, postHttpReader(t, "http://127.0.0.1:7111/logs/app", ApplicationJson, `[{"a":1}`, bearer))
+}
+
+func TestHttpReaderBackpressure(t *testing.T) {
+	c := conf.MapConf{
+		KeyHttpServiceAddress: "127.0.0.1:7112",
+		KeyHttpServicePath:    "/logkit/data",
+		KeyHttpMaxQueueDepth:  "2",
+	}
+	readConf := conf.MapConf{
+		KeyMetaPath:   metaDir,
+		KeyFileDone:   metaDir,
+		KeyMode:       ModeHttp,
+		KeyRunnerName: "TestHttpReaderBackpressure",
+	}
+	meta, err := NewMetaWithConf(readConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(metaDir)
+	r, err := NewHttpReader(meta, c)
+	assert.NoError(t, err)
+	httpReader := r.(*HttpReader)
+	assert.NoError(t, httpReader.Start())
+	defer httpReader.Close()
+
+	url := "http://127.0.0.1:7112/logkit/data"
+	assert.Equal(t, http.StatusOK, postHttpReader(t, url, "", "line1\nline2", nil))
+	assert.Equal(t, http.StatusTooManyRequests, postHttpReader(t, url, "", "line3", nil))
+	assert.Equal(t, "127.0.0.1:7112", httpReader.Source())
+
+	for _, exp := range []string{"line1", "line2"} {
+		got, err := httpReader.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, exp, got)
+	}
+	assert.Equal(t, http.StatusOK, postHttpReader(t, url, "", "line3", nil))
+	got, err := httpReader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "line3", got)
+
+	// 未配置或者配置为 0 时使用默认的队列深度，不会无限增长
+	for _, depth := range []string{"", "0", "-1"} {
+		c := conf.MapConf{KeyHttpServiceAddress: "127.0.0.1:7117"}
+		if depth != "" {
+			c[KeyHttpMaxQueueDepth] = depth
+		}
+		r, err := NewHttpReader(meta, c)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(DefaultHttpMaxQueueDepth), r.(*HttpReader).maxQueueDepth)
+		assert.NoError(t, r.Close())
+	}
+}
+
+type putErrorQueue struct {
+	queue.BackendQueue
+}
+
+func (q putErrorQueue) Put([]byte) error {
+	return errors.New("disk full")
+}
+
+func TestHttpReaderQueueError(t *testing.T) {
+	c := conf.MapConf{
+		KeyHttpServiceAddress: "127.0.0.1:7115",
+		KeyHttpServicePath:    "/logkit/data",
+	}
+	readConf := conf.MapConf{
+		KeyMetaPath:   metaDir,
+		KeyFileDone:   metaDir,
+		KeyMode:       ModeHttp,
+		KeyRunnerName: "TestHttpReaderQueueError",
+	}
+	meta, err := NewMetaWithConf(readConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(metaDir)
+	r, err := NewHttpReader(meta, c)
+	assert.NoError(t, err)
+	httpReader := r.(*HttpReader)
+	httpReader.bufQueue = putErrorQueue{httpReader.bufQueue}
+	assert.NoError(t, httpReader.Start())
+	defer httpReader.Close()
+
+	url := "http://127.0.0.1:7115/logkit/data"
+	assert.Equal(t, http.StatusInternalServerError, postHttpReader(t, url, "", "line1", nil))
+	assert.Equal(t, http.StatusInternalServerError, postHttpReader(t, url, ApplicationJson, `[{"a":1}]`, nil))
+	assert.Equal(t, http.StatusBadRequest, postHttpReader(t, url, ApplicationJson, `[{"a":1}`, nil))
+}
+
+func TestDecodeHttpItem(t *testing.T) {
+	tag, data := decodeHttpItem([]byte(httpTaggedItemPrefix + "app\x00line1"))
+	assert.Equal(t, "app", tag)
+	assert.Equal(t, "line1", string(data))
+
+	// 升级前写入队列的数据没有前缀，即使包含 \x00 也按原始数据读取
+	tag, data = decodeHttpItem([]byte("app\x00line1"))
+	assert.Equal(t, "", tag)
+	assert.Equal(t, "app\x00line1", string(data))
+}
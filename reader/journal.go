@@ -0,0 +1,653 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+const (
+	KeyJournalPollInterval = "journal_poll_interval"
+)
+
+const defaultJournalPath = "/var/log/journal"
+
+// systemd journal 文件格式，参见 https://systemd.io/JOURNAL_FILE_FORMAT/
+const (
+	journalSignature = "LPKSHHRH"
+
+	journalHeaderMinSize = 208
+
+	journalIncompatibleCompressedXZ   = 1 << 0
+	journalIncompatibleCompressedLZ4  = 1 << 1
+	journalIncompatibleKeyedHash      = 1 << 2
+	journalIncompatibleCompressedZSTD = 1 << 3
+	journalIncompatibleCompact        = 1 << 4
+	journalIncompatibleSupported      = journalIncompatibleCompressedXZ | journalIncompatibleCompressedLZ4 |
+		journalIncompatibleKeyedHash | journalIncompatibleCompressedZSTD | journalIncompatibleCompact
+
+	journalObjectHeaderSize = 16
+	journalObjectData       = 1
+	journalObjectEntry      = 3
+
+	journalObjectCompressedXZ   = 1 << 0
+	journalObjectCompressedLZ4  = 1 << 1
+	journalObjectCompressedZSTD = 1 << 2
+
+	journalEntryItemsOffset         = 64
+	journalDataPayloadOffset        = 64
+	journalCompactDataPayloadOffset = 72
+
+	journalDataCacheSize          = 4096
+	journalMaxObjectSize          = 64 * 1024 * 1024
+	journalDefaultPollingInterval = time.Second
+)
+
+// journalHeader 只包含读取时需要的字段
+type journalHeader struct {
+	incompatibleFlags uint32
+	seqnumID          string
+	headerSize        uint64
+	tailObjectOffset  uint64
+}
+
+// journalEntry 是从 journal 文件中解析出的一条日志
+type journalEntry struct {
+	seqnumID  string
+	seqnum    uint64
+	realtime  uint64
+	monotonic uint64
+	bootID    string
+	xorHash   uint64
+	items     []uint64
+	source    *journalFile
+}
+
+func (e *journalEntry) cursor() string {
+	return fmt.Sprintf("s=%s;i=%x;b=%s;m=%x;t=%x;x=%x", e.seqnumID, e.seqnum, e.bootID, e.monotonic, e.realtime, e.xorHash)
+}
+
+type journalField struct {
+	key   string
+	value string
+}
+
+// journalFile 按对象顺序扫描一个 journal 文件，新写入的 entry 会追加在 tail object 之后
+type journalFile struct {
+	path    string
+	f       *os.File
+	info    os.FileInfo
+	header  journalHeader
+	pos     uint64
+	pending *journalEntry
+	removed bool
+
+	dataCache map[uint64]journalField
+}
+
+func openJournalFile(path string, info os.FileInfo) (*journalFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	jf := &journalFile{
+		path:      path,
+		f:         f,
+		info:      info,
+		dataCache: make(map[uint64]journalField),
+	}
+	if err = jf.readHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	jf.pos = jf.header.headerSize
+	return jf, nil
+}
+
+func (jf *journalFile) readHeader() error {
+	buf := make([]byte, journalHeaderMinSize)
+	if _, err := jf.f.ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("read journal header of %v error %v", jf.path, err)
+	}
+	if string(buf[:8]) != journalSignature {
+		return fmt.Errorf("%v is not a journal file", jf.path)
+	}
+	h := journalHeader{
+		incompatibleFlags: binary.LittleEndian.Uint32(buf[12:16]),
+		seqnumID:          hex.EncodeToString(buf[72:88]),
+		headerSize:        binary.LittleEndian.Uint64(buf[88:96]),
+		tailObjectOffset:  binary.LittleEndian.Uint64(buf[136:144]),
+	}
+	if h.incompatibleFlags&^journalIncompatibleSupported != 0 {
+		return fmt.Errorf("journal file %v has unsupported incompatible flags %#x", jf.path, h.incompatibleFlags)
+	}
+	if h.headerSize < journalHeaderMinSize {
+		return fmt.Errorf("journal file %v has invalid header size %v", jf.path, h.headerSize)
+	}
+	jf.header = h
+	return nil
+}
+
+func (jf *journalFile) compact() bool {
+	return jf.header.incompatibleFlags&journalIncompatibleCompact != 0
+}
+
+// readObject 读取 offset 处的完整对象，返回对象类型、标志位以及对象内容(包含对象头)
+func (jf *journalFile) readObject(offset uint64) (typ, flags uint8, obj []byte, err error) {
+	head := make([]byte, journalObjectHeaderSize)
+	if _, err = jf.f.ReadAt(head, int64(offset)); err != nil {
+		return
+	}
+	typ, flags = head[0], head[1]
+	size := binary.LittleEndian.Uint64(head[8:16])
+	if size < journalObjectHeaderSize || size > journalMaxObjectSize {
+		err = fmt.Errorf("invalid object size %v at offset %v of %v", size, offset, jf.path)
+		return
+	}
+	obj = make([]byte, size)
+	_, err = jf.f.ReadAt(obj, int64(offset))
+	return
+}
+
+// skipToTail 跳过文件中已有的全部 entry，用于 read_from 为 newest 的情况
+func (jf *journalFile) skipToTail() error {
+	if err := jf.readHeader(); err != nil {
+		return err
+	}
+	tail := jf.header.tailObjectOffset
+	if tail == 0 {
+		return nil
+	}
+	head := make([]byte, journalObjectHeaderSize)
+	if _, err := jf.f.ReadAt(head, int64(tail)); err != nil {
+		return err
+	}
+	jf.pos = tail + align8(binary.LittleEndian.Uint64(head[8:16]))
+	return nil
+}
+
+// nextEntry 从当前位置向后查找下一个 entry 对象，没有新的 entry 时返回 nil
+func (jf *journalFile) nextEntry() (*journalEntry, error) {
+	if jf.pos > jf.header.tailObjectOffset {
+		// 重新读取 header 以发现 journald 新追加的对象
+		if err := jf.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	for jf.header.tailObjectOffset != 0 && jf.pos <= jf.header.tailObjectOffset {
+		typ, _, obj, err := jf.readObject(jf.pos)
+		if err != nil {
+			return nil, err
+		}
+		offset := jf.pos
+		jf.pos += align8(uint64(len(obj)))
+		if typ != journalObjectEntry {
+			continue
+		}
+		entry, err := jf.parseEntry(obj)
+		if err != nil {
+			return nil, fmt.Errorf("parse entry at offset %v of %v error %v", offset, jf.path, err)
+		}
+		return entry, nil
+	}
+	return nil, nil
+}
+
+func (jf *journalFile) parseEntry(obj []byte) (*journalEntry, error) {
+	if len(obj) < journalEntryItemsOffset {
+		return nil, fmt.Errorf("entry object too small: %v", len(obj))
+	}
+	entry := &journalEntry{
+		seqnumID:  jf.header.seqnumID,
+		seqnum:    binary.LittleEndian.Uint64(obj[16:24]),
+		realtime:  binary.LittleEndian.Uint64(obj[24:32]),
+		monotonic: binary.LittleEndian.Uint64(obj[32:40]),
+		bootID:    hex.EncodeToString(obj[40:56]),
+		xorHash:   binary.LittleEndian.Uint64(obj[56:64]),
+		source:    jf,
+	}
+	items := obj[journalEntryItemsOffset:]
+	if jf.compact() {
+		for i := 0; i+4 <= len(items); i += 4 {
+			entry.items = append(entry.items, uint64(binary.LittleEndian.Uint32(items[i:i+4])))
+		}
+	} else {
+		for i := 0; i+16 <= len(items); i += 16 {
+			entry.items = append(entry.items, binary.LittleEndian.Uint64(items[i:i+8]))
+		}
+	}
+	return entry, nil
+}
+
+// readData 读取 data 对象并解压，返回 FIELD=value 拆分后的结果
+func (jf *journalFile) readData(offset uint64, decoder *zstd.Decoder) (field journalField, err error) {
+	if field, ok := jf.dataCache[offset]; ok {
+		return field, nil
+	}
+	typ, flags, obj, err := jf.readObject(offset)
+	if err != nil {
+		return
+	}
+	if typ != journalObjectData {
+		err = fmt.Errorf("object at offset %v of %v is not a data object", offset, jf.path)
+		return
+	}
+	payloadOffset := journalDataPayloadOffset
+	if jf.compact() {
+		payloadOffset = journalCompactDataPayloadOffset
+	}
+	if len(obj) < payloadOffset {
+		err = fmt.Errorf("data object at offset %v of %v too small", offset, jf.path)
+		return
+	}
+	payload := obj[payloadOffset:]
+	switch {
+	case flags&journalObjectCompressedXZ != 0:
+		err = fmt.Errorf("data object at offset %v of %v is compressed by xz, which is not supported", offset, jf.path)
+		return
+	case flags&journalObjectCompressedLZ4 != 0:
+		if len(payload) < 8 {
+			err = fmt.Errorf("lz4 data object at offset %v of %v too small", offset, jf.path)
+			return
+		}
+		size := binary.LittleEndian.Uint64(payload[:8])
+		if size > journalMaxObjectSize {
+			err = fmt.Errorf("lz4 data object at offset %v of %v has invalid size %v", offset, jf.path, size)
+			return
+		}
+		dst := make([]byte, size)
+		var n int
+		if n, err = lz4.UncompressBlock(payload[8:], dst, 0); err != nil {
+			return
+		}
+		payload = dst[:n]
+	case flags&journalObjectCompressedZSTD != 0:
+		if payload, err = decoder.DecodeAll(payload, nil); err != nil {
+			return
+		}
+	}
+	idx := bytes.IndexByte(payload, '=')
+	if idx <= 0 {
+		err = fmt.Errorf("data object at offset %v of %v has invalid payload", offset, jf.path)
+		return
+	}
+	field = journalField{key: string(payload[:idx]), value: string(payload[idx+1:])}
+	if len(jf.dataCache) >= journalDataCacheSize {
+		jf.dataCache = make(map[uint64]journalField)
+	}
+	jf.dataCache[offset] = field
+	return field, nil
+}
+
+func (jf *journalFile) Close() error {
+	return jf.f.Close()
+}
+
+func align8(n uint64) uint64 {
+	return (n + 7) &^ 7
+}
+
+// journalCursor 是 journalctl 风格的游标，恢复时同一 seqnum_id 下按 seqnum 判断，否则按 realtime 判断
+type journalCursor struct {
+	seqnumID string
+	seqnum   uint64
+	realtime uint64
+}
+
+func parseJournalCursor(cursor string) (c journalCursor, err error) {
+	for _, kv := range strings.Split(cursor, ";") {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			return c, fmt.Errorf("invalid journal cursor %v", cursor)
+		}
+		key, value := kv[:idx], kv[idx+1:]
+		switch key {
+		case "s":
+			c.seqnumID = value
+		case "i":
+			c.seqnum, err = strconv.ParseUint(value, 16, 64)
+		case "t":
+			c.realtime, err = strconv.ParseUint(value, 16, 64)
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid journal cursor %v: %v", cursor, err)
+		}
+	}
+	if c.seqnumID == "" || c.realtime == 0 {
+		return c, fmt.Errorf("invalid journal cursor %v", cursor)
+	}
+	return c, nil
+}
+
+// consumed 判断 entry 是否已经在上次运行时读取过
+func (c *journalCursor) consumed(e *journalEntry) bool {
+	if c.seqnumID == e.seqnumID {
+		return e.seqnum <= c.seqnum
+	}
+	return e.realtime <= c.realtime
+}
+
+// JournalReader 直接解析 systemd journal 文件，不依赖 libsystemd
+type JournalReader struct {
+	meta         *Meta
+	path         string
+	whence       string
+	pollInterval time.Duration
+
+	files   []*journalFile
+	decoder *zstd.Decoder
+	// cursor 为上次运行保存的游标，nil 表示没有保存过
+	cursor      *journalCursor
+	lastCursor  string
+	lastSource  string
+	initialized bool
+
+	status    int32
+	mux       sync.Mutex
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewJournalReader(meta *Meta, conf conf.MapConf) (reader Reader, err error) {
+	path, _ := conf.GetStringOr(KeyLogPath, defaultJournalPath)
+	whence, _ := conf.GetStringOr(KeyWhence, WhenceOldest)
+	pollInterval, _ := conf.GetStringOr(KeyJournalPollInterval, "1s")
+	interval, err := time.ParseDuration(pollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v error %v", KeyJournalPollInterval, err)
+	}
+	if interval <= 0 {
+		interval = journalDefaultPollingInterval
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	jr := &JournalReader{
+		meta:         meta,
+		path:         path,
+		whence:       whence,
+		pollInterval: interval,
+		decoder:      decoder,
+		status:       StatusInit,
+	}
+	cursor, _, err := meta.ReadOffset()
+	if err == nil && cursor != "" {
+		c, cerr := parseJournalCursor(cursor)
+		if cerr != nil {
+			log.Errorf("Runner[%v] %v ignore saved cursor: %v", meta.RunnerName, jr.Name(), cerr)
+		} else {
+			jr.cursor = &c
+			jr.lastCursor = cursor
+		}
+	}
+	return jr, nil
+}
+
+func (jr *JournalReader) Name() string {
+	return "JournalReader:" + jr.path
+}
+
+// Source 返回最近一条日志所在的 journal 文件
+func (jr *JournalReader) Source() string {
+	jr.mux.Lock()
+	defer jr.mux.Unlock()
+	if jr.lastSource == "" {
+		return jr.path
+	}
+	return jr.lastSource
+}
+
+func (jr *JournalReader) SetMode(mode string, v interface{}) error {
+	return errors.New("JournalReader not support read mode")
+}
+
+func (jr *JournalReader) Status() StatsInfo {
+	jr.statsLock.RLock()
+	defer jr.statsLock.RUnlock()
+	return jr.stats
+}
+
+func (jr *JournalReader) setStatsError(err string) {
+	jr.statsLock.Lock()
+	defer jr.statsLock.Unlock()
+	jr.stats.Errors++
+	jr.stats.LastError = err
+}
+
+func (jr *JournalReader) ReadLine() (data string, err error) {
+	if atomic.LoadInt32(&jr.status) == StatusStopped {
+		return "", nil
+	}
+	atomic.CompareAndSwapInt32(&jr.status, StatusInit, StatusRunning)
+	jr.mux.Lock()
+	if atomic.LoadInt32(&jr.status) == StatusStopped {
+		jr.mux.Unlock()
+		return "", nil
+	}
+	entry := jr.next()
+	if entry == nil {
+		// 没有新的日志时重新扫描目录，发现轮转后新建的文件
+		jr.scanFiles()
+		entry = jr.next()
+	}
+	if entry == nil {
+		jr.mux.Unlock()
+		time.Sleep(jr.pollInterval)
+		return "", nil
+	}
+	data, err = jr.format(entry)
+	if err == nil {
+		jr.lastCursor = entry.cursor()
+		jr.lastSource = entry.source.path
+	}
+	jr.mux.Unlock()
+	return
+}
+
+// scanFiles 查找 path 下的 journal 文件，path 可以是单个文件、journal 目录或包含 machine-id 子目录的目录
+func (jr *JournalReader) scanFiles() {
+	paths, err := journalFiles(jr.path)
+	if err != nil {
+		log.Errorf("Runner[%v] %v scan journal files error %v", jr.meta.RunnerName, jr.Name(), err)
+		jr.setStatsError("Runner[" + jr.meta.RunnerName + "] " + jr.Name() + " scan journal files error " + err.Error())
+		return
+	}
+	for _, jf := range jr.files {
+		jf.removed = true
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		var exist bool
+		for _, jf := range jr.files {
+			// journald 轮转时会把文件重命名为 system@....journal，同一个文件继续读取
+			if os.SameFile(jf.info, info) {
+				jf.path = path
+				jf.removed = false
+				exist = true
+				break
+			}
+		}
+		if exist {
+			continue
+		}
+		jf, err := openJournalFile(path, info)
+		if err != nil {
+			log.Errorf("Runner[%v] %v open journal file error %v", jr.meta.RunnerName, jr.Name(), err)
+			jr.setStatsError("Runner[" + jr.meta.RunnerName + "] " + jr.Name() + " open journal file error " + err.Error())
+			continue
+		}
+		// 启动时已经存在的文件在没有游标且从最新开始读取时跳过已有内容，之后新建的文件从头读取
+		if !jr.initialized && jr.cursor == nil && jr.whence == WhenceNewest {
+			if err = jf.skipToTail(); err != nil {
+				log.Errorf("Runner[%v] %v skip journal file %v error %v", jr.meta.RunnerName, jr.Name(), path, err)
+			}
+		}
+		jr.files = append(jr.files, jf)
+		log.Infof("Runner[%v] %v start to read journal file %v", jr.meta.RunnerName, jr.Name(), path)
+	}
+	jr.initialized = true
+}
+
+func journalFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var paths []string
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		sub := filepath.Join(path, fi.Name())
+		if fi.IsDir() {
+			matches, err := filepath.Glob(filepath.Join(sub, "*.journal"))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+			continue
+		}
+		if strings.HasSuffix(fi.Name(), ".journal") {
+			paths = append(paths, sub)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// next 在所有文件中选出时间最早的下一条 entry，已经读取过的 entry 会被跳过
+func (jr *JournalReader) next() *journalEntry {
+	if !jr.initialized {
+		jr.scanFiles()
+	}
+	for {
+		var (
+			chosen *journalFile
+			alive  = jr.files[:0]
+		)
+		for _, jf := range jr.files {
+			if jf.pending == nil {
+				entry, err := jf.nextEntry()
+				if err != nil {
+					log.Errorf("Runner[%v] %v read journal file error %v", jr.meta.RunnerName, jr.Name(), err)
+					jr.setStatsError("Runner[" + jr.meta.RunnerName + "] " + jr.Name() + " read journal file error " + err.Error())
+				}
+				jf.pending = entry
+			}
+			if jf.pending == nil && jf.removed {
+				log.Infof("Runner[%v] %v journal file %v has been removed", jr.meta.RunnerName, jr.Name(), jf.path)
+				jf.Close()
+				continue
+			}
+			alive = append(alive, jf)
+			if jf.pending == nil {
+				continue
+			}
+			if chosen == nil || jf.pending.realtime < chosen.pending.realtime ||
+				(jf.pending.realtime == chosen.pending.realtime && jf.pending.seqnum < chosen.pending.seqnum) {
+				chosen = jf
+			}
+		}
+		jr.files = alive
+		if chosen == nil {
+			return nil
+		}
+		entry := chosen.pending
+		chosen.pending = nil
+		if jr.cursor != nil && jr.cursor.consumed(entry) {
+			continue
+		}
+		return entry
+	}
+}
+
+// format 把 entry 转换为 json，字段名与 journalctl -o json 一致
+func (jr *JournalReader) format(entry *journalEntry) (string, error) {
+	fields := map[string]interface{}{
+		"__CURSOR":              entry.cursor(),
+		"__REALTIME_TIMESTAMP":  strconv.FormatUint(entry.realtime, 10),
+		"__MONOTONIC_TIMESTAMP": strconv.FormatUint(entry.monotonic, 10),
+	}
+	for _, offset := range entry.items {
+		field, err := entry.source.readData(offset, jr.decoder)
+		if err != nil {
+			log.Errorf("Runner[%v] %v read journal field error %v", jr.meta.RunnerName, jr.Name(), err)
+			jr.setStatsError("Runner[" + jr.meta.RunnerName + "] " + jr.Name() + " read journal field error " + err.Error())
+			continue
+		}
+		// 同一个字段出现多次时与 journalctl 一样输出为数组
+		switch old := fields[field.key].(type) {
+		case nil:
+			fields[field.key] = field.value
+		case string:
+			fields[field.key] = []string{old, field.value}
+		case []string:
+			fields[field.key] = append(old, field.value)
+		}
+	}
+	if _, ok := fields["_BOOT_ID"]; !ok {
+		fields["_BOOT_ID"] = entry.bootID
+	}
+	data, err := jsoniter.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SyncMeta 保存最近一次读取的 entry 游标
+func (jr *JournalReader) SyncMeta() {
+	jr.mux.Lock()
+	cursor := jr.lastCursor
+	jr.mux.Unlock()
+	if cursor == "" {
+		return
+	}
+	c, err := parseJournalCursor(cursor)
+	if err != nil {
+		return
+	}
+	if err = jr.meta.WriteOffset(cursor, int64(c.realtime)); err != nil {
+		log.Errorf("Runner[%v] %v write cursor %v error %v", jr.meta.RunnerName, jr.Name(), cursor, err)
+		jr.setStatsError("Runner[" + jr.meta.RunnerName + "] " + jr.Name() + " write cursor error " + err.Error())
+	}
+}
+
+func (jr *JournalReader) Close() error {
+	atomic.StoreInt32(&jr.status, StatusStopped)
+	jr.mux.Lock()
+	defer jr.mux.Unlock()
+	for _, jf := range jr.files {
+		jf.Close()
+	}
+	jr.files = nil
+	jr.decoder.Close()
+	return nil
+}
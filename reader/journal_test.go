@@ -0,0 +1,193 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/journal/system.journal 由 systemd-journald 252 生成(zstd 压缩，compact 模式)，
+// system.json 是同一文件 journalctl -o json 的输出
+func readJournalExpected(t *testing.T) []map[string]interface{} {
+	f, err := os.Open("testdata/journal/system.json")
+	require.NoError(t, err)
+	defer f.Close()
+	var exp []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := map[string]interface{}{}
+		require.NoError(t, jsoniter.Unmarshal(scanner.Bytes(), &m))
+		exp = append(exp, m)
+	}
+	require.NoError(t, scanner.Err())
+	return exp
+}
+
+// journalEntryOffsets 返回文件中所有 entry 对象的 offset
+func journalEntryOffsets(t *testing.T, path string) []uint64 {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	jf, err := openJournalFile(path, info)
+	require.NoError(t, err)
+	defer jf.Close()
+	var offsets []uint64
+	for pos := jf.header.headerSize; pos <= jf.header.tailObjectOffset; {
+		typ, _, obj, err := jf.readObject(pos)
+		require.NoError(t, err)
+		if typ == journalObjectEntry {
+			offsets = append(offsets, pos)
+		}
+		pos += align8(uint64(len(obj)))
+	}
+	return offsets
+}
+
+// setJournalTail 修改 header 中的 tail_object_offset，模拟 journald 追加写入
+func setJournalTail(t *testing.T, path string, tail uint64) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, tail)
+	_, err = f.WriteAt(buf, 136)
+	require.NoError(t, err)
+}
+
+func readJournalLines(t *testing.T, r Reader, n int) []map[string]interface{} {
+	var got []map[string]interface{}
+	for i := 0; i < n+5 && len(got) < n; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line == "" {
+			continue
+		}
+		m := map[string]interface{}{}
+		require.NoError(t, jsoniter.Unmarshal([]byte(line), &m))
+		got = append(got, m)
+	}
+	return got
+}
+
+func TestJournalReader(t *testing.T) {
+	exp := readJournalExpected(t)
+	require.Len(t, exp, 8)
+
+	logDir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(logDir)
+	defer os.RemoveAll(metaDir)
+	machineDir := filepath.Join(logDir, "fed6b2924c424cf1b9a322f606b4de6d")
+	require.NoError(t, os.MkdirAll(machineDir, DefaultDirPerm))
+	data, err := ioutil.ReadFile("testdata/journal/system.journal")
+	require.NoError(t, err)
+	path := filepath.Join(machineDir, "system.journal")
+	require.NoError(t, ioutil.WriteFile(path, data, DefaultFilePerm))
+
+	offsets := journalEntryOffsets(t, path)
+	require.Len(t, offsets, 8)
+	// 只暴露前 5 条 entry
+	setJournalTail(t, path, offsets[4])
+
+	c := conf.MapConf{
+		KeyMetaPath:            metaDir,
+		KeyFileDone:            metaDir,
+		KeyLogPath:             logDir,
+		KeyMode:                ModeJournal,
+		KeyJournalPollInterval: "10ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewJournalReader(meta, c)
+	require.NoError(t, err)
+	assert.Equal(t, "JournalReader:"+logDir, r.Name())
+
+	got := readJournalLines(t, r, 5)
+	assert.Equal(t, exp[:5], got)
+	assert.Equal(t, "sshd.service", got[4]["_SYSTEMD_UNIT"])
+	assert.Equal(t, "5", got[4]["PRIORITY"])
+	assert.Equal(t, path, r.Source())
+
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+
+	// journald 追加写入后继续读取，第 6 条的 MESSAGE 经过 zstd 压缩
+	setJournalTail(t, path, offsets[7])
+	got = readJournalLines(t, r, 1)
+	assert.Equal(t, exp[5:6], got)
+	assert.Equal(t, "nginx.service", got[0]["_SYSTEMD_UNIT"])
+	assert.Equal(t, "3", got[0]["PRIORITY"])
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	assert.Equal(t, int64(0), r.(*JournalReader).Status().Errors)
+
+	cursor, _, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, exp[5]["__CURSOR"], cursor)
+
+	// 重启后从保存的游标继续读取
+	r, err = NewJournalReader(meta, c)
+	require.NoError(t, err)
+	got = readJournalLines(t, r, 2)
+	assert.Equal(t, exp[6:], got)
+	assert.NoError(t, r.Close())
+}
+
+func TestJournalReaderWhenceNewest(t *testing.T) {
+	logDir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(logDir)
+	defer os.RemoveAll(metaDir)
+	data, err := ioutil.ReadFile("testdata/journal/system.journal")
+	require.NoError(t, err)
+	path := filepath.Join(logDir, "system.journal")
+	require.NoError(t, ioutil.WriteFile(path, data, DefaultFilePerm))
+	offsets := journalEntryOffsets(t, path)
+	setJournalTail(t, path, offsets[6])
+
+	c := conf.MapConf{
+		KeyMetaPath:            metaDir,
+		KeyFileDone:            metaDir,
+		KeyLogPath:             path,
+		KeyMode:                ModeJournal,
+		KeyWhence:              WhenceNewest,
+		KeyJournalPollInterval: "10ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewJournalReader(meta, c)
+	require.NoError(t, err)
+	defer r.Close()
+
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+
+	setJournalTail(t, path, offsets[7])
+	got := readJournalLines(t, r, 1)
+	require.Len(t, got, 1)
+	assert.Equal(t, "Journal stopped", got[0]["MESSAGE"])
+}
+
+func TestParseJournalCursor(t *testing.T) {
+	c, err := parseJournalCursor("s=ac46b5a84e95483dbe72634ff92bae10;i=5;b=0b65eada78c44ed780f28370e51f047f;m=a2aab987;t=65e0effeab7cc;x=81e5edab09c79cb6")
+	assert.NoError(t, err)
+	assert.Equal(t, journalCursor{seqnumID: "ac46b5a84e95483dbe72634ff92bae10", seqnum: 5, realtime: 0x65e0effeab7cc}, c)
+	assert.True(t, c.consumed(&journalEntry{seqnumID: c.seqnumID, seqnum: 5, realtime: 1}))
+	assert.False(t, c.consumed(&journalEntry{seqnumID: c.seqnumID, seqnum: 6, realtime: 1}))
+	assert.True(t, c.consumed(&journalEntry{seqnumID: "other", seqnum: 100, realtime: 0x65e0effeab7cc}))
+	assert.False(t, c.consumed(&journalEntry{seqnumID: "other", seqnum: 1, realtime: 0x65e0effeab7cd}))
+
+	_, err = parseJournalCursor("i=5")
+	assert.Error(t, err)
+}
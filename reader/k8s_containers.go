@@ -0,0 +1,471 @@
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlsconfig"
+)
+
+// k8s_containers reader 的配置项
+const (
+	KeyK8sLogDir             = "k8s_log_dir"
+	KeyK8sAPIURL             = "k8s_api_url"
+	KeyK8sAPIType            = "k8s_api_type"
+	KeyK8sTokenFile          = "k8s_token_file"
+	KeyK8sCAFile             = "k8s_ca_file"
+	KeyK8sInsecureSkipVerify = "k8s_insecure_skip_verify"
+	KeyK8sMetadataTTL        = "k8s_metadata_ttl"
+)
+
+// Pod 元数据的来源
+const (
+	K8sAPITypeKubelet   = "kubelet"
+	K8sAPITypeAPIServer = "apiserver"
+)
+
+const (
+	K8sDefaultLogDir = "/var/log/containers"
+
+	// k8sMinListInterval 是 kubelet 模式下两次拉取 pod 列表的最小间隔，避免新 pod 未出现在列表中时频繁请求
+	k8sMinListInterval = 5 * time.Second
+)
+
+// k8sContainerLog 是一行容器日志对应的输出，pod 相关字段与 k8stag transformer 保持一致
+type k8sContainerLog struct {
+	Log           string            `json:"log"`
+	Stream        string            `json:"stream,omitempty"`
+	Time          string            `json:"time,omitempty"`
+	PodName       string            `json:"k8s_pod_name,omitempty"`
+	Namespace     string            `json:"k8s_namespace,omitempty"`
+	ContainerName string            `json:"k8s_container_name,omitempty"`
+	ContainerID   string            `json:"k8s_container_id,omitempty"`
+	Labels        map[string]string `json:"k8s_labels,omitempty"`
+	Annotations   map[string]string `json:"k8s_annotations,omitempty"`
+}
+
+// k8sContainerFile 是从 <pod>_<namespace>_<container>-<container id>.log 文件名中解析出的信息
+type k8sContainerFile struct {
+	podName       string
+	namespace     string
+	containerName string
+	containerID   string
+}
+
+func parseK8sContainerFile(path string) (k8sContainerFile, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".log")
+	splits := strings.SplitN(name, "_", 3)
+	if len(splits) < 3 {
+		return k8sContainerFile{}, fmt.Errorf("%v is not a kubernetes container log file", path)
+	}
+	f := k8sContainerFile{podName: splits[0], namespace: splits[1], containerName: splits[2]}
+	if idx := strings.LastIndex(splits[2], "-"); idx > 0 {
+		f.containerName = splits[2][:idx]
+		f.containerID = splits[2][idx+1:]
+	}
+	return f, nil
+}
+
+// parseK8sContainerLine 解析 docker json-file 或 CRI(containerd、CRI-O) 格式的一行日志，partial 为 true 表示该行日志被拆分且还未结束
+func parseK8sContainerLine(line string) (msg, stream, timestamp string, partial bool, err error) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "{") {
+		// {"log":"message\n","stream":"stdout","time":"2018-01-01T00:00:00.000000000Z"}，超过16K的日志会被拆分，只有最后一段以换行结尾
+		var entry struct {
+			Log    string `json:"log"`
+			Stream string `json:"stream"`
+			Time   string `json:"time"`
+		}
+		if err = jsoniter.Unmarshal([]byte(line), &entry); err != nil {
+			return line, "", "", false, fmt.Errorf("invalid docker json log: %v", err)
+		}
+		partial = !strings.HasSuffix(entry.Log, "\n")
+		return strings.TrimSuffix(entry.Log, "\n"), entry.Stream, entry.Time, partial, nil
+	}
+	// 2018-01-01T00:00:00.000000000Z stdout F message，P 表示被拆分的日志
+	splits := strings.SplitN(line, " ", 4)
+	if len(splits) < 3 || (splits[2] != "F" && splits[2] != "P") {
+		return line, "", "", false, errors.New("invalid CRI log")
+	}
+	if len(splits) == 4 {
+		msg = splits[3]
+	}
+	return msg, splits[1], splits[0], splits[2] == "P", nil
+}
+
+type k8sPartialLog struct {
+	buf       bytes.Buffer
+	timestamp string
+}
+
+// k8sPartialsMetaFile 保存在 meta 目录下，记录尚未合并完成的日志片段。
+// SyncMeta 记录的 offset 已经越过了这些片段，重启后需要从这里恢复，否则前面的片段会丢失
+const k8sPartialsMetaFile = "k8s_partial_logs.json"
+
+type k8sPartialMeta struct {
+	Log       string `json:"log"`
+	Timestamp string `json:"timestamp"`
+}
+
+type k8sPodMeta struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type k8sPodCache struct {
+	meta    *k8sPodMeta
+	fetched time.Time
+}
+
+// k8sMetadataClient 从 kubelet 的 /pods 接口或 apiserver 获取 pod 的 labels 和 annotations，结果缓存 ttl 时间
+type k8sMetadataClient struct {
+	apiURL    string
+	apiType   string
+	tokenFile string
+	ttl       time.Duration
+	client    *http.Client
+
+	mux      sync.Mutex
+	pods     map[string]*k8sPodCache
+	lastList time.Time
+}
+
+type k8sPodObject struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+func (c *k8sMetadataClient) get(path string, v interface{}) (found bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.apiURL, "/")+path, nil)
+	if err != nil {
+		return false, err
+	}
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return false, fmt.Errorf("read k8s token file error %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return false, fmt.Errorf("get %v status %v: %s", path, resp.Status, body)
+	}
+	return true, jsoniter.NewDecoder(resp.Body).Decode(v)
+}
+
+// podMeta 返回 pod 的元数据，pod 不存在时返回 nil，请求失败时返回缓存中的旧数据以及错误
+func (c *k8sMetadataClient) podMeta(namespace, name string) (*k8sPodMeta, error) {
+	key := namespace + "/" + name
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	cache, ok := c.pods[key]
+	if ok && time.Since(cache.fetched) < c.ttl {
+		return cache.meta, nil
+	}
+	if c.apiType == K8sAPITypeKubelet {
+		if time.Since(c.lastList) < k8sMinListInterval {
+			if ok {
+				return cache.meta, nil
+			}
+			return nil, nil
+		}
+		c.lastList = time.Now()
+		var list struct {
+			Items []k8sPodObject `json:"items"`
+		}
+		if _, err := c.get("/pods", &list); err != nil {
+			if ok {
+				return cache.meta, err
+			}
+			return nil, err
+		}
+		c.pods = make(map[string]*k8sPodCache, len(list.Items))
+		for _, pod := range list.Items {
+			c.pods[pod.Metadata.Namespace+"/"+pod.Metadata.Name] = &k8sPodCache{
+				meta:    &k8sPodMeta{Labels: pod.Metadata.Labels, Annotations: pod.Metadata.Annotations},
+				fetched: c.lastList,
+			}
+		}
+		if cache, ok = c.pods[key]; ok {
+			return cache.meta, nil
+		}
+		return nil, nil
+	}
+
+	if !ok {
+		cache = &k8sPodCache{}
+		c.pods[key] = cache
+	}
+	// 失败时同样记录请求时间，ttl 内不再重复请求
+	cache.fetched = time.Now()
+	var pod k8sPodObject
+	found, err := c.get("/api/v1/namespaces/"+url.PathEscape(namespace)+"/pods/"+url.PathEscape(name), &pod)
+	if err != nil {
+		return cache.meta, err
+	}
+	cache.meta = nil
+	if found {
+		cache.meta = &k8sPodMeta{Labels: pod.Metadata.Labels, Annotations: pod.Metadata.Annotations}
+	}
+	return cache.meta, nil
+}
+
+// K8sContainersReader 读取 kubelet 日志目录下所有容器的日志，解析 docker json-file 以及 CRI 格式，
+// 合并被拆分的日志，并附加 pod、namespace、container 以及 labels 和 annotations 等信息
+type K8sContainersReader struct {
+	mr       *MultiReader
+	meta     *Meta
+	logDir   string
+	partials map[string]*k8sPartialLog
+	files    map[string]k8sContainerFile
+	metadata *k8sMetadataClient
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewK8sContainersReader(meta *Meta, c conf.MapConf) (Reader, error) {
+	logDir, _ := c.GetStringOr(KeyK8sLogDir, K8sDefaultLogDir)
+	mrConf := conf.MapConf{}
+	for k, v := range c {
+		mrConf[k] = v
+	}
+	mrConf[KeyLogPath] = filepath.Join(logDir, "*.log")
+	// 容器的创建比较频繁，默认更快地发现新的日志文件
+	if _, ok := mrConf[KeyStatInterval]; !ok {
+		mrConf[KeyStatInterval] = "10s"
+	}
+	mr, err := NewMultiReader(meta, mrConf)
+	if err != nil {
+		return nil, err
+	}
+	kr := &K8sContainersReader{
+		mr:       mr.(*MultiReader),
+		meta:     meta,
+		logDir:   logDir,
+		partials: make(map[string]*k8sPartialLog),
+		files:    make(map[string]k8sContainerFile),
+	}
+	if err = kr.restorePartials(); err != nil {
+		log.Warnf("Runner[%v] %v restore partial logs error %v, ignore them", meta.RunnerName, kr.Name(), err)
+	}
+
+	apiURL, _ := c.GetStringOr(KeyK8sAPIURL, "")
+	if apiURL == "" {
+		return kr, nil
+	}
+	apiType, _ := c.GetStringOr(KeyK8sAPIType, K8sAPITypeKubelet)
+	if apiType != K8sAPITypeKubelet && apiType != K8sAPITypeAPIServer {
+		return nil, fmt.Errorf("unsupported %v %v", KeyK8sAPIType, apiType)
+	}
+	tokenFile, _ := c.GetStringOr(KeyK8sTokenFile, "")
+	caFile, _ := c.GetStringOr(KeyK8sCAFile, "")
+	insecureSkipVerify, _ := c.GetBoolOr(KeyK8sInsecureSkipVerify, false)
+	ttlStr, _ := c.GetStringOr(KeyK8sMetadataTTL, "1m")
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %v error %v", KeyK8sMetadataTTL, ttlStr, err)
+	}
+	tlsConfig, err := tlsconfig.NewClientConfig("", "", caFile, insecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	kr.metadata = &k8sMetadataClient{
+		apiURL:    apiURL,
+		apiType:   apiType,
+		tokenFile: tokenFile,
+		ttl:       ttl,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		pods: make(map[string]*k8sPodCache),
+	}
+	return kr, nil
+}
+
+func (kr *K8sContainersReader) Name() string {
+	return "K8sContainersReader:" + kr.logDir
+}
+
+func (kr *K8sContainersReader) Source() string {
+	return kr.mr.Source()
+}
+
+func (kr *K8sContainersReader) SetMode(mode string, v interface{}) error {
+	return errors.New("K8sContainersReader not support read mode")
+}
+
+func (kr *K8sContainersReader) setStatsError(err string) {
+	kr.statsLock.Lock()
+	defer kr.statsLock.Unlock()
+	kr.stats.Errors++
+	kr.stats.LastError = err
+}
+
+func (kr *K8sContainersReader) Status() StatsInfo {
+	st := kr.mr.Status()
+	kr.statsLock.RLock()
+	defer kr.statsLock.RUnlock()
+	st.Errors += kr.stats.Errors
+	if kr.stats.LastError != "" {
+		st.LastError = kr.stats.LastError
+	}
+	return st
+}
+
+// ReadLine 被拆分的日志在读到最后一段后才会输出，未读完的部分在 SyncMeta 时保存到 meta 目录
+func (kr *K8sContainersReader) ReadLine() (string, error) {
+	line, err := kr.mr.ReadLine()
+	if err != nil || line == "" {
+		return line, err
+	}
+	path := kr.mr.Source()
+	record := k8sContainerLog{}
+	var partial bool
+	record.Log, record.Stream, record.Time, partial, err = parseK8sContainerLine(line)
+	if err != nil {
+		log.Warnf("Runner[%v] %v parse %v error %v, send it as raw log", kr.meta.RunnerName, kr.Name(), path, err)
+		kr.setStatsError(err.Error())
+	}
+	key := path + "\x00" + record.Stream
+	if p, ok := kr.partials[key]; ok {
+		p.buf.WriteString(record.Log)
+		if partial {
+			return "", nil
+		}
+		record.Log, record.Time = p.buf.String(), p.timestamp
+		delete(kr.partials, key)
+	} else if partial {
+		p = &k8sPartialLog{timestamp: record.Time}
+		p.buf.WriteString(record.Log)
+		kr.partials[key] = p
+		return "", nil
+	}
+
+	file, ok := kr.files[path]
+	if !ok {
+		if file, err = parseK8sContainerFile(path); err != nil {
+			log.Warnf("Runner[%v] %v %v", kr.meta.RunnerName, kr.Name(), err)
+		}
+		kr.files[path] = file
+	}
+	record.PodName, record.Namespace = file.podName, file.namespace
+	record.ContainerName, record.ContainerID = file.containerName, file.containerID
+	if kr.metadata != nil && file.podName != "" {
+		podMeta, err := kr.metadata.podMeta(file.namespace, file.podName)
+		if err != nil {
+			log.Warnf("Runner[%v] %v get metadata of pod %v/%v error %v", kr.meta.RunnerName, kr.Name(), file.namespace, file.podName, err)
+			kr.setStatsError(err.Error())
+		}
+		if podMeta != nil {
+			record.Labels, record.Annotations = podMeta.Labels, podMeta.Annotations
+		}
+	}
+	data, err := jsoniter.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (kr *K8sContainersReader) SyncMeta() {
+	kr.mr.SyncMeta()
+	if err := kr.syncPartials(); err != nil {
+		log.Errorf("Runner[%v] %v save partial logs error %v", kr.meta.RunnerName, kr.Name(), err)
+	}
+	// 已经过期不再追踪的文件不需要保留解析结果
+	if len(kr.files) > kr.mr.maxOpenFiles {
+		kr.files = make(map[string]k8sContainerFile)
+	}
+}
+
+func (kr *K8sContainersReader) Close() error {
+	return kr.mr.Close()
+}
+
+func (kr *K8sContainersReader) Lag() (*LagInfo, error) {
+	return kr.mr.Lag()
+}
+
+func (kr *K8sContainersReader) Reset() error {
+	kr.partials = make(map[string]*k8sPartialLog)
+	if err := os.Remove(kr.partialsFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return kr.mr.Reset()
+}
+
+func (kr *K8sContainersReader) partialsFile() string {
+	return filepath.Join(kr.meta.dir, k8sPartialsMetaFile)
+}
+
+func (kr *K8sContainersReader) restorePartials() error {
+	data, err := ioutil.ReadFile(kr.partialsFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var partials map[string]k8sPartialMeta
+	if err = jsoniter.Unmarshal(data, &partials); err != nil {
+		return err
+	}
+	for key, pm := range partials {
+		p := &k8sPartialLog{timestamp: pm.Timestamp}
+		p.buf.WriteString(pm.Log)
+		kr.partials[key] = p
+	}
+	return nil
+}
+
+// syncPartials 先写临时文件再重命名，避免写入过程中退出导致文件损坏
+func (kr *K8sContainersReader) syncPartials() error {
+	path := kr.partialsFile()
+	if len(kr.partials) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	partials := make(map[string]k8sPartialMeta, len(kr.partials))
+	for key, p := range kr.partials {
+		partials[key] = k8sPartialMeta{Log: p.buf.String(), Timestamp: p.timestamp}
+	}
+	data, err := jsoniter.Marshal(partials)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, DefaultFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
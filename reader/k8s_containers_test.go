@@ -0,0 +1,177 @@
+package reader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestK8sContainersReader(t *testing.T) {
+	dir := "TestK8sContainersReader"
+	logDir := filepath.Join(dir, "containers")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+	defer os.RemoveAll(dir)
+
+	createTestFile(filepath.Join(logDir, "web-1_default_nginx-abc123.log"),
+		`{"log":"GET /index\n","stream":"stdout","time":"2018-01-01T00:00:00Z"}`+"\n"+
+			`{"log":"long ","stream":"stderr","time":"2018-01-01T00:00:01Z"}`+"\n"+
+			`{"log":"line\n","stream":"stderr","time":"2018-01-01T00:00:02Z"}`+"\n")
+	createTestFile(filepath.Join(logDir, "api-2_prod_app-def456.log"),
+		"2018-01-01T00:00:03Z stdout P part1 \n"+
+			"2018-01-01T00:00:04Z stderr F error\n"+
+			"2018-01-01T00:00:05Z stdout F part2\n")
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, "/pods", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"items":[{"metadata":{"name":"web-1","namespace":"default","labels":{"app":"web"},"annotations":{"team":"a"}}}]}`)
+	}))
+	defer srv.Close()
+	tokenFile := filepath.Join(dir, "token")
+	createTestFile(tokenFile, "secret\n")
+
+	readerConf := conf.MapConf{
+		KeyMetaPath:     filepath.Join(dir, "meta"),
+		KeyFileDone:     filepath.Join(dir, "meta"),
+		KeyMode:         ModeK8sContainers,
+		KeyK8sLogDir:    logDir,
+		KeyK8sAPIURL:    srv.URL,
+		KeyK8sTokenFile: tokenFile,
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewK8sContainersReader(meta, readerConf)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var records []k8sContainerLog
+	for i := 0; i < 20 && len(records) < 4; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line == "" {
+			continue
+		}
+		var record k8sContainerLog
+		assert.NoError(t, jsoniter.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Time < records[j].Time })
+	assert.Equal(t, []k8sContainerLog{
+		{Log: "GET /index", Stream: "stdout", Time: "2018-01-01T00:00:00Z", PodName: "web-1", Namespace: "default", ContainerName: "nginx", ContainerID: "abc123",
+			Labels: map[string]string{"app": "web"}, Annotations: map[string]string{"team": "a"}},
+		{Log: "long line", Stream: "stderr", Time: "2018-01-01T00:00:01Z", PodName: "web-1", Namespace: "default", ContainerName: "nginx", ContainerID: "abc123",
+			Labels: map[string]string{"app": "web"}, Annotations: map[string]string{"team": "a"}},
+		{Log: "part1 part2", Stream: "stdout", Time: "2018-01-01T00:00:03Z", PodName: "api-2", Namespace: "prod", ContainerName: "app", ContainerID: "def456"},
+		{Log: "error", Stream: "stderr", Time: "2018-01-01T00:00:04Z", PodName: "api-2", Namespace: "prod", ContainerName: "app", ContainerID: "def456"},
+	}, records)
+	// 不存在的 pod 不会在最小间隔内重复拉取列表
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests))
+}
+
+func TestK8sMetadataAPIServer(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/api/v1/namespaces/default/pods/web-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"metadata":{"name":"web-1","namespace":"default","labels":{"app":"web"}}}`)
+	}))
+	defer srv.Close()
+
+	c := &k8sMetadataClient{apiURL: srv.URL, apiType: K8sAPITypeAPIServer, ttl: 1 << 62, client: srv.Client(), pods: make(map[string]*k8sPodCache)}
+	for i := 0; i < 2; i++ {
+		podMeta, err := c.podMeta("default", "web-1")
+		assert.NoError(t, err)
+		assert.Equal(t, &k8sPodMeta{Labels: map[string]string{"app": "web"}}, podMeta)
+		podMeta, err = c.podMeta("default", "gone")
+		assert.NoError(t, err)
+		assert.Nil(t, podMeta)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+}
+
+func TestParseK8sContainerLine(t *testing.T) {
+	msg, stream, ts, partial, err := parseK8sContainerLine("2018-01-01T00:00:00Z stdout F \n")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"", "stdout", "2018-01-01T00:00:00Z", false}, []interface{}{msg, stream, ts, partial})
+
+	msg, _, _, _, err = parseK8sContainerLine("plain text")
+	assert.Error(t, err)
+	assert.Equal(t, "plain text", msg)
+
+	_, err = parseK8sContainerFile("/var/log/containers/invalid.log")
+	assert.Error(t, err)
+}
+
+func TestK8sContainersReaderPartialRestart(t *testing.T) {
+	dir := "TestK8sContainersReaderPartialRestart"
+	logDir := filepath.Join(dir, "containers")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(logDir, "api-2_prod_app-def456.log")
+	createTestFile(logFile, "2018-01-01T00:00:03Z stdout P part1 \n")
+
+	readerConf := conf.MapConf{
+		KeyMetaPath:  filepath.Join(dir, "meta"),
+		KeyFileDone:  filepath.Join(dir, "meta"),
+		KeyMode:      ModeK8sContainers,
+		KeyK8sLogDir: logDir,
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewK8sContainersReader(meta, readerConf)
+	require.NoError(t, err)
+	kr := r.(*K8sContainersReader)
+	for i := 0; i < 20 && len(kr.partials) == 0; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, "", line)
+	}
+	require.Len(t, kr.partials, 1)
+	// offset 已经越过了第一段，重启前需要保存未合并完成的片段
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	_, err = os.Stat(kr.partialsFile())
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("2018-01-01T00:00:04Z stdout F part2\n")
+	require.NoError(t, err)
+	f.Close()
+
+	meta, err = NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err = NewK8sContainersReader(meta, readerConf)
+	require.NoError(t, err)
+	defer r.Close()
+	var line string
+	for i := 0; i < 20 && line == ""; i++ {
+		line, err = r.ReadLine()
+		assert.NoError(t, err)
+	}
+	var record k8sContainerLog
+	require.NoError(t, jsoniter.Unmarshal([]byte(line), &record))
+	assert.Equal(t, "part1 part2", record.Log)
+	assert.Equal(t, "2018-01-01T00:00:03Z", record.Time)
+
+	// 合并完成后删除保存的片段
+	r.SyncMeta()
+	_, err = os.Stat(kr.partialsFile())
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,705 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlsconfig"
+
+	"github.com/Shopify/sarama"
+	"github.com/qiniu/logkit/conf"
+)
+
+const (
+	KeyKafkaBrokers           = "kafka_brokers"
+	KeyKafkaSessionTimeout    = "kafka_session_timeout"
+	KeyKafkaHeartbeatInterval = "kafka_heartbeat_interval"
+	KeyKafkaClientID          = "kafka_client_id"
+	KeyKafkaTLSEnable         = "kafka_tls_enable"
+	KeyKafkaTLSCert           = "kafka_tls_cert"
+	KeyKafkaTLSKey            = "kafka_tls_key"
+	KeyKafkaTLSCA             = "kafka_tls_ca"
+	KeyKafkaTLSSkipVerify     = "kafka_tls_insecure_skip_verify"
+	KeyKafkaSASLUsername      = "kafka_sasl_username"
+	KeyKafkaSASLPassword      = "kafka_sasl_password"
+)
+
+const (
+	kafkaProtocolType  = "consumer"
+	kafkaRangeProtocol = "range"
+)
+
+// KafkaBrokerReader 通过 kafka broker 自身的 group coordinator 加入消费组，offset 提交到 kafka 中，不再依赖 zookeeper
+type KafkaBrokerReader struct {
+	meta              *Meta
+	ConsumerGroup     string
+	Topics            []string
+	Brokers           []string
+	Whence            string
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+
+	config   *sarama.Config
+	client   sarama.Client
+	consumer sarama.Consumer
+
+	// 当前消费组中的身份，rebalance 之后会变化
+	memberID     string
+	generationID int32
+	assignment   map[string][]int32
+
+	readChan chan *sarama.ConsumerMessage
+
+	status   int32
+	mux      *sync.Mutex
+	startMux *sync.Mutex
+	started  bool
+
+	// curOffsets 记录每个 partition 下一条待读取的 offset
+	curOffsets map[string]map[int32]int64
+	// syncedOffsets 记录 runner 发送成功后 SyncMeta 时的 curOffsets，只有这些 offset 会提交
+	syncedOffsets map[string]map[int32]int64
+	stats         StatsInfo
+	statsLock     *sync.RWMutex
+}
+
+func NewKafkaBrokerReader(meta *Meta, conf conf.MapConf) (kr Reader, err error) {
+	whence, _ := conf.GetStringOr(KeyWhence, WhenceOldest)
+	consumerGroup, err := conf.GetString(KeyKafkaGroupID)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := conf.GetStringList(KeyKafkaTopic)
+	if err != nil {
+		return nil, err
+	}
+	brokers, err := conf.GetStringList(KeyKafkaBrokers)
+	if err != nil {
+		return nil, err
+	}
+	sessionTimeoutStr, _ := conf.GetStringOr(KeyKafkaSessionTimeout, "30s")
+	sessionTimeout, err := time.ParseDuration(sessionTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %v error %v", KeyKafkaSessionTimeout, sessionTimeoutStr, err)
+	}
+	heartbeatStr, _ := conf.GetStringOr(KeyKafkaHeartbeatInterval, "3s")
+	heartbeat, err := time.ParseDuration(heartbeatStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %v error %v", KeyKafkaHeartbeatInterval, heartbeatStr, err)
+	}
+	if heartbeat <= 0 || heartbeat >= sessionTimeout {
+		return nil, fmt.Errorf("%v %v must be positive and less than %v %v", KeyKafkaHeartbeatInterval, heartbeat, KeyKafkaSessionTimeout, sessionTimeout)
+	}
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_0_0
+	clientID, _ := conf.GetStringOr(KeyKafkaClientID, "logkit")
+	config.ClientID = clientID
+	// JoinGroup 请求最长会阻塞一个 session timeout，读超时需要比它更长
+	config.Net.ReadTimeout = sessionTimeout + 10*time.Second
+
+	tlsEnable, _ := conf.GetBoolOr(KeyKafkaTLSEnable, false)
+	certFile, _ := conf.GetStringOr(KeyKafkaTLSCert, "")
+	keyFile, _ := conf.GetStringOr(KeyKafkaTLSKey, "")
+	caFile, _ := conf.GetStringOr(KeyKafkaTLSCA, "")
+	skipVerify, _ := conf.GetBoolOr(KeyKafkaTLSSkipVerify, false)
+	if tlsEnable || certFile != "" || caFile != "" {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config, err = tlsconfig.NewClientConfig(certFile, keyFile, caFile, skipVerify)
+		if err != nil {
+			return nil, err
+		}
+	}
+	saslUser, _ := conf.GetStringOr(KeyKafkaSASLUsername, "")
+	if saslUser != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = saslUser
+		config.Net.SASL.Password, _ = conf.GetStringOr(KeyKafkaSASLPassword, "")
+	}
+	switch strings.ToLower(whence) {
+	case WhenceOldest, "":
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case WhenceNewest:
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		log.Warnf("Runner[%v] WARNING: Kafka consumer invalid offset '%s', using 'oldest'\n", meta.RunnerName, whence)
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	config.Consumer.Return.Errors = true
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+
+	kr = &KafkaBrokerReader{
+		meta:              meta,
+		ConsumerGroup:     consumerGroup,
+		Topics:            topics,
+		Brokers:           brokers,
+		Whence:            whence,
+		SessionTimeout:    sessionTimeout,
+		HeartbeatInterval: heartbeat,
+		config:            config,
+		memberID:          "",
+		readChan:          make(chan *sarama.ConsumerMessage),
+		status:            StatusInit,
+		mux:               new(sync.Mutex),
+		startMux:          new(sync.Mutex),
+		statsLock:         new(sync.RWMutex),
+		curOffsets:        make(map[string]map[int32]int64),
+		started:           false,
+	}
+	return kr, nil
+}
+
+func (kr *KafkaBrokerReader) Name() string {
+	return fmt.Sprintf("KafkaBrokerReader:[%s],[%s]", strings.Join(kr.Topics, ","), kr.ConsumerGroup)
+}
+
+func (kr *KafkaBrokerReader) Source() string {
+	return fmt.Sprintf("[%s],[%s]", strings.Join(kr.Topics, ","), kr.ConsumerGroup)
+}
+
+func (kr *KafkaBrokerReader) Status() StatsInfo {
+	kr.statsLock.RLock()
+	defer kr.statsLock.RUnlock()
+	return kr.stats
+}
+
+func (kr *KafkaBrokerReader) setStatsError(err string) {
+	kr.statsLock.Lock()
+	defer kr.statsLock.Unlock()
+	kr.stats.Errors++
+	kr.stats.LastError = err
+}
+
+func (kr *KafkaBrokerReader) SetMode(mode string, v interface{}) error {
+	return errors.New("KafkaBrokerReader not support read mode")
+}
+
+func (kr *KafkaBrokerReader) ReadLine() (data string, err error) {
+	if !kr.started {
+		kr.Start()
+	}
+	timer := time.NewTimer(time.Second)
+	select {
+	case msg := <-kr.readChan:
+		if msg != nil {
+			data = string(msg.Value)
+		}
+	case <-timer.C:
+	}
+	timer.Stop()
+	return
+}
+
+func (kr *KafkaBrokerReader) Start() {
+	kr.startMux.Lock()
+	defer kr.startMux.Unlock()
+	if kr.started {
+		return
+	}
+	client, err := sarama.NewClient(kr.Brokers, kr.config)
+	if err != nil {
+		log.Errorf("Runner[%v] %v connect to brokers %v error %v", kr.meta.RunnerName, kr.Name(), kr.Brokers, err)
+		kr.setStatsError("Runner[" + kr.meta.RunnerName + "] " + kr.Name() + " connect to brokers error " + err.Error())
+		return
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		log.Errorf("Runner[%v] %v create consumer error %v", kr.meta.RunnerName, kr.Name(), err)
+		client.Close()
+		return
+	}
+	kr.client = client
+	kr.consumer = consumer
+	go kr.run()
+	kr.started = true
+	log.Infof("Runner[%v] %v pull data daemon started", kr.meta.RunnerName, kr.Name())
+}
+
+func (kr *KafkaBrokerReader) Close() (err error) {
+	if atomic.CompareAndSwapInt32(&kr.status, StatusRunning, StatusStopping) {
+		log.Infof("Runner[%v] %v stopping", kr.meta.RunnerName, kr.Name())
+		return
+	}
+	atomic.StoreInt32(&kr.status, StatusStopped)
+	return
+}
+
+// SyncMeta 将已经读取的 offset 提交到 kafka 的 group coordinator
+func (kr *KafkaBrokerReader) SyncMeta() {
+	kr.statsLock.Lock()
+	kr.syncedOffsets = copyOffsets(kr.curOffsets)
+	kr.statsLock.Unlock()
+	kr.commitSynced()
+}
+
+// commitSynced 提交 syncedOffsets，已经交给 ReadLine 但是还没有发送成功的数据不会提交
+func (kr *KafkaBrokerReader) commitSynced() {
+	if err := kr.commit(); err != nil {
+		log.Errorf("Runner[%v] %v commit offsets error %v", kr.meta.RunnerName, kr.Name(), err)
+		kr.setStatsError("Runner[" + kr.meta.RunnerName + "] " + kr.Name() + " commit offsets error " + err.Error())
+	}
+}
+
+func (kr *KafkaBrokerReader) commit() error {
+	kr.mux.Lock()
+	defer kr.mux.Unlock()
+	if kr.client == nil || kr.memberID == "" {
+		return nil
+	}
+	req := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           kr.ConsumerGroup,
+		ConsumerGroupGeneration: kr.generationID,
+		ConsumerID:              kr.memberID,
+		RetentionTime:           -1,
+	}
+	count := 0
+	kr.statsLock.RLock()
+	for topic, partitions := range kr.syncedOffsets {
+		for partition, offset := range partitions {
+			req.AddBlock(topic, partition, offset, 0, "")
+			count++
+		}
+	}
+	kr.statsLock.RUnlock()
+	if count == 0 {
+		return nil
+	}
+	coordinator, err := kr.client.Coordinator(kr.ConsumerGroup)
+	if err != nil {
+		return err
+	}
+	resp, err := coordinator.CommitOffset(req)
+	if err != nil {
+		kr.client.RefreshCoordinator(kr.ConsumerGroup)
+		return err
+	}
+	for topic, errs := range resp.Errors {
+		for partition, kerr := range errs {
+			if kerr != sarama.ErrNoError {
+				return fmt.Errorf("commit offset of topic %v partition %v error %v", topic, partition, kerr)
+			}
+		}
+	}
+	return nil
+}
+
+func (kr *KafkaBrokerReader) run() {
+	// 防止并发run
+	for {
+		if atomic.LoadInt32(&kr.status) == StatusStopped {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&kr.status, StatusInit, StatusRunning) {
+			break
+		}
+	}
+	defer func() {
+		kr.leave()
+		kr.consumer.Close()
+		kr.client.Close()
+		atomic.StoreInt32(&kr.status, StatusStopped)
+		log.Infof("Runner[%v] %v successfully finished", kr.meta.RunnerName, kr.Name())
+	}()
+	for atomic.LoadInt32(&kr.status) == StatusRunning {
+		if err := kr.join(); err != nil {
+			log.Errorf("Runner[%v] %v join consumer group error %v, retry after %v", kr.meta.RunnerName, kr.Name(), err, kr.HeartbeatInterval)
+			kr.setStatsError("Runner[" + kr.meta.RunnerName + "] " + kr.Name() + " join consumer group error " + err.Error())
+			time.Sleep(kr.HeartbeatInterval)
+			continue
+		}
+		kr.consume()
+	}
+}
+
+// join 加入消费组并获取本成员被分配的 partition，由 leader 负责计算分配方案
+func (kr *KafkaBrokerReader) join() error {
+	coordinator, err := kr.client.Coordinator(kr.ConsumerGroup)
+	if err != nil {
+		kr.client.RefreshCoordinator(kr.ConsumerGroup)
+		return err
+	}
+	kr.mux.Lock()
+	memberID := kr.memberID
+	kr.mux.Unlock()
+
+	joinReq := &sarama.JoinGroupRequest{
+		GroupId:        kr.ConsumerGroup,
+		MemberId:       memberID,
+		SessionTimeout: int32(kr.SessionTimeout / time.Millisecond),
+		ProtocolType:   kafkaProtocolType,
+	}
+	err = joinReq.AddGroupProtocolMetadata(kafkaRangeProtocol, &sarama.ConsumerGroupMemberMetadata{
+		Version: 1,
+		Topics:  kr.Topics,
+	})
+	if err != nil {
+		return err
+	}
+	joinResp, err := coordinator.JoinGroup(joinReq)
+	if err != nil {
+		kr.client.RefreshCoordinator(kr.ConsumerGroup)
+		return err
+	}
+	switch joinResp.Err {
+	case sarama.ErrNoError:
+	case sarama.ErrUnknownMemberId:
+		kr.mux.Lock()
+		kr.memberID = ""
+		kr.mux.Unlock()
+		return joinResp.Err
+	case sarama.ErrNotCoordinatorForConsumer:
+		kr.client.RefreshCoordinator(kr.ConsumerGroup)
+		return joinResp.Err
+	default:
+		return joinResp.Err
+	}
+
+	syncReq := &sarama.SyncGroupRequest{
+		GroupId:      kr.ConsumerGroup,
+		GenerationId: joinResp.GenerationId,
+		MemberId:     joinResp.MemberId,
+	}
+	if joinResp.LeaderId == joinResp.MemberId {
+		members, err := joinResp.GetMembers()
+		if err != nil {
+			return err
+		}
+		partitions, err := kr.topicPartitions(members)
+		if err != nil {
+			return err
+		}
+		for member, topics := range rangeAssign(members, partitions) {
+			err = syncReq.AddGroupAssignmentMember(member, &sarama.ConsumerGroupMemberAssignment{
+				Version: 1,
+				Topics:  topics,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	syncResp, err := coordinator.SyncGroup(syncReq)
+	if err != nil {
+		kr.client.RefreshCoordinator(kr.ConsumerGroup)
+		return err
+	}
+	if syncResp.Err != sarama.ErrNoError {
+		return syncResp.Err
+	}
+	assignment := make(map[string][]int32)
+	// 没有分配到任何 partition 的成员 MemberAssignment 为空
+	if len(syncResp.MemberAssignment) > 0 {
+		memberAssignment, err := syncResp.GetMemberAssignment()
+		if err != nil {
+			return err
+		}
+		assignment = memberAssignment.Topics
+	}
+
+	kr.mux.Lock()
+	kr.memberID = joinResp.MemberId
+	kr.generationID = joinResp.GenerationId
+	kr.assignment = assignment
+	kr.mux.Unlock()
+	log.Infof("Runner[%v] %v joined group generation %v as member %v, assigned partitions %v", kr.meta.RunnerName, kr.Name(), joinResp.GenerationId, joinResp.MemberId, assignment)
+	return nil
+}
+
+func (kr *KafkaBrokerReader) topicPartitions(members map[string]sarama.ConsumerGroupMemberMetadata) (map[string][]int32, error) {
+	partitions := make(map[string][]int32)
+	for _, meta := range members {
+		for _, topic := range meta.Topics {
+			if _, ok := partitions[topic]; ok {
+				continue
+			}
+			ps, err := kr.client.Partitions(topic)
+			if err != nil {
+				return nil, err
+			}
+			partitions[topic] = ps
+		}
+	}
+	return partitions, nil
+}
+
+// rangeAssign 与 kafka 默认的 range 分配策略一致，每个 topic 按顺序将 partition 平均分给订阅的成员
+func rangeAssign(members map[string]sarama.ConsumerGroupMemberMetadata, partitions map[string][]int32) map[string]map[string][]int32 {
+	result := make(map[string]map[string][]int32)
+	for member := range members {
+		result[member] = make(map[string][]int32)
+	}
+	for topic, ps := range partitions {
+		var consumers []string
+		for member, meta := range members {
+			for _, t := range meta.Topics {
+				if t == topic {
+					consumers = append(consumers, member)
+					break
+				}
+			}
+		}
+		if len(consumers) == 0 {
+			continue
+		}
+		sort.Strings(consumers)
+		sorted := append([]int32{}, ps...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		per, extra := len(sorted)/len(consumers), len(sorted)%len(consumers)
+		start := 0
+		for i, member := range consumers {
+			n := per
+			if i < extra {
+				n++
+			}
+			if n > 0 {
+				result[member][topic] = sorted[start : start+n]
+			}
+			start += n
+		}
+	}
+	return result
+}
+
+// consume 消费当前分配到的 partition，直到发生 rebalance 或者 reader 被关闭
+func (kr *KafkaBrokerReader) consume() {
+	kr.mux.Lock()
+	assignment := kr.assignment
+	kr.mux.Unlock()
+
+	offsets, err := kr.fetchOffsets(assignment)
+	if err != nil {
+		log.Errorf("Runner[%v] %v fetch committed offsets error %v", kr.meta.RunnerName, kr.Name(), err)
+		kr.setStatsError("Runner[" + kr.meta.RunnerName + "] " + kr.Name() + " fetch committed offsets error " + err.Error())
+		time.Sleep(kr.HeartbeatInterval)
+		return
+	}
+	// forward 会并发更新 curOffsets，这里保存一份副本
+	kr.statsLock.Lock()
+	kr.curOffsets = copyOffsets(offsets)
+	kr.syncedOffsets = copyOffsets(offsets)
+	kr.statsLock.Unlock()
+
+	dying := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	var pcs []sarama.PartitionConsumer
+	defer func() {
+		close(dying)
+		wg.Wait()
+		for _, pc := range pcs {
+			if err := pc.Close(); err != nil {
+				log.Warnf("Runner[%v] %v close partition consumer error %v", kr.meta.RunnerName, kr.Name(), err)
+			}
+		}
+		// 重新加入消费组之前只提交 runner 已经确认发送的 offset，还没有发送的数据由新分配到 partition 的成员重新消费
+		kr.commitSynced()
+	}()
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			pc, err := kr.consumer.ConsumePartition(topic, partition, offset)
+			if err == sarama.ErrOffsetOutOfRange {
+				log.Warnf("Runner[%v] %v topic %v partition %v offset %v out of range, reset to initial offset", kr.meta.RunnerName, kr.Name(), topic, partition, offset)
+				pc, err = kr.consumer.ConsumePartition(topic, partition, kr.config.Consumer.Offsets.Initial)
+			}
+			if err != nil {
+				log.Errorf("Runner[%v] %v consume topic %v partition %v error %v", kr.meta.RunnerName, kr.Name(), topic, partition, err)
+				kr.setStatsError("Runner[" + kr.meta.RunnerName + "] " + kr.Name() + " consume partition error " + err.Error())
+				time.Sleep(kr.HeartbeatInterval)
+				return
+			}
+			pcs = append(pcs, pc)
+			wg.Add(1)
+			go kr.forward(pc, dying, wg)
+		}
+	}
+
+	ticker := time.NewTicker(kr.HeartbeatInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&kr.status) == StatusRunning {
+		<-ticker.C
+		if err := kr.heartbeat(); err != nil {
+			log.Warnf("Runner[%v] %v heartbeat error %v, rejoin consumer group", kr.meta.RunnerName, kr.Name(), err)
+			return
+		}
+	}
+}
+
+// forward 把消息交给 ReadLine，只有成功交出去的消息才会记录 offset
+func (kr *KafkaBrokerReader) forward(pc sarama.PartitionConsumer, dying <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-dying:
+			return
+		case err, ok := <-pc.Errors():
+			if !ok {
+				return
+			}
+			log.Errorf("Runner[%v] Consumer Error: %s\n", kr.meta.RunnerName, err)
+			kr.setStatsError("Runner[" + kr.meta.RunnerName + "] Consumer Error: " + err.Error())
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				return
+			}
+			if len(msg.Value) > 0 {
+				select {
+				case kr.readChan <- msg:
+				case <-dying:
+					return
+				}
+			}
+			kr.statsLock.Lock()
+			if tp, ok := kr.curOffsets[msg.Topic]; ok {
+				tp[msg.Partition] = msg.Offset + 1
+			}
+			kr.statsLock.Unlock()
+		}
+	}
+}
+
+// fetchOffsets 获取 assignment 中每个 partition 已提交的 offset，没有提交过的按照 read_from 初始化
+func (kr *KafkaBrokerReader) fetchOffsets(assignment map[string][]int32) (map[string]map[int32]int64, error) {
+	offsets := make(map[string]map[int32]int64)
+	if len(assignment) == 0 {
+		return offsets, nil
+	}
+	req := &sarama.OffsetFetchRequest{
+		Version:       1,
+		ConsumerGroup: kr.ConsumerGroup,
+	}
+	for topic, partitions := range assignment {
+		for _, partition := range partitions {
+			req.AddPartition(topic, partition)
+		}
+	}
+	coordinator, err := kr.client.Coordinator(kr.ConsumerGroup)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		kr.client.RefreshCoordinator(kr.ConsumerGroup)
+		return nil, err
+	}
+	for topic, partitions := range assignment {
+		offsets[topic] = make(map[int32]int64)
+		for _, partition := range partitions {
+			offset := int64(-1)
+			if block := resp.GetBlock(topic, partition); block != nil {
+				if block.Err != sarama.ErrNoError {
+					return nil, block.Err
+				}
+				offset = block.Offset
+			}
+			if offset < 0 {
+				offset, err = kr.client.GetOffset(topic, partition, kr.config.Consumer.Offsets.Initial)
+				if err != nil {
+					return nil, err
+				}
+			}
+			offsets[topic][partition] = offset
+		}
+	}
+	return offsets, nil
+}
+
+func (kr *KafkaBrokerReader) heartbeat() error {
+	kr.mux.Lock()
+	req := &sarama.HeartbeatRequest{
+		GroupId:      kr.ConsumerGroup,
+		MemberId:     kr.memberID,
+		GenerationId: kr.generationID,
+	}
+	kr.mux.Unlock()
+	coordinator, err := kr.client.Coordinator(kr.ConsumerGroup)
+	if err != nil {
+		return err
+	}
+	resp, err := coordinator.Heartbeat(req)
+	if err != nil {
+		kr.client.RefreshCoordinator(kr.ConsumerGroup)
+		return err
+	}
+	switch resp.Err {
+	case sarama.ErrNoError:
+		return nil
+	case sarama.ErrUnknownMemberId:
+		kr.mux.Lock()
+		kr.memberID = ""
+		kr.mux.Unlock()
+	}
+	return resp.Err
+}
+
+func (kr *KafkaBrokerReader) leave() {
+	kr.commitSynced()
+	kr.mux.Lock()
+	defer kr.mux.Unlock()
+	if kr.memberID == "" {
+		return
+	}
+	coordinator, err := kr.client.Coordinator(kr.ConsumerGroup)
+	if err == nil {
+		_, err = coordinator.LeaveGroup(&sarama.LeaveGroupRequest{
+			GroupId:  kr.ConsumerGroup,
+			MemberId: kr.memberID,
+		})
+	}
+	if err != nil {
+		log.Warnf("Runner[%v] %v leave consumer group error %v", kr.meta.RunnerName, kr.Name(), err)
+	}
+	kr.memberID = ""
+}
+
+// Lag 返回当前分配到的所有 partition 的 lag 之和，每个 partition 的 lag 记录在 Details 中
+func (kr *KafkaBrokerReader) Lag() (rl *LagInfo, err error) {
+	kr.startMux.Lock()
+	client := kr.client
+	kr.startMux.Unlock()
+	if client == nil || client.Closed() {
+		return nil, errors.New("kafka client is closed")
+	}
+	kr.statsLock.RLock()
+	offsets := copyOffsets(kr.curOffsets)
+	kr.statsLock.RUnlock()
+
+	rl = &LagInfo{
+		SizeUnit: "records",
+		Details:  make(map[string]int64),
+	}
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, err
+			}
+			lag := newest - offset
+			if lag < 0 {
+				lag = 0
+			}
+			rl.Details[fmt.Sprintf("%s:%d", topic, partition)] = lag
+			rl.Size += lag
+		}
+	}
+	return
+}
+
+func copyOffsets(offsets map[string]map[int32]int64) map[string]map[int32]int64 {
+	ret := make(map[string]map[int32]int64, len(offsets))
+	for topic, partitions := range offsets {
+		ret[topic] = make(map[int32]int64, len(partitions))
+		for partition, offset := range partitions {
+			ret[topic][partition] = offset
+		}
+	}
+	return ret
+}
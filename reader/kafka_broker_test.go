@@ -0,0 +1,327 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/qiniu/logkit/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+// encodeMemberAssignment 按照 kafka consumer 协议编码 SyncGroup 返回的分配方案
+func encodeMemberAssignment(topic string, partitions ...int32) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, int16(1))
+	binary.Write(buf, binary.BigEndian, int32(1))
+	binary.Write(buf, binary.BigEndian, int16(len(topic)))
+	buf.WriteString(topic)
+	binary.Write(buf, binary.BigEndian, int32(len(partitions)))
+	for _, p := range partitions {
+		binary.Write(buf, binary.BigEndian, p)
+	}
+	binary.Write(buf, binary.BigEndian, int32(-1))
+	return buf.Bytes()
+}
+
+func TestKafkaBrokerReader(t *testing.T) {
+	topic, group := "logkit_topic", "logkit_group"
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	fetch := &sarama.FetchResponse{Version: 2}
+	fetch.AddMessage(topic, 0, nil, sarama.StringEncoder("line1"), 0)
+	fetch.AddMessage(topic, 0, nil, sarama.StringEncoder("line2"), 1)
+	fetch.AddMessage(topic, 0, nil, sarama.StringEncoder("line3"), 2)
+	fetch.GetBlock(topic, 0).HighWaterMarkOffset = 3
+	empty := &sarama.FetchResponse{Version: 2}
+	empty.AddError(topic, 0, sarama.ErrNoError)
+	empty.GetBlock(topic, 0).HighWaterMarkOffset = 3
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(t).
+			SetCoordinator(group, broker),
+		"JoinGroupRequest": sarama.NewMockWrapper(&sarama.JoinGroupResponse{
+			Err:           sarama.ErrNoError,
+			GenerationId:  1,
+			GroupProtocol: kafkaRangeProtocol,
+			LeaderId:      "other-member",
+			MemberId:      "logkit-member",
+		}),
+		"SyncGroupRequest": sarama.NewMockWrapper(&sarama.SyncGroupResponse{
+			Err:              sarama.ErrNoError,
+			MemberAssignment: encodeMemberAssignment(topic, 0),
+		}),
+		"HeartbeatRequest":  sarama.NewMockWrapper(&sarama.HeartbeatResponse{Err: sarama.ErrNoError}),
+		"LeaveGroupRequest": sarama.NewMockWrapper(&sarama.LeaveGroupResponse{Err: sarama.ErrNoError}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, topic, 0, -1, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 3),
+		"FetchRequest":        sarama.NewMockSequence(fetch, empty),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	c := conf.MapConf{
+		KeyMetaPath:               metaDir,
+		KeyFileDone:               metaDir,
+		KeyMode:                   ModeKafkaBroker,
+		KeyKafkaGroupID:           group,
+		KeyKafkaTopic:             topic,
+		KeyKafkaBrokers:           broker.Addr(),
+		KeyKafkaSessionTimeout:    "6s",
+		KeyKafkaHeartbeatInterval: "100ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	assert.NoError(t, err)
+	defer os.RemoveAll(metaDir)
+	r, err := NewKafkaBrokerReader(meta, c)
+	assert.NoError(t, err)
+	kr := r.(*KafkaBrokerReader)
+	assert.Equal(t, "KafkaBrokerReader:[logkit_topic],[logkit_group]", kr.Name())
+
+	var lines []string
+	for i := 0; i < 10 && len(lines) < 3; i++ {
+		line, err := kr.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"line1", "line2", "line3"}, lines)
+
+	// 最后一条消息在 ReadLine 返回后才会被记录
+	time.Sleep(100 * time.Millisecond)
+	kr.statsLock.RLock()
+	assert.EqualValues(t, 3, kr.curOffsets[topic][0])
+	kr.statsLock.RUnlock()
+
+	lag, err := kr.Lag()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, lag.Size)
+	assert.EqualValues(t, map[string]int64{"logkit_topic:0": 0}, lag.Details)
+
+	kr.SyncMeta()
+	var committed bool
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			committed = true
+		}
+	}
+	assert.True(t, committed)
+	assert.Equal(t, int64(0), kr.Status().Errors)
+	assert.NoError(t, kr.Close())
+}
+
+func TestKafkaBrokerReaderRebalance(t *testing.T) {
+	topic, group := "logkit_topic", "logkit_group"
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	// MockBroker 只能返回固定的 FetchResponse，consumer 会跳过 offset 小于请求 offset 的消息
+	fetchResp := func(p1 ...string) sarama.MockResponse {
+		fetch := &sarama.FetchResponse{Version: 2}
+		fetch.AddMessage(topic, 0, nil, sarama.StringEncoder("a0"), 0)
+		fetch.AddMessage(topic, 0, nil, sarama.StringEncoder("a1"), 1)
+		fetch.GetBlock(topic, 0).HighWaterMarkOffset = 2
+		for i, v := range p1 {
+			fetch.AddMessage(topic, 1, nil, sarama.StringEncoder(v), int64(i))
+		}
+		fetch.GetBlock(topic, 1).HighWaterMarkOffset = int64(len(p1))
+		return sarama.NewMockWrapper(fetch)
+	}
+	joinResp := func(member string, generation int32) sarama.MockResponse {
+		return sarama.NewMockWrapper(&sarama.JoinGroupResponse{
+			Err:           sarama.ErrNoError,
+			GenerationId:  generation,
+			GroupProtocol: kafkaRangeProtocol,
+			LeaderId:      "other-member",
+			MemberId:      member,
+		})
+	}
+	syncResp := func(partitions ...int32) sarama.MockResponse {
+		return sarama.NewMockWrapper(&sarama.SyncGroupResponse{
+			Err:              sarama.ErrNoError,
+			MemberAssignment: encodeMemberAssignment(topic, partitions...),
+		})
+	}
+	heartbeatOK := sarama.NewMockWrapper(&sarama.HeartbeatResponse{Err: sarama.ErrNoError})
+	handlers := func(overrides map[string]sarama.MockResponse) map[string]sarama.MockResponse {
+		m := map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader(topic, 0, broker.BrokerID()).
+				SetLeader(topic, 1, broker.BrokerID()),
+			"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(t).
+				SetCoordinator(group, broker),
+			"HeartbeatRequest":  heartbeatOK,
+			"LeaveGroupRequest": sarama.NewMockWrapper(&sarama.LeaveGroupResponse{Err: sarama.ErrNoError}),
+			"OffsetRequest": sarama.NewMockOffsetResponse(t).
+				SetOffset(topic, 0, sarama.OffsetOldest, 0).
+				SetOffset(topic, 0, sarama.OffsetNewest, 2).
+				SetOffset(topic, 1, sarama.OffsetOldest, 0).
+				SetOffset(topic, 1, sarama.OffsetNewest, 3),
+			"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+			"FetchRequest":        fetchResp("b0", "b1"),
+		}
+		for k, v := range overrides {
+			m[k] = v
+		}
+		return m
+	}
+	newReader := func() *KafkaBrokerReader {
+		c := conf.MapConf{
+			KeyMetaPath:               metaDir,
+			KeyFileDone:               metaDir,
+			KeyMode:                   ModeKafkaBroker,
+			KeyKafkaGroupID:           group,
+			KeyKafkaTopic:             topic,
+			KeyKafkaBrokers:           broker.Addr(),
+			KeyKafkaSessionTimeout:    "6s",
+			KeyKafkaHeartbeatInterval: "100ms",
+		}
+		meta, err := NewMetaWithConf(c)
+		assert.NoError(t, err)
+		r, err := NewKafkaBrokerReader(meta, c)
+		assert.NoError(t, err)
+		return r.(*KafkaBrokerReader)
+	}
+	readLines := func(kr *KafkaBrokerReader, n int) []string {
+		var lines []string
+		for i := 0; i < 10 && len(lines) < n; i++ {
+			line, err := kr.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+	defer os.RemoveAll(metaDir)
+
+	// 只有 A 一个成员时分配到全部 partition
+	broker.SetHandlerByMap(handlers(map[string]sarama.MockResponse{
+		"JoinGroupRequest": joinResp("member-a", 1),
+		"SyncGroupRequest": syncResp(0, 1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, topic, 0, -1, "", sarama.ErrNoError).
+			SetOffset(group, topic, 1, -1, "", sarama.ErrNoError),
+	}))
+	a := newReader()
+	defer a.Close()
+	lines := readLines(a, 4)
+	sort.Strings(lines)
+	assert.Equal(t, []string{"a0", "a1", "b0", "b1"}, lines)
+	assert.True(t, waitFor(func() bool {
+		a.statsLock.RLock()
+		defer a.statsLock.RUnlock()
+		return a.curOffsets[topic][0] == 2 && a.curOffsets[topic][1] == 2
+	}))
+	a.SyncMeta()
+
+	// A 读到 b2 之后 runner 还没有发送成功，SyncMeta 之前 b2 的 offset 不会提交
+	broker.SetHandlerByMap(handlers(map[string]sarama.MockResponse{
+		"JoinGroupRequest": joinResp("member-a", 1),
+		"SyncGroupRequest": syncResp(0, 1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, topic, 0, -1, "", sarama.ErrNoError).
+			SetOffset(group, topic, 1, -1, "", sarama.ErrNoError),
+		"FetchRequest": fetchResp("b0", "b1", "b2"),
+	}))
+	assert.Equal(t, []string{"b2"}, readLines(a, 1))
+	assert.True(t, waitFor(func() bool {
+		a.statsLock.RLock()
+		defer a.statsLock.RUnlock()
+		return a.curOffsets[topic][1] == 3
+	}))
+	a.statsLock.RLock()
+	assert.Equal(t, map[string]map[int32]int64{topic: {0: 2, 1: 2}}, a.syncedOffsets)
+	a.statsLock.RUnlock()
+
+	// B 加入后发生 rebalance，A 只保留 partition 0，重新加入之前只提交 generation 1 中 SyncMeta 确认过的 offset
+	broker.SetHandlerByMap(handlers(map[string]sarama.MockResponse{
+		"HeartbeatRequest": sarama.NewMockSequence(&sarama.HeartbeatResponse{Err: sarama.ErrRebalanceInProgress}, heartbeatOK),
+		"JoinGroupRequest": joinResp("member-a", 2),
+		"SyncGroupRequest": syncResp(0),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, topic, 0, 2, "", sarama.ErrNoError).
+			SetOffset(group, topic, 1, 2, "", sarama.ErrNoError),
+	}))
+	assert.True(t, waitFor(func() bool {
+		a.mux.Lock()
+		defer a.mux.Unlock()
+		return a.generationID == 2
+	}))
+	commitBeforeRejoin, joins := false, 0
+	for _, rr := range broker.History() {
+		switch req := rr.Request.(type) {
+		case *sarama.JoinGroupRequest:
+			joins++
+		case *sarama.OffsetCommitRequest:
+			if joins == 1 && req.ConsumerGroupGeneration == 1 && req.ConsumerID == "member-a" {
+				commitBeforeRejoin = true
+			}
+		}
+	}
+	assert.Equal(t, 2, joins)
+	assert.True(t, commitBeforeRejoin)
+
+	a.statsLock.RLock()
+	assert.Equal(t, map[string]map[int32]int64{topic: {0: 2}}, a.syncedOffsets)
+	a.statsLock.RUnlock()
+
+	// B 分配到 partition 1，从 A 提交的 offset 继续读取，A 没有发送成功的 b2 会由 B 重新读取
+	broker.SetHandlerByMap(handlers(map[string]sarama.MockResponse{
+		"JoinGroupRequest": joinResp("member-b", 2),
+		"SyncGroupRequest": syncResp(1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, topic, 0, 2, "", sarama.ErrNoError).
+			SetOffset(group, topic, 1, 2, "", sarama.ErrNoError),
+		"FetchRequest": fetchResp("b0", "b1", "b2"),
+	}))
+	b := newReader()
+	defer b.Close()
+	assert.Equal(t, []string{"b2"}, readLines(b, 1))
+	line, err := b.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	a.mux.Lock()
+	assert.Equal(t, map[string][]int32{topic: {0}}, a.assignment)
+	a.mux.Unlock()
+	b.mux.Lock()
+	assert.Equal(t, map[string][]int32{topic: {1}}, b.assignment)
+	b.mux.Unlock()
+}
+
+func TestRangeAssign(t *testing.T) {
+	members := map[string]sarama.ConsumerGroupMemberMetadata{
+		"m1": {Topics: []string{"t1", "t2"}},
+		"m2": {Topics: []string{"t1"}},
+	}
+	partitions := map[string][]int32{
+		"t1": {2, 0, 1},
+		"t2": {0, 1},
+	}
+	exp := map[string]map[string][]int32{
+		"m1": {"t1": {0, 1}, "t2": {0, 1}},
+		"m2": {"t1": {2}},
+	}
+	assert.Equal(t, exp, rangeAssign(members, partitions))
+}
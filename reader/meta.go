@@ -60,6 +60,7 @@ type Meta struct {
 	tagFile           string                 //记录tag文件路径的标签名称
 	tags              map[string]interface{} //记录tag文件内容
 	readlimit         int                    //读取磁盘限速单位 MB/s
+	readCompressed    bool                   //是否解压读取 .gz/.bz2/.zst 文件
 	statisticPath     string                 // 记录 runner 计数信息
 	ftSaveLogPath     string                 // 记录 ft_sender 日志信息
 	RunnerName        string
@@ -164,6 +165,7 @@ func NewMetaWithConf(conf conf.MapConf) (meta *Meta, err error) {
 	filedonepath, _ := conf.GetStringOr(KeyFileDone, metapath)
 	donefileRetention, _ := conf.GetIntOr(doneFileRetention, defautFileRetention)
 	readlimit, _ := conf.GetIntOr(KeyReadIOLimit, defaultIOLimit)
+	readCompressed, _ := conf.GetBoolOr(KeyReadCompressed, false)
 	meta, err = NewMeta(metapath, filedonepath, logPath, mode, tagFile, donefileRetention)
 	if err != nil {
 		log.Warnf("Runner[%v] %s - newMeta failed, err:%v", runnerName, metapath, err)
@@ -181,6 +183,7 @@ func NewMetaWithConf(conf conf.MapConf) (meta *Meta, err error) {
 	}
 	meta.dataSourceTag = datasourceTag
 	meta.readlimit = readlimit * 1024 * 1024 //readlimit*MB
+	meta.readCompressed = readCompressed
 	meta.RunnerName = runnerName
 	return
 }
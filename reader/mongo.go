@@ -2,6 +2,7 @@ package reader
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -42,6 +43,13 @@ type MongoReader struct {
 	session      *mgo.Session
 	offset       interface{} //对于默认的offset_key: "_id", 是objectID作为offset，存储的表现形式是string，其他则是int64
 
+	readMode     string
+	fullDocument string
+	changeChan   chan mongoChangeLine
+	resumeToken  bson.Raw // change stream 已读取到的位置
+	changeToken  string   // ReadLine 已读取的数据对应的 resume token，用于 SyncMeta
+	changeLock   sync.Mutex
+
 	execOnStart bool
 	status      int32
 	started     bool
@@ -52,14 +60,26 @@ type MongoReader struct {
 
 func NewMongoReader(meta *Meta, conf conf.MapConf) (mr Reader, err error) {
 	readBatch, _ := conf.GetIntOr(KeyMongoReadBatch, 100)
+	readMode, _ := conf.GetStringOr(KeyMongoReadMode, MongoReadModePoll)
+	if readMode != MongoReadModePoll && readMode != MongoReadModeChangeStream {
+		return nil, fmt.Errorf("unsupported %v %v", KeyMongoReadMode, readMode)
+	}
+	// change stream 模式下 database 和 collection 可以为空，分别表示监听整个集群和整个数据库
 	database, err := conf.GetString(KeyMongoDatabase)
-	if err != nil {
+	if err != nil && readMode != MongoReadModeChangeStream {
 		return nil, err
 	}
 	collection, err := conf.GetString(KeyMongoCollection)
-	if err != nil {
+	if err != nil && readMode != MongoReadModeChangeStream {
 		return nil, err
 	}
+	if database == "" && collection != "" {
+		return nil, fmt.Errorf("%v is required when %v is set", KeyMongoDatabase, KeyMongoCollection)
+	}
+	fullDocument, _ := conf.GetStringOr(KeyMongoFullDocument, MongoFullDocumentUpdateLookup)
+	if fullDocument != MongoFullDocumentDefault && fullDocument != MongoFullDocumentUpdateLookup {
+		return nil, fmt.Errorf("unsupported %v %v", KeyMongoFullDocument, fullDocument)
+	}
 	host, _ := conf.GetStringOr(KeyMongoHost, "localhost:9200")
 	offsetkey, _ := conf.GetStringOr(KeyMongoOffsetKey, MongoDefaultOffsetKey)
 	cronSched, _ := conf.GetStringOr(KeyMongoCron, "")
@@ -84,7 +104,11 @@ func NewMongoReader(meta *Meta, conf conf.MapConf) (mr Reader, err error) {
 		database:   database,
 		collection: collection,
 		offsetkey:  offsetkey,
-		readBatch:  readBatch, //这个参数目前没有用
+		readBatch:  readBatch, //这个参数目前只在 change stream 模式中使用
+
+		readMode:     readMode,
+		fullDocument: fullDocument,
+		changeChan:   make(chan mongoChangeLine),
 
 		collectionFilters: map[string]CollectionFilter{},
 		Cron:              cron.New(),
@@ -95,7 +119,16 @@ func NewMongoReader(meta *Meta, conf conf.MapConf) (mr Reader, err error) {
 		mux:               sync.Mutex{},
 		statsLock:         sync.RWMutex{},
 	}
-	if offsetkey == MongoDefaultOffsetKey {
+	if readMode == MongoReadModeChangeStream {
+		if keyOrObj != "" {
+			if mmr.resumeToken, err = decodeMongoResumeToken(keyOrObj); err != nil {
+				log.Errorf("Runner[%v] %v -meta data %v is not a valid resume token: %v, omit meta data", meta.RunnerName, meta.MetaFile(), keyOrObj, err)
+				err = nil
+			} else {
+				mmr.changeToken = keyOrObj
+			}
+		}
+	} else if offsetkey == MongoDefaultOffsetKey {
 		if bson.IsObjectIdHex(keyOrObj) {
 			mmr.offset = bson.ObjectIdHex(keyOrObj)
 		} else {
@@ -173,7 +206,9 @@ func (mr *MongoReader) Start() {
 	if mr.started {
 		return
 	}
-	if mr.loop {
+	if mr.readMode == MongoReadModeChangeStream {
+		go mr.run()
+	} else if mr.loop {
 		go mr.LoopRun()
 	} else {
 		if mr.execOnStart {
@@ -204,6 +239,11 @@ func (mr *MongoReader) ReadLine() (data string, err error) {
 	select {
 	case dat := <-mr.readChan:
 		data = string(dat)
+	case line := <-mr.changeChan:
+		data = string(line.data)
+		mr.changeLock.Lock()
+		mr.changeToken = line.token
+		mr.changeLock.Unlock()
 	case <-timer.C:
 	}
 	timer.Stop()
@@ -280,6 +320,10 @@ func (mr *MongoReader) exec() (err error) {
 		}
 	}
 
+	if mr.readMode == MongoReadModeChangeStream {
+		return mr.watchChangeStream()
+	}
+
 	iter := mr.catQuery(mr.collection, mr.offset, mr.session).Iter()
 
 	var result bson.M
@@ -308,7 +352,11 @@ func (mr *MongoReader) exec() (err error) {
 func (mr *MongoReader) SyncMeta() {
 	var key string
 	var offset int64
-	if mr.offsetkey == MongoDefaultOffsetKey {
+	if mr.readMode == MongoReadModeChangeStream {
+		mr.changeLock.Lock()
+		key = mr.changeToken
+		mr.changeLock.Unlock()
+	} else if mr.offsetkey == MongoDefaultOffsetKey {
 		if id, ok := mr.offset.(bson.ObjectId); ok {
 			key = id.Hex()
 		}
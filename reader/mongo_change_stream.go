@@ -0,0 +1,205 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/json-iterator/go"
+	"github.com/qiniu/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoDB 的读取方式，poll 按 offset_key 定时查询，change_stream 通过 change stream 监听数据变更
+const (
+	MongoReadModePoll         = "poll"
+	MongoReadModeChangeStream = "change_stream"
+)
+
+// change stream 中 fullDocument 的取值，updateLookup 会在 update 事件中带上变更后的完整文档
+const (
+	MongoFullDocumentDefault      = "default"
+	MongoFullDocumentUpdateLookup = "updateLookup"
+)
+
+// mongoResumeTokenPrefix 用于区分 meta 中记录的 resume token 与 poll 模式的 offset
+const mongoResumeTokenPrefix = "resume_token:"
+
+// mongoChangeEvent 是 change stream 返回的事件，_id 即为 resume token
+type mongoChangeEvent struct {
+	ID            bson.Raw `bson:"_id"`
+	OperationType string   `bson:"operationType"`
+	NS            struct {
+		DB   string `bson:"db"`
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey       bson.M                  `bson:"documentKey"`
+	FullDocument      bson.M                  `bson:"fullDocument"`
+	UpdateDescription *mongoUpdateDescription `bson:"updateDescription"`
+	ClusterTime       bson.MongoTimestamp     `bson:"clusterTime"`
+}
+
+type mongoUpdateDescription struct {
+	UpdatedFields bson.M   `bson:"updatedFields" json:"updated_fields"`
+	RemovedFields []string `bson:"removedFields" json:"removed_fields"`
+}
+
+// mongoChangeRecord 是一个变更事件对应的输出，op 为 MongoDB 的 operationType
+type mongoChangeRecord struct {
+	Op                string                  `json:"op"`
+	Database          string                  `json:"database"`
+	Collection        string                  `json:"collection,omitempty"`
+	Timestamp         int64                   `json:"timestamp,omitempty"`
+	DocumentKey       bson.M                  `json:"document_key,omitempty"`
+	FullDocument      bson.M                  `json:"full_document,omitempty"`
+	UpdateDescription *mongoUpdateDescription `json:"update_description,omitempty"`
+}
+
+// mongoChangeLine 是 watch 与 ReadLine 之间传递的数据，token 为读取该数据后应记录的 resume token，
+// data 为空时只更新 resume token
+type mongoChangeLine struct {
+	data  []byte
+	token string
+}
+
+type mongoCursorReply struct {
+	Cursor struct {
+		ID                   int64      `bson:"id"`
+		NS                   string     `bson:"ns"`
+		FirstBatch           []bson.Raw `bson:"firstBatch"`
+		NextBatch            []bson.Raw `bson:"nextBatch"`
+		PostBatchResumeToken bson.Raw   `bson:"postBatchResumeToken"`
+	} `bson:"cursor"`
+}
+
+// encodeMongoResumeToken 将 resume token 编码为不含空白字符的字符串，便于写入 meta
+func encodeMongoResumeToken(token bson.Raw) string {
+	if token.Kind != 0x03 {
+		return ""
+	}
+	return mongoResumeTokenPrefix + base64.RawURLEncoding.EncodeToString(token.Data)
+}
+
+func decodeMongoResumeToken(s string) (bson.Raw, error) {
+	if !strings.HasPrefix(s, mongoResumeTokenPrefix) {
+		return bson.Raw{}, errors.New("missing " + mongoResumeTokenPrefix + " prefix")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, mongoResumeTokenPrefix))
+	if err != nil {
+		return bson.Raw{}, err
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return bson.Raw{}, err
+	}
+	return bson.Raw{Kind: 0x03, Data: data}, nil
+}
+
+// changeStreamTarget 返回执行 aggregate 的数据库以及 aggregate 的参数，
+// 未指定 collection 时监听整个数据库，未指定 database 时监听整个集群
+func (mr *MongoReader) changeStreamTarget(session *mgo.Session) (*mgo.Database, interface{}) {
+	if mr.database == "" {
+		return session.DB("admin"), 1
+	}
+	if mr.collection == "" {
+		return session.DB(mr.database), 1
+	}
+	return session.DB(mr.database), mr.collection
+}
+
+func (mr *MongoReader) watchChangeStream() error {
+	// Close 时会关闭 mr.session，使用副本避免关闭后继续 getMore 引发 panic
+	session := mr.session.Copy()
+	defer session.Close()
+
+	db, target := mr.changeStreamTarget(session)
+	stage := bson.D{{Name: "fullDocument", Value: mr.fullDocument}}
+	if mr.database == "" {
+		stage = append(stage, bson.DocElem{Name: "allChangesForCluster", Value: true})
+	}
+	if mr.resumeToken.Kind != 0 {
+		stage = append(stage, bson.DocElem{Name: "resumeAfter", Value: mr.resumeToken})
+	}
+	var reply mongoCursorReply
+	err := db.Run(bson.D{
+		{Name: "aggregate", Value: target},
+		{Name: "pipeline", Value: []bson.M{{"$changeStream": stage}}},
+		{Name: "cursor", Value: bson.M{"batchSize": mr.readBatch}},
+	}, &reply)
+	if err != nil {
+		return fmt.Errorf("open change stream error %v", err)
+	}
+	cursorID := reply.Cursor.ID
+	// getMore 需要 ns 中去掉数据库名的部分，监听数据库或集群时为 $cmd.aggregate
+	collection := reply.Cursor.NS[strings.Index(reply.Cursor.NS, ".")+1:]
+	batch := reply.Cursor.FirstBatch
+	for {
+		for _, raw := range batch {
+			if err = mr.sendChangeEvent(raw); err != nil {
+				return err
+			}
+		}
+		if token := reply.Cursor.PostBatchResumeToken; token.Kind == 0x03 && !bytes.Equal(token.Data, mr.resumeToken.Data) {
+			mr.resumeToken = token
+			mr.changeChan <- mongoChangeLine{token: encodeMongoResumeToken(token)}
+		}
+		if atomic.LoadInt32(&mr.status) == StatusStopping {
+			log.Warnf("Runner[%v] %v stopped from running", mr.meta.RunnerName, mr.Name())
+			if cursorID != 0 {
+				db.Run(bson.D{{Name: "killCursors", Value: collection}, {Name: "cursors", Value: []int64{cursorID}}}, nil)
+			}
+			return nil
+		}
+		if cursorID == 0 {
+			return errors.New("change stream closed by server")
+		}
+		reply = mongoCursorReply{}
+		err = db.Run(bson.D{
+			{Name: "getMore", Value: cursorID},
+			{Name: "collection", Value: collection},
+			{Name: "batchSize", Value: mr.readBatch},
+			{Name: "maxTimeMS", Value: 1000},
+		}, &reply)
+		if err != nil {
+			return fmt.Errorf("change stream getMore error %v", err)
+		}
+		cursorID = reply.Cursor.ID
+		batch = reply.Cursor.NextBatch
+	}
+}
+
+func (mr *MongoReader) sendChangeEvent(raw bson.Raw) error {
+	var event mongoChangeEvent
+	if err := raw.Unmarshal(&event); err != nil {
+		return fmt.Errorf("invalid change event: %v", err)
+	}
+	if event.ID.Kind != 0x03 {
+		return errors.New("change event has no resume token")
+	}
+	record := mongoChangeRecord{
+		Op:                event.OperationType,
+		Database:          event.NS.DB,
+		Collection:        event.NS.Coll,
+		Timestamp:         int64(event.ClusterTime) >> 32,
+		DocumentKey:       event.DocumentKey,
+		FullDocument:      event.FullDocument,
+		UpdateDescription: event.UpdateDescription,
+	}
+	data, err := jsoniter.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("json marshal change event error %v", err)
+	}
+	mr.resumeToken = event.ID
+	token := encodeMongoResumeToken(event.ID)
+	// invalidate 之后的 resume token 无法用于恢复，重新打开 change stream 时从最新的变更开始读取
+	if event.OperationType == "invalidate" {
+		mr.resumeToken = bson.Raw{}
+		token = ""
+	}
+	mr.changeChan <- mongoChangeLine{data: data, token: token}
+	return nil
+}
@@ -0,0 +1,240 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeMongo 实现了 mgo 用到的 OP_QUERY 命令，模拟单个集合上的 change stream
+type fakeMongo struct {
+	ln     net.Listener
+	mux    sync.Mutex
+	events []bson.M
+	stages []bson.M
+	cursor int
+}
+
+func newFakeMongo(t *testing.T) *fakeMongo {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeMongo{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeMongo) addEvent(token, op string, id int, extra bson.M) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	event := bson.M{
+		"_id":           bson.M{"_data": token},
+		"operationType": op,
+		"ns":            bson.M{"db": "testdb", "coll": "coll"},
+		"documentKey":   bson.M{"_id": id},
+		"clusterTime":   bson.MongoTimestamp(int64(1500000000+len(f.events))<<32 | 1),
+	}
+	for k, v := range extra {
+		event[k] = v
+	}
+	f.events = append(f.events, event)
+}
+
+func (f *fakeMongo) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		msg := make([]byte, binary.LittleEndian.Uint32(header)-16)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		// OP_QUERY: flags, fullCollectionName, numberToSkip, numberToReturn, query
+		msg = msg[4:]
+		msg = msg[bytes.IndexByte(msg, 0)+1+8:]
+		raw := msg[:binary.LittleEndian.Uint32(msg)]
+		var cmd bson.D
+		if err := bson.Unmarshal(raw, &cmd); err != nil {
+			return
+		}
+		doc, _ := bson.Marshal(f.handle(cmd[0].Name, raw))
+		reply := make([]byte, 36, 36+len(doc))
+		binary.LittleEndian.PutUint32(reply, uint32(36+len(doc)))
+		copy(reply[8:12], header[4:8])
+		binary.LittleEndian.PutUint32(reply[12:], 1)
+		binary.LittleEndian.PutUint32(reply[32:], 1)
+		if _, err := conn.Write(append(reply, doc...)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeMongo) handle(name string, raw []byte) bson.M {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	switch name {
+	case "getnonce":
+		return bson.M{"nonce": "2375531c32080ae8", "ok": 1}
+	case "ismaster", "isMaster":
+		return bson.M{"ismaster": true, "maxWireVersion": 6, "ok": 1}
+	case "aggregate":
+		var cmd struct {
+			Pipeline []struct {
+				ChangeStream bson.M `bson:"$changeStream"`
+			} `bson:"pipeline"`
+		}
+		if err := bson.Unmarshal(raw, &cmd); err != nil || len(cmd.Pipeline) != 1 {
+			return bson.M{"ok": 0, "errmsg": "invalid pipeline"}
+		}
+		stage := cmd.Pipeline[0].ChangeStream
+		f.stages = append(f.stages, stage)
+		// 没有 resumeAfter 时从最新的变更开始
+		f.cursor = len(f.events)
+		if resume, ok := stage["resumeAfter"].(bson.M); ok {
+			for i, event := range f.events {
+				if event["_id"].(bson.M)["_data"] == resume["_data"] {
+					f.cursor = i + 1
+				}
+			}
+		}
+		return bson.M{"cursor": bson.M{"id": int64(1), "ns": "testdb.coll", "firstBatch": []bson.M{}}, "ok": 1}
+	case "getMore":
+		batch := f.events[f.cursor:]
+		f.cursor = len(f.events)
+		if len(batch) == 0 {
+			f.mux.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			f.mux.Lock()
+		}
+		return bson.M{"cursor": bson.M{"id": int64(1), "ns": "testdb.coll", "nextBatch": batch}, "ok": 1}
+	}
+	return bson.M{"ok": 1}
+}
+
+func (f *fakeMongo) aggregates() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return len(f.stages)
+}
+
+func TestMongoChangeStreamReader(t *testing.T) {
+	fake := newFakeMongo(t)
+	defer fake.ln.Close()
+
+	metaDir := "TestMongoChangeStreamReader"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:        metaDir,
+		KeyFileDone:        metaDir,
+		KeyMode:            ModeMongo,
+		KeyRunnerName:      "TestMongoChangeStreamReader",
+		KeyMongoHost:       fake.ln.Addr().String(),
+		KeyMongoDatabase:   "testdb",
+		KeyMongoCollection: "coll",
+		KeyMongoReadMode:   MongoReadModeChangeStream,
+	}
+	readLines := func(r Reader, n int) []string {
+		var lines []string
+		for i := 0; i < 20 && len(lines) < n; i++ {
+			line, err := r.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+	waitAggregate := func(n int) {
+		for i := 0; i < 100 && fake.aggregates() < n; i++ {
+			time.Sleep(50 * time.Millisecond)
+		}
+		require.Equal(t, n, fake.aggregates())
+	}
+
+	fake.addEvent("t0", "insert", 0, bson.M{"fullDocument": bson.M{"_id": 0}})
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewMongoReader(meta, readerConf)
+	require.NoError(t, err)
+	r.(*MongoReader).Start()
+	waitAggregate(1)
+	assert.Equal(t, bson.M{"fullDocument": MongoFullDocumentUpdateLookup}, fake.stages[0])
+
+	fake.addEvent("t1", "insert", 1, bson.M{"fullDocument": bson.M{"_id": 1, "name": "a"}})
+	fake.addEvent("t2", "update", 1, bson.M{
+		"fullDocument":      bson.M{"_id": 1, "name": "b"},
+		"updateDescription": bson.M{"updatedFields": bson.M{"name": "b"}, "removedFields": []string{"age"}},
+	})
+	fake.addEvent("t3", "delete", 1, nil)
+	lines := readLines(r, 3)
+	require.Len(t, lines, 3)
+	assert.JSONEq(t, `{"op":"insert","database":"testdb","collection":"coll","timestamp":1500000001,"document_key":{"_id":1},"full_document":{"_id":1,"name":"a"}}`, lines[0])
+	assert.JSONEq(t, `{"op":"update","database":"testdb","collection":"coll","timestamp":1500000002,"document_key":{"_id":1},"full_document":{"_id":1,"name":"b"},"update_description":{"updated_fields":{"name":"b"},"removed_fields":["age"]}}`, lines[1])
+	assert.JSONEq(t, `{"op":"delete","database":"testdb","collection":"coll","timestamp":1500000003,"document_key":{"_id":1}}`, lines[2])
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	key, _, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	token, err := decodeMongoResumeToken(key)
+	assert.NoError(t, err)
+	var tokenDoc bson.M
+	assert.NoError(t, token.Unmarshal(&tokenDoc))
+	assert.Equal(t, bson.M{"_data": "t3"}, tokenDoc)
+
+	// 重启后从 meta 中的 resume token 继续读取
+	fake.addEvent("t4", "replace", 2, bson.M{"fullDocument": bson.M{"_id": 2}})
+	meta, err = NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err = NewMongoReader(meta, readerConf)
+	require.NoError(t, err)
+	lines = readLines(r, 1)
+	require.Len(t, lines, 1)
+	var record mongoChangeRecord
+	assert.NoError(t, jsoniter.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "replace", record.Op)
+	assert.NoError(t, r.Close())
+	waitAggregate(2)
+	assert.Equal(t, bson.M{"_data": "t3"}, fake.stages[1]["resumeAfter"])
+
+	readerConf[KeyMongoReadMode] = "tail"
+	_, err = NewMongoReader(meta, readerConf)
+	assert.Error(t, err)
+}
+
+func TestMongoResumeToken(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"_data": "825F"})
+	assert.NoError(t, err)
+	token := bson.Raw{Kind: 0x03, Data: data}
+	s := encodeMongoResumeToken(token)
+	assert.Equal(t, fmt.Sprintf("%v%v", mongoResumeTokenPrefix, "FQAAAAJfZGF0YQAFAAAAODI1RgAA"), s)
+	got, err := decodeMongoResumeToken(s)
+	assert.NoError(t, err)
+	assert.Equal(t, token, got)
+	assert.Equal(t, "", encodeMongoResumeToken(bson.Raw{}))
+	_, err = decodeMongoResumeToken("5a0b0c")
+	assert.Error(t, err)
+}
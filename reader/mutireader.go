@@ -73,6 +73,7 @@ func NewActiveReader(originPath, realPath, whence string, meta *Meta, msgChan ch
 		return nil, err
 	}
 	subMeta.readlimit = meta.readlimit
+	subMeta.readCompressed = meta.readCompressed
 	fr, err := NewSingleFile(subMeta, realPath, whence, false)
 	if err != nil {
 		return
@@ -0,0 +1,708 @@
+package reader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// mysql 地址，默认 127.0.0.1:3306
+	KeyMysqlBinlogAddress  = "mysql_binlog_address"
+	KeyMysqlBinlogUser     = "mysql_binlog_user"
+	KeyMysqlBinlogPassword = "mysql_binlog_password"
+	// 作为复制从库的 server_id，需要与集群中其他实例不同，默认随机生成
+	KeyMysqlBinlogServerID = "mysql_binlog_server_id"
+	// mysql 或 mariadb
+	KeyMysqlBinlogFlavor = "mysql_binlog_flavor"
+	// 需要采集的表，格式为 db.table，支持通配符，如 "shop.*, user.account_?"，为空则采集全部表
+	KeyMysqlBinlogTables = "mysql_binlog_tables"
+	// 开启后按 GTID 同步，meta 中记录 GTID 集合，否则记录 binlog 文件名与位置
+	KeyMysqlBinlogGTID = "mysql_binlog_gtid"
+	// 没有 meta 记录时的起始 binlog 文件与位置，不填则按 read_from 决定
+	KeyMysqlBinlogFile = "mysql_binlog_file"
+	KeyMysqlBinlogPos  = "mysql_binlog_pos"
+	// 没有 meta 记录时的起始 GTID 集合，不填则按 read_from 决定
+	KeyMysqlBinlogGTIDSet = "mysql_binlog_gtid_set"
+)
+
+// 数据库行变更的操作类型
+const (
+	ChangeOpInsert = "insert"
+	ChangeOpUpdate = "update"
+	ChangeOpDelete = "delete"
+)
+
+const (
+	binlogStartPos      = 4
+	binlogRetryInterval = 5 * time.Second
+)
+
+// binlogRecord 是一行变更对应的输出，before 与 after 分别为变更前后的行数据
+type binlogRecord struct {
+	Op        string                 `json:"op"`
+	Database  string                 `json:"database"`
+	Table     string                 `json:"table"`
+	Timestamp uint32                 `json:"timestamp"`
+	LogFile   string                 `json:"log_file"`
+	LogPos    uint32                 `json:"log_pos"`
+	GTID      string                 `json:"gtid,omitempty"`
+	Before    map[string]interface{} `json:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty"`
+}
+
+// binlogLine 是 run 与 ReadLine 之间传递的数据，commit 为 true 时表示一个事务结束，
+// 只携带可以安全恢复的同步位置
+type binlogLine struct {
+	data   string
+	source string
+	commit bool
+	pos    mysql.Position
+	gset   string
+}
+
+// binlogLogger 将 go-mysql 的日志输出到 logkit 的日志中
+type binlogLogger struct {
+	*log.Logger
+}
+
+func (l binlogLogger) Debugln(v ...interface{}) { l.Debug(v...) }
+func (l binlogLogger) Infoln(v ...interface{})  { l.Info(v...) }
+func (l binlogLogger) Warnln(v ...interface{})  { l.Warn(v...) }
+func (l binlogLogger) Errorln(v ...interface{}) { l.Error(v...) }
+
+type MysqlBinlogReader struct {
+	meta     *Meta
+	address  string
+	user     string
+	password string
+	serverID uint32
+	flavor   string
+	tables   []string
+	gtidMode bool
+	whence   string
+
+	readChan chan binlogLine
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// 以下字段只在 run 所在的 goroutine 中使用
+	syncPos  mysql.Position
+	syncGset string
+	logFile  string
+	gtid     string
+	columns  map[string][]string
+
+	// 已经被 ReadLine 读过的事务位置，SyncMeta 时写入 meta
+	position   mysql.Position
+	gtidSet    string
+	lastSource string
+
+	conn    *client.Conn
+	connMux sync.Mutex
+	syncer  *replication.BinlogSyncer
+	status  int32
+	mux     sync.Mutex
+	started bool
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewMysqlBinlogReader(meta *Meta, conf conf.MapConf) (reader Reader, err error) {
+	address, _ := conf.GetStringOr(KeyMysqlBinlogAddress, "127.0.0.1:3306")
+	user, _ := conf.GetStringOr(KeyMysqlBinlogUser, "root")
+	password, _ := conf.GetStringOr(KeyMysqlBinlogPassword, "")
+	serverID, _ := conf.GetIntOr(KeyMysqlBinlogServerID, 0)
+	if serverID <= 0 {
+		serverID = 1001 + rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1<<16)
+	}
+	flavor, _ := conf.GetStringOr(KeyMysqlBinlogFlavor, mysql.MySQLFlavor)
+	if flavor != mysql.MySQLFlavor && flavor != mysql.MariaDBFlavor {
+		return nil, fmt.Errorf("unsupported %v %v, must be %v or %v", KeyMysqlBinlogFlavor, flavor, mysql.MySQLFlavor, mysql.MariaDBFlavor)
+	}
+	tables, _ := conf.GetStringListOr(KeyMysqlBinlogTables, []string{})
+	for _, table := range tables {
+		if _, err = path.Match(table, ""); err != nil {
+			return nil, fmt.Errorf("invalid %v pattern %v: %v", KeyMysqlBinlogTables, table, err)
+		}
+	}
+	gtidMode, _ := conf.GetBoolOr(KeyMysqlBinlogGTID, false)
+	whence, _ := conf.GetStringOr(KeyWhence, WhenceOldest)
+
+	br := &MysqlBinlogReader{
+		meta:     meta,
+		address:  address,
+		user:     user,
+		password: password,
+		serverID: uint32(serverID),
+		flavor:   flavor,
+		tables:   tables,
+		gtidMode: gtidMode,
+		whence:   whence,
+		readChan: make(chan binlogLine),
+		columns:  make(map[string][]string),
+		status:   StatusInit,
+	}
+	br.ctx, br.cancel = context.WithCancel(context.Background())
+
+	offset, pos, err := meta.ReadOffset()
+	if err == nil && offset != "" {
+		if gtidMode {
+			if _, err = mysql.ParseGTIDSet(flavor, offset); err == nil {
+				br.syncGset = offset
+			}
+		} else if pos >= binlogStartPos {
+			br.syncPos = mysql.Position{Name: offset, Pos: uint32(pos)}
+		}
+		if br.syncGset == "" && br.syncPos.Name == "" {
+			log.Warnf("Runner[%v] %v ignore invalid meta offset %v %v", meta.RunnerName, br.Name(), offset, pos)
+		}
+	} else if gtidMode {
+		gset, _ := conf.GetStringOr(KeyMysqlBinlogGTIDSet, "")
+		if gset != "" {
+			if _, err = mysql.ParseGTIDSet(flavor, gset); err != nil {
+				return nil, fmt.Errorf("invalid %v %v: %v", KeyMysqlBinlogGTIDSet, gset, err)
+			}
+			br.syncGset = gset
+		}
+	} else {
+		file, _ := conf.GetStringOr(KeyMysqlBinlogFile, "")
+		pos, _ := conf.GetInt64Or(KeyMysqlBinlogPos, binlogStartPos)
+		if file != "" {
+			br.syncPos = mysql.Position{Name: file, Pos: uint32(pos)}
+		}
+	}
+	br.position = br.syncPos
+	br.gtidSet = br.syncGset
+	return br, nil
+}
+
+func (br *MysqlBinlogReader) Name() string {
+	return "MysqlBinlogReader:" + br.address
+}
+
+// Source 返回最近一行数据所属的表，格式为 db.table
+func (br *MysqlBinlogReader) Source() string {
+	br.mux.Lock()
+	defer br.mux.Unlock()
+	if br.lastSource == "" {
+		return br.address
+	}
+	return br.lastSource
+}
+
+func (br *MysqlBinlogReader) SetMode(mode string, v interface{}) error {
+	return errors.New("MysqlBinlogReader not support read mode")
+}
+
+func (br *MysqlBinlogReader) Status() StatsInfo {
+	br.statsLock.RLock()
+	defer br.statsLock.RUnlock()
+	return br.stats
+}
+
+func (br *MysqlBinlogReader) setStatsError(err string) {
+	br.statsLock.Lock()
+	defer br.statsLock.Unlock()
+	br.stats.Errors++
+	br.stats.LastError = err
+}
+
+func (br *MysqlBinlogReader) Start() {
+	br.mux.Lock()
+	defer br.mux.Unlock()
+	if br.started {
+		return
+	}
+	br.started = true
+	go br.run()
+	log.Infof("Runner[%v] %v pull data daemon started", br.meta.RunnerName, br.Name())
+}
+
+func (br *MysqlBinlogReader) ReadLine() (data string, err error) {
+	if !br.started {
+		br.Start()
+	}
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-br.readChan:
+			if !ok {
+				return
+			}
+			br.mux.Lock()
+			if line.commit {
+				br.position = line.pos
+				if line.gset != "" {
+					br.gtidSet = line.gset
+				}
+				br.mux.Unlock()
+				continue
+			}
+			br.lastSource = line.source
+			br.mux.Unlock()
+			return line.data, nil
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// SyncMeta 记录最后一个已被读取完整的事务位置，重启后从该位置继续同步
+func (br *MysqlBinlogReader) SyncMeta() {
+	br.mux.Lock()
+	position, gtidSet := br.position, br.gtidSet
+	br.mux.Unlock()
+	var err error
+	if br.gtidMode && gtidSet != "" {
+		err = br.meta.WriteOffset(gtidSet, 0)
+	} else if position.Name != "" {
+		err = br.meta.WriteOffset(position.Name, int64(position.Pos))
+	}
+	if err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", br.meta.RunnerName, br.Name(), err)
+	}
+}
+
+func (br *MysqlBinlogReader) Close() error {
+	if !atomic.CompareAndSwapInt32(&br.status, StatusInit, StatusStopped) {
+		atomic.StoreInt32(&br.status, StatusStopping)
+	}
+	br.cancel()
+	br.mux.Lock()
+	if br.syncer != nil {
+		br.syncer.Close()
+		br.syncer = nil
+	}
+	br.mux.Unlock()
+	br.connMux.Lock()
+	if br.conn != nil {
+		br.conn.Close()
+		br.conn = nil
+	}
+	br.connMux.Unlock()
+	log.Infof("Runner[%v] %v stopped", br.meta.RunnerName, br.Name())
+	return nil
+}
+
+func (br *MysqlBinlogReader) isStopping() bool {
+	return atomic.LoadInt32(&br.status) == StatusStopping || atomic.LoadInt32(&br.status) == StatusStopped
+}
+
+func (br *MysqlBinlogReader) run() {
+	if !atomic.CompareAndSwapInt32(&br.status, StatusInit, StatusRunning) {
+		return
+	}
+	defer atomic.CompareAndSwapInt32(&br.status, StatusStopping, StatusStopped)
+	for !br.isStopping() {
+		err := br.sync()
+		if err == nil || br.isStopping() {
+			return
+		}
+		log.Errorf("Runner[%v] %v sync binlog error %v, retry after %v", br.meta.RunnerName, br.Name(), err, binlogRetryInterval)
+		br.setStatsError("Runner[" + br.meta.RunnerName + "] " + br.Name() + " sync binlog error " + err.Error())
+		select {
+		case <-br.ctx.Done():
+			return
+		case <-time.After(binlogRetryInterval):
+		}
+	}
+}
+
+// sync 从上一个事务结束的位置开始同步，直到出错或者 reader 被关闭
+func (br *MysqlBinlogReader) sync() error {
+	streamer, err := br.startSync()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		br.mux.Lock()
+		if br.syncer != nil {
+			br.syncer.Close()
+			br.syncer = nil
+		}
+		br.mux.Unlock()
+	}()
+	for {
+		ev, err := streamer.GetEvent(br.ctx)
+		if err != nil {
+			if br.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, line := range br.handleEvent(ev) {
+			select {
+			case br.readChan <- line:
+			case <-br.ctx.Done():
+				return nil
+			}
+			if line.commit {
+				br.syncPos = line.pos
+				if line.gset != "" {
+					br.syncGset = line.gset
+				}
+			}
+		}
+	}
+}
+
+func (br *MysqlBinlogReader) startSync() (*replication.BinlogStreamer, error) {
+	host, portStr, err := splitMysqlAddress(br.address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %v: %v", br.address, err)
+	}
+	syncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID:        br.serverID,
+		Flavor:          br.flavor,
+		Host:            host,
+		Port:            uint16(port),
+		User:            br.user,
+		Password:        br.password,
+		UseDecimal:      true,
+		HeartbeatPeriod: 30 * time.Second,
+		ReadTimeout:     90 * time.Second,
+		Logger:          binlogLogger{log.Std},
+	})
+	br.mux.Lock()
+	if br.isStopping() {
+		br.mux.Unlock()
+		syncer.Close()
+		return nil, errors.New("reader is closed")
+	}
+	br.syncer = syncer
+	br.mux.Unlock()
+
+	var streamer *replication.BinlogStreamer
+	if br.gtidMode {
+		if br.syncGset == "" {
+			if br.syncGset, err = br.initialGTIDSet(); err != nil {
+				return nil, err
+			}
+		}
+		gset, err := mysql.ParseGTIDSet(br.flavor, br.syncGset)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Runner[%v] %v start sync from gtid set %v", br.meta.RunnerName, br.Name(), gset)
+		streamer, err = syncer.StartSyncGTID(gset)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if br.syncPos.Name == "" {
+			if br.syncPos, err = br.initialPosition(); err != nil {
+				return nil, err
+			}
+		}
+		log.Infof("Runner[%v] %v start sync from %v", br.meta.RunnerName, br.Name(), br.syncPos)
+		streamer, err = syncer.StartSync(br.syncPos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	br.logFile = br.syncPos.Name
+	return streamer, nil
+}
+
+func splitMysqlAddress(address string) (host, port string, err error) {
+	if !strings.Contains(address, ":") {
+		return address, "3306", nil
+	}
+	idx := strings.LastIndex(address, ":")
+	host, port = strings.Trim(address[:idx], "[]"), address[idx+1:]
+	if host == "" || port == "" {
+		return "", "", fmt.Errorf("invalid mysql address %v", address)
+	}
+	return host, port, nil
+}
+
+// initialPosition 在没有记录时根据 read_from 决定从最老或最新的 binlog 开始同步
+func (br *MysqlBinlogReader) initialPosition() (mysql.Position, error) {
+	if br.whence == WhenceNewest {
+		res, err := br.execute("SHOW MASTER STATUS")
+		if err != nil {
+			// MySQL 8.4 之后改为 SHOW BINARY LOG STATUS
+			res, err = br.execute("SHOW BINARY LOG STATUS")
+		}
+		if err != nil {
+			return mysql.Position{}, err
+		}
+		if res.RowNumber() == 0 {
+			return mysql.Position{}, errors.New("binary log is not enabled")
+		}
+		file, _ := res.GetString(0, 0)
+		pos, err := res.GetUint(0, 1)
+		return mysql.Position{Name: file, Pos: uint32(pos)}, err
+	}
+	res, err := br.execute("SHOW BINARY LOGS")
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	if res.RowNumber() == 0 {
+		return mysql.Position{}, errors.New("binary log is not enabled")
+	}
+	file, err := res.GetString(0, 0)
+	return mysql.Position{Name: file, Pos: binlogStartPos}, err
+}
+
+// initialGTIDSet 在没有记录时根据 read_from 决定起始的 GTID 集合，
+// 最老即从尚未被清理的 binlog 开始，最新即跳过所有已执行的事务
+func (br *MysqlBinlogReader) initialGTIDSet() (string, error) {
+	var query string
+	switch {
+	case br.flavor == mysql.MariaDBFlavor && br.whence == WhenceNewest:
+		query = "SELECT @@GLOBAL.gtid_current_pos"
+	case br.flavor == mysql.MariaDBFlavor:
+		return "", nil
+	case br.whence == WhenceNewest:
+		query = "SELECT @@GLOBAL.gtid_executed"
+	default:
+		query = "SELECT @@GLOBAL.gtid_purged"
+	}
+	res, err := br.execute(query)
+	if err != nil {
+		return "", err
+	}
+	gset, err := res.GetString(0, 0)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(gset), ""), nil
+}
+
+// execute 使用普通连接执行查询，出错后关闭连接，下次查询时重连
+func (br *MysqlBinlogReader) execute(query string, args ...interface{}) (*mysql.Result, error) {
+	br.connMux.Lock()
+	defer br.connMux.Unlock()
+	var err error
+	if br.conn == nil {
+		if br.conn, err = client.Connect(br.address, br.user, br.password, ""); err != nil {
+			br.conn = nil
+			return nil, err
+		}
+	}
+	res, err := br.conn.Execute(query, args...)
+	if err != nil {
+		br.conn.Close()
+		br.conn = nil
+	}
+	return res, err
+}
+
+// handleEvent 将 binlog 事件转换为需要输出的数据，事务结束时附加一个记录同步位置的 commit
+func (br *MysqlBinlogReader) handleEvent(ev *replication.BinlogEvent) []binlogLine {
+	switch e := ev.Event.(type) {
+	case *replication.RotateEvent:
+		br.logFile = string(e.NextLogName)
+		return []binlogLine{{commit: true, pos: mysql.Position{Name: br.logFile, Pos: uint32(e.Position)}}}
+	case *replication.GTIDEvent:
+		u, err := uuid.FromBytes(e.SID)
+		if err == nil {
+			br.gtid = fmt.Sprintf("%s:%d", u, e.GNO)
+		}
+	case *replication.MariadbGTIDEvent:
+		br.gtid = e.GTID.String()
+	case *replication.RowsEvent:
+		return br.rowsLines(ev.Header, e)
+	case *replication.XIDEvent:
+		return []binlogLine{br.commitLine(ev.Header, e.GSet)}
+	case *replication.QueryEvent:
+		query := strings.TrimSpace(string(e.Query))
+		if strings.EqualFold(query, "BEGIN") {
+			return nil
+		}
+		if !strings.EqualFold(query, "COMMIT") {
+			// DDL 可能修改表结构，清空缓存的列名
+			br.columns = make(map[string][]string)
+		}
+		return []binlogLine{br.commitLine(ev.Header, e.GSet)}
+	}
+	return nil
+}
+
+func (br *MysqlBinlogReader) commitLine(header *replication.EventHeader, gset mysql.GTIDSet) binlogLine {
+	br.gtid = ""
+	line := binlogLine{commit: true, pos: mysql.Position{Name: br.logFile, Pos: header.LogPos}}
+	if gset != nil {
+		line.gset = gset.String()
+	}
+	return line
+}
+
+func (br *MysqlBinlogReader) matchTable(schema, table string) bool {
+	if len(br.tables) == 0 {
+		return true
+	}
+	name := schema + "." + table
+	for _, pattern := range br.tables {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (br *MysqlBinlogReader) rowsLines(header *replication.EventHeader, e *replication.RowsEvent) []binlogLine {
+	var op string
+	switch header.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		op = ChangeOpInsert
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		op = ChangeOpUpdate
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		op = ChangeOpDelete
+	default:
+		return nil
+	}
+	if e.Table == nil {
+		return nil
+	}
+	schema, table := string(e.Table.Schema), string(e.Table.Table)
+	if !br.matchTable(schema, table) {
+		return nil
+	}
+	names := br.columnNames(e.Table)
+	unsigned := e.Table.UnsignedMap()
+
+	step := 1
+	if op == ChangeOpUpdate {
+		step = 2
+	}
+	source := schema + "." + table
+	lines := make([]binlogLine, 0, len(e.Rows)/step)
+	for i := 0; i+step <= len(e.Rows); i += step {
+		record := binlogRecord{
+			Op:        op,
+			Database:  schema,
+			Table:     table,
+			Timestamp: header.Timestamp,
+			LogFile:   br.logFile,
+			LogPos:    header.LogPos,
+			GTID:      br.gtid,
+		}
+		switch op {
+		case ChangeOpInsert:
+			record.After = br.rowData(e, i, names, unsigned)
+		case ChangeOpUpdate:
+			record.Before = br.rowData(e, i, names, unsigned)
+			record.After = br.rowData(e, i+1, names, unsigned)
+		case ChangeOpDelete:
+			record.Before = br.rowData(e, i, names, unsigned)
+		}
+		data, err := jsoniter.Marshal(record)
+		if err != nil {
+			log.Errorf("Runner[%v] %v marshal %v row of %v error %v", br.meta.RunnerName, br.Name(), op, source, err)
+			br.setStatsError("Runner[" + br.meta.RunnerName + "] " + br.Name() + " marshal row error " + err.Error())
+			continue
+		}
+		lines = append(lines, binlogLine{data: string(data), source: source})
+	}
+	return lines
+}
+
+// columnNames 优先使用 binlog_row_metadata=FULL 时 binlog 中带有的列名，否则从 information_schema 中查询
+func (br *MysqlBinlogReader) columnNames(table *replication.TableMapEvent) []string {
+	if names := table.ColumnNameString(); len(names) == int(table.ColumnCount) {
+		return names
+	}
+	schema, name := string(table.Schema), string(table.Table)
+	key := schema + "." + name
+	if names, ok := br.columns[key]; ok && len(names) == int(table.ColumnCount) {
+		return names
+	}
+	res, err := br.execute("SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", schema, name)
+	if err != nil {
+		log.Errorf("Runner[%v] %v query columns of %v error %v", br.meta.RunnerName, br.Name(), key, err)
+		br.setStatsError("Runner[" + br.meta.RunnerName + "] " + br.Name() + " query columns of " + key + " error " + err.Error())
+		return nil
+	}
+	names := make([]string, 0, res.RowNumber())
+	for i := 0; i < res.RowNumber(); i++ {
+		column, _ := res.GetString(i, 0)
+		names = append(names, column)
+	}
+	br.columns[key] = names
+	return names
+}
+
+// rowData 将一行的值按列名组织，拿不到列名时使用 col_<序号>，minimal 行格式下未记录的列不输出
+func (br *MysqlBinlogReader) rowData(e *replication.RowsEvent, idx int, names []string, unsigned map[int]bool) map[string]interface{} {
+	skipped := make(map[int]bool)
+	if idx < len(e.SkippedColumns) {
+		for _, col := range e.SkippedColumns[idx] {
+			skipped[col] = true
+		}
+	}
+	row := e.Rows[idx]
+	data := make(map[string]interface{}, len(row))
+	for i, v := range row {
+		if skipped[i] {
+			continue
+		}
+		name := "col_" + strconv.Itoa(i)
+		if i < len(names) {
+			name = names[i]
+		}
+		var columnType byte
+		if i < len(e.Table.ColumnType) {
+			columnType = e.Table.ColumnType[i]
+		}
+		data[name] = binlogValue(v, unsigned[i], columnType)
+	}
+	return data
+}
+
+func binlogValue(v interface{}, unsigned bool, columnType byte) interface{} {
+	switch value := v.(type) {
+	case []byte:
+		return string(value)
+	case decimal.Decimal:
+		return json.Number(value.String())
+	case int8:
+		if unsigned {
+			return uint8(value)
+		}
+	case int16:
+		if unsigned {
+			return uint16(value)
+		}
+	case int32:
+		if unsigned && columnType == mysql.MYSQL_TYPE_INT24 {
+			return uint32(value) & 0xffffff
+		}
+		if unsigned {
+			return uint32(value)
+		}
+	case int64:
+		if unsigned {
+			return uint64(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,207 @@
+package reader
+
+import (
+	"os"
+	"testing"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBinlogReader(t *testing.T, c conf.MapConf) (*MysqlBinlogReader, *Meta) {
+	readConf := conf.MapConf{
+		KeyMetaPath:   metaDir,
+		KeyFileDone:   metaDir,
+		KeyMode:       ModeMysqlBinlog,
+		KeyRunnerName: "TestMysqlBinlogReader",
+	}
+	meta, err := NewMetaWithConf(readConf)
+	require.NoError(t, err)
+	// 地址不可连接，查询列名会失败
+	c[KeyMysqlBinlogAddress] = "127.0.0.1:1"
+	r, err := NewMysqlBinlogReader(meta, c)
+	require.NoError(t, err)
+	br := r.(*MysqlBinlogReader)
+	// 由测试代替 run 投递数据
+	br.started = true
+	return br, meta
+}
+
+func binlogEvent(typ replication.EventType, logPos uint32, e replication.Event) *replication.BinlogEvent {
+	return &replication.BinlogEvent{
+		Header: &replication.EventHeader{Timestamp: 1514764800, EventType: typ, LogPos: logPos},
+		Event:  e,
+	}
+}
+
+func ordersTable(withNames bool) *replication.TableMapEvent {
+	table := &replication.TableMapEvent{
+		Schema:      []byte("shop"),
+		Table:       []byte("orders"),
+		ColumnCount: 4,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_NEWDECIMAL},
+		// id 与 qty 为 unsigned，amount 为 signed
+		SignednessBitmap: []byte{0xc0},
+	}
+	if withNames {
+		table.ColumnName = [][]byte{[]byte("id"), []byte("qty"), []byte("item"), []byte("amount")}
+	}
+	return table
+}
+
+func rowsEvent(table *replication.TableMapEvent, rows ...[]interface{}) *replication.RowsEvent {
+	return &replication.RowsEvent{Table: table, ColumnCount: table.ColumnCount, Rows: rows}
+}
+
+func TestMysqlBinlogReaderEvents(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	br, meta := newTestBinlogReader(t, conf.MapConf{KeyMysqlBinlogTables: "shop.*"})
+	defer br.Close()
+
+	sid := uuid.MustParse("3e11fa47-71ca-11e1-9e33-c80aa9429562")
+	gset, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-7")
+	require.NoError(t, err)
+	orders := ordersTable(true)
+	amount := mustDecimal(t, "12.50")
+	events := []*replication.BinlogEvent{
+		binlogEvent(replication.ROTATE_EVENT, 0, &replication.RotateEvent{Position: 4, NextLogName: []byte("mysql-bin.000003")}),
+		binlogEvent(replication.GTID_EVENT, 300, &replication.GTIDEvent{SID: sid[:], GNO: 7}),
+		binlogEvent(replication.QUERY_EVENT, 380, &replication.QueryEvent{Query: []byte("BEGIN")}),
+		binlogEvent(replication.WRITE_ROWS_EVENTv2, 450, rowsEvent(orders,
+			[]interface{}{int32(-1), int32(-1), []byte("book"), amount})),
+		binlogEvent(replication.UPDATE_ROWS_EVENTv2, 520, rowsEvent(orders,
+			[]interface{}{int32(1), int32(2), "book", amount},
+			[]interface{}{int32(1), int32(3), "book", nil})),
+		binlogEvent(replication.DELETE_ROWS_EVENTv2, 580, rowsEvent(orders,
+			[]interface{}{int32(2), int32(1), "pen", amount})),
+		// 不在采集范围内的表
+		binlogEvent(replication.WRITE_ROWS_EVENTv2, 600, rowsEvent(&replication.TableMapEvent{Schema: []byte("mysql"), Table: []byte("user"), ColumnCount: 1},
+			[]interface{}{"root"})),
+		binlogEvent(replication.XID_EVENT, 640, &replication.XIDEvent{XID: 10, GSet: gset}),
+	}
+	var lines []binlogLine
+	for _, ev := range events {
+		lines = append(lines, br.handleEvent(ev)...)
+	}
+	require.Len(t, lines, 5)
+	assert.True(t, lines[0].commit)
+	assert.Equal(t, mysql.Position{Name: "mysql-bin.000003", Pos: 4}, lines[0].pos)
+	assert.Equal(t, binlogLine{commit: true, pos: mysql.Position{Name: "mysql-bin.000003", Pos: 640}, gset: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-7"}, lines[4])
+
+	go func() {
+		for _, line := range lines {
+			br.readChan <- line
+		}
+	}()
+	exp := []string{
+		`{"op":"insert","database":"shop","table":"orders","timestamp":1514764800,"log_file":"mysql-bin.000003","log_pos":450,"gtid":"3e11fa47-71ca-11e1-9e33-c80aa9429562:7","after":{"amount":12.5,"id":4294967295,"item":"book","qty":16777215}}`,
+		`{"op":"update","database":"shop","table":"orders","timestamp":1514764800,"log_file":"mysql-bin.000003","log_pos":520,"gtid":"3e11fa47-71ca-11e1-9e33-c80aa9429562:7","before":{"amount":12.5,"id":1,"item":"book","qty":2},"after":{"amount":null,"id":1,"item":"book","qty":3}}`,
+		`{"op":"delete","database":"shop","table":"orders","timestamp":1514764800,"log_file":"mysql-bin.000003","log_pos":580,"gtid":"3e11fa47-71ca-11e1-9e33-c80aa9429562:7","before":{"amount":12.5,"id":2,"item":"pen","qty":1}}`,
+	}
+	for _, e := range exp {
+		line, err := br.ReadLine()
+		assert.NoError(t, err)
+		assert.JSONEq(t, e, line)
+		assert.Equal(t, "shop.orders", br.Source())
+	}
+	// 事务还没有读完，只记录到 rotate 的位置
+	br.SyncMeta()
+	file, pos, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "mysql-bin.000003", file)
+	assert.Equal(t, int64(4), pos)
+
+	line, err := br.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	br.SyncMeta()
+	file, pos, err = meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "mysql-bin.000003", file)
+	assert.Equal(t, int64(640), pos)
+
+	// 重启后从记录的位置继续同步
+	r, err := NewMysqlBinlogReader(meta, conf.MapConf{})
+	require.NoError(t, err)
+	assert.Equal(t, mysql.Position{Name: "mysql-bin.000003", Pos: 640}, r.(*MysqlBinlogReader).syncPos)
+	assert.NoError(t, r.Close())
+}
+
+func TestMysqlBinlogReaderGTID(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	br, meta := newTestBinlogReader(t, conf.MapConf{
+		KeyMysqlBinlogGTID:    "true",
+		KeyMysqlBinlogGTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5",
+	})
+	defer br.Close()
+	assert.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5", br.syncGset)
+
+	gset, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-6")
+	require.NoError(t, err)
+	// 没有列名元数据且查询失败时使用 col_<序号>
+	lines := br.handleEvent(binlogEvent(replication.WRITE_ROWS_EVENTv1, 200, rowsEvent(ordersTable(false),
+		[]interface{}{int32(5), int32(1), []byte("cup"), mustDecimal(t, "-0.10")})))
+	lines = append(lines, br.handleEvent(binlogEvent(replication.QUERY_EVENT, 260, &replication.QueryEvent{Query: []byte("ALTER TABLE orders ADD note TEXT"), GSet: gset}))...)
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"op":"insert","database":"shop","table":"orders","timestamp":1514764800,"log_file":"","log_pos":200,"after":{"col_0":5,"col_1":1,"col_2":"cup","col_3":-0.10}}`, lines[0].data)
+	assert.Equal(t, int64(1), br.Status().Errors)
+
+	go func() {
+		for _, line := range lines {
+			br.readChan <- line
+		}
+	}()
+	line, err := br.ReadLine()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, line)
+	line, err = br.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+	br.SyncMeta()
+	offset, _, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-6", offset)
+
+	r, err := NewMysqlBinlogReader(meta, conf.MapConf{KeyMysqlBinlogGTID: "true"})
+	require.NoError(t, err)
+	assert.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-6", r.(*MysqlBinlogReader).syncGset)
+	assert.NoError(t, r.Close())
+}
+
+func mustDecimal(t *testing.T, s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	require.NoError(t, err)
+	return d
+}
+
+func TestNewMysqlBinlogReaderConfig(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	meta, err := NewMetaWithConf(conf.MapConf{KeyMetaPath: metaDir, KeyFileDone: metaDir, KeyMode: ModeMysqlBinlog})
+	require.NoError(t, err)
+	_, err = NewMysqlBinlogReader(meta, conf.MapConf{KeyMysqlBinlogFlavor: "oracle"})
+	assert.Error(t, err)
+	_, err = NewMysqlBinlogReader(meta, conf.MapConf{KeyMysqlBinlogTables: "shop.[a"})
+	assert.Error(t, err)
+	_, err = NewMysqlBinlogReader(meta, conf.MapConf{KeyMysqlBinlogGTID: "true", KeyMysqlBinlogGTIDSet: "bad"})
+	assert.Error(t, err)
+
+	r, err := NewMysqlBinlogReader(meta, conf.MapConf{KeyMysqlBinlogFile: "mysql-bin.000010", KeyMysqlBinlogPos: "154"})
+	require.NoError(t, err)
+	br := r.(*MysqlBinlogReader)
+	assert.Equal(t, mysql.Position{Name: "mysql-bin.000010", Pos: 154}, br.syncPos)
+	assert.True(t, br.matchTable("any", "table"))
+
+	host, port, err := splitMysqlAddress("[::1]:3307")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"::1", "3307"}, []string{host, port})
+	host, port, err = splitMysqlAddress("db.local")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db.local", "3306"}, []string{host, port})
+}
@@ -0,0 +1,227 @@
+package reader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScramClient(t *testing.T) {
+	// RFC 7677 中的示例
+	s := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	s.clientFirst()
+	s.clientFirstBare = "n=user,r=rOprNGfwEbeRWgbNEkqO"
+	final, err := s.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	require.NoError(t, err)
+	assert.Equal(t, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", final)
+	assert.NoError(t, s.verifyServerFinal("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="))
+	assert.Error(t, s.verifyServerFinal("v=AAAA"))
+
+	_, err = s.clientFinal("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	assert.Error(t, err)
+
+	s, err = newScramClient("pencil")
+	require.NoError(t, err)
+	assert.Equal(t, "n,,n=,r="+s.nonce, s.clientFirst())
+}
+
+func TestPGMD5Password(t *testing.T) {
+	assert.Equal(t, "md50427ae66ec1d43d41bdce9c74f06c52b", pgMD5Password("logkit", "secret", []byte{1, 2, 3, 4}))
+}
+
+func TestParsePGDataSource(t *testing.T) {
+	cfg, err := parsePGDataSource("host=db.local port=5433 connect_timeout=3 user=logkit password='p@ss' sslmode=require")
+	require.NoError(t, err)
+	assert.Equal(t, &pgConnConfig{
+		host:           "db.local",
+		port:           "5433",
+		user:           "logkit",
+		password:       "p@ss",
+		database:       "logkit",
+		sslmode:        "require",
+		connectTimeout: 3 * time.Second,
+	}, cfg)
+	_, err = parsePGDataSource("host")
+	assert.Error(t, err)
+	_, err = parsePGDataSource("connect_timeout=abc")
+	assert.Error(t, err)
+}
+
+func TestParsePGConnInfo(t *testing.T) {
+	fields, err := parsePGConnInfo(`  host = db.local password='a b' user=it\'s dbname='it\'s \\ db' sslmode=''`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"host":     "db.local",
+		"password": "a b",
+		"user":     "it's",
+		"dbname":   `it's \ db`,
+		"sslmode":  "",
+	}, fields)
+
+	cfg, err := parsePGDataSource("password='a b' dbname=logs")
+	require.NoError(t, err)
+	assert.Equal(t, "a b", cfg.password)
+	assert.Equal(t, "logs", cfg.database)
+
+	for _, dataSource := range []string{"password='a b", "host db.local", "=db.local", "host"} {
+		_, err = parsePGConnInfo(dataSource)
+		assert.Error(t, err, dataSource)
+	}
+}
+
+func TestPGProtocolHelpers(t *testing.T) {
+	lsn, err := parseLSN("16/B374D848")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x16B374D848), lsn)
+	assert.Equal(t, "16/B374D848", formatLSN(lsn))
+	_, err = parseLSN("16B374D848")
+	assert.Error(t, err)
+
+	err = parsePGError([]byte("SERROR\x00C42710\x00Mreplication slot \"s\" already exists\x00\x00"))
+	assert.Equal(t, &pgError{severity: "ERROR", code: "42710", message: `replication slot "s" already exists`}, err)
+
+	row, err := parsePGDataRow([]byte{0, 2, 0, 0, 0, 2, 'o', 'k', 0xff, 0xff, 0xff, 0xff})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ok", ""}, row)
+	_, err = parsePGDataRow([]byte{0, 1, 0, 0, 0, 9, 'x'})
+	assert.Error(t, err)
+}
+
+// pgTestCA 生成自签名的 CA 以及由它签发的服务端证书，服务端证书只包含 127.0.0.1
+func pgTestCA(t *testing.T) (caPEM []byte, serverCert tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "logkit test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return caPEM, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startFakePGTLSServer 回应 SSLRequest 并完成 TLS 握手
+func startFakePGTLSServer(t *testing.T, cert tls.Certificate) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req := make([]byte, 8)
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+				conn.Write([]byte{'S'})
+				tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+				if tlsConn.Handshake() == nil {
+					io.Copy(ioutil.Discard, tlsConn)
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestPGStartTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgtls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caPEM, cert := pgTestCA(t)
+	otherCAPEM, _ := pgTestCA(t)
+	caFile := filepath.Join(dir, "root.crt")
+	otherCAFile := filepath.Join(dir, "other.crt")
+	invalidFile := filepath.Join(dir, "invalid.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0644))
+	require.NoError(t, ioutil.WriteFile(otherCAFile, otherCAPEM, 0644))
+	require.NoError(t, ioutil.WriteFile(invalidFile, []byte("not a certificate"), 0644))
+
+	ln := startFakePGTLSServer(t, cert)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	tests := []struct {
+		host        string
+		sslmode     string
+		sslrootcert string
+		parseErr    bool
+		connectErr  bool
+	}{
+		{host: "127.0.0.1", sslmode: "require"},
+		{host: "127.0.0.1", sslmode: "require", sslrootcert: caFile},
+		{host: "127.0.0.1", sslmode: "require", sslrootcert: otherCAFile, connectErr: true},
+		{host: "localhost", sslmode: "verify-ca", sslrootcert: caFile},
+		{host: "127.0.0.1", sslmode: "verify-ca", sslrootcert: otherCAFile, connectErr: true},
+		{host: "127.0.0.1", sslmode: "verify-ca", parseErr: true},
+		{host: "127.0.0.1", sslmode: "verify-ca", sslrootcert: filepath.Join(dir, "missing.crt"), parseErr: true},
+		{host: "127.0.0.1", sslmode: "verify-ca", sslrootcert: invalidFile, parseErr: true},
+		{host: "127.0.0.1", sslmode: "verify-full", sslrootcert: caFile},
+		{host: "localhost", sslmode: "verify-full", sslrootcert: caFile, connectErr: true},
+		{host: "127.0.0.1", sslmode: "verify-full", sslrootcert: otherCAFile, connectErr: true},
+		// 自签名的证书不在系统的根证书中
+		{host: "127.0.0.1", sslmode: "verify-full", connectErr: true},
+	}
+	for _, ti := range tests {
+		dataSource := "host=" + ti.host + " port=" + port + " sslmode=" + ti.sslmode
+		if ti.sslrootcert != "" {
+			dataSource += " sslrootcert=" + ti.sslrootcert
+		}
+		cfg, err := parsePGDataSource(dataSource)
+		if ti.parseErr {
+			assert.Error(t, err, dataSource)
+			continue
+		}
+		require.NoError(t, err, dataSource)
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		conn, err = pgStartTLS(conn, cfg)
+		if ti.connectErr {
+			assert.Error(t, err, dataSource)
+			continue
+		}
+		if assert.NoError(t, err, dataSource) {
+			conn.Close()
+		}
+	}
+}
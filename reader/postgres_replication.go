@@ -0,0 +1,446 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// 数据源格式与 postgres_datasource 相同，如 "host=localhost port=5432 user=postgres password=123456 dbname=db sslmode=disable"
+	KeyPGReplicationDataSource = "postgres_replication_datasource"
+	// 逻辑复制槽名称，只能包含小写字母、数字与下划线
+	KeyPGReplicationSlot = "postgres_replication_slot"
+	// pgoutput 或 wal2json，默认 pgoutput
+	KeyPGReplicationPlugin = "postgres_replication_plugin"
+	// pgoutput 使用的 publication，多个用逗号分隔
+	KeyPGReplicationPublication = "postgres_replication_publication"
+	// 复制槽不存在时自动创建，默认 true
+	KeyPGReplicationCreateSlot = "postgres_replication_create_slot"
+	// 向服务端汇报确认位置的间隔，默认 10s
+	KeyPGReplicationStatusInterval = "postgres_replication_status_interval"
+)
+
+const pgReplicationRetryInterval = 5 * time.Second
+
+var pgSlotNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// pgLine 是 stream 与 ReadLine 之间传递的数据，commit 为 true 时只携带事务结束的 LSN
+type pgLine struct {
+	data   string
+	source string
+	commit bool
+	lsn    uint64
+}
+
+// PGReplicationReader 消费 PostgreSQL 的逻辑复制槽，
+// 只有在 SyncMeta 之后才会把确认的 LSN 写入 meta 并汇报给服务端，保证至少一次的投递
+type PGReplicationReader struct {
+	meta           *Meta
+	cfg            *pgConnConfig
+	slot           string
+	plugin         string
+	publication    string
+	createSlot     bool
+	statusInterval time.Duration
+
+	readChan chan pgLine
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// 已经被 ReadLine 读过的事务结束位置
+	readLSN uint64
+	// SyncMeta 确认的位置，stream 汇报给服务端，需要原子操作
+	confirmedLSN uint64
+	lastSource   string
+
+	conn    *pgConn
+	status  int32
+	mux     sync.Mutex
+	started bool
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewPGReplicationReader(meta *Meta, conf conf.MapConf) (reader Reader, err error) {
+	dataSource, err := conf.GetString(KeyPGReplicationDataSource)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parsePGDataSource(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	slot, err := conf.GetString(KeyPGReplicationSlot)
+	if err != nil {
+		return nil, err
+	}
+	if !pgSlotNameRegexp.MatchString(slot) {
+		return nil, fmt.Errorf("invalid %v %v, only lower case letters, numbers and underscore are allowed", KeyPGReplicationSlot, slot)
+	}
+	plugin, _ := conf.GetStringOr(KeyPGReplicationPlugin, PGPluginPgoutput)
+	if plugin != PGPluginPgoutput && plugin != PGPluginWal2json {
+		return nil, fmt.Errorf("unsupported %v %v, must be %v or %v", KeyPGReplicationPlugin, plugin, PGPluginPgoutput, PGPluginWal2json)
+	}
+	publication, _ := conf.GetStringOr(KeyPGReplicationPublication, "")
+	if plugin == PGPluginPgoutput && publication == "" {
+		return nil, fmt.Errorf("%v is required by %v", KeyPGReplicationPublication, PGPluginPgoutput)
+	}
+	createSlot, _ := conf.GetBoolOr(KeyPGReplicationCreateSlot, true)
+	interval, _ := conf.GetStringOr(KeyPGReplicationStatusInterval, "10s")
+	statusInterval, err := time.ParseDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+	if statusInterval <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeyPGReplicationStatusInterval)
+	}
+
+	pr := &PGReplicationReader{
+		meta:           meta,
+		cfg:            cfg,
+		slot:           slot,
+		plugin:         plugin,
+		publication:    publication,
+		createSlot:     createSlot,
+		statusInterval: statusInterval,
+		readChan:       make(chan pgLine),
+		status:         StatusInit,
+	}
+	pr.ctx, pr.cancel = context.WithCancel(context.Background())
+
+	offset, lsn, err := meta.ReadOffset()
+	if err == nil && offset == slot && lsn > 0 {
+		pr.readLSN = uint64(lsn)
+		pr.confirmedLSN = uint64(lsn)
+	} else if err == nil && offset != "" {
+		log.Warnf("Runner[%v] %v ignore meta offset of slot %v", meta.RunnerName, pr.Name(), offset)
+	}
+	return pr, nil
+}
+
+func (pr *PGReplicationReader) Name() string {
+	return "PGReplicationReader:" + pr.slot
+}
+
+// Source 返回最近一行数据所属的表，格式为 schema.table
+func (pr *PGReplicationReader) Source() string {
+	pr.mux.Lock()
+	defer pr.mux.Unlock()
+	if pr.lastSource == "" {
+		return pr.cfg.host + ":" + pr.cfg.port + "/" + pr.cfg.database
+	}
+	return pr.lastSource
+}
+
+func (pr *PGReplicationReader) SetMode(mode string, v interface{}) error {
+	return errors.New("PGReplicationReader not support read mode")
+}
+
+func (pr *PGReplicationReader) Status() StatsInfo {
+	pr.statsLock.RLock()
+	defer pr.statsLock.RUnlock()
+	return pr.stats
+}
+
+func (pr *PGReplicationReader) setStatsError(err string) {
+	pr.statsLock.Lock()
+	defer pr.statsLock.Unlock()
+	pr.stats.Errors++
+	pr.stats.LastError = err
+}
+
+func (pr *PGReplicationReader) Start() {
+	pr.mux.Lock()
+	defer pr.mux.Unlock()
+	if pr.started {
+		return
+	}
+	pr.started = true
+	go pr.run()
+	log.Infof("Runner[%v] %v pull data daemon started", pr.meta.RunnerName, pr.Name())
+}
+
+func (pr *PGReplicationReader) ReadLine() (data string, err error) {
+	if !pr.started {
+		pr.Start()
+	}
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case line := <-pr.readChan:
+			pr.mux.Lock()
+			if line.commit {
+				if line.lsn > pr.readLSN {
+					pr.readLSN = line.lsn
+				}
+				pr.mux.Unlock()
+				continue
+			}
+			pr.lastSource = line.source
+			pr.mux.Unlock()
+			return line.data, nil
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// SyncMeta 在数据发送成功后被调用，此时才把已读完的事务位置记录下来并确认给服务端
+func (pr *PGReplicationReader) SyncMeta() {
+	pr.mux.Lock()
+	lsn := pr.readLSN
+	pr.mux.Unlock()
+	if lsn <= atomic.LoadUint64(&pr.confirmedLSN) {
+		return
+	}
+	if err := pr.meta.WriteOffset(pr.slot, int64(lsn)); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", pr.meta.RunnerName, pr.Name(), err)
+		return
+	}
+	atomic.StoreUint64(&pr.confirmedLSN, lsn)
+}
+
+func (pr *PGReplicationReader) Close() error {
+	if !atomic.CompareAndSwapInt32(&pr.status, StatusInit, StatusStopped) {
+		atomic.StoreInt32(&pr.status, StatusStopping)
+	}
+	pr.cancel()
+	pr.mux.Lock()
+	if pr.conn != nil {
+		pr.conn.Close()
+		pr.conn = nil
+	}
+	pr.mux.Unlock()
+	log.Infof("Runner[%v] %v stopped", pr.meta.RunnerName, pr.Name())
+	return nil
+}
+
+func (pr *PGReplicationReader) isStopping() bool {
+	status := atomic.LoadInt32(&pr.status)
+	return status == StatusStopping || status == StatusStopped
+}
+
+func (pr *PGReplicationReader) run() {
+	if !atomic.CompareAndSwapInt32(&pr.status, StatusInit, StatusRunning) {
+		return
+	}
+	defer atomic.CompareAndSwapInt32(&pr.status, StatusStopping, StatusStopped)
+	for !pr.isStopping() {
+		err := pr.stream()
+		if err == nil || pr.isStopping() {
+			return
+		}
+		log.Errorf("Runner[%v] %v replication error %v, retry after %v", pr.meta.RunnerName, pr.Name(), err, pgReplicationRetryInterval)
+		pr.setStatsError("Runner[" + pr.meta.RunnerName + "] " + pr.Name() + " replication error " + err.Error())
+		select {
+		case <-pr.ctx.Done():
+			return
+		case <-time.After(pgReplicationRetryInterval):
+		}
+	}
+}
+
+func pgQuoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func (pr *PGReplicationReader) startReplicationQuery(lsn uint64) string {
+	var options []string
+	if pr.plugin == PGPluginPgoutput {
+		options = []string{`"proto_version" '1'`, `"publication_names" ` + pgQuoteLiteral(pr.publication)}
+	} else {
+		options = []string{`"format-version" '2'`, `"include-xids" '1'`, `"include-timestamp" '1'`}
+	}
+	return fmt.Sprintf("START_REPLICATION SLOT %s LOGICAL %s (%s)", pr.slot, formatLSN(lsn), strings.Join(options, ", "))
+}
+
+// stream 建立复制连接并持续接收数据，未确认的数据在重连后由服务端重新发送
+func (pr *PGReplicationReader) stream() error {
+	conn, err := dialPGReplication(pr.cfg)
+	if err != nil {
+		return err
+	}
+	pr.mux.Lock()
+	if pr.isStopping() {
+		pr.mux.Unlock()
+		conn.Close()
+		return nil
+	}
+	pr.conn = conn
+	pr.mux.Unlock()
+	defer func() {
+		pr.mux.Lock()
+		if pr.conn != nil {
+			pr.conn.Close()
+			pr.conn = nil
+		}
+		pr.mux.Unlock()
+	}()
+
+	if pr.createSlot {
+		_, err = conn.simpleQuery(fmt.Sprintf("CREATE_REPLICATION_SLOT %s LOGICAL %s", pr.slot, pr.plugin))
+		if pgErr, ok := err.(*pgError); ok && pgErr.code == pgErrDuplicateObject {
+			err = nil
+		} else if err == nil {
+			log.Infof("Runner[%v] %v created replication slot %v with plugin %v", pr.meta.RunnerName, pr.Name(), pr.slot, pr.plugin)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	startLSN := atomic.LoadUint64(&pr.confirmedLSN)
+	if err = conn.startCopyBoth(pr.startReplicationQuery(startLSN)); err != nil {
+		return err
+	}
+	log.Infof("Runner[%v] %v start replication from %v", pr.meta.RunnerName, pr.Name(), formatLSN(startLSN))
+
+	msgs := make(chan pgMessage)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := conn.receive()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-pr.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	s := &pgStream{reader: pr, conn: conn, decoder: newPGLogicalDecoder(pr.plugin), errs: errs, lastCommit: startLSN}
+	s.ticker = time.NewTicker(pr.statusInterval)
+	defer s.ticker.Stop()
+	for {
+		select {
+		case msg := <-msgs:
+			if err = s.handle(msg); err != nil {
+				return err
+			}
+		case <-s.ticker.C:
+			if err = s.sendStatus(); err != nil {
+				return err
+			}
+		case err = <-errs:
+			return err
+		case <-pr.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// pgStream 保存一次复制会话的状态，只在 stream 所在的 goroutine 中使用
+type pgStream struct {
+	reader     *PGReplicationReader
+	conn       *pgConn
+	decoder    *pgLogicalDecoder
+	ticker     *time.Ticker
+	errs       chan error
+	received   uint64
+	lastCommit uint64
+}
+
+func (s *pgStream) sendStatus() error {
+	return s.conn.sendStandbyStatus(s.received, atomic.LoadUint64(&s.reader.confirmedLSN))
+}
+
+// emit 等待 ReadLine 取走数据，等待期间仍然按时汇报状态，避免服务端因 wal_sender_timeout 断开连接
+func (s *pgStream) emit(line pgLine) error {
+	for {
+		select {
+		case s.reader.readChan <- line:
+			return nil
+		case <-s.ticker.C:
+			if err := s.sendStatus(); err != nil {
+				return err
+			}
+		case err := <-s.errs:
+			return err
+		case <-s.reader.ctx.Done():
+			return context.Canceled
+		}
+	}
+}
+
+func (s *pgStream) handle(msg pgMessage) error {
+	switch msg.typ {
+	case 'E':
+		return parsePGError(msg.data)
+	case 'c':
+		return errors.New("server ended the replication stream")
+	case 'd':
+	default:
+		return nil
+	}
+	if len(msg.data) == 0 {
+		return errors.New("empty copy data")
+	}
+	pr := s.reader
+	switch msg.data[0] {
+	case 'w':
+		if len(msg.data) < 25 {
+			return errors.New("invalid XLogData message")
+		}
+		walStart := binary.BigEndian.Uint64(msg.data[1:])
+		if walStart > s.received {
+			s.received = walStart
+		}
+		records, commit, err := s.decoder.decode(walStart, msg.data[25:])
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			data, err := jsoniter.Marshal(record)
+			if err != nil {
+				log.Errorf("Runner[%v] %v marshal %v row of %v.%v error %v", pr.meta.RunnerName, pr.Name(), record.Op, record.Schema, record.Table, err)
+				pr.setStatsError("Runner[" + pr.meta.RunnerName + "] " + pr.Name() + " marshal row error " + err.Error())
+				continue
+			}
+			if err = s.emit(pgLine{data: string(data), source: record.Schema + "." + record.Table}); err != nil {
+				return err
+			}
+		}
+		if commit {
+			// 事务提交消息的起始位置即事务的结束位置
+			s.lastCommit = walStart
+			return s.emit(pgLine{commit: true, lsn: walStart})
+		}
+	case 'k':
+		if len(msg.data) < 18 {
+			return errors.New("invalid keepalive message")
+		}
+		walEnd := binary.BigEndian.Uint64(msg.data[1:])
+		if walEnd > s.received {
+			s.received = walEnd
+		}
+		// 不在事务中时，keepalive 的位置之前的数据都已经收到，可以推进位置，避免空闲的复制槽一直保留 WAL
+		if !s.decoder.inTx && walEnd > s.lastCommit {
+			s.lastCommit = walEnd
+			if err := s.emit(pgLine{commit: true, lsn: walEnd}); err != nil {
+				return err
+			}
+		}
+		if msg.data[17] == 1 {
+			return s.sendStatus()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePGServer 模拟 walsender，按顺序发送 copyData 中的消息，并记录客户端汇报的确认位置
+type fakePGServer struct {
+	ln       net.Listener
+	copyData [][]byte
+	queries  chan string
+	flushed  chan uint64
+}
+
+func pgTestMessage(typ byte, data []byte) []byte {
+	buf := []byte{typ}
+	buf = appendUint32(buf, uint32(len(data)+4))
+	return append(buf, data...)
+}
+
+func pgTestXLogData(lsn uint64, payload []byte) []byte {
+	buf := []byte{'w'}
+	buf = appendUint64(buf, lsn)
+	buf = appendUint64(buf, lsn)
+	buf = appendUint64(buf, 0)
+	return append(buf, payload...)
+}
+
+func pgTestKeepalive(walEnd uint64) []byte {
+	buf := []byte{'k'}
+	buf = appendUint64(buf, walEnd)
+	buf = appendUint64(buf, 0)
+	return append(buf, 0)
+}
+
+func startFakePGServer(t *testing.T, copyData [][]byte) *fakePGServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakePGServer{ln: ln, copyData: copyData, queries: make(chan string, 10), flushed: make(chan uint64, 1000)}
+	go s.serve()
+	return s
+}
+
+func (s *fakePGServer) port() string {
+	return strings.Split(s.ln.Addr().String(), ":")[1]
+}
+
+func (s *fakePGServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	startup := make([]byte, binary.BigEndian.Uint32(header)-4)
+	if _, err = io.ReadFull(r, startup); err != nil || !strings.Contains(string(startup), "replication\x00database\x00") {
+		return
+	}
+	conn.Write(pgTestMessage('R', appendUint32(nil, pgAuthCleartext)))
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if _, err = io.ReadFull(r, header); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header)-4)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return
+		}
+		switch typ {
+		case 'p':
+			if string(data) != "secret\x00" {
+				conn.Write(pgTestMessage('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00")))
+				return
+			}
+			conn.Write(pgTestMessage('R', appendUint32(nil, pgAuthOk)))
+			conn.Write(pgTestMessage('Z', []byte{'I'}))
+		case 'Q':
+			query := strings.TrimSuffix(string(data), "\x00")
+			s.queries <- query
+			if strings.HasPrefix(query, "CREATE_REPLICATION_SLOT") {
+				conn.Write(pgTestMessage('E', []byte("SERROR\x00C42710\x00Mreplication slot already exists\x00\x00")))
+				conn.Write(pgTestMessage('Z', []byte{'I'}))
+				continue
+			}
+			conn.Write(pgTestMessage('W', []byte{0, 0, 0}))
+			for _, d := range s.copyData {
+				conn.Write(pgTestMessage('d', d))
+			}
+		case 'd':
+			if len(data) == 34 && data[0] == 'r' {
+				select {
+				case s.flushed <- binary.BigEndian.Uint64(data[9:]):
+				default:
+				}
+			}
+		case 'X':
+			return
+		}
+	}
+}
+
+// waitFlushed 等待客户端汇报指定的确认位置
+func (s *fakePGServer) waitFlushed(lsn uint64, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case flushed := <-s.flushed:
+			if flushed == lsn {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func pgoutputRelation(id uint32, schema, table string, columns ...pgColumn) []byte {
+	buf := []byte{'R'}
+	buf = appendUint32(buf, id)
+	buf = append(append(buf, schema...), 0)
+	buf = append(append(buf, table...), 0)
+	buf = append(buf, 'd', 0, byte(len(columns)))
+	for _, col := range columns {
+		if col.key {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		buf = append(append(buf, col.name...), 0)
+		buf = appendUint32(buf, col.oid)
+		buf = appendUint32(buf, 0xffffffff)
+	}
+	return buf
+}
+
+// pgoutputTuple 编码 TupleData，nil 表示 NULL，"\x00u" 表示未变化的 TOAST 值
+func pgoutputTuple(values ...interface{}) []byte {
+	buf := []byte{0, byte(len(values))}
+	for _, v := range values {
+		switch v {
+		case nil:
+			buf = append(buf, 'n')
+		case "\x00u":
+			buf = append(buf, 'u')
+		default:
+			s := v.(string)
+			buf = append(buf, 't')
+			buf = appendUint32(buf, uint32(len(s)))
+			buf = append(buf, s...)
+		}
+	}
+	return buf
+}
+
+func pgoutputBegin(finalLSN uint64, ts time.Time, xid uint32) []byte {
+	buf := appendUint64([]byte{'B'}, finalLSN)
+	buf = appendUint64(buf, uint64(ts.Sub(pgEpoch)/time.Microsecond))
+	return appendUint32(buf, xid)
+}
+
+func pgoutputCommit(commitLSN, endLSN uint64) []byte {
+	buf := appendUint64([]byte{'C', 0}, commitLSN)
+	buf = appendUint64(buf, endLSN)
+	return appendUint64(buf, 0)
+}
+
+func TestPGReplicationReader(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	commitTime := time.Date(2018, 1, 1, 0, 0, 0, 500000000, time.UTC)
+	copyData := [][]byte{
+		pgTestXLogData(0x16B2400, pgoutputBegin(0x16B2700, commitTime, 731)),
+		pgTestXLogData(0x16B2400, pgoutputRelation(16386, "public", "orders",
+			pgColumn{name: "id", key: true, oid: pgOidInt4},
+			pgColumn{name: "item", oid: 25},
+			pgColumn{name: "price", oid: pgOidNumeric},
+			pgColumn{name: "paid", oid: pgOidBool},
+			pgColumn{name: "note", oid: 25})),
+		pgTestXLogData(0x16B2438, append(appendUint32([]byte{'I'}, 16386), append([]byte{'N'}, pgoutputTuple("1", "book", "12.50", "f", nil)...)...)),
+		pgTestXLogData(0x16B2500, append(appendUint32([]byte{'U'}, 16386), append([]byte{'N'}, pgoutputTuple("1", "book", "NaN", "t", "\x00u")...)...)),
+		pgTestXLogData(0x16B2600, append(appendUint32([]byte{'D'}, 16386), append([]byte{'K'}, pgoutputTuple("1", nil, nil, nil, nil)...)...)),
+		pgTestXLogData(0x16B2700, pgoutputCommit(0x16B2690, 0x16B2700)),
+		pgTestKeepalive(0x16B2800),
+	}
+	server := startFakePGServer(t, copyData)
+	defer server.ln.Close()
+
+	c := conf.MapConf{
+		KeyMetaPath:                    metaDir,
+		KeyFileDone:                    metaDir,
+		KeyMode:                        ModePGReplication,
+		KeyPGReplicationDataSource:     "host=127.0.0.1 port=" + server.port() + " user=logkit password=secret dbname=shop sslmode=disable",
+		KeyPGReplicationSlot:           "logkit_slot",
+		KeyPGReplicationPublication:    "logkit_pub",
+		KeyPGReplicationStatusInterval: "20ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewPGReplicationReader(meta, c)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, "PGReplicationReader:logkit_slot", r.Name())
+
+	exp := []string{
+		`{"op":"insert","schema":"public","table":"orders","lsn":"0/16B2438","xid":731,"timestamp":"2018-01-01T00:00:00.5Z","after":{"id":1,"item":"book","price":12.50,"paid":false,"note":null}}`,
+		`{"op":"update","schema":"public","table":"orders","lsn":"0/16B2500","xid":731,"timestamp":"2018-01-01T00:00:00.5Z","after":{"id":1,"item":"book","price":"NaN","paid":true}}`,
+		`{"op":"delete","schema":"public","table":"orders","lsn":"0/16B2600","xid":731,"timestamp":"2018-01-01T00:00:00.5Z","before":{"id":1}}`,
+	}
+	for _, e := range exp {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		assert.JSONEq(t, e, line)
+		assert.Equal(t, "public.orders", r.Source())
+	}
+	assert.Equal(t, "CREATE_REPLICATION_SLOT logkit_slot LOGICAL pgoutput", <-server.queries)
+	assert.Equal(t, `START_REPLICATION SLOT logkit_slot LOGICAL 0/0 ("proto_version" '1', "publication_names" 'logkit_pub')`, <-server.queries)
+
+	// 读取 commit 与 keepalive 带来的位置，但在 SyncMeta 之前不会确认
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	assert.False(t, server.waitFlushed(0x16B2800, 100*time.Millisecond))
+	_, _, err = meta.ReadOffset()
+	assert.Error(t, err)
+
+	r.SyncMeta()
+	assert.True(t, server.waitFlushed(0x16B2800, 5*time.Second))
+	slot, lsn, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "logkit_slot", slot)
+	assert.Equal(t, int64(0x16B2800), lsn)
+	assert.Equal(t, int64(0), r.(*PGReplicationReader).Status().Errors)
+	assert.NoError(t, r.Close())
+
+	// 重启后从确认的位置开始复制
+	r, err = NewPGReplicationReader(meta, c)
+	require.NoError(t, err)
+	assert.Equal(t, `START_REPLICATION SLOT logkit_slot LOGICAL 0/16B2800 ("proto_version" '1', "publication_names" 'logkit_pub')`,
+		r.(*PGReplicationReader).startReplicationQuery(r.(*PGReplicationReader).confirmedLSN))
+}
+
+func TestPGLogicalDecoderWal2json(t *testing.T) {
+	d := newPGLogicalDecoder(PGPluginWal2json)
+	messages := []string{
+		`{"action":"B","xid":731,"timestamp":"2018-01-01 08:00:00.5+08"}`,
+		`{"action":"I","schema":"public","table":"orders","columns":[{"name":"id","type":"integer","value":1},{"name":"price","type":"numeric(10,2)","value":12.50}]}`,
+		`{"action":"U","schema":"public","table":"orders","columns":[{"name":"id","type":"integer","value":1},{"name":"price","type":"numeric(10,2)","value":13.00}],"identity":[{"name":"id","type":"integer","value":1}]}`,
+		`{"action":"D","schema":"public","table":"orders","identity":[{"name":"id","type":"integer","value":1}]}`,
+		`{"action":"M","transactional":false,"prefix":"x","content":"y"}`,
+	}
+	var records []pgChangeRecord
+	for i, m := range messages {
+		rs, commit, err := d.decode(uint64(0x100+i), []byte(m))
+		require.NoError(t, err)
+		assert.False(t, commit)
+		records = append(records, rs...)
+	}
+	assert.True(t, d.inTx)
+	require.Len(t, records, 3)
+	assert.Equal(t, ChangeOpInsert, records[0].Op)
+	assert.Equal(t, "2018-01-01T00:00:00.5Z", records[0].Timestamp)
+	assert.Equal(t, uint32(731), records[0].XID)
+	assert.Equal(t, "0/101", records[0].LSN)
+	assert.Equal(t, "12.50", records[0].After["price"].(json.Number).String())
+	assert.Equal(t, ChangeOpUpdate, records[1].Op)
+	assert.Len(t, records[1].Before, 1)
+	assert.Equal(t, ChangeOpDelete, records[2].Op)
+	assert.Nil(t, records[2].After)
+
+	_, commit, err := d.decode(0x200, []byte(`{"action":"C","xid":731}`))
+	assert.NoError(t, err)
+	assert.True(t, commit)
+	assert.False(t, d.inTx)
+	_, _, err = d.decode(0x201, []byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestNewPGReplicationReaderConfig(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	meta, err := NewMetaWithConf(conf.MapConf{KeyMetaPath: metaDir, KeyFileDone: metaDir, KeyMode: ModePGReplication})
+	require.NoError(t, err)
+	base := conf.MapConf{KeyPGReplicationDataSource: "host=localhost", KeyPGReplicationSlot: "slot", KeyPGReplicationPublication: "pub"}
+	for key, value := range map[string]string{
+		KeyPGReplicationSlot:           "Bad-Slot",
+		KeyPGReplicationPlugin:         "test_decoding",
+		KeyPGReplicationPublication:    "",
+		KeyPGReplicationStatusInterval: "0s",
+		KeyPGReplicationDataSource:     "host=localhost sslmode=prefer",
+	} {
+		c := conf.MapConf{}
+		for k, v := range base {
+			c[k] = v
+		}
+		c[key] = value
+		_, err = NewPGReplicationReader(meta, c)
+		assert.Error(t, err, key)
+	}
+	// wal2json 不需要 publication
+	r, err := NewPGReplicationReader(meta, conf.MapConf{KeyPGReplicationDataSource: "host=localhost", KeyPGReplicationSlot: "slot", KeyPGReplicationPlugin: PGPluginWal2json})
+	require.NoError(t, err)
+	assert.Equal(t, `START_REPLICATION SLOT slot LOGICAL 0/0 ("format-version" '2', "include-xids" '1', "include-timestamp" '1')`,
+		r.(*PGReplicationReader).startReplicationQuery(0))
+	assert.Equal(t, "localhost:5432/postgres", r.Source())
+}
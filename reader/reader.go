@@ -90,6 +90,7 @@ const (
 
 	KeySQLSchema        = "sql_schema"
 	KeyMagicLagDuration = "magic_lag_duration"
+	KeySQLKeysetColumns = "sql_keyset_columns"
 
 	KeyMssqlOffsetKey   = "mssql_offset_key"
 	KeyMssqlReadBatch   = "mssql_limit_batch"
@@ -107,6 +108,13 @@ const (
 	KeyPGsqlCron        = "postgres_cron"
 	KeyPGsqlExecOnStart = "postgres_exec_onstart"
 
+	KeySQLiteOffsetKey   = "sqlite_offset_key"
+	KeySQLiteReadBatch   = "sqlite_limit_batch"
+	KeySQLiteDataSource  = "sqlite_datasource"
+	KeySQLiteSQL         = "sqlite_sql"
+	KeySQLiteCron        = "sqlite_cron"
+	KeySQLiteExecOnStart = "sqlite_exec_onstart"
+
 	KeyESReadBatch = "es_limit_batch"
 	KeyESIndex     = "es_index"
 	KeyESType      = "es_type"
@@ -114,6 +122,12 @@ const (
 	KeyESKeepAlive = "es_keepalive"
 	KeyESVersion   = "es_version"
 
+	KeyESReadMode        = "es_read_mode"
+	KeyESQuery           = "es_query"
+	KeyESTimestampField  = "es_timestamp_field"
+	KeyESTiebreakerField = "es_tiebreaker_field"
+	KeyESPollInterval    = "es_poll_interval"
+
 	KeyMongoHost        = "mongo_host"
 	KeyMongoDatabase    = "mongo_database"
 	KeyMongoCollection  = "mongo_collection"
@@ -124,6 +138,9 @@ const (
 	KeyMongoFilters     = "mongo_filters"
 	KeyMongoCert        = "mongo_cacert"
 
+	KeyMongoReadMode     = "mongo_read_mode"
+	KeyMongoFullDocument = "mongo_full_document"
+
 	KeyKafkaGroupID          = "kafka_groupid"
 	KeyKafkaTopic            = "kafka_topic"
 	KeyKafkaZookeeper        = "kafka_zookeeper"
@@ -133,6 +150,10 @@ const (
 	KeyExecInterpreter   = "script_exec_interprepter"
 	KeyScriptCron        = "script_cron"
 	KeyScriptExecOnStart = "script_exec_onstart"
+	KeyScriptReadMode    = "script_read_mode"
+	KeyScriptEnv         = "script_env"
+	KeyScriptTimeout     = "script_timeout"
+	KeyScriptStderrField = "script_stderr_field"
 
 	KeyErrDirectReturn = "errDirectReturn"
 )
@@ -160,6 +181,17 @@ const (
 	ModeSnmp       = "snmp"
 	ModeCloudWatch = "cloudwatch"
 	ModeCloudTrail = "cloudtrail"
+
+	ModeSQLite        = "sqlite"
+	ModeKafkaBroker   = "kafka_broker"
+	ModeJournal       = "journal"
+	ModeMysqlBinlog   = "mysql_binlog"
+	ModePGReplication = "postgres_replication"
+	ModeK8sContainers = "k8s_containers"
+	ModeDocker        = "docker"
+	ModeEvtx          = "evtx"
+	ModeHttpPoll      = "http_poll"
+	ModeS3            = "s3"
 )
 
 const (
@@ -198,9 +230,11 @@ func NewReaderRegistry() *ReaderRegistry {
 	ret.RegisterReader(ModeMysql, NewSQLReader)
 	ret.RegisterReader(ModeMssql, NewSQLReader)
 	ret.RegisterReader(ModePG, NewSQLReader)
+	ret.RegisterReader(ModeSQLite, NewSQLReader)
 	ret.RegisterReader(ModeElastic, NewESReader)
 	ret.RegisterReader(ModeMongo, NewMongoReader)
 	ret.RegisterReader(ModeKafka, NewKafkaReader)
+	ret.RegisterReader(ModeKafkaBroker, NewKafkaBrokerReader)
 	ret.RegisterReader(ModeRedis, NewRedisReader)
 	ret.RegisterReader(ModeSocket, NewSocketReader)
 	ret.RegisterReader(ModeHttp, NewHttpReader)
@@ -208,6 +242,14 @@ func NewReaderRegistry() *ReaderRegistry {
 	ret.RegisterReader(ModeSnmp, NewSnmpReader)
 	ret.RegisterReader(ModeCloudWatch, NewCloudWatchReader)
 	ret.RegisterReader(ModeCloudTrail, NewCloudTrailReader)
+	ret.RegisterReader(ModeJournal, NewJournalReader)
+	ret.RegisterReader(ModeMysqlBinlog, NewMysqlBinlogReader)
+	ret.RegisterReader(ModePGReplication, NewPGReplicationReader)
+	ret.RegisterReader(ModeK8sContainers, NewK8sContainersReader)
+	ret.RegisterReader(ModeDocker, NewDockerReader)
+	ret.RegisterReader(ModeEvtx, NewEvtxReader)
+	ret.RegisterReader(ModeHttpPoll, NewHttpPollReader)
+	ret.RegisterReader(ModeS3, NewS3Reader)
 
 	return ret
 }
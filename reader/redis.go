@@ -3,6 +3,7 @@ package reader
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,6 +24,7 @@ const (
 	DataTypeList          = "list"
 	DataTypeChannel       = "channel"
 	DataTypePatterChannel = "pattern_channel"
+	DataTypeStream        = "stream"
 )
 
 const (
@@ -33,12 +35,32 @@ const (
 	KeyRedisAddress    = "redis_address" // 默认127.0.0.1:6379
 	KeyRedisPassword   = "redis_password"
 	KeyTimeoutDuration = "redis_timeout"
+	KeyRedisDeployMode = "redis_deploy_mode" // 默认 single
+	KeyRedisMasterName = "redis_master_name" // sentinel 模式必填
+
+	KeyRedisStreamGroup     = "redis_stream_group"      // 默认 logkit
+	KeyRedisStreamConsumer  = "redis_stream_consumer"   // 默认 主机名_runner名
+	KeyRedisStreamCount     = "redis_stream_count"      // 默认 100
+	KeyRedisStreamStartID   = "redis_stream_start_id"   // 创建消费组时的起始位置，默认 $
+	KeyRedisStreamClaimIdle = "redis_stream_claim_idle" // 默认 5m，为 0 时不认领其他消费者的消息
+)
+
+// redis 的部署方式，sentinel 和 cluster 模式下 redis_address 为逗号分隔的地址列表
+const (
+	RedisDeploySingle   = "single"
+	RedisDeploySentinel = "sentinel"
+	RedisDeployCluster  = "cluster"
 )
 
 type RedisReader struct {
 	meta   *Meta
 	opt    RedisOptionn
-	client *redis.Client
+	client redis.UniversalClient
+	// 集群模式下用于订阅 channel 的单节点客户端，节点不可用时轮换到 redis_address 中的下一个地址
+	pubsubClient *redis.Client
+	pubsub       *redis.PubSub
+	pubsubNode   int
+	pubsubPinged bool
 
 	readChan  chan string
 	channelIn <-chan *redis.Message
@@ -49,6 +71,10 @@ type RedisReader struct {
 
 	stats     StatsInfo
 	statsLock sync.RWMutex
+
+	streamChan chan redisStreamLine
+	unacked    map[string][]string // 已被读取、等待 SyncMeta 时 XACK 的消息
+	ackMux     sync.Mutex
 }
 
 type RedisOptionn struct {
@@ -62,6 +88,15 @@ type RedisOptionn struct {
 	//batchCount int
 	//threads    int
 	timeout time.Duration
+
+	deployMode string
+	masterName string
+
+	group     string
+	consumer  string
+	count     int
+	startID   string
+	claimIdle time.Duration
 }
 
 func NewRedisReader(meta *Meta, conf conf.MapConf) (rr Reader, err error) {
@@ -82,34 +117,160 @@ func NewRedisReader(meta *Meta, conf conf.MapConf) (rr Reader, err error) {
 	if err != nil {
 		return
 	}
+	deployMode, _ := conf.GetStringOr(KeyRedisDeployMode, RedisDeploySingle)
+	masterName, _ := conf.GetStringOr(KeyRedisMasterName, "")
 	opt := RedisOptionn{
-		address:  address,
-		password: password,
-		db:       db,
-		key:      key,
-		area:     area,
-		timeout:  timeout,
-		dataType: dataType,
+		address:    address,
+		password:   password,
+		db:         db,
+		key:        key,
+		area:       area,
+		timeout:    timeout,
+		dataType:   dataType,
+		deployMode: deployMode,
+		masterName: masterName,
+	}
+	var readTimeout time.Duration
+	if dataType == DataTypeStream {
+		if err = opt.initStream(meta, conf); err != nil {
+			return
+		}
+		// XREADGROUP 会阻塞 redis_timeout，读超时需要比它长
+		readTimeout = timeout + time.Second
+	}
+	client, err := newRedisClient(opt, readTimeout)
+	if err != nil {
+		return
 	}
-	client := redis.NewClient(&redis.Options{
-		Addr:     opt.address,
-		DB:       opt.db,
-		Password: opt.password,
-	})
 
 	rr = &RedisReader{
-		meta:      meta,
-		opt:       opt,
-		client:    client,
-		readChan:  make(chan string),
-		status:    StatusInit,
-		mux:       sync.Mutex{},
-		started:   false,
-		statsLock: sync.RWMutex{},
+		meta:       meta,
+		opt:        opt,
+		client:     client,
+		readChan:   make(chan string),
+		streamChan: make(chan redisStreamLine),
+		status:     StatusInit,
+		mux:        sync.Mutex{},
+		started:    false,
+		statsLock:  sync.RWMutex{},
 	}
 	return
 }
 
+func redisAddrs(address string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(address, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func newRedisClient(opt RedisOptionn, readTimeout time.Duration) (redis.UniversalClient, error) {
+	addrs := redisAddrs(opt.address)
+	if len(addrs) == 0 {
+		return nil, errors.New("redis_address is empty")
+	}
+	switch opt.deployMode {
+	case RedisDeploySingle:
+		return redis.NewClient(&redis.Options{
+			Addr:        opt.address,
+			DB:          opt.db,
+			Password:    opt.password,
+			ReadTimeout: readTimeout,
+		}), nil
+	case RedisDeploySentinel:
+		if opt.masterName == "" {
+			return nil, fmt.Errorf("%v is required in sentinel deploy mode", KeyRedisMasterName)
+		}
+		// 主节点切换后 sentinel 会通知客户端重新连接新的主节点
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    opt.masterName,
+			SentinelAddrs: addrs,
+			DB:            opt.db,
+			Password:      opt.password,
+			ReadTimeout:   readTimeout,
+		}), nil
+	case RedisDeployCluster:
+		if opt.db != 0 {
+			return nil, fmt.Errorf("redis cluster only support db 0, got %v", opt.db)
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:       addrs,
+			Password:    opt.password,
+			ReadTimeout: readTimeout,
+		}), nil
+	}
+	return nil, fmt.Errorf("redis deploy mode %v not supported", opt.deployMode)
+}
+
+// subscribe 订阅 channel，集群模式下 vendor 的 ClusterClient 不支持订阅，由 receiveClusterMessage 订阅单个节点
+func (rr *RedisReader) subscribe() <-chan *redis.Message {
+	client, ok := rr.client.(*redis.Client)
+	if !ok {
+		return nil
+	}
+	if rr.opt.dataType == DataTypePatterChannel {
+		return client.PSubscribe(rr.opt.key...).Channel()
+	}
+	return client.Subscribe(rr.opt.key...).Channel()
+}
+
+// receiveClusterMessage 从当前订阅的节点读取一条消息，集群中 PUBLISH 的消息会广播到所有节点，订阅任一节点即可。
+// 节点连接失败，或者读取超时后 ping 也没有响应时，轮换到下一个地址重新订阅
+func (rr *RedisReader) receiveClusterMessage() {
+	addrs := redisAddrs(rr.opt.address)
+	if rr.pubsub == nil {
+		addr := addrs[rr.pubsubNode%len(addrs)]
+		rr.pubsubClient = redis.NewClient(&redis.Options{Addr: addr, Password: rr.opt.password})
+		if rr.opt.dataType == DataTypePatterChannel {
+			rr.pubsub = rr.pubsubClient.PSubscribe(rr.opt.key...)
+		} else {
+			rr.pubsub = rr.pubsubClient.Subscribe(rr.opt.key...)
+		}
+		rr.pubsubPinged = false
+		log.Infof("Runner[%v] %v subscribe redis node %v", rr.meta.RunnerName, rr.Name(), addr)
+	}
+	msg, err := rr.pubsub.ReceiveTimeout(rr.opt.timeout)
+	if err == nil {
+		rr.pubsubPinged = false
+		if message, ok := msg.(*redis.Message); ok {
+			rr.readChan <- message.Payload
+		}
+		return
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && !rr.pubsubPinged {
+		// 没有消息时也会超时，发送 ping 后下一次读取仍然超时才认为节点不可用
+		if err = rr.pubsub.Ping(); err == nil {
+			rr.pubsubPinged = true
+			return
+		}
+	}
+	addr := addrs[rr.pubsubNode%len(addrs)]
+	log.Errorf("Runner[%v] %v receive from redis node %v error %v, subscribe the next node", rr.meta.RunnerName, rr.Name(), addr, err)
+	rr.setStatsError("Runner[" + rr.meta.RunnerName + "] " + rr.Name() + " receive from redis node " + addr + " error " + err.Error())
+	rr.closePubsub()
+	rr.pubsubNode = (rr.pubsubNode + 1) % len(addrs)
+	time.Sleep(time.Second)
+}
+
+func (rr *RedisReader) closePubsub() {
+	if rr.pubsub != nil {
+		rr.pubsub.Close()
+		rr.pubsub = nil
+	}
+	if rr.pubsubClient != nil {
+		rr.pubsubClient.Close()
+		rr.pubsubClient = nil
+	}
+}
+
+func (rr *RedisReader) closeClient() {
+	rr.client.Close()
+	rr.closePubsub()
+}
+
 func (rr *RedisReader) Name() string {
 	return fmt.Sprintf("[%s],[%v],[%s]", rr.opt.dataType, rr.opt.db, rr.opt.key)
 }
@@ -139,6 +300,10 @@ func (rr *RedisReader) ReadLine() (data string, err error) {
 	select {
 	case dat := <-rr.readChan:
 		data = string(dat)
+	case line := <-rr.streamChan:
+		// 被读走的消息在下一次 SyncMeta 时确认
+		data = line.data
+		rr.addUnacked(line.key, line.id)
 	case <-timer.C:
 	}
 	timer.Stop()
@@ -150,12 +315,16 @@ func (rr *RedisReader) Close() (err error) {
 		log.Infof("Runner[%v] %v stopping", rr.meta.RunnerName, rr.Name())
 	} else {
 		close(rr.readChan)
-		rr.client.Close()
+		rr.closeClient()
 	}
 	return
 }
 
 func (rr *RedisReader) SyncMeta() {
+	if rr.opt.dataType == DataTypeStream {
+		rr.ackStream()
+		return
+	}
 	log.Debugf("Runner[%v] %v redis reader do not support meta sync", rr.meta.RunnerName, rr.Name())
 	return
 }
@@ -168,15 +337,14 @@ func (rr *RedisReader) Start() {
 	}
 	rr.started = true
 	switch rr.opt.dataType {
-	case DataTypeChannel:
-		rr.channelIn = rr.client.Subscribe(rr.opt.key...).Channel()
-	case DataTypePatterChannel:
-		rr.channelIn = rr.client.PSubscribe(rr.opt.key...).Channel()
+	case DataTypeChannel, DataTypePatterChannel:
+		rr.channelIn = rr.subscribe()
 	case DataTypeList:
 	case DataTypeString:
 	case DataTypeSet:
 	case DateTypeSortedSet:
 	case DateTypeHash:
+	case DataTypeStream:
 	default:
 		err := fmt.Errorf("data Type < %v > not exist, exit", rr.opt.dataType)
 		log.Error(err)
@@ -201,12 +369,30 @@ func (rr *RedisReader) run() (err error) {
 		atomic.CompareAndSwapInt32(&rr.status, StatusRunning, StatusInit)
 		if atomic.CompareAndSwapInt32(&rr.status, StatusStopping, StatusStopped) {
 			close(rr.readChan)
-			rr.client.Close()
+			rr.closeClient()
 		}
 		if err == nil {
 			log.Infof("Runner[%v] %v successfully finished", rr.meta.RunnerName, rr.Name())
 		}
 	}()
+	// 集群模式下 list 和 stream 的各个 key 可能分布在不同节点上，按 key 并发读取
+	if rr.opt.deployMode == RedisDeployCluster && (rr.opt.dataType == DataTypeList || rr.opt.dataType == DataTypeStream) {
+		var wg sync.WaitGroup
+		for _, key := range rr.opt.key {
+			wg.Add(1)
+			go func(keys []string) {
+				defer wg.Done()
+				rr.readLoop(keys)
+			}([]string{key})
+		}
+		wg.Wait()
+		return
+	}
+	return rr.readLoop(rr.opt.key)
+}
+
+func (rr *RedisReader) readLoop(keys []string) (err error) {
+	stream := &redisStreamState{keys: keys}
 	// 开始work逻辑
 	for {
 		if atomic.LoadInt32(&rr.status) == StatusStopping {
@@ -215,12 +401,16 @@ func (rr *RedisReader) run() (err error) {
 		}
 		switch rr.opt.dataType {
 		case DataTypeChannel, DataTypePatterChannel:
+			if rr.opt.deployMode == RedisDeployCluster {
+				rr.receiveClusterMessage()
+				continue
+			}
 			message := <-rr.channelIn
 			if message != nil {
 				rr.readChan <- message.Payload
 			}
 		case DataTypeList:
-			for _, key := range rr.opt.key {
+			for _, key := range keys {
 				ans, subErr := rr.client.BLPop(rr.opt.timeout, key).Result()
 				if subErr != nil && subErr != redis.Nil {
 					log.Errorf("Runner[%v] %v BLPop redis error %v", rr.meta.RunnerName, rr.Name(), subErr)
@@ -234,7 +424,7 @@ func (rr *RedisReader) run() (err error) {
 			}
 			//Added string support for redis
 		case DataTypeString:
-			for _, key := range rr.opt.key {
+			for _, key := range keys {
 				anString, subErr := rr.client.Get(key).Result()
 				if subErr != nil && subErr != redis.Nil {
 					log.Errorf("Runner[%v] %v Get redis error %v", rr.meta.RunnerName, rr.Name(), subErr)
@@ -247,7 +437,7 @@ func (rr *RedisReader) run() (err error) {
 			}
 			//Added set support for redis
 		case DataTypeSet:
-			for _, key := range rr.opt.key {
+			for _, key := range keys {
 				anSet, subErr := rr.client.SPop(key).Result()
 				if subErr != nil && subErr != redis.Nil {
 					log.Errorf("Runner[%v] %v SPop redis error %v", rr.meta.RunnerName, rr.Name(), subErr)
@@ -258,7 +448,7 @@ func (rr *RedisReader) run() (err error) {
 			}
 			//Added sortedSet support for redis
 		case DateTypeSortedSet:
-			for _, key := range rr.opt.key {
+			for _, key := range keys {
 				anSortedSet, subErr := rr.client.ZRange(key, 0, -1).Result()
 				if subErr != nil && subErr != redis.Nil {
 					log.Errorf("Runner[%v] %v ZRange redis error %v", rr.meta.RunnerName, rr.Name(), subErr)
@@ -270,7 +460,7 @@ func (rr *RedisReader) run() (err error) {
 			}
 			//Added hash support for redis
 		case DateTypeHash:
-			for _, key := range rr.opt.key {
+			for _, key := range keys {
 				anHash, subErr := rr.client.HGet(key, rr.opt.area).Result() //redis key and area for hash
 				if subErr != nil && subErr != redis.Nil {
 					log.Errorf("Runner[%v] %v HGetAll redis error %v", rr.meta.RunnerName, rr.Name(), subErr)
@@ -280,6 +470,12 @@ func (rr *RedisReader) run() (err error) {
 					rr.readChan <- anHash
 				}
 			}
+		case DataTypeStream:
+			if subErr := rr.readStream(stream); subErr != nil {
+				log.Errorf("Runner[%v] %v read redis stream error %v", rr.meta.RunnerName, rr.Name(), subErr)
+				rr.setStatsError("Runner[" + rr.meta.RunnerName + "] " + rr.Name() + " read redis stream error " + subErr.Error())
+				time.Sleep(time.Second)
+			}
 		default:
 			err = fmt.Errorf("data Type < %v > not exist, exit", rr.opt.dataType)
 			log.Error(err)
@@ -0,0 +1,280 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/go-redis/redis"
+	"github.com/json-iterator/go"
+	"github.com/qiniu/log"
+)
+
+// stream 消息输出时附加的字段
+const (
+	RedisStreamIDField  = "redis_stream_id"
+	RedisStreamKeyField = "redis_stream_key"
+)
+
+func (opt *RedisOptionn) initStream(meta *Meta, conf conf.MapConf) (err error) {
+	if len(opt.key) == 0 {
+		return errors.New("redis_key is required in stream data type")
+	}
+	opt.group, _ = conf.GetStringOr(KeyRedisStreamGroup, "logkit")
+	// 消费者名称需要在重启后保持不变，才能读回自己未确认的消息
+	consumer, _ := os.Hostname()
+	if consumer == "" {
+		consumer = "logkit"
+	}
+	if meta != nil && meta.RunnerName != "" {
+		consumer += "_" + meta.RunnerName
+	}
+	opt.consumer, _ = conf.GetStringOr(KeyRedisStreamConsumer, consumer)
+	opt.count, _ = conf.GetIntOr(KeyRedisStreamCount, 100)
+	if opt.count <= 0 {
+		return fmt.Errorf("%v must be positive, got %v", KeyRedisStreamCount, opt.count)
+	}
+	opt.startID, _ = conf.GetStringOr(KeyRedisStreamStartID, "$")
+	claimIdle, _ := conf.GetStringOr(KeyRedisStreamClaimIdle, "5m")
+	opt.claimIdle, err = time.ParseDuration(claimIdle)
+	return
+}
+
+// redisStreamLine 是发给 ReadLine 的一条消息，带上 ID 用于确认
+type redisStreamLine struct {
+	key  string
+	id   string
+	data string
+}
+
+// redisStreamState 记录一组 stream 的读取状态，集群模式下每个 key 各有一组
+type redisStreamState struct {
+	keys      []string
+	ids       []string  // 与 keys 对应，下次 XREADGROUP 的 ID
+	lastClaim time.Time // 上次 XAUTOCLAIM 的时间
+}
+
+// redisStreamEntry 是 XREADGROUP、XAUTOCLAIM 返回的一条消息，fields 为 nil 表示消息已被删除
+type redisStreamEntry struct {
+	id     string
+	fields map[string]interface{}
+}
+
+func parseRedisStreamEntries(v interface{}) ([]redisStreamEntry, error) {
+	items, ok := v.([]interface{})
+	if !ok && v != nil {
+		return nil, fmt.Errorf("invalid stream entries %v", v)
+	}
+	entries := make([]redisStreamEntry, 0, len(items))
+	for _, item := range items {
+		kv, ok := item.([]interface{})
+		if !ok || len(kv) != 2 {
+			return nil, fmt.Errorf("invalid stream entry %v", item)
+		}
+		id, ok := kv[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid stream entry id %v", kv[0])
+		}
+		entry := redisStreamEntry{id: id}
+		if values, ok := kv[1].([]interface{}); ok {
+			entry.fields = make(map[string]interface{}, len(values)/2)
+			for i := 0; i+1 < len(values); i += 2 {
+				entry.fields[fmt.Sprint(values[i])] = values[i+1]
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (rr *RedisReader) createStreamGroups(stream *redisStreamState) error {
+	ids := make([]string, len(stream.keys))
+	for i, key := range stream.keys {
+		err := rr.client.Process(redis.NewStatusCmd("XGROUP", "CREATE", key, rr.opt.group, rr.opt.startID, "MKSTREAM"))
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("create consumer group %v on %v error %v", rr.opt.group, key, err)
+		}
+		// 先从 0 开始读取本消费者已投递但未确认的消息
+		ids[i] = "0"
+	}
+	stream.ids = ids
+	return nil
+}
+
+func (rr *RedisReader) readStream(stream *redisStreamState) error {
+	if stream.ids == nil {
+		if err := rr.createStreamGroups(stream); err != nil {
+			return err
+		}
+	}
+	if rr.opt.claimIdle > 0 && time.Since(stream.lastClaim) >= rr.opt.claimIdle {
+		for i, key := range stream.keys {
+			// 认领的消息会进入本消费者的未确认列表，读取未确认消息时会再次返回，因此读完后再认领
+			if stream.ids[i] != ">" {
+				continue
+			}
+			if err := rr.claimStream(key); err != nil {
+				return err
+			}
+		}
+		stream.lastClaim = time.Now()
+	}
+
+	args := []interface{}{"XREADGROUP", "GROUP", rr.opt.group, rr.opt.consumer, "COUNT", rr.opt.count,
+		"BLOCK", int64(rr.opt.timeout / time.Millisecond), "STREAMS"}
+	for _, key := range stream.keys {
+		args = append(args, key)
+	}
+	for _, id := range stream.ids {
+		args = append(args, id)
+	}
+	cmd := redis.NewSliceCmd(args...)
+	err := rr.client.Process(cmd)
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, item := range cmd.Val() {
+		kv, ok := item.([]interface{})
+		if !ok || len(kv) != 2 {
+			return fmt.Errorf("invalid XREADGROUP reply %v", item)
+		}
+		key := fmt.Sprint(kv[0])
+		entries, err := parseRedisStreamEntries(kv[1])
+		if err != nil {
+			return err
+		}
+		for i := range stream.keys {
+			if stream.keys[i] != key || stream.ids[i] == ">" {
+				continue
+			}
+			// 未确认的消息读完后开始读取新消息
+			if len(entries) == 0 {
+				stream.ids[i] = ">"
+			} else {
+				stream.ids[i] = entries[len(entries)-1].id
+			}
+		}
+		for _, entry := range entries {
+			if err = rr.sendStreamEntry(key, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// claimStream 将其他消费者空闲超过 claimIdle 的未确认消息认领过来读取，
+// XAUTOCLAIM 也会返回本消费者已经交给 ReadLine 但是还在等待发送的消息，这些消息不再重复读取
+func (rr *RedisReader) claimStream(key string) error {
+	pending := rr.unackedIDs(key)
+	start := "0-0"
+	for atomic.LoadInt32(&rr.status) != StatusStopping {
+		cmd := redis.NewSliceCmd("XAUTOCLAIM", key, rr.opt.group, rr.opt.consumer,
+			int64(rr.opt.claimIdle/time.Millisecond), start, "COUNT", rr.opt.count)
+		if err := rr.client.Process(cmd); err != nil {
+			return fmt.Errorf("XAUTOCLAIM %v error %v", key, err)
+		}
+		// Redis 7 起还会返回第三个元素，即已被删除的消息 ID，它们已经从 PEL 中移除
+		val := cmd.Val()
+		if len(val) < 2 {
+			return fmt.Errorf("invalid XAUTOCLAIM reply %v", val)
+		}
+		entries, err := parseRedisStreamEntries(val[1])
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			log.Infof("Runner[%v] %v claimed %v pending entries from %v", rr.meta.RunnerName, rr.Name(), len(entries), key)
+		}
+		for _, entry := range entries {
+			if pending[entry.id] {
+				continue
+			}
+			if err = rr.sendStreamEntry(key, entry); err != nil {
+				return err
+			}
+		}
+		start = fmt.Sprint(val[0])
+		if start == "0-0" {
+			break
+		}
+	}
+	return nil
+}
+
+func (rr *RedisReader) sendStreamEntry(key string, entry redisStreamEntry) error {
+	// 已删除的消息无法再读取，直接确认
+	if entry.fields == nil {
+		rr.addUnacked(key, entry.id)
+		return nil
+	}
+	data := make(map[string]interface{}, len(entry.fields)+2)
+	for k, v := range entry.fields {
+		data[k] = v
+	}
+	data[RedisStreamIDField] = entry.id
+	data[RedisStreamKeyField] = key
+	line, err := jsoniter.Marshal(data)
+	if err != nil {
+		return err
+	}
+	rr.streamChan <- redisStreamLine{key: key, id: entry.id, data: string(line)}
+	return nil
+}
+
+func (rr *RedisReader) addUnacked(key string, ids ...string) {
+	rr.ackMux.Lock()
+	defer rr.ackMux.Unlock()
+	if rr.unacked == nil {
+		rr.unacked = make(map[string][]string)
+	}
+	rr.unacked[key] = append(rr.unacked[key], ids...)
+}
+
+// unackedIDs 返回 key 上已经交给 ReadLine 但是还没有确认的消息 ID
+func (rr *RedisReader) unackedIDs(key string) map[string]bool {
+	rr.ackMux.Lock()
+	defer rr.ackMux.Unlock()
+	ids := make(map[string]bool, len(rr.unacked[key]))
+	for _, id := range rr.unacked[key] {
+		ids[id] = true
+	}
+	return ids
+}
+
+// ackStream 确认已经读取的消息，确认成功后才从 unacked 中移除，失败的留到下一次 SyncMeta 重试
+func (rr *RedisReader) ackStream() {
+	rr.ackMux.Lock()
+	unacked := make(map[string][]string, len(rr.unacked))
+	for key, ids := range rr.unacked {
+		unacked[key] = ids[:len(ids):len(ids)]
+	}
+	rr.ackMux.Unlock()
+	for key, ids := range unacked {
+		args := []interface{}{"XACK", key, rr.opt.group}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		if err := rr.client.Process(redis.NewIntCmd(args...)); err != nil {
+			log.Errorf("Runner[%v] %v XACK %v error %v", rr.meta.RunnerName, rr.Name(), key, err)
+			rr.setStatsError("Runner[" + rr.meta.RunnerName + "] " + rr.Name() + " XACK error " + err.Error())
+			continue
+		}
+		// 确认期间新读取的消息追加在后面，只移除已经确认的部分
+		rr.ackMux.Lock()
+		if rest := rr.unacked[key][len(ids):]; len(rest) > 0 {
+			rr.unacked[key] = append([]string(nil), rest...)
+		} else {
+			delete(rr.unacked, key)
+		}
+		rr.ackMux.Unlock()
+	}
+}
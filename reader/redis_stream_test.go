@@ -0,0 +1,123 @@
+package reader
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStreamReader(t *testing.T, addr, consumer, claimIdle string) *RedisReader {
+	c := conf.MapConf{
+		KeyMetaPath:             metaDir,
+		KeyFileDone:             metaDir,
+		KeyMode:                 ModeRedis,
+		KeyRunnerName:           "TestRedisStreamReader",
+		KeyRedisDataType:        DataTypeStream,
+		KeyRedisAddress:         addr,
+		KeyRedisKey:             "logs",
+		KeyRedisStreamGroup:     "g",
+		KeyRedisStreamConsumer:  consumer,
+		KeyRedisStreamCount:     "2",
+		KeyRedisStreamStartID:   "0",
+		KeyRedisStreamClaimIdle: claimIdle,
+		KeyTimeoutDuration:      "100ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewRedisReader(meta, c)
+	require.NoError(t, err)
+	return r.(*RedisReader)
+}
+
+func readStreamIDs(t *testing.T, r Reader, n int) []string {
+	var ids []string
+	for i := 0; i < 5 && len(ids) < n; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line == "" {
+			continue
+		}
+		data := make(map[string]string)
+		require.NoError(t, jsoniter.Unmarshal([]byte(line), &data))
+		assert.Equal(t, "logs", data[RedisStreamKeyField])
+		assert.Equal(t, "v"+data[RedisStreamIDField], data["f"])
+		ids = append(ids, data[RedisStreamIDField])
+	}
+	return ids
+}
+
+func pendingCount(t *testing.T, addr string) int64 {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	cmd := redis.NewSliceCmd("XPENDING", "logs", "g")
+	require.NoError(t, client.Process(cmd))
+	return cmd.Val()[0].(int64)
+}
+
+func TestRedisStreamReader(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	s := miniredis.RunT(t)
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		_, err := s.XAdd("logs", id, []string{"f", "v" + id})
+		require.NoError(t, err)
+	}
+
+	r := newTestStreamReader(t, s.Addr(), "c1", "0s")
+	assert.Equal(t, []string{"1-0", "2-0"}, readStreamIDs(t, r, 2))
+	// 只有 SyncMeta 之后才确认
+	assert.EqualValues(t, 3, pendingCount(t, s.Addr()))
+	r.SyncMeta()
+	assert.EqualValues(t, 1, pendingCount(t, s.Addr()))
+	r.Close()
+
+	// 重启后先读回未确认的消息，再读新消息
+	_, err := s.XAdd("logs", "4-0", []string{"f", "v4-0"})
+	require.NoError(t, err)
+	r = newTestStreamReader(t, s.Addr(), "c1", "0s")
+	assert.Equal(t, []string{"3-0", "4-0"}, readStreamIDs(t, r, 2))
+	r.Close()
+	assert.EqualValues(t, 2, pendingCount(t, s.Addr()))
+
+	// 其他消费者通过 XAUTOCLAIM 认领空闲的消息
+	time.Sleep(20 * time.Millisecond)
+	r = newTestStreamReader(t, s.Addr(), "c2", "10ms")
+	defer r.Close()
+	assert.Equal(t, []string{"3-0", "4-0"}, readStreamIDs(t, r, 2))
+	r.SyncMeta()
+	assert.EqualValues(t, 0, pendingCount(t, s.Addr()))
+	assert.Equal(t, StatsInfo{}, r.Status())
+
+	// 已经交给 ReadLine 但是还没有 SyncMeta 的消息空闲超过 claimIdle 后也不会被自己重复认领
+	for _, id := range []string{"5-0", "6-0"} {
+		_, err := s.XAdd("logs", id, []string{"f", "v" + id})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"5-0", "6-0"}, readStreamIDs(t, r, 2))
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, readStreamIDs(t, r, 1))
+	r.SyncMeta()
+	assert.EqualValues(t, 0, pendingCount(t, s.Addr()))
+}
+
+func TestParseRedisStreamEntries(t *testing.T) {
+	entries, err := parseRedisStreamEntries([]interface{}{
+		[]interface{}{"1-0", []interface{}{"a", "1", "b", "2"}},
+		[]interface{}{"2-0", nil},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []redisStreamEntry{
+		{id: "1-0", fields: map[string]interface{}{"a": "1", "b": "2"}},
+		{id: "2-0"},
+	}, entries)
+	_, err = parseRedisStreamEntries([]interface{}{"1-0"})
+	assert.Error(t, err)
+}
@@ -1,12 +1,24 @@
 package reader
 
 import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/qiniu/logkit/conf"
 	. "github.com/qiniu/logkit/utils/models"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewRedisReader(t *testing.T) {
@@ -20,3 +32,219 @@ func TestNewRedisReader(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, StatsInfo{}, rr.Status())
 }
+
+func TestRedisReaderCluster(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	s := miniredis.RunT(t)
+	c := conf.MapConf{
+		KeyMetaPath:        metaDir,
+		KeyFileDone:        metaDir,
+		KeyMode:            ModeRedis,
+		KeyRunnerName:      "TestRedisReaderCluster",
+		KeyRedisDataType:   DataTypeList,
+		KeyRedisDeployMode: RedisDeployCluster,
+		KeyRedisAddress:    s.Addr() + ", " + s.Addr(),
+		KeyRedisKey:        "q1,q2",
+		KeyTimeoutDuration: "100ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewRedisReader(meta, c)
+	require.NoError(t, err)
+	rr := r.(*RedisReader)
+	_, ok := rr.client.(*redis.ClusterClient)
+	assert.True(t, ok)
+	// miniredis 不支持 CLUSTER INFO，用单节点客户端验证按 key 并发读取
+	rr.client.Close()
+	rr.client = redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer r.Close()
+
+	_, err = s.Push("q1", "a", "b")
+	require.NoError(t, err)
+	_, err = s.Push("q2", "c")
+	require.NoError(t, err)
+	var got []string
+	for i := 0; i < 10 && len(got) < 3; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line != "" {
+			got = append(got, line)
+		}
+	}
+	sort.Strings(got)
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+
+	c[KeyRedisDB] = "1"
+	_, err = NewRedisReader(meta, c)
+	assert.Error(t, err)
+	c[KeyRedisDeployMode] = RedisDeploySentinel
+	_, err = NewRedisReader(meta, c)
+	assert.Error(t, err)
+}
+
+// fakeSentinel 只实现客户端发现主节点和订阅主节点切换所需的命令
+type fakeSentinel struct {
+	ln     net.Listener
+	mux    sync.Mutex
+	master string
+	subs   []net.Conn
+}
+
+func newFakeSentinel(t *testing.T, master string) *fakeSentinel {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSentinel{ln: ln, master: master}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSentinel) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			rd.ReadString('\n')
+			arg, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args[i] = strings.ToLower(strings.TrimSpace(arg))
+		}
+		s.mux.Lock()
+		switch {
+		case n == 3 && args[1] == "get-master-addr-by-name":
+			host, port, _ := net.SplitHostPort(s.master)
+			fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		case n == 3 && args[1] == "sentinels":
+			fmt.Fprint(conn, "*0\r\n")
+		case n == 2 && args[0] == "subscribe":
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+			s.subs = append(s.subs, conn)
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+		s.mux.Unlock()
+	}
+}
+
+func (s *fakeSentinel) subscribed() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.subs) > 0
+}
+
+func (s *fakeSentinel) switchMaster(name, master string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(s.master)
+	host, port, _ := net.SplitHostPort(master)
+	s.master = master
+	payload := strings.Join([]string{name, oldHost, oldPort, host, port}, " ")
+	for _, conn := range s.subs {
+		fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$%d\r\n%s\r\n", len(payload), payload)
+	}
+}
+
+func TestRedisReaderSentinel(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	m1 := miniredis.RunT(t)
+	m2 := miniredis.RunT(t)
+	sentinel := newFakeSentinel(t, m1.Addr())
+	defer sentinel.ln.Close()
+	c := conf.MapConf{
+		KeyMetaPath:        metaDir,
+		KeyFileDone:        metaDir,
+		KeyMode:            ModeRedis,
+		KeyRunnerName:      "TestRedisReaderSentinel",
+		KeyRedisDataType:   DataTypeList,
+		KeyRedisDeployMode: RedisDeploySentinel,
+		KeyRedisMasterName: "mymaster",
+		KeyRedisAddress:    sentinel.ln.Addr().String(),
+		KeyRedisKey:        "q",
+		KeyTimeoutDuration: "100ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewRedisReader(meta, c)
+	require.NoError(t, err)
+	defer r.Close()
+
+	readOne := func() string {
+		for i := 0; i < 10; i++ {
+			line, err := r.ReadLine()
+			require.NoError(t, err)
+			if line != "" {
+				return line
+			}
+		}
+		return ""
+	}
+	_, err = m1.Push("q", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", readOne())
+
+	// 主节点切换后从新的主节点读取
+	for i := 0; i < 100 && !sentinel.subscribed(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.True(t, sentinel.subscribed())
+	sentinel.switchMaster("mymaster", m2.Addr())
+	_, err = m2.Push("q", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "b", readOne())
+}
+
+func TestRedisReaderClusterChannel(t *testing.T) {
+	defer os.RemoveAll(metaDir)
+	m1 := miniredis.RunT(t)
+	m2 := miniredis.RunT(t)
+	c := conf.MapConf{
+		KeyMetaPath:        metaDir,
+		KeyFileDone:        metaDir,
+		KeyMode:            ModeRedis,
+		KeyRunnerName:      "TestRedisReaderClusterChannel",
+		KeyRedisDataType:   DataTypeChannel,
+		KeyRedisDeployMode: RedisDeployCluster,
+		KeyRedisAddress:    m1.Addr() + "," + m2.Addr(),
+		KeyRedisKey:        "ch",
+		KeyTimeoutDuration: "100ms",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewRedisReader(meta, c)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// 订阅建立后才能收到消息，一直发布直到读到为止
+	readFrom := func(m *miniredis.Miniredis, msg string) string {
+		for i := 0; i < 50; i++ {
+			m.Publish("ch", msg)
+			line, err := r.ReadLine()
+			require.NoError(t, err)
+			if line != "" {
+				return line
+			}
+		}
+		return ""
+	}
+	assert.Equal(t, "a", readFrom(m1, "a"))
+
+	// 订阅的节点不可用后轮换到下一个节点
+	m1.Close()
+	assert.Equal(t, "b", readFrom(m2, "b"))
+	assert.NotEmpty(t, r.(*RedisReader).Status().LastError)
+}
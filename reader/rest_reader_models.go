@@ -19,6 +19,7 @@ var ModeUsages = []KeyValue{
 	{ModeElastic, "从 Elasticsearch 读取"},
 	{ModeMongo, "从 MongoDB 读取"},
 	{ModeKafka, "从 Kafka 读取"},
+	{ModeKafkaBroker, "从 Kafka 读取(broker 消费组模式)"},
 	{ModeRedis, "从 Redis 读取"},
 	{ModeSocket, "从 Socket 读取"},
 	{ModeHttp, "从 http 请求中读取"},
@@ -26,6 +27,15 @@ var ModeUsages = []KeyValue{
 	{ModeSnmp, "从 SNMP 服务中读取"},
 	{ModeCloudWatch, "从 AWS Cloudwatch 中读取"},
 	{ModeCloudTrail, "从 AWS CloudTrail 中读取"},
+	{ModeJournal, "从 systemd journal 文件读取"},
+	{ModeMysqlBinlog, "从 MySQL binlog 读取行变更"},
+	{ModePGReplication, "从 PostgreSQL 逻辑复制槽读取行变更"},
+	{ModeSQLite, "从 SQLite 读取"},
+	{ModeK8sContainers, "从 Kubernetes 容器日志目录读取"},
+	{ModeDocker, "从 Docker API 读取容器日志"},
+	{ModeEvtx, "从 Windows EVTX 文件读取"},
+	{ModeHttpPoll, "定时请求 http 接口读取"},
+	{ModeS3, "从 S3 兼容的对象存储读取"},
 }
 
 var (
@@ -96,6 +106,16 @@ var (
 		Advance:      true,
 		ToolTip:      "读取文件的磁盘限速，填写正整数，单位为MB/s, 默认限速20MB/s",
 	}
+	OptionReadCompressed = Option{
+		KeyName:       KeyReadCompressed,
+		ChooseOnly:    true,
+		ChooseOptions: []interface{}{"false", "true"},
+		Default:       "false",
+		DefaultNoUse:  false,
+		Description:   "解压读取压缩文件(read_compressed)",
+		Advance:       true,
+		ToolTip:       "开启后，logrotate 压缩过的 .gz/.bz2/.zst 文件会被解压后读取，读取位置按解压后的字节数记录",
+	}
 	OptionHeadPattern = Option{
 		KeyName:      KeyHeadPattern,
 		ChooseOnly:   false,
@@ -123,6 +143,16 @@ var (
 		Advance:      true,
 		ToolTip:      `针对魔法变量进行时间延迟，单位支持h(时)、m(分)、s(秒)，如写24h，则渲染出来的时间魔法变量往前1天`,
 	}
+	OptionSQLKeysetColumns = Option{
+		KeyName:      KeySQLKeysetColumns,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Placeholder:  "ts,id",
+		Description:  "keyset 分页的排序列(sql_keyset_columns)",
+		Advance:      true,
+		ToolTip:      `按填写的列排序分页读取，每批读取上一批最后一行之后的数据，适用于时间戳、UUID 等非连续的列，最后一列需保证唯一，如 "ts,id"，排序列不能为 NULL。填写后 offset_key 不再生效，查询语句中不要带 ORDER BY`,
+	}
 	OptionKeyNewFileNewLine = Option{
 		KeyName:       KeyNewFileNewLine,
 		ChooseOnly:    true,
@@ -173,6 +203,7 @@ var ModeKeyOptions = map[string][]Option{
 		OptionEncoding,
 		OptionDataSourceTag,
 		OptionReadIoLimit,
+		OptionReadCompressed,
 		OptionHeadPattern,
 		OptionKeyNewFileNewLine,
 		OptionKeySkipFileFirstLine,
@@ -214,6 +245,7 @@ var ModeKeyOptions = map[string][]Option{
 		OptionDataSourceTag,
 		OptionEncoding,
 		OptionReadIoLimit,
+		OptionReadCompressed,
 		OptionHeadPattern,
 	},
 	ModeTailx: {
@@ -232,6 +264,7 @@ var ModeKeyOptions = map[string][]Option{
 		OptionWhence,
 		OptionEncoding,
 		OptionReadIoLimit,
+		OptionReadCompressed,
 		OptionDataSourceTag,
 		OptionHeadPattern,
 		{
@@ -357,6 +390,7 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionSQLSchema,
 		OptionMagicLagDuration,
+		OptionSQLKeysetColumns,
 	},
 	ModeMssql: {
 		{
@@ -433,6 +467,7 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionSQLSchema,
 		OptionMagicLagDuration,
+		OptionSQLKeysetColumns,
 	},
 	ModePG: {
 		{
@@ -509,6 +544,7 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionSQLSchema,
 		OptionMagicLagDuration,
+		OptionSQLKeysetColumns,
 	},
 	ModeElastic: {
 		{
@@ -570,6 +606,54 @@ var ModeKeyOptions = map[string][]Option{
 			Advance:      true,
 			ToolTip:      "logkit重启后可以继续读取ES数据的Offset记录在es服务端保存的时长，默认1d",
 		},
+		{
+			KeyName:       KeyESReadMode,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{ESReadModeScroll, ESReadModeIncremental},
+			Default:       ESReadModeScroll,
+			DefaultNoUse:  false,
+			Description:   "读取方式(es_read_mode)",
+			Advance:       true,
+			ToolTip:       "scroll 一次性读取整个索引，incremental 按时间戳增量持续读取，仅支持5.x和6.x",
+		},
+		{
+			KeyName:      KeyESQuery,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  `{"term":{"level":"error"}}`,
+			DefaultNoUse: false,
+			Description:  "查询语句(es_query)",
+			Advance:      true,
+			ToolTip:      "incremental 模式下的查询条件，为 query 字段中的 json，不填则读取全部数据",
+		},
+		{
+			KeyName:      KeyESTimestampField,
+			ChooseOnly:   false,
+			Default:      "@timestamp",
+			DefaultNoUse: false,
+			Description:  "时间戳字段(es_timestamp_field)",
+			Advance:      true,
+			ToolTip:      "incremental 模式下按该字段升序读取，默认@timestamp",
+		},
+		{
+			KeyName:      KeyESTiebreakerField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "排序辅助字段(es_tiebreaker_field)",
+			Advance:      true,
+			ToolTip:      "时间戳相同时用于排序的唯一字段，默认6.x为_id，5.x为_uid",
+		},
+		{
+			KeyName:      KeyESPollInterval,
+			ChooseOnly:   false,
+			Default:      "10s",
+			DefaultNoUse: false,
+			Description:  "查询间隔(es_poll_interval)",
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			ToolTip:      "incremental 模式下读到最新数据后再次查询的间隔，默认10s",
+		},
 	},
 	ModeMongo: {
 		{
@@ -654,6 +738,26 @@ var ModeKeyOptions = map[string][]Option{
 			Advance:      true,
 			ToolTip:      "表示collection的过滤规则，默认不过滤，全部获取",
 		},
+		{
+			KeyName:       KeyMongoReadMode,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{MongoReadModePoll, MongoReadModeChangeStream},
+			Default:       MongoReadModePoll,
+			DefaultNoUse:  false,
+			Description:   "读取方式(mongo_read_mode)",
+			Advance:       true,
+			ToolTip:       "poll 按递增主键定时查询，change_stream 监听插入、更新、删除等变更，需要 MongoDB 3.6 及以上的副本集或分片集群，不填collection时监听整个数据库，database也不填时监听整个集群",
+		},
+		{
+			KeyName:       KeyMongoFullDocument,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{MongoFullDocumentUpdateLookup, MongoFullDocumentDefault},
+			Default:       MongoFullDocumentUpdateLookup,
+			DefaultNoUse:  false,
+			Description:   "更新事件的完整文档(mongo_full_document)",
+			Advance:       true,
+			ToolTip:       "change_stream 模式下 updateLookup 会在更新事件中附带更新后的完整文档，default 只附带变更的字段",
+		},
 	},
 	ModeKafka: {
 		{
@@ -708,11 +812,133 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionDataSourceTag,
 	},
+	ModeKafkaBroker: {
+		{
+			KeyName:      KeyKafkaGroupID,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "logkit1",
+			DefaultNoUse: true,
+			Description:  "consumer组名称(kafka_groupid)",
+			ToolTip:      "kafka组名，多个logkit同时消费时写同一个组名可以协同读取数据",
+		},
+		{
+			KeyName:      KeyKafkaTopic,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "test_topic1",
+			DefaultNoUse: true,
+			Description:  "topic名称(kafka_topic)",
+		},
+		{
+			KeyName:       KeyKafkaBrokers,
+			ChooseOnly:    false,
+			Default:       "",
+			Required:      true,
+			Placeholder:   "localhost:9092",
+			DefaultNoUse:  true,
+			Description:   "kafka broker地址(kafka_brokers)",
+			ToolTip:       "kafka bootstrap broker 地址列表，多个用逗号分隔，常用端口是9092",
+			ToolTipActive: true,
+		},
+		OptionWhence,
+		{
+			KeyName:      KeyKafkaSessionTimeout,
+			ChooseOnly:   false,
+			Default:      "30s",
+			DefaultNoUse: false,
+			Description:  "消费组会话超时时间(kafka_session_timeout)",
+			CheckRegex:   "\\d+[ms]",
+			Advance:      true,
+			ToolTip:      "超过该时间没有心跳，broker 会认为消费者已经离开并触发 rebalance",
+		},
+		{
+			KeyName:      KeyKafkaHeartbeatInterval,
+			ChooseOnly:   false,
+			Default:      "3s",
+			DefaultNoUse: false,
+			Description:  "心跳间隔(kafka_heartbeat_interval)",
+			CheckRegex:   "\\d+[ms]",
+			Advance:      true,
+			ToolTip:      "向 group coordinator 发送心跳的间隔，必须小于会话超时时间",
+		},
+		{
+			KeyName:      KeyKafkaClientID,
+			ChooseOnly:   false,
+			Default:      "logkit",
+			DefaultNoUse: false,
+			Description:  "客户端ID(kafka_client_id)",
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyKafkaTLSEnable,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "启用TLS(kafka_tls_enable)",
+			Advance:       true,
+			ToolTip:       "填写了证书或CA文件时会自动启用TLS",
+		},
+		{
+			KeyName:      KeyKafkaTLSCert,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "客户端证书路径(kafka_tls_cert)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyKafkaTLSKey,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "客户端私钥路径(kafka_tls_key)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyKafkaTLSCA,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "CA证书路径(kafka_tls_ca)",
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyKafkaTLSSkipVerify,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "跳过服务端证书校验(kafka_tls_insecure_skip_verify)",
+			Advance:       true,
+		},
+		{
+			KeyName:      KeyKafkaSASLUsername,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "SASL用户名(kafka_sasl_username)",
+			Advance:      true,
+			ToolTip:      "使用 SASL/PLAIN 认证时填写",
+		},
+		{
+			KeyName:      KeyKafkaSASLPassword,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "SASL密码(kafka_sasl_password)",
+			Advance:      true,
+		},
+		OptionDataSourceTag,
+	},
 	ModeRedis: {
 		{
 			KeyName:       KeyRedisDataType,
 			ChooseOnly:    true,
-			ChooseOptions: []interface{}{DataTypeList, DataTypeChannel, DataTypePatterChannel, DataTypeString, DataTypeSet, DateTypeSortedSet, DateTypeHash},
+			ChooseOptions: []interface{}{DataTypeList, DataTypeChannel, DataTypePatterChannel, DataTypeString, DataTypeSet, DateTypeSortedSet, DateTypeHash, DataTypeStream},
 			Description:   "数据读取模式(redis_datatype)",
 			ToolTip:       "",
 		},
@@ -754,9 +980,28 @@ var ModeKeyOptions = map[string][]Option{
 			Required:      true,
 			DefaultNoUse:  false,
 			Description:   "数据库地址(redis_address)",
-			ToolTip:       `Redis的地址（IP+端口），默认为"127.0.0.1:6379"`,
+			ToolTip:       `Redis的地址（IP+端口），默认为"127.0.0.1:6379"，sentinel 模式下填写 sentinel 地址，cluster 模式下填写集群节点地址，多个地址用逗号分隔`,
 			ToolTipActive: true,
 		},
+		{
+			KeyName:       KeyRedisDeployMode,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{RedisDeploySingle, RedisDeploySentinel, RedisDeployCluster},
+			Default:       RedisDeploySingle,
+			DefaultNoUse:  false,
+			Description:   "部署方式(redis_deploy_mode)",
+			Advance:       true,
+			ToolTip:       "single 为单节点，sentinel 为 sentinel 管理的主从，会跟随主节点切换，cluster 为集群，list 和 stream 模式下各个 key 并发读取",
+		},
+		{
+			KeyName:      KeyRedisMasterName,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "sentinel 主节点名称(redis_master_name)",
+			Advance:      true,
+			ToolTip:      "sentinel 模式下必填，sentinel 中配置的主节点名称",
+		},
 		{
 			KeyName:      KeyRedisPassword,
 			ChooseOnly:   false,
@@ -776,6 +1021,52 @@ var ModeKeyOptions = map[string][]Option{
 			Advance:      true,
 			ToolTip:      "每次等待键值数据的超时时间[m(分)、s(秒)]",
 		},
+		{
+			KeyName:      KeyRedisStreamGroup,
+			ChooseOnly:   false,
+			Default:      "logkit",
+			DefaultNoUse: false,
+			Description:  "stream 模式的消费组(redis_stream_group)",
+			Advance:      true,
+			ToolTip:      "stream 模式下使用的消费组名称，不存在时自动创建",
+		},
+		{
+			KeyName:      KeyRedisStreamConsumer,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "stream 模式的消费者名称(redis_stream_consumer)",
+			Advance:      true,
+			ToolTip:      "默认为 主机名_runner名称，重启后需保持不变才能重新读取未确认的消息",
+		},
+		{
+			KeyName:      KeyRedisStreamCount,
+			ChooseOnly:   false,
+			Default:      "100",
+			DefaultNoUse: false,
+			Description:  "stream 模式单次读取的消息数(redis_stream_count)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyRedisStreamStartID,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"$", "0"},
+			Default:       "$",
+			DefaultNoUse:  false,
+			Description:   "stream 模式消费组的起始位置(redis_stream_start_id)",
+			Advance:       true,
+			ToolTip:       "创建消费组时从哪里开始读取，$ 表示只读取新消息，0 表示从头读取",
+		},
+		{
+			KeyName:      KeyRedisStreamClaimIdle,
+			ChooseOnly:   false,
+			Default:      "5m",
+			DefaultNoUse: false,
+			Description:  "stream 模式认领消息的空闲时间(redis_stream_claim_idle)",
+			Advance:      true,
+			ToolTip:      "其他消费者未确认的消息空闲超过该时间后，通过 XAUTOCLAIM 认领过来读取，0s 表示不认领",
+		},
 		OptionDataSourceTag,
 	},
 	ModeSocket: {
@@ -828,6 +1119,62 @@ var ModeKeyOptions = map[string][]Option{
 			ToolTip:       "填0为关闭keep_alive",
 			ToolTipActive: true,
 		},
+		{
+			KeyName:       KeySocketFraming,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{SocketFramingNewline, SocketFramingOctetCounting, SocketFramingLengthPrefixed},
+			Default:       SocketFramingNewline,
+			DefaultNoUse:  false,
+			Description:   "分帧方式(socket_framing)",
+			Advance:       true,
+			ToolTip:       "仅用于tcp/unix，newline按换行符分割，octet_counting为RFC 6587的长度前缀格式，length_prefixed为4字节大端序长度前缀",
+		},
+		{
+			KeyName:       KeySocketSourceMeta,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "记录远端地址与连接ID(socket_source_meta)",
+			Advance:       true,
+			ToolTip:       "开启后datasource_tag记录的来源为\"远端地址#连接ID\"",
+		},
+		{
+			KeyName:      KeySocketTLSCert,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "服务端证书路径(socket_tls_cert)",
+			Advance:      true,
+			ToolTip:      "与私钥同时配置后 tcp/unix socket 使用 TLS 加密",
+		},
+		{
+			KeyName:      KeySocketTLSKey,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "服务端私钥路径(socket_tls_key)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySocketTLSCA,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "客户端CA证书路径(socket_tls_ca)",
+			Advance:      true,
+			ToolTip:      "用于校验客户端证书，配置后默认要求客户端提供证书",
+		},
+		{
+			KeyName:      KeySocketTLSClientAuth,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "require_and_verify",
+			DefaultNoUse: false,
+			Description:  "客户端证书校验方式(socket_tls_client_auth)",
+			Advance:      true,
+			ToolTip:      "可选none/request/require/verify_if_given/require_and_verify，为空时配置了CA则为require_and_verify，否则为none；客户端证书的身份(CommonName)会作为datasource_tag的值",
+		},
 		OptionDataSourceTag,
 	},
 	ModeHttp: {
@@ -849,8 +1196,73 @@ var ModeKeyOptions = map[string][]Option{
 			Required:     true,
 			DefaultNoUse: true,
 			Description:  "监听地址前缀(http_service_path)",
-			ToolTip:      "监听的请求地址，如 /data ",
+			ToolTip:      "监听的请求地址，如 /data ，多个地址用逗号分隔，地址前可加\"标签=\"作为datasource_tag的值，如 nginx=/logs/nginx,app=/logs/app",
+		},
+		{
+			KeyName:      KeyHttpAuthToken,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "认证token(http_auth_token)",
+			Advance:      true,
+			ToolTip:      "配置后请求需要带上 Authorization: Bearer <token> 请求头",
+		},
+		{
+			KeyName:      KeyHttpAuthUsername,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "basic auth 用户名(http_auth_username)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyHttpAuthPassword,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "basic auth 密码(http_auth_password)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyHttpMaxQueueDepth,
+			ChooseOnly:   false,
+			Default:      "1000000",
+			DefaultNoUse: false,
+			Description:  "缓存队列最大条数(http_max_queue_depth)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "磁盘缓存中未读取的数据超过该条数时返回429，必须大于0",
+		},
+		{
+			KeyName:       KeyHttpESBulk,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "模拟Elasticsearch的_bulk接口(http_es_bulk)",
+			Advance:       true,
+			ToolTip:       "开启后Filebeat等客户端可以把logkit当作Elasticsearch写入，datasource_tag记录文档所在的索引",
+		},
+		{
+			KeyName:      KeyHttpESVersion,
+			ChooseOnly:   false,
+			Default:      DefaultESBulkVersion,
+			DefaultNoUse: false,
+			Description:  "模拟的Elasticsearch版本(http_es_version)",
+			Advance:      true,
+			ToolTip:      "客户端会检查Elasticsearch的版本是否兼容",
+		},
+		{
+			KeyName:       KeyHttpLokiPush,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "模拟Loki的push接口(http_loki_push)",
+			Advance:       true,
+			ToolTip:       "开启后Promtail等客户端可以推送到/loki/api/v1/push，datasource_tag记录日志流的标签",
 		},
+		OptionDataSourceTag,
 	},
 	ModeScript: {
 		{
@@ -893,14 +1305,54 @@ var ModeKeyOptions = map[string][]Option{
 			Description:   "启动时立即执行(script_exec_onstart)",
 			ToolTip:       "",
 		},
-	},
-	ModeCloudWatch: {
 		{
-			KeyName:      KeyRegion,
-			ChooseOnly:   false,
-			Default:      "",
-			Placeholder:  "us-east-1",
-			DefaultNoUse: true,
+			KeyName:       KeyScriptReadMode,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{ScriptReadModeBatch, ScriptReadModeStream},
+			Default:       ScriptReadModeBatch,
+			DefaultNoUse:  false,
+			Description:   "读取方式(script_read_mode)",
+			Advance:       true,
+			ToolTip:       "batch 按定时任务执行脚本并读取全部输出，stream 持续运行脚本(如 tail -F、kubectl logs -f)并按行读取，脚本退出后自动重启",
+		},
+		{
+			KeyName:      KeyScriptEnv,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "KEY1=VALUE1,KEY2=VALUE2",
+			DefaultNoUse: false,
+			Description:  "环境变量(script_env)",
+			Advance:      true,
+			ToolTip:      "脚本执行时额外设置的环境变量，多个用逗号分隔",
+		},
+		{
+			KeyName:      KeyScriptTimeout,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "30s",
+			DefaultNoUse: false,
+			Description:  "超时时间(script_timeout)",
+			Advance:      true,
+			ToolTip:      "batch 模式下单次执行的超时时间，stream 模式下标准输出持续无数据的超时时间，超时后结束脚本，默认不超时",
+		},
+		{
+			KeyName:      KeyScriptStderrField,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "stderr",
+			DefaultNoUse: false,
+			Description:  "标准错误输出字段(script_stderr_field)",
+			Advance:      true,
+			ToolTip:      "stream 模式下将标准错误输出的每一行以 {字段名:内容} 的 json 形式读取，不填则只记录到日志中",
+		},
+	},
+	ModeCloudWatch: {
+		{
+			KeyName:      KeyRegion,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "us-east-1",
+			DefaultNoUse: true,
 			Required:     true,
 			Description:  "区域(region)",
 			ToolTip:      "服务所在区域",
@@ -1339,4 +1791,654 @@ var ModeKeyOptions = map[string][]Option{
 		OptionKeyValidFilePattern,
 		OptionKeySkipFileFirstLine,
 	},
+	ModeJournal: {
+		{
+			KeyName:      KeyLogPath,
+			ChooseOnly:   false,
+			Default:      defaultJournalPath,
+			Placeholder:  defaultJournalPath,
+			DefaultNoUse: false,
+			Description:  "journal 路径(log_path)",
+			ToolTip:      "journal 文件或所在目录，目录下 machine-id 子目录中的 *.journal 文件也会被读取",
+		},
+		OptionMetaPath,
+		OptionWhence,
+		{
+			KeyName:      KeyJournalPollInterval,
+			ChooseOnly:   false,
+			Default:      "1s",
+			DefaultNoUse: false,
+			Description:  "检查新日志的间隔(journal_poll_interval)",
+			Advance:      true,
+			ToolTip:      "没有新日志时等待的时间",
+		},
+		OptionDataSourceTag,
+	},
+	ModeMysqlBinlog: {
+		{
+			KeyName:      KeyMysqlBinlogAddress,
+			ChooseOnly:   false,
+			Default:      "127.0.0.1:3306",
+			Required:     true,
+			Placeholder:  "<hostname>:<port>",
+			DefaultNoUse: false,
+			Description:  "数据库地址(mysql_binlog_address)",
+		},
+		{
+			KeyName:      KeyMysqlBinlogUser,
+			ChooseOnly:   false,
+			Default:      "root",
+			Required:     true,
+			DefaultNoUse: false,
+			Description:  "用户名(mysql_binlog_user)",
+			ToolTip:      "用户需要 REPLICATION SLAVE、REPLICATION CLIENT 权限以及 information_schema 的查询权限",
+		},
+		{
+			KeyName:      KeyMysqlBinlogPassword,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "密码(mysql_binlog_password)",
+		},
+		{
+			KeyName:      KeyMysqlBinlogTables,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "db.table, db.*",
+			DefaultNoUse: false,
+			Description:  "采集的表(mysql_binlog_tables)",
+			ToolTip:      "格式为 db.table，多个用逗号分隔，支持 * 和 ? 通配符，为空则采集所有表",
+		},
+		OptionMetaPath,
+		OptionWhence,
+		{
+			KeyName:       KeyMysqlBinlogFlavor,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"mysql", "mariadb"},
+			Default:       "mysql",
+			DefaultNoUse:  false,
+			Description:   "数据库类型(mysql_binlog_flavor)",
+			Advance:       true,
+		},
+		{
+			KeyName:      KeyMysqlBinlogServerID,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "复制使用的 server_id(mysql_binlog_server_id)",
+			Advance:      true,
+			ToolTip:      "需要与集群中其他实例的 server_id 不同，不填则随机生成",
+		},
+		{
+			KeyName:       KeyMysqlBinlogGTID,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "按 GTID 同步(mysql_binlog_gtid)",
+			Advance:       true,
+			ToolTip:       "开启后 meta 中记录已同步的 GTID 集合，主从切换后仍可继续同步，需要数据库开启 GTID",
+		},
+		{
+			KeyName:      KeyMysqlBinlogFile,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "mysql-bin.000001",
+			DefaultNoUse: false,
+			Description:  "起始 binlog 文件(mysql_binlog_file)",
+			Advance:      true,
+			ToolTip:      "没有读取记录时从该文件开始同步，不填则按读取起始位置决定",
+		},
+		{
+			KeyName:      KeyMysqlBinlogPos,
+			ChooseOnly:   false,
+			Default:      "4",
+			DefaultNoUse: false,
+			Description:  "起始 binlog 位置(mysql_binlog_pos)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyMysqlBinlogGTIDSet,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "起始 GTID 集合(mysql_binlog_gtid_set)",
+			Advance:      true,
+			ToolTip:      "按 GTID 同步且没有读取记录时，跳过该集合中的事务，不填则按读取起始位置决定",
+		},
+		OptionDataSourceTag,
+	},
+	ModePGReplication: {
+		{
+			KeyName:       KeyPGReplicationDataSource,
+			ChooseOnly:    false,
+			Default:       "",
+			Required:      true,
+			Placeholder:   "host=localhost port=5432 user=postgres password=123456 dbname=postgres sslmode=disable",
+			DefaultNoUse:  true,
+			Description:   "数据库地址(postgres_replication_datasource)",
+			ToolTip:       `格式与 postgres_datasource 相同，属性和值用=(等于)符号连接，不同的属性用(空格)隔开，支持 host、port、user、password、dbname、sslmode、sslrootcert、connect_timeout，用户需要有 REPLICATION 权限`,
+			ToolTipActive: true,
+		},
+		{
+			KeyName:      KeyPGReplicationSlot,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "logkit_slot",
+			DefaultNoUse: true,
+			Description:  "逻辑复制槽名称(postgres_replication_slot)",
+			ToolTip:      "只能包含小写字母、数字和下划线，不同的 runner 需要使用不同的复制槽",
+		},
+		{
+			KeyName:       KeyPGReplicationPlugin,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{PGPluginPgoutput, PGPluginWal2json},
+			Default:       PGPluginPgoutput,
+			DefaultNoUse:  false,
+			Description:   "输出插件(postgres_replication_plugin)",
+			ToolTip:       "pgoutput 为 PostgreSQL 10 及以上版本内置，wal2json 需要单独安装",
+		},
+		{
+			KeyName:      KeyPGReplicationPublication,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "logkit_pub",
+			DefaultNoUse: false,
+			Description:  "publication 名称(postgres_replication_publication)",
+			ToolTip:      "使用 pgoutput 时必填，通过 CREATE PUBLICATION 指定需要采集的表，多个用逗号分隔",
+		},
+		OptionMetaPath,
+		{
+			KeyName:       KeyPGReplicationCreateSlot,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "自动创建复制槽(postgres_replication_create_slot)",
+			Advance:       true,
+			ToolTip:       "复制槽不存在时自动创建，新建的复制槽从当前位置开始采集",
+		},
+		{
+			KeyName:      KeyPGReplicationStatusInterval,
+			ChooseOnly:   false,
+			Default:      "10s",
+			DefaultNoUse: false,
+			Description:  "汇报确认位置的间隔(postgres_replication_status_interval)",
+			Advance:      true,
+			ToolTip:      "数据发送成功后确认的位置按此间隔汇报给服务端，服务端才会释放对应的 WAL",
+		},
+		OptionDataSourceTag,
+	},
+	ModeSQLite: {
+		{
+			KeyName:      KeySQLiteDataSource,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "/path/to/data.db",
+			DefaultNoUse: true,
+			Description:  "数据库文件路径(sqlite_datasource)",
+			ToolTip:      `sqlite 数据库文件的路径，也可以填写 file:/path/to/data.db?mode=ro 形式的 URI`,
+		},
+		{
+			KeyName:      KeySQLiteSQL,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "select * from <table>;",
+			DefaultNoUse: true,
+			Description:  "数据查询语句(sqlite_sql)",
+			ToolTip:      "要执行的查询语句",
+		},
+		{
+			KeyName:      KeySQLiteOffsetKey,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: true,
+			Description:  "递增的列名称(sqlite_offset_key)",
+			Advance:      true,
+			ToolTip:      `指定一个 sqlite 的列名，作为 offset 的记录，类型必须是整型，建议使用插入(或修改)数据的时间戳(unixnano)作为该字段`,
+		},
+		OptionMetaPath,
+		OptionDataSourceTag,
+		{
+			KeyName:      KeySQLiteReadBatch,
+			ChooseOnly:   false,
+			Default:      "100",
+			DefaultNoUse: false,
+			Description:  "分批查询的单批次大小(sqlite_limit_batch)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "若数据量大，可以填写该字段，分批次查询",
+		},
+		{
+			KeyName:       KeySQLiteCron,
+			ChooseOnly:    false,
+			Default:       "",
+			Placeholder:   "00 00 04 * * *",
+			DefaultNoUse:  false,
+			Description:   "定时任务(sqlite_cron)",
+			Advance:       true,
+			ToolTip:       `定时任务触发周期，直接写"loop"循环执行，crontab的写法，类似于* * * * * *，对应的是秒(0~59)，分(0~59)，时(0~23)，日(1~31)，月(1-12)，星期(0~6)，填*号表示所有遍历都执行`,
+			ToolTipActive: true,
+		},
+		{
+			KeyName:       KeySQLiteExecOnStart,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "启动时立即执行(sqlite_exec_onstart)",
+			ToolTip:       "启动时立即执行一次",
+		},
+		OptionSQLSchema,
+		OptionMagicLagDuration,
+		OptionSQLKeysetColumns,
+	},
+	ModeK8sContainers: {
+		{
+			KeyName:      KeyK8sLogDir,
+			ChooseOnly:   false,
+			Default:      K8sDefaultLogDir,
+			DefaultNoUse: false,
+			Description:  "容器日志目录(k8s_log_dir)",
+			ToolTip:      "kubelet 存放容器日志的目录，文件名为 <pod>_<namespace>_<container>-<container id>.log，支持 docker json-file 以及 containerd、CRI-O 的日志格式",
+		},
+		OptionMetaPath,
+		OptionWhence,
+		OptionReadIoLimit,
+		OptionDataSourceTag,
+		{
+			KeyName:      KeyK8sAPIURL,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "https://127.0.0.1:10250",
+			DefaultNoUse: false,
+			Description:  "元数据接口地址(k8s_api_url)",
+			ToolTip:      "kubelet 或 apiserver 的地址，用于获取 pod 的 labels 和 annotations，不填则只附加从文件名中解析的 pod 信息",
+		},
+		{
+			KeyName:       KeyK8sAPIType,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{K8sAPITypeKubelet, K8sAPITypeAPIServer},
+			Default:       K8sAPITypeKubelet,
+			DefaultNoUse:  false,
+			Description:   "元数据接口类型(k8s_api_type)",
+			ToolTip:       "kubelet 通过 /pods 接口获取本节点所有 pod，apiserver 按 pod 逐个查询",
+		},
+		{
+			KeyName:      KeyK8sTokenFile,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "/var/run/secrets/kubernetes.io/serviceaccount/token",
+			DefaultNoUse: false,
+			Description:  "认证 token 文件(k8s_token_file)",
+			Advance:      true,
+			ToolTip:      "请求元数据接口时使用的 Bearer token 所在文件",
+		},
+		{
+			KeyName:      KeyK8sCAFile,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+			DefaultNoUse: false,
+			Description:  "CA 证书文件(k8s_ca_file)",
+			Advance:      true,
+			ToolTip:      "校验元数据接口证书使用的 CA 文件",
+		},
+		{
+			KeyName:       KeyK8sInsecureSkipVerify,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "跳过证书校验(k8s_insecure_skip_verify)",
+			Advance:       true,
+			ToolTip:       "kubelet 通常使用自签名证书，可以选择跳过校验",
+		},
+		{
+			KeyName:      KeyK8sMetadataTTL,
+			ChooseOnly:   false,
+			Default:      "1m",
+			DefaultNoUse: false,
+			Description:  "元数据缓存时间(k8s_metadata_ttl)",
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			ToolTip:      "pod 元数据的缓存时间，过期后重新获取",
+		},
+		{
+			KeyName:      KeyExpire,
+			ChooseOnly:   false,
+			Default:      "24h",
+			DefaultNoUse: false,
+			Description:  "忽略文件的最大过期时间(expire)",
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			ToolTip:      "超过该时间没有更新的容器日志不再追踪，默认24h",
+		},
+		{
+			KeyName:      KeyMaxOpenFiles,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "最大打开文件数(max_open_files)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "最大同时追踪的文件数，默认为256",
+		},
+		{
+			KeyName:      KeyStatInterval,
+			ChooseOnly:   false,
+			Default:      "10s",
+			DefaultNoUse: false,
+			Description:  "扫描间隔(stat_interval)",
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			ToolTip:      "感知新增容器日志的定时检查时间，默认10s",
+		},
+	},
+	ModeDocker: {
+		{
+			KeyName:      KeyDockerHost,
+			ChooseOnly:   false,
+			Default:      DockerDefaultHost,
+			DefaultNoUse: false,
+			Description:  "Docker API 地址(docker_host)",
+			ToolTip:      "Docker daemon 的地址，支持 unix://、tcp://、http:// 以及 https:// 形式",
+		},
+		OptionMetaPath,
+		OptionWhence,
+		OptionDataSourceTag,
+		{
+			KeyName:      KeyDockerLabelFilters,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "app=web,env",
+			DefaultNoUse: false,
+			Description:  "容器 label 过滤条件(docker_label_filters)",
+			ToolTip:      "只读取匹配所有条件的容器，条件为 key=value 或 key，多个条件用逗号分隔，不填读取所有容器",
+		},
+		{
+			KeyName:      KeyDockerDiscoverInterval,
+			ChooseOnly:   false,
+			Default:      "10s",
+			DefaultNoUse: false,
+			Description:  "发现新容器的间隔(docker_discover_interval)",
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			ToolTip:      "定期列出容器以读取新启动的容器，默认10s",
+		},
+	},
+	ModeEvtx: {
+		{
+			KeyName:      KeyLogPath,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "/data/evtx",
+			DefaultNoUse: true,
+			Description:  "EVTX 路径(log_path)",
+			ToolTip:      "EVTX 文件或所在目录，目录下的 *.evtx 文件按修改时间顺序读取",
+		},
+		OptionMetaPath,
+		{
+			KeyName:      KeyEvtxPollInterval,
+			ChooseOnly:   false,
+			Default:      "10s",
+			DefaultNoUse: false,
+			Description:  "检查新文件的间隔(evtx_poll_interval)",
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			ToolTip:      "所有文件读取完后等待的时间，之后重新扫描新增或变化的文件",
+		},
+		OptionDataSourceTag,
+	},
+	ModeHttpPoll: {
+		{
+			KeyName:      KeyHttpPollUrl,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "https://api.example.com/v1/audit_logs",
+			DefaultNoUse: true,
+			Description:  "请求地址(http_poll_url)",
+		},
+		OptionMetaPath,
+		OptionDataSourceTag,
+		{
+			KeyName:       KeyHttpPollMethod,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"GET", "POST"},
+			Default:       "GET",
+			DefaultNoUse:  false,
+			Description:   "请求方法(http_poll_method)",
+			ToolTip:       "",
+		},
+		{
+			KeyName:      KeyHttpPollBody,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "请求内容(http_poll_body)",
+			ToolTip:      "POST 请求的内容",
+		},
+		{
+			KeyName:      KeyHttpPollHeaders,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "请求头(http_poll_headers)",
+			ToolTip:      "json 格式的请求头，如 {\"Accept\":\"application/json\"}",
+		},
+		{
+			KeyName:       KeyHttpPollAuthType,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{HttpPollAuthNone, HttpPollAuthBasic, HttpPollAuthBearer},
+			Default:       HttpPollAuthNone,
+			DefaultNoUse:  false,
+			Description:   "认证方式(http_poll_auth_type)",
+			ToolTip:       "basic 使用用户名和密码，bearer 使用 token",
+		},
+		{
+			KeyName:      KeyHttpPollUsername,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "用户名(http_poll_username)",
+			ToolTip:      "basic 认证的用户名",
+		},
+		{
+			KeyName:      KeyHttpPollPassword,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "密码(http_poll_password)",
+			ToolTip:      "basic 认证的密码",
+		},
+		{
+			KeyName:      KeyHttpPollToken,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "token(http_poll_token)",
+			ToolTip:      "bearer 认证的 token",
+		},
+		{
+			KeyName:       KeyHttpPollCron,
+			ChooseOnly:    false,
+			Default:       "",
+			Placeholder:   "loop 1m",
+			DefaultNoUse:  false,
+			Description:   "定时任务(http_poll_cron)",
+			ToolTip:       `定时任务触发周期，"loop 1m"表示每次请求结束后等待1分钟，crontab的写法，类似于* * * * * *，对应的是秒(0~59)，分(0~59)，时(0~23)，日(1~31)，月(1-12)，星期(0~6)，填*号表示所有遍历都执行`,
+			ToolTipActive: true,
+		},
+		{
+			KeyName:       KeyHttpPollExecOnStart,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "启动时立即执行(http_poll_exec_onstart)",
+			ToolTip:       "",
+		},
+		{
+			KeyName:      KeyHttpPollRecordsPath,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Placeholder:  "data.items",
+			Description:  "记录所在路径(http_poll_records_path)",
+			ToolTip:      "响应中记录数组所在的字段，多层用.分隔，如 data.items，不填表示响应本身为记录数组",
+		},
+		{
+			KeyName:       KeyHttpPollPagination,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{HttpPollPaginationNone, HttpPollPaginationLinkHeader, HttpPollPaginationNextURL, HttpPollPaginationCursor},
+			Default:       HttpPollPaginationNone,
+			DefaultNoUse:  false,
+			Description:   "分页方式(http_poll_pagination)",
+			ToolTip:       "link_header 使用 Link 响应头中 rel=next 的地址，next_url 使用响应中的下一页地址，cursor 将响应中的游标作为请求参数；最后一页仍返回下一页时，下一轮从该页继续",
+		},
+		{
+			KeyName:      KeyHttpPollNextPath,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "下一页字段(http_poll_next_path)",
+			ToolTip:      "next_url 和 cursor 分页时下一页地址或游标所在的字段，多层用.分隔",
+		},
+		{
+			KeyName:      KeyHttpPollCursorParam,
+			ChooseOnly:   false,
+			Default:      "cursor",
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "游标参数名(http_poll_cursor_param)",
+			ToolTip:      "cursor 分页时游标的请求参数名",
+		},
+		{
+			KeyName:      KeyHttpPollTimestampPath,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "时间字段(http_poll_timestamp_path)",
+			ToolTip:      "记录中的时间字段，配置后只发送比已读取的最大时间更新的记录，时间为数字或 RFC3339 格式",
+		},
+		{
+			KeyName:      KeyHttpPollTimestampParam,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "时间参数名(http_poll_timestamp_param)",
+			ToolTip:      "每轮第一页请求时将已读取的最大时间作为该参数，如 since",
+		},
+		{
+			KeyName:      KeyHttpPollMaxPages,
+			ChooseOnly:   false,
+			Default:      "100",
+			DefaultNoUse: false,
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			Description:  "每轮最大页数(http_poll_max_pages)",
+			ToolTip:      "每轮最多读取的页数，剩余的页在下一轮继续读取",
+		},
+		{
+			KeyName:      KeyHttpPollTimeout,
+			ChooseOnly:   false,
+			Default:      "30s",
+			DefaultNoUse: false,
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			Description:  "请求超时时间(http_poll_timeout)",
+			ToolTip:      "",
+		},
+	},
+	ModeS3: {
+		{
+			KeyName:      KeyS3Endpoint,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "http://127.0.0.1:9000",
+			DefaultNoUse: false,
+			Description:  "服务地址(s3_endpoint)",
+			ToolTip:      "S3 兼容存储的服务地址，如 MinIO、七牛 Kodo、Ceph，不填则访问 AWS S3；地址中包含 ${bucket} 时使用 bucket 域名访问，请求使用 AWS V4 签名",
+		},
+		{
+			KeyName:      KeyS3Region,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "us-east-1",
+			DefaultNoUse: false,
+			Description:  "区域(s3_region)",
+			ToolTip:      "S3服务区域，访问 AWS S3 时必填",
+		},
+		{
+			KeyName:      KeyS3AccessKey,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "访问密钥",
+			DefaultNoUse: false,
+			Required:     true,
+			Description:  "AK(s3_access_key)",
+			ToolTip:      "访问密钥ID(AK)",
+		},
+		{
+			KeyName:      KeyS3SecretKey,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "访问密钥",
+			DefaultNoUse: false,
+			Required:     true,
+			Description:  "SK(s3_secret_key)",
+			ToolTip:      "与访问密钥ID结合使用的密钥(SK)",
+		},
+		{
+			KeyName:      KeyS3Bucket,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "",
+			DefaultNoUse: false,
+			Required:     true,
+			Description:  "存储桶名称(s3_bucket)",
+			ToolTip:      "存储桶名称",
+		},
+		{
+			KeyName:      KeyS3Prefix,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "logs/",
+			DefaultNoUse: false,
+			Description:  "文件前缀(s3_prefix)",
+			ToolTip:      "只读取该前缀下的对象",
+		},
+		{
+			KeyName:      KeyS3KeyPattern,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "\\.log(\\.gz)?$",
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "对象名称正则(s3_key_pattern)",
+			ToolTip:      "只读取 key 匹配该正则的对象，不填读取前缀下的全部对象",
+		},
+		{
+			KeyName:      KeyS3ListInterval,
+			ChooseOnly:   false,
+			Default:      "1m",
+			Placeholder:  "",
+			DefaultNoUse: false,
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			Description:  "列举对象间隔(s3_list_interval)",
+			ToolTip:      "每隔多久列举一次对象，发现新增的对象；对象按 key 排序读取，key 小于已读取对象的新对象会被忽略",
+		},
+		OptionWhence,
+	},
 }
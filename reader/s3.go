@@ -0,0 +1,561 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// s3 reader 的配置项，region、ak、sk、bucket 以及 prefix 与 cloudtrail reader 共用
+const (
+	KeyS3Endpoint     = "s3_endpoint"
+	KeyS3KeyPattern   = "s3_key_pattern"
+	KeyS3ListInterval = "s3_list_interval"
+)
+
+const (
+	s3DefaultRegion       = "us-east-1"
+	s3DefaultListInterval = time.Minute
+	// s3MaxObjectRetries 为同一个对象读取失败的最大轮数，超过后跳过该对象
+	s3MaxObjectRetries = 3
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// s3RecentObjects 为记录 etag 的已读取完成的对象数量，超出时 key 最小的对象只通过 marker 记录
+var s3RecentObjects = 1000
+
+// s3Position 是正在读取的对象以及已经读取的解压后的字节数，etag 用于发现对象被覆盖
+type s3Position struct {
+	Key    string `json:"key"`
+	ETag   string `json:"etag"`
+	Offset int64  `json:"offset"`
+}
+
+// s3State 是写入 meta 的读取位置，按 key 排序不大于 marker 的对象都已经读取完成，
+// done 为 marker 之后已经读取完成的对象及其 etag，current 为正在读取的对象
+type s3State struct {
+	Marker  string            `json:"marker,omitempty"`
+	Done    map[string]string `json:"done"`
+	Current s3Position        `json:"current"`
+}
+
+// s3ObjectDone 判断对象是否已经读取完成，marker 之后的对象 etag 变化时视为被覆盖，需要重新读取
+func s3ObjectDone(marker string, done map[string]string, object s3.Key) bool {
+	if marker != "" && object.Key <= marker {
+		return true
+	}
+	etag, ok := done[object.Key]
+	return ok && etag == object.ETag
+}
+
+// compactS3Done 在 done 超过 s3RecentObjects 个时移除 key 最小的对象，返回新的 marker
+func compactS3Done(marker string, done map[string]string) string {
+	if len(done) <= s3RecentObjects {
+		return marker
+	}
+	keys := make([]string, 0, len(done))
+	for key := range done {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys[:len(keys)-s3RecentObjects] {
+		delete(done, key)
+		if key > marker {
+			marker = key
+		}
+	}
+	return marker
+}
+
+// s3Failure 是对象读取失败的记录，etag 变化时重新计数，offset 为失败前已经读取的位置
+type s3Failure struct {
+	etag   string
+	count  int
+	offset int64
+}
+
+// s3Line 是读取对象的协程与 ReadLine 之间传递的数据，done 为 true 时表示 pos 对应的对象已经读取完成，data 为空时只更新位置
+type s3Line struct {
+	data []byte
+	pos  s3Position
+	done bool
+}
+
+// S3Reader 定期列出 S3 兼容存储中匹配前缀和 key 正则的对象，逐行读取对象内容，gzip 压缩的对象会自动解压，
+// 已经读取完成的对象和正在读取的对象的位置记录在 meta 中。
+// 对象按 key 排序读取，key 小于已记录的 marker 的新对象不会被读取，适用于 key 按时间递增的场景。
+// 请求使用 AWS Signature Version 4 签名，vendor 中的 goamz 已经改为使用 aws-sdk-go 的 v4 签名
+type S3Reader struct {
+	meta         *Meta
+	bucket       *s3.Bucket
+	prefix       string
+	keyPattern   *regexp.Regexp
+	whence       string
+	listInterval time.Duration
+
+	readChan chan s3Line
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	status  int32
+	mux     sync.Mutex
+	started bool
+
+	// marker、done 和 current 为读取对象的协程使用的位置，state 为已经被 ReadLine 取走的数据对应的位置
+	marker   string
+	done     map[string]string
+	current  s3Position
+	state    s3State
+	syncLock sync.Mutex
+	source   string
+	// failures 记录读取失败的对象的 etag 和失败的轮数，只在读取对象的协程中使用
+	failures map[string]s3Failure
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewS3Reader(meta *Meta, c conf.MapConf) (Reader, error) {
+	region, _ := c.GetStringOr(KeyS3Region, "")
+	endpoint, _ := c.GetStringOr(KeyS3Endpoint, "")
+	accessKey, err := c.GetString(KeyS3AccessKey)
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := c.GetString(KeyS3SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	bucketName, err := c.GetString(KeyS3Bucket)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := c.GetStringOr(KeyS3Prefix, "")
+	pattern, _ := c.GetStringOr(KeyS3KeyPattern, "")
+	var keyPattern *regexp.Regexp
+	if pattern != "" {
+		if keyPattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("compile %v %v error %v", KeyS3KeyPattern, pattern, err)
+		}
+	}
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	intervalStr, _ := c.GetStringOr(KeyS3ListInterval, "")
+	interval := s3DefaultListInterval
+	if intervalStr != "" {
+		if interval, err = time.ParseDuration(intervalStr); err != nil {
+			return nil, fmt.Errorf("parse %v %v error %v", KeyS3ListInterval, intervalStr, err)
+		}
+	}
+	s3Region, err := newS3Region(region, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sr := &S3Reader{
+		meta:         meta,
+		bucket:       s3.New(aws.Auth{AccessKey: accessKey, SecretKey: secretKey}, s3Region).Bucket(bucketName),
+		prefix:       prefix,
+		keyPattern:   keyPattern,
+		whence:       whence,
+		listInterval: interval,
+		readChan:     make(chan s3Line),
+		ctx:          ctx,
+		cancel:       cancel,
+		status:       StatusInit,
+		state:        s3State{Done: make(map[string]string)},
+		failures:     make(map[string]s3Failure),
+	}
+	sr.restoreState()
+	sr.state.Marker = compactS3Done(sr.state.Marker, sr.state.Done)
+	sr.marker = sr.state.Marker
+	sr.done = make(map[string]string, len(sr.state.Done))
+	for k, v := range sr.state.Done {
+		sr.done[k] = v
+	}
+	sr.current = sr.state.Current
+	return sr, nil
+}
+
+// newS3Region 未配置 endpoint 时使用 AWS 的区域，否则使用 path 方式访问 endpoint；
+// endpoint 中包含 ${bucket} 时作为 bucket 的域名使用
+func newS3Region(region, endpoint string) (aws.Region, error) {
+	if endpoint == "" {
+		r, ok := aws.Regions[region]
+		if !ok {
+			return aws.Region{}, fmt.Errorf("unknown %v %q, %v is required for non-AWS storage", KeyS3Region, region, KeyS3Endpoint)
+		}
+		return r, nil
+	}
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if strings.Contains(endpoint, "${bucket}") {
+		return aws.Region{Name: region, S3Endpoint: strings.Replace(endpoint, "${bucket}.", "", 1), S3BucketEndpoint: endpoint}, nil
+	}
+	return aws.Region{Name: region, S3Endpoint: endpoint}, nil
+}
+
+func (sr *S3Reader) restoreState() {
+	_, _, bufsize, err := sr.meta.ReadBufMeta()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Runner[%v] %v recover from meta error %v, ignore...", sr.meta.RunnerName, sr.Name(), err)
+		}
+		return
+	}
+	buf := make([]byte, bufsize)
+	if _, err = sr.meta.ReadBuf(buf); err != nil {
+		log.Warnf("Runner[%v] %v read buf error %v, ignore...", sr.meta.RunnerName, sr.Name(), err)
+		return
+	}
+	if err = jsoniter.Unmarshal(buf, &sr.state); err != nil {
+		log.Warnf("Runner[%v] %v Unmarshal read buf error %v, ignore...", sr.meta.RunnerName, sr.Name(), err)
+		sr.state = s3State{}
+	}
+	if sr.state.Done == nil {
+		sr.state.Done = make(map[string]string)
+	}
+}
+
+func (sr *S3Reader) Name() string {
+	return "S3Reader:" + sr.bucket.Name + "/" + sr.prefix
+}
+
+// Source 返回最近一行所在的对象
+func (sr *S3Reader) Source() string {
+	sr.syncLock.Lock()
+	defer sr.syncLock.Unlock()
+	if sr.source == "" {
+		return "s3://" + sr.bucket.Name + "/" + sr.prefix
+	}
+	return sr.source
+}
+
+func (sr *S3Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("S3Reader not support read mode")
+}
+
+func (sr *S3Reader) Status() StatsInfo {
+	sr.statsLock.RLock()
+	defer sr.statsLock.RUnlock()
+	return sr.stats
+}
+
+func (sr *S3Reader) setStatsError(err string) {
+	sr.statsLock.Lock()
+	defer sr.statsLock.Unlock()
+	sr.stats.Errors++
+	sr.stats.LastError = err
+}
+
+//Start 仅调用一次，借用ReadLine启动，不能在new实例的时候启动，会有并发问题
+func (sr *S3Reader) Start() {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	if sr.started {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&sr.status, StatusInit, StatusRunning) {
+		return
+	}
+	sr.wg.Add(1)
+	go sr.run()
+	sr.started = true
+	log.Infof("Runner[%v] %v pull data deamon started", sr.meta.RunnerName, sr.Name())
+}
+
+func (sr *S3Reader) ReadLine() (data string, err error) {
+	if !sr.started {
+		sr.Start()
+	}
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case line := <-sr.readChan:
+		sr.syncLock.Lock()
+		if line.done {
+			sr.state.Done[line.pos.Key] = line.pos.ETag
+			sr.state.Marker = compactS3Done(sr.state.Marker, sr.state.Done)
+			sr.state.Current = s3Position{}
+		} else {
+			sr.state.Current = line.pos
+		}
+		sr.source = "s3://" + sr.bucket.Name + "/" + line.pos.Key
+		sr.syncLock.Unlock()
+		return string(line.data), nil
+	case <-timer.C:
+	}
+	return "", nil
+}
+
+//SyncMeta 记录已经读取完成的对象的 marker、最近读取完成的对象以及正在读取的对象的位置
+func (sr *S3Reader) SyncMeta() {
+	sr.syncLock.Lock()
+	buf, err := jsoniter.Marshal(sr.state)
+	sr.syncLock.Unlock()
+	if err != nil {
+		log.Errorf("Runner[%v] %v sync meta error %v", sr.meta.RunnerName, sr.Name(), err)
+		return
+	}
+	if err = sr.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		log.Errorf("Runner[%v] %v sync meta WriteBuf error %v, buf %v", sr.meta.RunnerName, sr.Name(), err, string(buf))
+	}
+}
+
+func (sr *S3Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&sr.status, StatusRunning, StatusStopping) {
+		atomic.StoreInt32(&sr.status, StatusStopped)
+		sr.cancel()
+		return nil
+	}
+	log.Infof("Runner[%v] %v stopping", sr.meta.RunnerName, sr.Name())
+	sr.cancel()
+	sr.wg.Wait()
+	atomic.StoreInt32(&sr.status, StatusStopped)
+	return nil
+}
+
+// run 定期列出对象，依次读取新增的对象
+func (sr *S3Reader) run() {
+	defer sr.wg.Done()
+	first := true
+	for {
+		if err := sr.readObjects(first); err != nil {
+			log.Errorf("Runner[%v] %v read objects error %v", sr.meta.RunnerName, sr.Name(), err)
+			sr.setStatsError(err.Error())
+		} else {
+			first = false
+		}
+		select {
+		case <-sr.ctx.Done():
+			log.Warnf("Runner[%v] %v stopped from running", sr.meta.RunnerName, sr.Name())
+			return
+		case <-time.After(sr.listInterval):
+		}
+	}
+}
+
+// listObjects 列出前缀下 key 大于 marker 并且匹配 key 正则的全部对象，结果按 key 排序
+func (sr *S3Reader) listObjects(marker string) ([]s3.Key, error) {
+	var objects []s3.Key
+	for {
+		resp, err := sr.bucket.List(sr.prefix, "", marker, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range resp.Contents {
+			// 忽略目录
+			if strings.HasSuffix(key.Key, "/") {
+				continue
+			}
+			if sr.keyPattern != nil && !sr.keyPattern.MatchString(key.Key) {
+				continue
+			}
+			objects = append(objects, key)
+		}
+		if !resp.IsTruncated || len(resp.Contents) == 0 {
+			return objects, nil
+		}
+		marker = resp.NextMarker
+		if marker == "" {
+			marker = resp.Contents[len(resp.Contents)-1].Key
+		}
+	}
+}
+
+// readObjects 读取未读取过或者已经被覆盖的对象，首次列出且没有读取记录时，whence 为 newest 则跳过已经存在的对象
+func (sr *S3Reader) readObjects(first bool) error {
+	objects, err := sr.listObjects(sr.marker)
+	if err != nil {
+		return err
+	}
+	if first && sr.whence == WhenceNewest && sr.marker == "" && len(sr.done) == 0 && sr.current.Key == "" {
+		for _, object := range objects {
+			if !sr.send(s3Line{pos: s3Position{Key: object.Key, ETag: object.ETag}, done: true}) {
+				return nil
+			}
+			sr.finish(object)
+		}
+		return nil
+	}
+	// 优先读完上次未读完的对象，避免其位置被其他对象覆盖
+	for i, object := range objects {
+		if i > 0 && object.Key == sr.current.Key {
+			copy(objects[1:i+1], objects[:i])
+			objects[0] = object
+			break
+		}
+	}
+	exists := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		exists[object.Key] = true
+		if s3ObjectDone(sr.marker, sr.done, object) {
+			continue
+		}
+		err = sr.readObject(object)
+		if sr.ctx.Err() != nil {
+			return nil
+		}
+		// 一个对象读取失败时继续读取后面的对象，避免损坏的对象阻塞整个前缀
+		if err != nil {
+			sr.objectFailed(object, err)
+			continue
+		}
+		delete(sr.failures, object.Key)
+	}
+	for key := range sr.failures {
+		if !exists[key] {
+			delete(sr.failures, key)
+		}
+	}
+	// 已经删除的对象不再记录
+	for key := range sr.done {
+		if !exists[key] {
+			delete(sr.done, key)
+			sr.syncLock.Lock()
+			delete(sr.state.Done, key)
+			sr.syncLock.Unlock()
+		}
+	}
+	return nil
+}
+
+// readObject 从上次记录的位置开始逐行读取对象，读取完成后发送只包含位置的一行标记对象读取完成
+func (sr *S3Reader) readObject(object s3.Key) error {
+	var offset int64
+	if sr.current.Key == object.Key && sr.current.ETag == object.ETag {
+		offset = sr.current.Offset
+	} else if failure, ok := sr.failures[object.Key]; ok && failure.etag == object.ETag {
+		offset = failure.offset
+	}
+	body, err := sr.bucket.GetReader(object.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// 关闭时中断阻塞的读取
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-sr.ctx.Done():
+			body.Close()
+		case <-stop:
+		}
+	}()
+
+	br := bufio.NewReader(body)
+	var rd io.Reader = br
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		rd = gr
+	}
+	lr := bufio.NewReader(rd)
+	if offset > 0 {
+		if _, err = io.CopyN(ioutil.Discard, lr, offset); err != nil {
+			return fmt.Errorf("skip to offset %v error %v", offset, err)
+		}
+	}
+	log.Infof("Runner[%v] %v start reading %v from offset %v", sr.meta.RunnerName, sr.Name(), object.Key, offset)
+	for {
+		line, err := lr.ReadBytes('\n')
+		if len(line) > 0 {
+			offset += int64(len(line))
+			pos := s3Position{Key: object.Key, ETag: object.ETag, Offset: offset}
+			if !sr.send(s3Line{data: bytes.TrimRight(line, "\r\n"), pos: pos}) {
+				return nil
+			}
+			sr.current = pos
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if sr.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+	if !sr.send(s3Line{pos: s3Position{Key: object.Key, ETag: object.ETag}, done: true}) {
+		return nil
+	}
+	sr.finish(object)
+	sr.current = s3Position{}
+	return nil
+}
+
+// objectFailed 记录对象读取失败，连续 s3MaxObjectRetries 轮失败后跳过该对象，将其作为已经读取完成的对象记录
+func (sr *S3Reader) objectFailed(object s3.Key, err error) {
+	failure := sr.failures[object.Key]
+	if failure.etag != object.ETag {
+		failure = s3Failure{etag: object.ETag}
+	}
+	failure.count++
+	// 读取后面的对象会覆盖 current，记录失败前的位置，重试时从该位置继续读取
+	if sr.current.Key == object.Key && sr.current.ETag == object.ETag {
+		failure.offset = sr.current.Offset
+	}
+	sr.failures[object.Key] = failure
+	msg := fmt.Sprintf("Runner[%v] %v read object %v error %v, failed %v times", sr.meta.RunnerName, sr.Name(), object.Key, err, failure.count)
+	if failure.count < s3MaxObjectRetries {
+		log.Error(msg)
+		sr.setStatsError(msg)
+		return
+	}
+	msg += ", skip it"
+	log.Error(msg)
+	sr.setStatsError(msg)
+	if !sr.send(s3Line{pos: s3Position{Key: object.Key, ETag: object.ETag}, done: true}) {
+		return
+	}
+	delete(sr.failures, object.Key)
+	sr.finish(object)
+	if sr.current.Key == object.Key {
+		sr.current = s3Position{}
+	}
+}
+
+func (sr *S3Reader) finish(object s3.Key) {
+	sr.done[object.Key] = object.ETag
+	sr.marker = compactS3Done(sr.marker, sr.done)
+}
+
+// send 在 reader 关闭时返回 false
+func (sr *S3Reader) send(line s3Line) bool {
+	select {
+	case sr.readChan <- line:
+		return true
+	case <-sr.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,348 @@
+package reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/mitchellh/goamz/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 模拟 path 方式访问的 S3 接口，每次列举最多返回两个对象
+type fakeS3 struct {
+	mux     sync.Mutex
+	objects map[string][]byte
+	gets    []string
+	markers []string
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.objects[key] = data
+}
+
+func (f *fakeS3) getRequests() []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]string{}, f.gets...)
+}
+
+func (f *fakeS3) listMarkers() []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]string{}, f.markers...)
+}
+
+// verifyS3SignatureV4 使用请求中签名的 header 重新计算 AWS Signature Version 4 签名并比较
+func verifyS3SignatureV4(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=ak/") {
+		return false
+	}
+	signTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(auth, ", ") {
+		if !strings.HasPrefix(part, "SignedHeaders=") {
+			continue
+		}
+		for _, name := range strings.Split(strings.TrimPrefix(part, "SignedHeaders="), ";") {
+			if name != "host" {
+				req.Header.Set(name, r.Header.Get(name))
+			}
+		}
+	}
+	signer := v4.NewSigner(credentials.NewStaticCredentials("ak", "sk", ""), func(s *v4.Signer) {
+		s.DisableURIPathEscaping = true
+	})
+	if _, err = signer.Sign(req, nil, "s3", "us-east-1", signTime); err != nil {
+		return false
+	}
+	return req.Header.Get("Authorization") == auth
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if !verifyS3SignatureV4(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/bucket")
+	if path == "" || path == "/" {
+		query := r.URL.Query()
+		f.markers = append(f.markers, query.Get("marker"))
+		var keys []string
+		for key := range f.objects {
+			if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("marker") {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		resp := s3.ListResp{Name: "bucket"}
+		if len(keys) > 2 {
+			keys, resp.IsTruncated = keys[:2], true
+		}
+		for _, key := range keys {
+			sum := md5.Sum(f.objects[key])
+			resp.Contents = append(resp.Contents, s3.Key{Key: key, Size: int64(len(f.objects[key])), ETag: `"` + hex.EncodeToString(sum[:]) + `"`})
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"ListBucketResult"`
+			s3.ListResp
+		}{ListResp: resp})
+		return
+	}
+	key := strings.TrimPrefix(path, "/")
+	data, ok := f.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.gets = append(f.gets, key)
+	w.Write(data)
+}
+
+func TestS3Reader(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte("b1\nb2\n"))
+	gw.Close()
+	fake.put("logs/a.log", []byte("a1\r\na2\na3"))
+	fake.put("logs/b.log.gz", gz.Bytes())
+	fake.put("logs/c.txt", []byte("ignored\n"))
+	fake.put("other/d.log", []byte("ignored\n"))
+
+	metaDir := "TestS3Reader"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:       metaDir,
+		KeyFileDone:       metaDir,
+		KeyMode:           ModeS3,
+		KeyS3Endpoint:     srv.URL,
+		KeyS3AccessKey:    "ak",
+		KeyS3SecretKey:    "sk",
+		KeyS3Bucket:       "bucket",
+		KeyS3Prefix:       "logs/",
+		KeyS3KeyPattern:   `\.log(\.gz)?$`,
+		KeyS3ListInterval: "100ms",
+	}
+	readLines := func(r Reader, n int) []string {
+		var lines []string
+		for i := 0; i < 20 && len(lines) < n; i++ {
+			line, err := r.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewS3Reader(meta, readerConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1", "a2", "a3", "b1"}, readLines(r, 4))
+	assert.Equal(t, "s3://bucket/logs/b.log.gz", r.Source())
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	assert.Equal(t, []string{"logs/a.log", "logs/b.log.gz"}, fake.getRequests())
+
+	// 重启后先从未读完的对象记录的位置继续读取，跳过已经读取完成的对象，覆盖写入的对象重新读取
+	fake.put("logs/a.log", []byte("new a\n"))
+	fake.put("logs/e.log", []byte("e1\n"))
+	meta, err = NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err = NewS3Reader(meta, readerConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b2", "new a", "e1"}, readLines(r, 3))
+	assert.NoError(t, r.Close())
+
+	readerConf[KeyS3KeyPattern] = "("
+	_, err = NewS3Reader(meta, readerConf)
+	assert.Error(t, err)
+}
+
+func TestNewS3Region(t *testing.T) {
+	region, err := newS3Region("", "minio:9000/")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", region.Name)
+	assert.Equal(t, "https://minio:9000", region.S3Endpoint)
+	assert.Equal(t, "", region.S3BucketEndpoint)
+
+	region, err = newS3Region("cn-east-1", "http://${bucket}.s3-cn-east-1.qiniucs.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "cn-east-1", region.Name)
+	assert.Equal(t, "http://s3-cn-east-1.qiniucs.com", region.S3Endpoint)
+	assert.Equal(t, "http://${bucket}.s3-cn-east-1.qiniucs.com", region.S3BucketEndpoint)
+
+	region, err = newS3Region("us-west-2", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-west-2", region.Name)
+
+	_, err = newS3Region("", "")
+	assert.Error(t, err)
+}
+
+func TestS3ReaderRecentObjects(t *testing.T) {
+	defer func(n int) { s3RecentObjects = n }(s3RecentObjects)
+	s3RecentObjects = 2
+
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	for _, key := range []string{"logs/1.log", "logs/2.log", "logs/3.log", "logs/4.log"} {
+		fake.put(key, []byte(key+"\n"))
+	}
+
+	metaDir := "TestS3ReaderRecentObjects"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:       metaDir,
+		KeyFileDone:       metaDir,
+		KeyMode:           ModeS3,
+		KeyS3Endpoint:     srv.URL,
+		KeyS3AccessKey:    "ak",
+		KeyS3SecretKey:    "sk",
+		KeyS3Bucket:       "bucket",
+		KeyS3Prefix:       "logs/",
+		KeyS3ListInterval: "100ms",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewS3Reader(meta, readerConf)
+	require.NoError(t, err)
+	var lines []string
+	for i := 0; i < 20 && len(lines) < 4; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"logs/1.log", "logs/2.log", "logs/3.log", "logs/4.log"}, lines)
+	// 读取最后一个对象读取完成的标记
+	r.ReadLine()
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	sr := r.(*S3Reader)
+	assert.Equal(t, "logs/2.log", sr.state.Marker)
+	assert.Equal(t, map[string]string{"logs/3.log": sr.done["logs/3.log"], "logs/4.log": sr.done["logs/4.log"]}, sr.state.Done)
+
+	// 重启后从 marker 之后开始列出对象，最近读取完成的对象被覆盖时重新读取
+	listed := len(fake.listMarkers())
+	fake.put("logs/4.log", []byte("new 4\n"))
+	fake.put("logs/5.log", []byte("logs/5.log\n"))
+	meta, err = NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err = NewS3Reader(meta, readerConf)
+	require.NoError(t, err)
+	lines = nil
+	for i := 0; i < 20 && len(lines) < 2; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.NoError(t, r.Close())
+	assert.Equal(t, []string{"new 4", "logs/5.log"}, lines)
+	assert.Equal(t, "logs/2.log", fake.listMarkers()[listed])
+}
+
+func TestS3ReaderSkipFailedObject(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	// 损坏的 gzip 对象每次都读取失败，不能阻塞后面的对象
+	fake.put("logs/a.log", append(append([]byte{}, gzipMagic...), "broken"...))
+	fake.put("logs/b.log", []byte("b1\n"))
+
+	metaDir := "TestS3ReaderSkipFailedObject"
+	defer os.RemoveAll(metaDir)
+	readerConf := conf.MapConf{
+		KeyMetaPath:       metaDir,
+		KeyFileDone:       metaDir,
+		KeyMode:           ModeS3,
+		KeyS3Endpoint:     srv.URL,
+		KeyS3AccessKey:    "ak",
+		KeyS3SecretKey:    "sk",
+		KeyS3Bucket:       "bucket",
+		KeyS3Prefix:       "logs/",
+		KeyS3ListInterval: "100ms",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	require.NoError(t, err)
+	r, err := NewS3Reader(meta, readerConf)
+	require.NoError(t, err)
+	sr := r.(*S3Reader)
+	var lines []string
+	skipped := false
+	for i := 0; i < 50 && !skipped; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+		sr.syncLock.Lock()
+		_, skipped = sr.state.Done["logs/a.log"]
+		sr.syncLock.Unlock()
+	}
+	assert.NoError(t, r.Close())
+	assert.True(t, skipped)
+	assert.Equal(t, []string{"b1"}, lines)
+	var gets int
+	for _, key := range fake.getRequests() {
+		if key == "logs/a.log" {
+			gets++
+		}
+	}
+	assert.Equal(t, s3MaxObjectRetries, gets)
+	assert.NotEmpty(t, sr.Status().LastError)
+}
+
+func TestCompactS3Done(t *testing.T) {
+	defer func(n int) { s3RecentObjects = n }(s3RecentObjects)
+	s3RecentObjects = 2
+
+	done := map[string]string{"a": "1", "b": "2"}
+	assert.Equal(t, "", compactS3Done("", done))
+	assert.Len(t, done, 2)
+	done["c"] = "3"
+	done["d"] = "4"
+	assert.Equal(t, "b", compactS3Done("", done))
+	assert.Equal(t, map[string]string{"c": "3", "d": "4"}, done)
+
+	assert.True(t, s3ObjectDone("b", done, s3.Key{Key: "a", ETag: "x"}))
+	assert.True(t, s3ObjectDone("b", done, s3.Key{Key: "c", ETag: "3"}))
+	assert.False(t, s3ObjectDone("b", done, s3.Key{Key: "c", ETag: "x"}))
+	assert.False(t, s3ObjectDone("b", done, s3.Key{Key: "e", ETag: "5"}))
+}
@@ -1,7 +1,10 @@
 package reader
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
@@ -23,6 +26,7 @@ type ScriptReader struct {
 	Cron *cron.Cron //定时任务
 
 	readChan chan []byte
+	stopChan chan struct{} // Close 时关闭，避免发送数据的协程在无人读取时阻塞
 
 	meta *Meta
 
@@ -34,6 +38,14 @@ type ScriptReader struct {
 	loop         bool
 	loopDuration time.Duration
 
+	readMode    string
+	env         []string      // 追加到脚本执行环境中的环境变量，KEY=VALUE 形式
+	timeout     time.Duration // batch 模式下单次执行的超时时间，stream 模式下 stdout 无输出的超时时间
+	stderrField string        // stream 模式下 stderr 的输出字段，为空时 stderr 只记录日志
+	restarts    int64         // stream 模式下进程重启的次数
+	cmd         *exec.Cmd
+	cmdLock     sync.Mutex
+
 	stats     StatsInfo
 	statsLock sync.RWMutex
 }
@@ -53,18 +65,48 @@ func NewScriptReader(meta *Meta, conf conf.MapConf) (sr Reader, err error) {
 	cronSchedule, _ := conf.GetStringOr(KeyScriptCron, "")
 	execOnStart, _ := conf.GetBoolOr(KeyScriptExecOnStart, true)
 	scriptType, _ := conf.GetStringOr(KeyExecInterpreter, "bash")
+	readMode, _ := conf.GetStringOr(KeyScriptReadMode, ScriptReadModeBatch)
+	if readMode != ScriptReadModeBatch && readMode != ScriptReadModeStream {
+		return nil, fmt.Errorf("unsupported %v %v", KeyScriptReadMode, readMode)
+	}
+	env, _ := conf.GetStringListOr(KeyScriptEnv, []string{})
+	for _, e := range env {
+		if !strings.Contains(e, "=") {
+			return nil, fmt.Errorf("%v %v should be in KEY=VALUE format", KeyScriptEnv, e)
+		}
+	}
+	var timeout time.Duration
+	if timeoutStr, _ := conf.GetStringOr(KeyScriptTimeout, ""); timeoutStr != "" {
+		if timeout, err = time.ParseDuration(timeoutStr); err != nil {
+			return nil, fmt.Errorf("parse %v %v error %v", KeyScriptTimeout, timeoutStr, err)
+		}
+	}
+	stderrField, _ := conf.GetStringOr(KeyScriptStderrField, "")
+	var restarts int64
+	if readMode == ScriptReadModeStream {
+		key, offset, merr := meta.ReadOffset()
+		if merr == nil && key == scriptRestartsMetaKey {
+			restarts = offset
+		}
+	}
 	ssr := &ScriptReader{
 		originpath:  originPath,
 		realpath:    path,
 		scripttype:  scriptType,
 		Cron:        cron.New(),
 		readChan:    make(chan []byte),
+		stopChan:    make(chan struct{}),
 		meta:        meta,
 		status:      StatusInit,
 		mux:         sync.Mutex{},
 		started:     false,
 		execOnStart: execOnStart,
 		statsLock:   sync.RWMutex{},
+		readMode:    readMode,
+		env:         env,
+		timeout:     timeout,
+		stderrField: stderrField,
+		restarts:    restarts,
 	}
 
 	//schedule    string     //定时任务配置串
@@ -109,7 +151,9 @@ func (sr *ScriptReader) Start() {
 	if sr.started {
 		return
 	}
-	if sr.loop {
+	if sr.readMode == ScriptReadModeStream {
+		go sr.streamRun()
+	} else if sr.loop {
 		go sr.LoopRun()
 	} else {
 		sr.Cron.Start()
@@ -133,12 +177,22 @@ func (sr *ScriptReader) SetMode(mode string, v interface{}) error {
 	return errors.New("ScriptReader not support readmode")
 }
 
-func (sr *ScriptReader) SyncMeta() {}
+//SyncMeta stream 模式下记录进程重启的次数
+func (sr *ScriptReader) SyncMeta() {
+	if sr.readMode != ScriptReadModeStream {
+		return
+	}
+	if err := sr.meta.WriteOffset(scriptRestartsMetaKey, atomic.LoadInt64(&sr.restarts)); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", sr.meta.RunnerName, sr.Name(), err)
+	}
+}
 
 func (sr *ScriptReader) Close() (err error) {
 	sr.Cron.Stop()
 	if atomic.CompareAndSwapInt32(&sr.status, StatusRunning, StatusStopping) {
 		log.Infof("Runner[%v] %v stopping", sr.meta.RunnerName, sr.Name())
+		close(sr.stopChan)
+		sr.killCmd()
 	} else {
 		close(sr.readChan)
 	}
@@ -200,16 +254,62 @@ func (sr *ScriptReader) run() {
 func (sr *ScriptReader) exec() (err error) {
 	sr.mux.Lock()
 	defer sr.mux.Unlock()
-	command := exec.Command(sr.scripttype, sr.realpath) //初始化Cmd
+	command := sr.newCommand() //初始化Cmd
 
-	res, err := command.Output()
-	if err != nil {
+	var out, stderr bytes.Buffer
+	command.Stdout = &out
+	command.Stderr = &stderr
+	if err = command.Start(); err != nil {
 		return err
 	}
-	sr.readChan <- res
+	var timer *time.Timer
+	if sr.timeout > 0 {
+		timer = time.AfterFunc(sr.timeout, func() {
+			killProcess(command)
+		})
+	}
+	err = command.Wait()
+	if timer != nil && !timer.Stop() {
+		return fmt.Errorf("execute script timeout after %v%v", sr.timeout, stderrMessage(stderr.Bytes()))
+	}
+	if err != nil {
+		return fmt.Errorf("%v%v", err, stderrMessage(stderr.Bytes()))
+	}
+	sr.send(out.Bytes())
 	return nil
 }
 
+// scriptMaxStderrInError 是错误信息中保留的 stderr 的最大长度，超过时保留末尾部分
+const scriptMaxStderrInError = 1024
+
+func stderrMessage(stderr []byte) string {
+	stderr = bytes.TrimSpace(stderr)
+	if len(stderr) == 0 {
+		return ""
+	}
+	if len(stderr) > scriptMaxStderrInError {
+		stderr = append([]byte("..."), stderr[len(stderr)-scriptMaxStderrInError:]...)
+	}
+	return ", stderr: " + string(stderr)
+}
+
+// send 发送数据，reader 被关闭时放弃发送
+func (sr *ScriptReader) send(data []byte) {
+	select {
+	case sr.readChan <- data:
+	case <-sr.stopChan:
+	}
+}
+
+func (sr *ScriptReader) newCommand() *exec.Cmd {
+	command := exec.Command(sr.scripttype, sr.realpath)
+	if len(sr.env) > 0 {
+		command.Env = append(os.Environ(), sr.env...)
+	}
+	setProcessGroup(command)
+	return command
+}
+
 func (sr *ScriptReader) setStatsError(err string) {
 	sr.statsLock.Lock()
 	defer sr.statsLock.Unlock()
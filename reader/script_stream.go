@@ -0,0 +1,169 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/qiniu/log"
+)
+
+// 脚本的读取方式，batch 按定时任务执行并一次性读取全部输出，stream 持续运行脚本并按行读取输出
+const (
+	ScriptReadModeBatch  = "batch"
+	ScriptReadModeStream = "stream"
+)
+
+// scriptRestartsMetaKey 是 stream 模式下 meta 中记录重启次数的 key
+const scriptRestartsMetaKey = "restarts"
+
+// stream 模式下进程退出后重启的等待时间，每次翻倍直到上限，进程持续运行超过上限后重新计算
+const (
+	scriptMinBackoff = time.Second
+	scriptMaxBackoff = time.Minute
+)
+
+// streamRun 持续运行脚本，进程退出后按退避时间重启，直到 reader 被关闭
+func (sr *ScriptReader) streamRun() {
+	if !atomic.CompareAndSwapInt32(&sr.status, StatusInit, StatusRunning) {
+		return
+	}
+	defer func() {
+		atomic.CompareAndSwapInt32(&sr.status, StatusRunning, StatusInit)
+		if atomic.CompareAndSwapInt32(&sr.status, StatusStopping, StatusStopped) {
+			close(sr.readChan)
+		}
+	}()
+
+	backoff := scriptMinBackoff
+	for {
+		if atomic.LoadInt32(&sr.status) == StatusStopping {
+			log.Warnf("Runner[%v] %v stopped from running", sr.meta.RunnerName, sr.Name())
+			return
+		}
+		begin := time.Now()
+		err := sr.execStream()
+		if atomic.LoadInt32(&sr.status) == StatusStopping {
+			log.Warnf("Runner[%v] %v stopped from running", sr.meta.RunnerName, sr.Name())
+			return
+		}
+		if time.Since(begin) > scriptMaxBackoff {
+			backoff = scriptMinBackoff
+		}
+		if err != nil {
+			log.Errorf("Runner[%v] %v script exited with error [%v], restart after %v", sr.meta.RunnerName, sr.Name(), err, backoff)
+			sr.setStatsError(err.Error())
+		} else {
+			log.Warnf("Runner[%v] %v script exited, restart after %v", sr.meta.RunnerName, sr.Name(), backoff)
+		}
+		for waited := time.Duration(0); waited < backoff; waited += 100 * time.Millisecond {
+			if atomic.LoadInt32(&sr.status) == StatusStopping {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if backoff *= 2; backoff > scriptMaxBackoff {
+			backoff = scriptMaxBackoff
+		}
+		atomic.AddInt64(&sr.restarts, 1)
+	}
+}
+
+// execStream 启动脚本并按行发送 stdout，直到进程退出或被结束
+func (sr *ScriptReader) execStream() error {
+	command := sr.newCommand()
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return err
+	}
+	sr.cmdLock.Lock()
+	if atomic.LoadInt32(&sr.status) == StatusStopping {
+		sr.cmdLock.Unlock()
+		return nil
+	}
+	if err = command.Start(); err != nil {
+		sr.cmdLock.Unlock()
+		return err
+	}
+	sr.cmd = command
+	sr.cmdLock.Unlock()
+	defer func() {
+		sr.cmdLock.Lock()
+		sr.cmd = nil
+		sr.cmdLock.Unlock()
+	}()
+
+	var idle *time.Timer
+	if sr.timeout > 0 {
+		idle = time.AfterFunc(sr.timeout, func() {
+			log.Warnf("Runner[%v] %v no output in %v, kill script", sr.meta.RunnerName, sr.Name(), sr.timeout)
+			killProcess(command)
+		})
+		defer idle.Stop()
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sr.readStderr(stderr)
+	}()
+	sr.readLines(stdout, func(line []byte) {
+		if idle != nil {
+			idle.Reset(sr.timeout)
+		}
+		sr.send(line)
+	})
+	wg.Wait()
+	return command.Wait()
+}
+
+// readStderr 在配置了 stderrField 时将 stderr 的每一行作为 {stderrField: line} 的 json 输出，否则只记录日志
+func (sr *ScriptReader) readStderr(r io.Reader) {
+	sr.readLines(r, func(line []byte) {
+		if sr.stderrField == "" {
+			log.Warnf("Runner[%v] %v stderr: %s", sr.meta.RunnerName, sr.Name(), line)
+			return
+		}
+		data, err := jsoniter.Marshal(map[string]string{sr.stderrField: string(line)})
+		if err != nil {
+			log.Errorf("Runner[%v] %v marshal stderr error %v", sr.meta.RunnerName, sr.Name(), err)
+			return
+		}
+		sr.send(data)
+	})
+}
+
+// readLines 按行读取直到 EOF，空行会被忽略
+func (sr *ScriptReader) readLines(r io.Reader, send func([]byte)) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			send(line)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("Runner[%v] %v read script output error %v", sr.meta.RunnerName, sr.Name(), err)
+			}
+			return
+		}
+	}
+}
+
+func (sr *ScriptReader) killCmd() {
+	sr.cmdLock.Lock()
+	defer sr.cmdLock.Unlock()
+	if sr.cmd != nil {
+		if err := killProcess(sr.cmd); err != nil {
+			log.Errorf("Runner[%v] %v kill script error %v", sr.meta.RunnerName, sr.Name(), err)
+		}
+	}
+}
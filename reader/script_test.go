@@ -2,9 +2,13 @@ package reader
 
 import (
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -38,3 +42,144 @@ func Test_scriptFile(t *testing.T) {
 	}
 	assert.Equal(t, "hello world\n", data)
 }
+
+func TestScriptStreamReader(t *testing.T) {
+	dir := "TestScriptStreamReader"
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	fileName, _ := filepath.Abs(filepath.Join(dir, "stream.sh"))
+	createTestFile(fileName, "echo \"out $LOGKIT_TEST\"\necho \"err line\" >&2\nsleep 0.2\nexit 1\n")
+
+	readerConf := conf.MapConf{
+		KeyMetaPath:          filepath.Join(dir, "meta"),
+		KeyFileDone:          filepath.Join(dir, "meta"),
+		KeyMode:              ModeScript,
+		KeyRunnerName:        "TestScriptStreamReader",
+		KeyExecInterpreter:   "bash",
+		KeyLogPath:           fileName,
+		KeyScriptReadMode:    ScriptReadModeStream,
+		KeyScriptEnv:         "LOGKIT_TEST=hello",
+		KeyScriptStderrField: "stderr",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	assert.NoError(t, err)
+	sr, err := NewScriptReader(meta, readerConf)
+	assert.NoError(t, err)
+
+	// 第一次运行的输出以及退出后重启的输出
+	got := map[string]int{}
+	for i := 0; i < 10 && got["out hello"] < 2; i++ {
+		line, err := sr.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			got[line]++
+		}
+	}
+	assert.Equal(t, 2, got["out hello"])
+	assert.True(t, got[`{"stderr":"err line"}`] >= 1)
+	sr.SyncMeta()
+	key, restarts, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, scriptRestartsMetaKey, key)
+	assert.True(t, restarts >= 1)
+	assert.NoError(t, sr.Close())
+
+	// 重启 logkit 后重启次数继续累加
+	sr, err = NewScriptReader(meta, readerConf)
+	assert.NoError(t, err)
+	assert.Equal(t, restarts, sr.(*ScriptReader).restarts)
+
+	_, err = NewScriptReader(meta, conf.MapConf{KeyLogPath: fileName, KeyScriptEnv: "LOGKIT_TEST"})
+	assert.Error(t, err)
+}
+
+func TestScriptStreamTimeout(t *testing.T) {
+	dir := "TestScriptStreamTimeout"
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	fileName, _ := filepath.Abs(filepath.Join(dir, "stream.sh"))
+	createTestFile(fileName, "echo start\nsleep 100 | cat\n")
+
+	readerConf := conf.MapConf{
+		KeyMetaPath:        filepath.Join(dir, "meta"),
+		KeyFileDone:        filepath.Join(dir, "meta"),
+		KeyExecInterpreter: "bash",
+		KeyLogPath:         fileName,
+		KeyScriptReadMode:  ScriptReadModeStream,
+		KeyScriptTimeout:   "300ms",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	assert.NoError(t, err)
+	r, err := NewScriptReader(meta, readerConf)
+	assert.NoError(t, err)
+	sr := r.(*ScriptReader)
+
+	// 没有输出超过 timeout 后结束整个进程组并重启
+	var starts int
+	for i := 0; i < 10 && starts < 2; i++ {
+		line, err := sr.ReadLine()
+		assert.NoError(t, err)
+		if line == "start" {
+			starts++
+		}
+	}
+	assert.Equal(t, 2, starts)
+
+	// Close 会结束正在运行的脚本
+	assert.NoError(t, sr.Close())
+	closed := make(chan struct{})
+	go func() {
+		for range sr.readChan {
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("script reader not closed")
+	}
+
+	// batch 模式下执行超时返回错误
+	sr = &ScriptReader{scripttype: "bash", realpath: fileName, timeout: 300 * time.Millisecond, readChan: make(chan []byte)}
+	assert.Error(t, sr.exec())
+
+	// batch 模式下执行失败时错误中带上 stderr
+	createTestFile(fileName, "echo \"bad argument\" >&2\nexit 3\n")
+	sr = &ScriptReader{scripttype: "bash", realpath: fileName, readChan: make(chan []byte)}
+	err = sr.exec()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 3, stderr: bad argument")
+}
+
+func TestScriptStreamCloseWithoutRead(t *testing.T) {
+	dir := "TestScriptStreamCloseWithoutRead"
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	fileName, _ := filepath.Abs(filepath.Join(dir, "stream.sh"))
+	createTestFile(fileName, "while true; do echo line; echo err >&2; sleep 0.01; done\n")
+
+	readerConf := conf.MapConf{
+		KeyMetaPath:          filepath.Join(dir, "meta"),
+		KeyFileDone:          filepath.Join(dir, "meta"),
+		KeyExecInterpreter:   "bash",
+		KeyLogPath:           fileName,
+		KeyScriptReadMode:    ScriptReadModeStream,
+		KeyScriptStderrField: "stderr",
+	}
+	meta, err := NewMetaWithConf(readerConf)
+	assert.NoError(t, err)
+	r, err := NewScriptReader(meta, readerConf)
+	assert.NoError(t, err)
+	sr := r.(*ScriptReader)
+	line, err := sr.ReadLine()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, line)
+
+	// 关闭后不再读取，发送数据的协程不能阻塞，readChan 最终被关闭
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, sr.Close())
+	for i := 0; i < 50 && atomic.LoadInt32(&sr.status) != StatusStopped; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, StatusStopped, atomic.LoadInt32(&sr.status))
+}
@@ -0,0 +1,20 @@
+// +build !windows
+
+package reader
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 让脚本运行在单独的进程组中，结束时连同脚本启动的子进程一起结束
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcess(command *exec.Cmd) error {
+	if command.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+}
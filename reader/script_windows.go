@@ -0,0 +1,14 @@
+// +build windows
+
+package reader
+
+import "os/exec"
+
+func setProcessGroup(command *exec.Cmd) {}
+
+func killProcess(command *exec.Cmd) error {
+	if command.Process == nil {
+		return nil
+	}
+	return command.Process.Kill()
+}
@@ -1,6 +1,7 @@
 package reader
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -12,7 +13,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/qiniu/logkit/rateio"
 	. "github.com/qiniu/logkit/utils/models"
 	utilsos "github.com/qiniu/logkit/utils/os"
 
@@ -48,6 +48,11 @@ type SeqFile struct {
 
 	lastSyncPath   string
 	lastSyncOffset int64
+
+	// logrotate 压缩已经读完的文件后，压缩文件的修改时间可能比正在读取的文件新，
+	// 通过比较解压后开头的内容识别这类文件，避免重复读取
+	readHeads         [][]byte               // 已经读完的文件开头的内容
+	skippedCompressed map[string]os.FileInfo // 已经识别为读过的压缩文件
 }
 
 func getStartFile(path, whence string, meta *Meta, sf *SeqFile) (f *os.File, dir, currFile string, offset int64, err error) {
@@ -68,6 +73,9 @@ func getStartFile(path, whence string, meta *Meta, sf *SeqFile) (f *os.File, dir
 			currFile, offset, err = oldestFile(dir, sf.getIgnoreCondition())
 		case WhenceNewest:
 			currFile, offset, err = newestFile(dir, sf.getIgnoreCondition())
+			if err == nil && meta.readCompressed && compressedSuffix(currFile) != "" {
+				offset = compressedFileEnd
+			}
 		default:
 			err = errors.New("reader_whence paramter does not support: " + whence)
 			return
@@ -97,11 +105,12 @@ func getStartFile(path, whence string, meta *Meta, sf *SeqFile) (f *os.File, dir
 
 func NewSeqFile(meta *Meta, path string, ignoreHidden, newFileNewLine bool, suffixes []string, validFileRegex, whence string) (sf *SeqFile, err error) {
 	sf = &SeqFile{
-		ignoreFileSuffix: suffixes,
-		ignoreHidden:     ignoreHidden,
-		validFilePattern: validFileRegex,
-		mux:              sync.Mutex{},
-		newFileAsNewLine: newFileNewLine,
+		ignoreFileSuffix:  suffixes,
+		ignoreHidden:      ignoreHidden,
+		validFilePattern:  validFileRegex,
+		mux:               sync.Mutex{},
+		newFileAsNewLine:  newFileNewLine,
+		skippedCompressed: make(map[string]os.FileInfo),
 	}
 	//原来的for循环替换成单次执行，启动的时候出错就直接报错给用户即可，不需要等待重试。
 	f, dir, currFile, offset, err := getStartFile(path, whence, meta, sf)
@@ -109,7 +118,7 @@ func NewSeqFile(meta *Meta, path string, ignoreHidden, newFileNewLine bool, suff
 		return
 	}
 	if f != nil {
-		_, err = f.Seek(offset, io.SeekStart)
+		sf.ratereader, err = newFileRateReader(meta, f, offset)
 		if err != nil {
 			f.Close()
 			return nil, err
@@ -119,7 +128,6 @@ func NewSeqFile(meta *Meta, path string, ignoreHidden, newFileNewLine bool, suff
 			return nil, err
 		}
 		sf.f = f
-		sf.ratereader = rateio.NewRateReader(f, meta.readlimit)
 		sf.offset = offset
 	} else {
 		sf.inode = 0
@@ -214,7 +222,7 @@ func (sf *SeqFile) reopenForESTALE() error {
 		return fmt.Errorf("%s -cannot reopen currfile file for ESTALE err:%v", sf.currFile, err)
 	}
 
-	_, err = f.Seek(sf.offset, io.SeekStart)
+	ratereader, err := newFileRateReader(sf.meta, f, sf.offset)
 	if err != nil {
 		f.Close()
 		return err
@@ -224,7 +232,7 @@ func (sf *SeqFile) reopenForESTALE() error {
 	if sf.ratereader != nil {
 		sf.ratereader.Close()
 	}
-	sf.ratereader = rateio.NewRateReader(f, sf.meta.readlimit)
+	sf.ratereader = ratereader
 	ninode, err := utilsos.GetIdentifyIDByFile(f)
 	if err != nil {
 		//为了不影响程序运行
@@ -326,6 +334,7 @@ func (sf *SeqFile) nextFile() (fi os.FileInfo, err error) {
 		}
 		condition = andCondition(newerThanCurrFile, sf.getIgnoreCondition())
 	}
+	condition = andCondition(condition, notCondition(sf.isReadCompressed))
 	fi, err = getMinFile(sf.dir, condition, modTimeLater)
 	if err != nil {
 		log.Debugf("Runner[%v] getMinFile error %v", sf.meta.RunnerName, err)
@@ -339,6 +348,55 @@ func (sf *SeqFile) nextFile() (fi os.FileInfo, err error) {
 	return nil, nil
 }
 
+// isReadCompressed 判断压缩文件是否由已经读过的文件压缩而来
+func (sf *SeqFile) isReadCompressed(fi os.FileInfo) bool {
+	if !sf.meta.readCompressed {
+		return false
+	}
+	path := filepath.Join(sf.dir, fi.Name())
+	suffix := compressedSuffix(path)
+	if suffix == "" {
+		return false
+	}
+	if prev, ok := sf.skippedCompressed[fi.Name()]; ok && prev.Size() == fi.Size() && prev.ModTime().Equal(fi.ModTime()) {
+		return true
+	}
+	heads := sf.readHeads
+	if head := sf.currentHead(); len(head) > 0 {
+		heads = append(heads[:len(heads):len(heads)], head)
+	}
+	if len(heads) == 0 {
+		return false
+	}
+	head, err := compressedFileHead(path, suffix)
+	if err != nil || len(head) == 0 {
+		return false
+	}
+	for _, h := range heads {
+		if bytes.Equal(h, head) {
+			if len(sf.skippedCompressed) >= maxReadFileHeads {
+				sf.skippedCompressed = make(map[string]os.FileInfo)
+			}
+			sf.skippedCompressed[fi.Name()] = fi
+			log.Infof("Runner[%v] %s - %s is compressed from a file already read, skip it", sf.meta.RunnerName, sf.dir, fi.Name())
+			return true
+		}
+	}
+	return false
+}
+
+// currentHead 返回当前读取的非压缩文件开头的内容
+func (sf *SeqFile) currentHead() []byte {
+	if sf.f == nil || compressedSuffix(sf.currFile) != "" {
+		return nil
+	}
+	head, err := readHead(io.NewSectionReader(sf.f, 0, fileHeadSize))
+	if err != nil {
+		return nil
+	}
+	return head
+}
+
 func (sf *SeqFile) isNewFile(newFileInfo os.FileInfo, filePath string) bool {
 	if newFileInfo == nil {
 		return false
@@ -390,10 +448,15 @@ func (sf *SeqFile) newOpen() (err error) {
 	if err != nil {
 		return fmt.Errorf("os.Open %s: %v", fname, err)
 	}
+	ratereader, err := newFileRateReader(sf.meta, f, 0)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open reader of %s: %v", fname, err)
+	}
 	if sf.ratereader != nil {
 		sf.ratereader.Close()
 	}
-	sf.ratereader = rateio.NewRateReader(f, sf.meta.readlimit)
+	sf.ratereader = ratereader
 	sf.f = f
 	sf.offset = 0
 	sf.inode, err = utilsos.GetIdentifyIDByPath(sf.currFile)
@@ -407,6 +470,12 @@ func (sf *SeqFile) open(fi os.FileInfo) (err error) {
 	if fi == nil {
 		return
 	}
+	if head := sf.currentHead(); sf.meta.readCompressed && len(head) > 0 {
+		if len(sf.readHeads) >= maxReadFileHeads {
+			sf.readHeads = sf.readHeads[1:]
+		}
+		sf.readHeads = append(sf.readHeads, head)
+	}
 	err = sf.f.Close()
 	if err != nil && err != syscall.EINVAL {
 		log.Warnf("Runner[%v] %s - %s f.Close: %v", sf.meta.RunnerName, sf.dir, sf.currFile)
@@ -427,12 +496,18 @@ func (sf *SeqFile) open(fi os.FileInfo) (err error) {
 			log.Warnf("Runner[%v] os.Open %s: %v", sf.meta.RunnerName, fname, err)
 			return err
 		}
+		ratereader, err := newFileRateReader(sf.meta, f, 0)
+		if err != nil {
+			f.Close()
+			log.Warnf("Runner[%v] open reader of %s: %v", sf.meta.RunnerName, fname, err)
+			return err
+		}
 		sf.f = f
 		//开新的之前关掉老的
 		if sf.ratereader != nil {
 			sf.ratereader.Close()
 		}
-		sf.ratereader = rateio.NewRateReader(f, sf.meta.readlimit)
+		sf.ratereader = ratereader
 		sf.offset = 0
 		sf.inode, err = utilsos.GetIdentifyIDByPath(sf.currFile)
 		if err != nil {
@@ -473,7 +548,7 @@ func (sf *SeqFile) SyncMeta() (err error) {
 
 func (sf *SeqFile) Lag() (rl *LagInfo, err error) {
 	sf.mux.Lock()
-	rl = &LagInfo{Size: -sf.offset}
+	rl = &LagInfo{Size: -readPosition(sf.meta, sf.f, sf.offset)}
 	logReading := filepath.Base(sf.currFile)
 	sf.mux.Unlock()
 
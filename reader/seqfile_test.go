@@ -2,14 +2,18 @@ package reader
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/qiniu/logkit/utils/models"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -294,3 +298,125 @@ func Test_SeekUnreachable(t *testing.T) {
 	st, err := f.Stat()
 	fmt.Println(st.Size())
 }
+
+func Test_ReadCompressed(t *testing.T) {
+	logdir := "./readcompressed_logdir"
+	metadir := "./readcompressed_meta"
+	os.MkdirAll(logdir, DefaultDirPerm)
+	defer os.RemoveAll(logdir)
+	defer os.RemoveAll(metadir)
+
+	now := time.Now()
+	gzBuf := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzBuf)
+	gw.Write([]byte("gz1\ngz2\n"))
+	gw.Close()
+	zstBuf := new(bytes.Buffer)
+	zw, err := zstd.NewWriter(zstBuf)
+	assert.NoError(t, err)
+	zw.Write([]byte("zst1\nzst2\n"))
+	zw.Close()
+	logs := []struct {
+		name    string
+		content []byte
+	}{
+		{"app.log.2.gz", gzBuf.Bytes()},
+		{"app.log.1.zst", zstBuf.Bytes()},
+		{"app.log", []byte("plain1\n")},
+	}
+	for i, l := range logs {
+		path := filepath.Join(logdir, l.name)
+		assert.NoError(t, ioutil.WriteFile(path, l.content, DefaultFilePerm))
+		mtime := now.Add(time.Duration(i-len(logs)) * time.Minute)
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	meta, err := NewMeta(metadir, metadir, logdir, ModeDir, "", defautFileRetention)
+	assert.NoError(t, err)
+	meta.readCompressed = true
+	sf, err := NewSeqFile(meta, logdir, true, false, defaultIgnoreFileSuffix, "*", WhenceOldest)
+	assert.NoError(t, err)
+	buf := make([]byte, 4)
+	n, err := sf.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "gz1\n", string(buf[:n]))
+	assert.NoError(t, sf.SyncMeta())
+	sf.Close()
+
+	// 重启后从解压后的 offset 继续读取
+	sf, err = NewSeqFile(meta, logdir, true, false, defaultIgnoreFileSuffix, "*", WhenceOldest)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, sf.offset)
+	buf = make([]byte, 1024)
+	var got []byte
+	exp := "gz2\nzst1\nzst2\nplain1\n"
+	for i := 0; i < 5 && len(got) < len(exp); i++ {
+		n, _ = sf.Read(buf[:len(exp)-len(got)])
+		got = append(got, buf[:n]...)
+	}
+	assert.Equal(t, exp, string(got))
+	sf.Close()
+}
+
+func Test_ReadCompressedRotated(t *testing.T) {
+	logdir := "./readcompressed_rotated_logdir"
+	metadir := "./readcompressed_rotated_meta"
+	os.MkdirAll(logdir, DefaultDirPerm)
+	defer os.RemoveAll(logdir)
+	defer os.RemoveAll(metadir)
+
+	now := time.Now()
+	writeLog := func(name string, content []byte, mtime time.Time) {
+		path := filepath.Join(logdir, name)
+		assert.NoError(t, ioutil.WriteFile(path, content, DefaultFilePerm))
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	gzipped := func(content string) []byte {
+		buf := new(bytes.Buffer)
+		gw := gzip.NewWriter(buf)
+		gw.Write([]byte(content))
+		gw.Close()
+		return buf.Bytes()
+	}
+	readAll := func(sf *SeqFile, exp string) string {
+		buf := make([]byte, 1024)
+		var got []byte
+		for i := 0; i < 5 && len(got) < len(exp); i++ {
+			n, _ := sf.Read(buf[:len(exp)-len(got)])
+			got = append(got, buf[:n]...)
+		}
+		return string(got)
+	}
+
+	writeLog("app.log.1", []byte("a1\na2\n"), now.Add(-3*time.Minute))
+	writeLog("app.log", []byte("b1\n"), now.Add(-2*time.Minute))
+	meta, err := NewMeta(metadir, metadir, logdir, ModeDir, "", defautFileRetention)
+	assert.NoError(t, err)
+	meta.readCompressed = true
+	sf, err := NewSeqFile(meta, logdir, true, false, defaultIgnoreFileSuffix, "*", WhenceOldest)
+	assert.NoError(t, err)
+	assert.Equal(t, "a1\na2\nb1\n", readAll(sf, "a1\na2\nb1\n"))
+
+	// logrotate 压缩 app.log.1 后生成的 app.log.2.gz 修改时间最新，内容已经读过，需要跳过
+	writeLog("app.log.2.gz", gzipped("a1\na2\n"), now.Add(-time.Minute))
+	assert.NoError(t, os.Remove(filepath.Join(logdir, "app.log.1")))
+	writeLog("app.log.0", []byte("c1\n"), now)
+	assert.Equal(t, "c1\n", readAll(sf, "c1\n"))
+	sf.Close()
+
+	// 最新的文件是压缩文件时从该文件末尾开始，不需要解压，Lag 按压缩后的大小计算
+	assert.NoError(t, os.RemoveAll(metadir))
+	writeLog("app.log.3.gz", gzipped("d1\nd2\n"), now.Add(time.Minute))
+	meta, err = NewMeta(metadir, metadir, logdir, ModeDir, "", defautFileRetention)
+	assert.NoError(t, err)
+	meta.readCompressed = true
+	sf, err = NewSeqFile(meta, logdir, true, false, defaultIgnoreFileSuffix, "*", WhenceNewest)
+	assert.NoError(t, err)
+	assert.Equal(t, compressedFileEnd, sf.offset)
+	lag, err := sf.Lag()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, lag.Size)
+	writeLog("app.log.4", []byte("e1\n"), now.Add(2*time.Minute))
+	assert.Equal(t, "e1\n", readAll(sf, "e1\n"))
+	sf.Close()
+}
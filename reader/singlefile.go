@@ -12,7 +12,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/qiniu/logkit/rateio"
 	. "github.com/qiniu/logkit/utils/models"
 	utilsos "github.com/qiniu/logkit/utils/os"
 
@@ -91,7 +90,6 @@ func NewSingleFile(meta *Meta, path, whence string, errDirectReturn bool) (sf *S
 		originpath: originpath,
 		pfi:        pfi,
 		f:          f,
-		ratereader: rateio.NewRateReader(f, meta.readlimit),
 		mux:        sync.Mutex{},
 	}
 
@@ -109,11 +107,11 @@ func NewSingleFile(meta *Meta, path, whence string, errDirectReturn bool) (sf *S
 	if err != nil {
 		return nil, err
 	}
-	//遇到Offset超过最大的文件了,重新来过
-	if sf.offset > st.Size() {
+	//遇到Offset超过最大的文件了,重新来过，压缩文件的offset是解压后的位置，不做比较
+	if sf.offset > st.Size() && !(meta.readCompressed && compressedSuffix(path) != "") {
 		sf.offset = 0
 	}
-	_, err = f.Seek(sf.offset, io.SeekStart)
+	sf.ratereader, err = newFileRateReader(meta, f, sf.offset)
 	if err != nil {
 		return nil, err
 	}
@@ -173,6 +171,9 @@ func (sf *SingleFile) startOffset(whence string) (int64, error) {
 	case WhenceOldest:
 		return 0, nil
 	case WhenceNewest:
+		if sf.meta.readCompressed && compressedSuffix(sf.realpath) != "" {
+			return compressedFileEnd, nil
+		}
 		return sf.f.Seek(0, io.SeekEnd)
 	default:
 		return 0, errors.New("whence not supported " + whence)
@@ -247,12 +248,17 @@ func (sf *SingleFile) Reopen() (err error) {
 	if err != nil {
 		return
 	}
+	ratereader, err := newFileRateReader(sf.meta, f, 0)
+	if err != nil {
+		f.Close()
+		return
+	}
 	sf.pfi = pfi
 	sf.f = f
 	if sf.ratereader != nil {
 		sf.ratereader.Close()
 	}
-	sf.ratereader = rateio.NewRateReader(f, sf.meta.readlimit)
+	sf.ratereader = ratereader
 	sf.offset = 0
 	return
 }
@@ -267,7 +273,7 @@ func (sf *SingleFile) reopenForESTALE() (err error) {
 		f.Close()
 		return
 	}
-	_, err = f.Seek(sf.offset, io.SeekStart)
+	ratereader, err := newFileRateReader(sf.meta, f, sf.offset)
 	if err != nil {
 		f.Close()
 		return
@@ -278,7 +284,7 @@ func (sf *SingleFile) reopenForESTALE() (err error) {
 	if sf.ratereader != nil {
 		sf.ratereader.Close()
 	}
-	sf.ratereader = rateio.NewRateReader(f, sf.meta.readlimit)
+	sf.ratereader = ratereader
 	return
 }
 
@@ -329,7 +335,7 @@ func (sf *SingleFile) SyncMeta() error {
 
 func (sf *SingleFile) Lag() (rl *LagInfo, err error) {
 	sf.mux.Lock()
-	rl = &LagInfo{Size: -sf.offset}
+	rl = &LagInfo{Size: -readPosition(sf.meta, sf.f, sf.offset)}
 	sf.mux.Unlock()
 
 	fi, err := os.Stat(sf.originpath)
@@ -2,11 +2,14 @@ package reader
 
 import (
 	"bufio"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,6 +17,7 @@ import (
 
 	"github.com/qiniu/log"
 	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/utils/tlsconfig"
 )
 
 const (
@@ -51,8 +55,53 @@ const (
 	// 0 表示关闭keep_alive
 	// 默认5分钟
 	KeySocketKeepAlivePeriod = "socket_keep_alive_period"
+
+	// TLS 的证书与私钥，均配置后 stream sockets 使用 TLS 加密(如 RFC 5425 的 syslog over TLS)
+	// socket_tls_cert = "/etc/logkit/server.crt"
+	// socket_tls_key = "/etc/logkit/server.key"
+	KeySocketTLSCert = "socket_tls_cert"
+	KeySocketTLSKey  = "socket_tls_key"
+
+	// 校验客户端证书的 CA 文件，配置后默认要求客户端提供证书(mutual TLS)
+	// socket_tls_ca = "/etc/logkit/ca.crt"
+	KeySocketTLSCA = "socket_tls_ca"
+
+	// 客户端证书的校验方式，可选 none/request/require/verify_if_given/require_and_verify
+	// 通过校验的客户端证书身份(CommonName)会作为 datasource_tag 的值
+	KeySocketTLSClientAuth = "socket_tls_client_auth"
+
+	// stream sockets 的分帧方式，默认按换行符分割
+	// newline: 按换行符分割
+	// octet_counting: RFC 6587 的 "长度 空格 消息" 格式，不以数字开头的帧回退为按换行符分割
+	// length_prefixed: 4 字节大端序长度加消息体的二进制格式
+	// socket_framing = "octet_counting"
+	KeySocketFraming = "socket_framing"
+
+	// 开启后每行数据的来源为 "远端地址#连接ID"，可以配合 datasource_tag 使用
+	// 开启 mutual TLS 时为 "客户端证书身份@远端地址#连接ID"
+	// socket_source_meta = true
+	KeySocketSourceMeta = "socket_source_meta"
+)
+
+// socket_framing 的可选项
+const (
+	SocketFramingNewline        = "newline"
+	SocketFramingOctetCounting  = "octet_counting"
+	SocketFramingLengthPrefixed = "length_prefixed"
 )
 
+// tls 握手的超时时间，socket_read_timeout 未设置时使用
+const socketTLSHandshakeTimeout = 10 * time.Second
+
+// 单个帧的最大长度
+const socketMaxFrameSize = 1024 * 1024
+
+// socketLine 是读取到的一行数据及其来源
+type socketLine struct {
+	data   string
+	source string
+}
+
 type setReadBufferer interface {
 	SetReadBuffer(bytes int) error
 }
@@ -88,14 +137,17 @@ func (ssr *streamSocketReader) listen() {
 			c.Close()
 			continue
 		}
-		ssr.connections[c.RemoteAddr().String()] = c
-		ssr.connectionsMtx.Unlock()
 
 		if ssr.netproto == "tcp" || ssr.netproto == "tcp4" || ssr.netproto == "tcp6" {
 			if err := ssr.setKeepAlive(c); err != nil {
 				log.Error(fmt.Errorf("unable to configure keep alive (%s): %s", ssr.ServiceAddress, err))
 			}
 		}
+		if ssr.tlsConfig != nil {
+			c = tls.Server(c, ssr.tlsConfig)
+		}
+		ssr.connections[c.RemoteAddr().String()] = c
+		ssr.connectionsMtx.Unlock()
 
 		go ssr.read(c)
 	}
@@ -127,11 +179,56 @@ func (ssr *streamSocketReader) removeConnection(c net.Conn) {
 	ssr.connectionsMtx.Unlock()
 }
 
+// handshake 完成 tls 握手，返回客户端证书的身份，客户端没有提供证书时返回空
+func (ssr *streamSocketReader) handshake(c *tls.Conn) (string, error) {
+	timeout := socketTLSHandshakeTimeout
+	if ssr.ReadTimeout > 0 {
+		timeout = ssr.ReadTimeout
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	defer c.SetDeadline(time.Time{})
+	if err := c.Handshake(); err != nil {
+		return "", err
+	}
+	certs := c.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", nil
+	}
+	return certIdentity(certs[0]), nil
+}
+
 func (ssr *streamSocketReader) read(c net.Conn) {
 	defer ssr.removeConnection(c)
 	defer c.Close()
 
+	connID := atomic.AddUint64(&ssr.connID, 1)
+	var identity string
+	if tc, ok := c.(*tls.Conn); ok {
+		var err error
+		identity, err = ssr.handshake(tc)
+		if err != nil {
+			log.Errorf("Runner[%v] Reader[%v] tls handshake with %v error %v", ssr.meta.RunnerName, ssr.Name(), c.RemoteAddr(), err)
+			return
+		}
+	}
+	source := ssr.ServiceAddress
+	if ssr.SourceMeta {
+		source = c.RemoteAddr().String() + "#" + strconv.FormatUint(connID, 10)
+		if identity != "" {
+			source = identity + "@" + source
+		}
+	} else if identity != "" {
+		source = identity
+	}
+
 	scnr := bufio.NewScanner(c)
+	scnr.Buffer(make([]byte, 0, 4096), socketMaxFrameSize)
+	switch ssr.Framing {
+	case SocketFramingOctetCounting:
+		scnr.Split(scanOctetCounted)
+	case SocketFramingLengthPrefixed:
+		scnr.Split(scanLengthPrefixed)
+	}
 	for {
 		if ssr.ReadTimeout != 0 && ssr.ReadTimeout > 0 {
 			c.SetReadDeadline(time.Now().Add(ssr.ReadTimeout))
@@ -139,7 +236,7 @@ func (ssr *streamSocketReader) read(c net.Conn) {
 		if !scnr.Scan() {
 			break
 		}
-		ssr.ReadChan <- string(scnr.Bytes())
+		ssr.ReadChan <- socketLine{data: string(scnr.Bytes()), source: source}
 	}
 
 	if err := scnr.Err(); err != nil {
@@ -151,6 +248,64 @@ func (ssr *streamSocketReader) read(c net.Conn) {
 	}
 }
 
+// scanOctetCounted 按照 RFC 6587 的 octet counting 分帧，帧内可以包含换行符，帧的内容原样返回
+// 不以 "数字 空格" 开头的帧按照 non-transparent framing 处理，即按换行符分割
+func scanOctetCounted(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	sp := -1
+	for i, b := range data {
+		if b == ' ' && i > 0 {
+			sp = i
+			break
+		}
+		if b < '0' || b > '9' || data[0] == '0' {
+			return bufio.ScanLines(data, atEOF)
+		}
+	}
+	if sp < 0 {
+		if atEOF {
+			return bufio.ScanLines(data, atEOF)
+		}
+		return 0, nil, nil
+	}
+	length, err := strconv.Atoi(string(data[:sp]))
+	if err != nil || length > socketMaxFrameSize {
+		return 0, nil, fmt.Errorf("invalid octet counting frame length %q", data[:sp])
+	}
+	end := sp + 1 + length
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	return end, data[sp+1 : end], nil
+}
+
+// scanLengthPrefixed 按照 4 字节大端序长度前缀分帧，帧的内容原样返回
+func scanLengthPrefixed(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) < 4 {
+		if atEOF && len(data) > 0 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	length := binary.BigEndian.Uint32(data[:4])
+	if length > socketMaxFrameSize {
+		return 0, nil, fmt.Errorf("frame length %v exceeds the limit %v", length, socketMaxFrameSize)
+	}
+	end := 4 + int(length)
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	return end, data[4:end], nil
+}
+
 type packetSocketReader struct {
 	PacketConn net.PacketConn
 	*SocketReader
@@ -166,29 +321,42 @@ func (psr *packetSocketReader) listen() {
 	}()
 
 	for {
-		n, _, err := psr.PacketConn.ReadFrom(buf)
+		n, addr, err := psr.PacketConn.ReadFrom(buf)
 		if err != nil {
 			if !strings.HasSuffix(err.Error(), ": use of closed network connection") {
 				log.Error(err)
 			}
 			break
 		}
-		psr.ReadChan <- string(buf[:n])
+		source := psr.ServiceAddress
+		if psr.SourceMeta && addr != nil {
+			source = addr.String()
+		}
+		psr.ReadChan <- socketLine{data: string(buf[:n]), source: source}
 	}
 }
 
 type SocketReader struct {
+	// connID 为 stream sockets 的连接计数，用于生成连接ID，放在首位保证 32 位平台上原子操作的对齐
+	connID uint64
+
 	netproto        string
 	ServiceAddress  string
 	MaxConnections  int
 	ReadBufferSize  int
 	ReadTimeout     time.Duration
 	KeepAlivePeriod time.Duration
+	Framing         string
+	SourceMeta      bool
 	status          int32
 	meta            *Meta // 记录offset的元数据
 
+	tlsConfig *tls.Config
+	// lastSource 为最近一行数据的来源，开启 mutual TLS 时为客户端证书的身份
+	lastSource string
+
 	// resource need  close
-	ReadChan chan string
+	ReadChan chan socketLine
 	Closer   io.Closer
 }
 
@@ -197,6 +365,9 @@ func (sr *SocketReader) Name() string {
 }
 
 func (sr *SocketReader) Source() string {
+	if sr.lastSource != "" {
+		return sr.lastSource
+	}
 	return sr.ServiceAddress
 }
 
@@ -219,7 +390,8 @@ func (sr *SocketReader) ReadLine() (data string, err error) {
 	timer := time.NewTimer(time.Second)
 	select {
 	case dat := <-sr.ReadChan:
-		data = string(dat)
+		data = dat.data
+		sr.lastSource = dat.source
 	case <-timer.C:
 	}
 	timer.Stop()
@@ -244,6 +416,9 @@ func (sr *SocketReader) Start() error {
 
 	switch spl[0] {
 	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		if sr.tlsConfig != nil && spl[0] == "unixpacket" {
+			return fmt.Errorf("tls is not supported on a %s socket", spl[0])
+		}
 		l, err := net.Listen(spl[0], spl[1])
 		if err != nil {
 			return err
@@ -265,6 +440,9 @@ func (sr *SocketReader) Start() error {
 		sr.Closer = l
 		go ssr.listen()
 	case "udp", "udp4", "udp6", "ip", "ip4", "ip6", "unixgram":
+		if sr.tlsConfig != nil {
+			return fmt.Errorf("tls is not supported on a %s socket", spl[0])
+		}
 		pc, err := net.ListenPacket(spl[0], spl[1])
 		if err != nil {
 			return err
@@ -330,13 +508,38 @@ func NewSocketReader(meta *Meta, conf conf.MapConf) (Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	Framing, _ := conf.GetStringOr(KeySocketFraming, SocketFramingNewline)
+	switch Framing {
+	case SocketFramingNewline, SocketFramingOctetCounting, SocketFramingLengthPrefixed:
+	default:
+		return nil, fmt.Errorf("unknown %v %v", KeySocketFraming, Framing)
+	}
+	SourceMeta, _ := conf.GetBoolOr(KeySocketSourceMeta, false)
+
+	var tlsConfig *tls.Config
+	tlsCert, _ := conf.GetStringOr(KeySocketTLSCert, "")
+	tlsKey, _ := conf.GetStringOr(KeySocketTLSKey, "")
+	tlsCA, _ := conf.GetStringOr(KeySocketTLSCA, "")
+	tlsClientAuth, _ := conf.GetStringOr(KeySocketTLSClientAuth, "")
+	if tlsCert != "" || tlsKey != "" {
+		tlsConfig, err = tlsconfig.NewServerConfig(tlsCert, tlsKey, tlsCA, tlsClientAuth)
+		if err != nil {
+			return nil, err
+		}
+	} else if tlsCA != "" || tlsClientAuth != "" {
+		return nil, fmt.Errorf("%v and %v are required when tls is enabled", KeySocketTLSCert, KeySocketTLSKey)
+	}
 	return &SocketReader{
 		ServiceAddress:  ServiceAddress,
 		MaxConnections:  MaxConnections,
 		ReadBufferSize:  ReadBufferSize,
 		ReadTimeout:     ReadTimeoutdur,
 		KeepAlivePeriod: KeepAlivePeriodDur,
-		ReadChan:        make(chan string),
+		Framing:         Framing,
+		SourceMeta:      SourceMeta,
+		tlsConfig:       tlsConfig,
+		ReadChan:        make(chan socketLine),
 		status:          StatusInit,
 		meta:            meta,
 	}, nil
@@ -1,14 +1,29 @@
 package reader
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"log/syslog"
+	"math/big"
+	"net"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/qiniu/logkit/conf"
 	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlsconfig"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -133,3 +148,215 @@ func TestUnixSocketReader(t *testing.T) {
 	err = sr.Close()
 	assert.NoError(t, err)
 }
+
+// writeTestCert 签发证书并写入 dir，parent 为空时生成自签名的 CA
+func writeTestCert(t *testing.T, dir, name string, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, DefaultFilePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, DefaultFilePerm))
+	return cert, key
+}
+
+func createTestCerts(t *testing.T, dir string) {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := writeTestCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "logkit-test-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	writeTestCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeTestCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "dmz-syslog-01"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+}
+
+func TestTLSSocketReader(t *testing.T) {
+	certDir, err := ioutil.TempDir("", "socket_tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(certDir)
+	createTestCerts(t, certDir)
+
+	logkitConf := conf.MapConf{
+		KeyMetaPath:             metaDir,
+		KeyFileDone:             metaDir,
+		KeyRunnerName:           "TestTLSSocketReader",
+		KeyMode:                 ModeSocket,
+		KeySocketServiceAddress: "tcp://127.0.0.1:5142",
+		KeySocketTLSCert:        filepath.Join(certDir, "server.crt"),
+		KeySocketTLSKey:         filepath.Join(certDir, "server.key"),
+		KeySocketTLSCA:          filepath.Join(certDir, "ca.crt"),
+	}
+	meta, err := NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(metaDir)
+
+	ssr, err := NewSocketReader(meta, logkitConf)
+	assert.NoError(t, err)
+	sr := ssr.(*SocketReader)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, sr.tlsConfig.ClientAuth)
+	err = sr.Start()
+	assert.NoError(t, err)
+	defer sr.Close()
+
+	rootCAs, err := tlsconfig.LoadCertPool(filepath.Join(certDir, "ca.crt"))
+	assert.NoError(t, err)
+
+	// 没有客户端证书的连接会在握手时被拒绝
+	conn, err := tls.Dial("tcp", "127.0.0.1:5142", &tls.Config{RootCAs: rootCAs, ServerName: "localhost"})
+	if err == nil {
+		fmt.Fprintf(conn, "should not be read\n")
+		conn.Close()
+	}
+	line, err := sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(certDir, "client.crt"), filepath.Join(certDir, "client.key"))
+	assert.NoError(t, err)
+	conn, err = tls.Dial("tcp", "127.0.0.1:5142", &tls.Config{
+		RootCAs:      rootCAs,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{clientCert},
+	})
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 - tls message\nthis is OK\n")
+	assert.NoError(t, err)
+
+	line, err = sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Contains(t, line, "tls message")
+	assert.Equal(t, "dmz-syslog-01", sr.Source())
+	line, err = sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "this is OK", line)
+}
+
+func TestNewServerTLSConfig(t *testing.T) {
+	certDir, err := ioutil.TempDir("", "socket_tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(certDir)
+	createTestCerts(t, certDir)
+	cert, key, ca := filepath.Join(certDir, "server.crt"), filepath.Join(certDir, "server.key"), filepath.Join(certDir, "ca.crt")
+
+	cfg, err := tlsconfig.NewServerConfig(cert, key, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	cfg, err = tlsconfig.NewServerConfig(cert, key, ca, "verify_if_given")
+	assert.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, cfg.ClientAuth)
+
+	_, err = tlsconfig.NewServerConfig(cert, key, "", "require_and_verify")
+	assert.Error(t, err)
+	_, err = tlsconfig.NewServerConfig(cert, key, ca, "unknown")
+	assert.Error(t, err)
+}
+
+func scanFrames(data []byte, split bufio.SplitFunc) ([]string, error) {
+	scnr := bufio.NewScanner(bytes.NewReader(data))
+	scnr.Split(split)
+	var frames []string
+	for scnr.Scan() {
+		frames = append(frames, scnr.Text())
+	}
+	return frames, scnr.Err()
+}
+
+func TestScanOctetCounted(t *testing.T) {
+	trace := "java.lang.NullPointerException\n\tat com.example.Foo.bar(Foo.java:42)"
+	data := fmt.Sprintf("%d %s", len(trace), trace) + "12 hello world\nplain line\n5 last\n"
+	frames, err := scanFrames([]byte(data), scanOctetCounted)
+	assert.NoError(t, err)
+	// 按长度分帧时帧内容原样返回，末尾的换行符也属于帧的内容
+	assert.Equal(t, []string{trace, "hello world\n", "plain line", "last\n"}, frames)
+
+	// 以数字开头但不是 octet counting 的行按换行符分割
+	frames, err = scanFrames([]byte("2018-01-01 message\n"), scanOctetCounted)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2018-01-01 message"}, frames)
+
+	_, err = scanFrames([]byte("20 too short"), scanOctetCounted)
+	assert.Error(t, err)
+}
+
+func TestScanLengthPrefixed(t *testing.T) {
+	var buf bytes.Buffer
+	for _, frame := range []string{"line1\nline2", "", "line3\n", "\x00\x01\r\n"} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(frame)))
+		buf.WriteString(frame)
+	}
+	frames, err := scanFrames(buf.Bytes(), scanLengthPrefixed)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line1\nline2", "", "line3\n", "\x00\x01\r\n"}, frames)
+
+	_, err = scanFrames([]byte{0, 0, 0, 10, 'a'}, scanLengthPrefixed)
+	assert.Error(t, err)
+}
+
+func TestSocketReaderFramingAndSourceMeta(t *testing.T) {
+	logkitConf := conf.MapConf{
+		KeyMetaPath:             metaDir,
+		KeyFileDone:             metaDir,
+		KeyRunnerName:           "TestSocketReaderFramingAndSourceMeta",
+		KeyMode:                 ModeSocket,
+		KeySocketServiceAddress: "tcp://127.0.0.1:5143",
+		KeySocketFraming:        SocketFramingOctetCounting,
+		KeySocketSourceMeta:     "true",
+	}
+	meta, err := NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(metaDir)
+
+	ssr, err := NewSocketReader(meta, logkitConf)
+	assert.NoError(t, err)
+	sr := ssr.(*SocketReader)
+	err = sr.Start()
+	assert.NoError(t, err)
+	defer sr.Close()
+
+	for i, msg := range []string{"first\nstack", "second"} {
+		conn, err := net.Dial("tcp", "127.0.0.1:5143")
+		assert.NoError(t, err)
+		_, err = fmt.Fprintf(conn, "%d %s", len(msg), msg)
+		assert.NoError(t, err)
+
+		line, err := sr.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, msg, line)
+		assert.Equal(t, fmt.Sprintf("%s#%d", conn.LocalAddr().String(), i+1), sr.Source())
+		conn.Close()
+	}
+
+	logkitConf[KeySocketFraming] = "unknown"
+	_, err = NewSocketReader(meta, logkitConf)
+	assert.Error(t, err)
+}
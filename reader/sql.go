@@ -2,9 +2,11 @@ package reader
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -28,6 +30,11 @@ const (
 	mb                 = 1024 * 1024 // 1MB
 	sqlOffsetConnector = "##"
 	SQL_SPLITER        = ";"
+
+	// keyset 游标在 meta 中的前缀，用于和整型 offset 区分
+	keysetMetaPrefix = "keyset:"
+	// keyset 分页时原始 sql 作为子查询的别名
+	keysetAlias = "logkit_keyset"
 )
 
 type SqlReader struct {
@@ -47,6 +54,9 @@ type SqlReader struct {
 	syncSQLs []string // 当前在查询的sqls
 	schemas  map[string]string
 
+	keysetColumns []string        // keyset 分页的排序列，为空时使用 offset 分页
+	cursors       [][]interface{} // 每条 sql 已读取的最后一行在排序列上的值
+
 	status  int32
 	mux     sync.Mutex
 	started bool
@@ -160,6 +170,28 @@ func NewSQLReader(meta *Meta, conf conf.MapConf) (ret Reader, err error) {
 		}
 		cronSchedule, _ = conf.GetStringOr(KeyPGsqlCron, "")
 		execOnStart, _ = conf.GetBoolOr(KeyPGsqlExecOnStart, true)
+	case ModeSQLite:
+		if !sqliteSupported {
+			return nil, errors.New("sqlite mode is not supported, logkit is built without cgo")
+		}
+		readBatch, _ = conf.GetIntOr(KeySQLiteReadBatch, 100)
+		offsetKey, _ = conf.GetStringOr(KeySQLiteOffsetKey, "")
+		dataSource, err = conf.GetString(KeySQLiteDataSource)
+		if err != nil {
+			dataSource = logpath
+			if logpath == "" {
+				return nil, err
+			}
+			err = nil
+		}
+		// sqlite 一个文件就是一个数据库，用文件名作为数据库名称
+		database = filepath.Base(strings.SplitN(strings.TrimPrefix(dataSource, "file:"), "?", 2)[0])
+		rawSqls, err = conf.GetString(KeySQLiteSQL)
+		if err != nil {
+			return nil, err
+		}
+		cronSchedule, _ = conf.GetStringOr(KeySQLiteCron, "")
+		execOnStart, _ = conf.GetBoolOr(KeySQLiteExecOnStart, true)
 	default:
 		err = fmt.Errorf("%v mode not support in sql reader", dbtype)
 		return
@@ -177,8 +209,12 @@ func NewSQLReader(meta *Meta, conf conf.MapConf) (ret Reader, err error) {
 	if err != nil {
 		return
 	}
+	keysetColumns, _ := conf.GetStringListOr(KeySQLKeysetColumns, []string{})
+	if len(keysetColumns) > 0 && readBatch <= 0 {
+		return nil, fmt.Errorf("limit batch must be positive when %v is set, got %v", KeySQLKeysetColumns, readBatch)
+	}
 
-	offsets, sqls, omitMeta := restoreMeta(meta, rawSqls, mgld)
+	offsets, cursors, sqls, omitMeta := restoreMeta(meta, rawSqls, mgld)
 
 	mr := &SqlReader{
 		datasource:  dataSource,
@@ -198,13 +234,17 @@ func NewSQLReader(meta *Meta, conf conf.MapConf) (ret Reader, err error) {
 		magicLagDur: mgld,
 		schemas:     schemas,
 		statsLock:   sync.RWMutex{},
+
+		keysetColumns: keysetColumns,
 	}
 
 	// 如果meta初始信息损坏
 	if !omitMeta {
 		mr.offsets = offsets
+		mr.cursors = cursors
 	} else {
 		mr.offsets = make([]int64, len(mr.syncSQLs))
+		mr.cursors = make([][]interface{}, len(mr.syncSQLs))
 	}
 	//schedule    string     //定时任务配置串
 	if len(cronSchedule) > 0 {
@@ -254,7 +294,7 @@ func schemaCheck(rawSchemas []string) (schemas map[string]string, err error) {
 	return
 }
 
-func restoreMeta(meta *Meta, rawSqls string, magicLagDur time.Duration) (offsets []int64, sqls []string, omitMeta bool) {
+func restoreMeta(meta *Meta, rawSqls string, magicLagDur time.Duration) (offsets []int64, cursors [][]interface{}, sqls []string, omitMeta bool) {
 	now := time.Now().Add(-magicLagDur)
 	sqls = updateSqls(rawSqls, now)
 	omitMeta = true
@@ -270,9 +310,20 @@ func restoreMeta(meta *Meta, rawSqls string, magicLagDur time.Duration) (offsets
 	}
 	omitMeta = false
 	offsets = make([]int64, length)
+	cursors = make([][]interface{}, length)
 	for idx, sql := range sqls {
 		syncSQL := strings.Replace(tmps[idx], "@", " ", -1)
-		offset, err := strconv.ParseInt(tmps[idx+int(length)], 10, 64)
+		rawOffset := tmps[idx+int(length)]
+		if strings.HasPrefix(rawOffset, keysetMetaPrefix) {
+			cursor, err := decodeKeysetCursor(strings.TrimPrefix(rawOffset, keysetMetaPrefix))
+			if err != nil || sql != syncSQL {
+				log.Errorf("Runner[%v] %v -meta file sql is out of date %v or parse keyset cursor err %v， omit this cursor", meta.RunnerName, meta.MetaFile(), syncSQL, err)
+				continue
+			}
+			cursors[idx] = cursor
+			continue
+		}
+		offset, err := strconv.ParseInt(rawOffset, 10, 64)
 		if err != nil || sql != syncSQL {
 			log.Errorf("Runner[%v] %v -meta file sql is out of date %v or parse offset err %v， omit this offset", meta.RunnerName, meta.MetaFile(), syncSQL, err)
 		}
@@ -418,9 +469,13 @@ func updateSqls(rawsqls string, now time.Time) []string {
 
 //check if syncSQLs is out of date
 func (mr *SqlReader) updateOffsets(sqls []string) {
+	for len(mr.cursors) < len(mr.offsets) {
+		mr.cursors = append(mr.cursors, nil)
+	}
 	for idx, sql := range sqls {
 		if idx >= len(mr.offsets) {
 			mr.offsets = append(mr.offsets, 0)
+			mr.cursors = append(mr.cursors, nil)
 			continue
 		}
 		if idx >= len(mr.syncSQLs) {
@@ -428,6 +483,7 @@ func (mr *SqlReader) updateOffsets(sqls []string) {
 		}
 		if mr.syncSQLs[idx] != sql {
 			mr.offsets[idx] = 0
+			mr.cursors[idx] = nil
 		}
 	}
 }
@@ -485,6 +541,8 @@ func (mr *SqlReader) run() {
 			spls = append(spls, "dbname="+mr.database)
 		}
 		connectStr = strings.Join(spls, " ")
+	case ModeSQLite:
+		connectStr = mr.datasource
 	}
 	// 开始work逻辑
 	for {
@@ -577,10 +635,38 @@ func (mr *SqlReader) getOffsetIndex(columns []string) int {
 	return offsetKeyIndex
 }
 
+// getKeysetIndexes 返回 keyset 排序列在结果中的位置，有列不存在时返回 nil
+func (mr *SqlReader) getKeysetIndexes(columns []string) []int {
+	indexes := make([]int, 0, len(mr.keysetColumns))
+	for _, key := range mr.keysetColumns {
+		key = strings.Trim(key, "`\"[]")
+		found := false
+		for idx, column := range columns {
+			if column == key {
+				indexes = append(indexes, idx)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return indexes
+}
+
+// sqlDriverName 返回 dbtype 对应的 database/sql 驱动名称
+func sqlDriverName(dbtype string) string {
+	if dbtype == ModeSQLite {
+		return "sqlite3"
+	}
+	return dbtype
+}
+
 func (mr *SqlReader) exec(connectStr string) (err error) {
 	now := time.Now().Add(-mr.magicLagDur)
 
-	db, err := sql.Open(mr.dbtype, connectStr)
+	db, err := sql.Open(sqlDriverName(mr.dbtype), connectStr)
 	if err != nil {
 		return fmt.Errorf("%v open %v failed: %v", mr.Name(), mr.dbtype, err)
 	}
@@ -601,14 +687,20 @@ func (mr *SqlReader) exec(connectStr string) (err error) {
 		for !exit {
 			exit = true
 			isRawSQL = false
-			execSQL, err := mr.getSQL(idx)
-			if err != nil {
-				log.Errorf("Runner[%v] get SQL error %v, use raw SQL", mr.meta.RunnerName, err)
-				execSQL = rawSQL
-				isRawSQL = true
+			var execSQL string
+			var args []interface{}
+			if len(mr.keysetColumns) > 0 {
+				execSQL, args = mr.getKeysetSQL(idx)
+			} else {
+				execSQL, err = mr.getSQL(idx)
+				if err != nil {
+					log.Errorf("Runner[%v] get SQL error %v, use raw SQL", mr.meta.RunnerName, err)
+					execSQL = rawSQL
+					isRawSQL = true
+				}
 			}
-			log.Infof("Runner[%v] reader <%v> exec sql <%v>", mr.meta.RunnerName, mr.Name(), execSQL)
-			rows, err := db.Query(execSQL)
+			log.Infof("Runner[%v] reader <%v> exec sql <%v> args %v", mr.meta.RunnerName, mr.Name(), execSQL, args)
+			rows, err := db.Query(execSQL, args...)
 			if err != nil {
 				log.Errorf("Runner[%v] %v prepare %v <%v> query error %v", mr.meta.RunnerName, mr.Name(), mr.dbtype, execSQL, err)
 				continue
@@ -617,22 +709,43 @@ func (mr *SqlReader) exec(connectStr string) (err error) {
 			columns, err := rows.Columns()
 			if err != nil {
 				log.Errorf("Runner[%v] %v prepare %v <%v> columns error %v", mr.meta.RunnerName, mr.Name(), mr.dbtype, execSQL, err)
+				rows.Close()
 				continue
 			}
 			log.Infof("Runner[%v] SQL ：<%v>, schemas: <%v>", mr.meta.RunnerName, execSQL, strings.Join(columns, ", "))
 			scanArgs, nochiced := mr.getInitScans(len(columns), rows, mr.dbtype)
 			offsetKeyIndex := mr.getOffsetIndex(columns)
+			var keysetIndexes []int
+			if len(mr.keysetColumns) > 0 {
+				keysetIndexes = mr.getKeysetIndexes(columns)
+				if keysetIndexes == nil {
+					// 结果中没有 keyset 字段时无法记录读取的位置，读取数据只会重复发送同一批数据
+					rows.Close()
+					return fmt.Errorf("Runner[%v] %v keyset columns %v not all found in columns %v", mr.meta.RunnerName, mr.Name(), mr.keysetColumns, columns)
+				}
+			}
 
 			// Fetch rows
 			var maxOffset int64 = -1
+			var count int
 			for rows.Next() {
 				exit = false
+				count++
 				// get RawBytes from data
 				err = rows.Scan(scanArgs...)
 				if err != nil {
 					log.Errorf("Runner[%v] %v scan rows error %v", mr.meta.RunnerName, mr.Name(), err)
 					continue
 				}
+				var cursor []interface{}
+				if keysetIndexes != nil {
+					if cursor, err = mr.keysetCursor(scanArgs, keysetIndexes); err != nil {
+						log.Errorf("Runner[%v] %v %v, exit after exec once...", mr.meta.RunnerName, mr.Name(), err)
+						mr.setStatsError(err.Error())
+						isRawSQL = true
+						break
+					}
+				}
 				data := make(map[string]interface{})
 				for i := 0; i < len(scanArgs); i++ {
 					vtype, ok := mr.schemas[columns[i]]
@@ -707,11 +820,14 @@ func (mr *SqlReader) exec(connectStr string) (err error) {
 				}
 				if atomic.LoadInt32(&mr.status) == StatusStopping {
 					log.Warnf("Runner[%v] %v stopped from running", mr.meta.RunnerName, mr.Name())
+					rows.Close()
 					return nil
 				}
 				mr.readChan <- ret
 
-				if offsetKeyIndex >= 0 {
+				if cursor != nil {
+					mr.cursors[idx] = cursor
+				} else if offsetKeyIndex >= 0 {
 					var tmpOffsetIndex int64
 					tmpOffsetIndex, err = convertLong(scanArgs[offsetKeyIndex])
 					if err != nil {
@@ -724,10 +840,18 @@ func (mr *SqlReader) exec(connectStr string) (err error) {
 					mr.offsets[idx]++
 				}
 			}
+			// 中途 break 时 rows 没有读完，需要关闭以释放连接
+			rows.Close()
 			if maxOffset > 0 {
 				mr.offsets[idx] = maxOffset + 1
 			}
-			if exit {
+			if len(mr.keysetColumns) > 0 {
+				// keyset 分页不会跳过数据，取到的行数不足一批即为读完
+				exit = count < mr.readBatch
+				if exit {
+					log.Infof("Runner[%v] %v no data any more, exit...", mr.meta.RunnerName, mr.Name())
+				}
+			} else if exit {
 				var newOffsetIdx int64
 				exit, newOffsetIdx = mr.checkExit(idx, db)
 				if !exit {
@@ -971,11 +1095,176 @@ func (mr *SqlReader) getSQL(idx int) (sql string, err error) {
 			err = fmt.Errorf("%v dbtype is not support get SQL without id now", mr.dbtype)
 		}
 		return
+	case ModeSQLite:
+		if len(mr.offsetKey) > 0 {
+			sql = fmt.Sprintf("%s WHERE %v >= %d AND %v < %d;", rawSQL, mr.offsetKey, mr.offsets[idx], mr.offsetKey, mr.offsets[idx]+int64(mr.readBatch))
+		} else {
+			sql = fmt.Sprintf("%s LIMIT %d OFFSET %d;", rawSQL, mr.readBatch, mr.offsets[idx])
+		}
+		return
 	}
 	err = fmt.Errorf("%v dbtype is not support get SQL now", mr.dbtype)
 	return
 }
 
+// getKeysetSQL 将原始 sql 作为子查询，按 keyset 排序列取上次游标之后的一批数据
+func (mr *SqlReader) getKeysetSQL(idx int) (sql string, args []interface{}) {
+	rawSQL := mr.syncSQLs[idx]
+	rawSQL = strings.TrimSuffix(strings.TrimSpace(rawSQL), ";")
+	var where string
+	if cursor := mr.cursors[idx]; len(cursor) == len(mr.keysetColumns) {
+		where, args = mr.keysetCondition(cursor)
+		where = " WHERE " + where
+	}
+	orderBy := strings.Join(mr.keysetColumns, ", ")
+	if mr.dbtype == ModeMssql {
+		sql = fmt.Sprintf("SELECT TOP(%d) * FROM (%s) AS %s%s ORDER BY %s;", mr.readBatch, rawSQL, keysetAlias, where, orderBy)
+		return
+	}
+	sql = fmt.Sprintf("SELECT * FROM (%s) AS %s%s ORDER BY %s LIMIT %d;", rawSQL, keysetAlias, where, orderBy, mr.readBatch)
+	return
+}
+
+// keysetCondition 生成 (a, b) > (?, ?) 形式的条件，占位符按数据库的写法生成
+func (mr *SqlReader) keysetCondition(cursor []interface{}) (string, []interface{}) {
+	var args []interface{}
+	placeholder := func(v interface{}) string {
+		// sqlite 中时间以文本存储，按其默认格式比较
+		if t, ok := v.(time.Time); ok && mr.dbtype == ModeSQLite {
+			v = t.Format("2006-01-02 15:04:05.999999999")
+		}
+		args = append(args, v)
+		switch mr.dbtype {
+		case ModePG:
+			return "$" + strconv.Itoa(len(args))
+		case ModeMssql:
+			return "@p" + strconv.Itoa(len(args))
+		}
+		return "?"
+	}
+	if mr.dbtype != ModeMssql {
+		holders := make([]string, len(cursor))
+		for i, v := range cursor {
+			holders[i] = placeholder(v)
+		}
+		return fmt.Sprintf("(%s) > (%s)", strings.Join(mr.keysetColumns, ", "), strings.Join(holders, ", ")), args
+	}
+	// mssql 不支持行值比较，展开为 a > ? OR (a = ? AND b > ?)
+	ors := make([]string, len(cursor))
+	for i := range cursor {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, fmt.Sprintf("%s = %s", mr.keysetColumns[j], placeholder(cursor[j])))
+		}
+		ands = append(ands, fmt.Sprintf("%s > %s", mr.keysetColumns[i], placeholder(cursor[i])))
+		ors[i] = "(" + strings.Join(ands, " AND ") + ")"
+	}
+	return strings.Join(ors, " OR "), args
+}
+
+// keysetCursor 返回当前行在 keyset 排序列上的值，NULL 无法参与 (a, b) > (?, ?) 的比较，
+// 各数据库对 NULL 的排序也不一致，因此排序列出现 NULL 时返回错误
+func (mr *SqlReader) keysetCursor(scanArgs []interface{}, keysetIndexes []int) ([]interface{}, error) {
+	cursor := make([]interface{}, len(keysetIndexes))
+	for i, keyIndex := range keysetIndexes {
+		cursor[i] = keysetValue(scanArgs[keyIndex])
+		if cursor[i] == nil {
+			return nil, fmt.Errorf("keyset column %v is NULL, keyset columns must be NOT NULL", mr.keysetColumns[i])
+		}
+	}
+	return cursor, nil
+}
+
+// keysetValue 取出扫描到的值作为游标，[]byte 转为 string 以便比较和记录
+func keysetValue(v interface{}) interface{} {
+	switch d := v.(type) {
+	case *int64:
+		return *d
+	case *float64:
+		return *d
+	case *uint64:
+		return *d
+	case *bool:
+		return *d
+	case *[]byte:
+		return string(*d)
+	case *interface{}:
+		if b, ok := (*d).([]byte); ok {
+			return string(b)
+		}
+		return *d
+	}
+	return nil
+}
+
+// keysetMetaValue 是游标在 meta 中的形式，记录类型以便恢复后按原类型作为查询参数
+type keysetMetaValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v,omitempty"`
+}
+
+func encodeKeysetCursor(cursor []interface{}) (string, error) {
+	values := make([]keysetMetaValue, len(cursor))
+	for i, v := range cursor {
+		switch d := v.(type) {
+		case int64:
+			values[i] = keysetMetaValue{Type: "int", Value: strconv.FormatInt(d, 10)}
+		case uint64:
+			values[i] = keysetMetaValue{Type: "uint", Value: strconv.FormatUint(d, 10)}
+		case float64:
+			values[i] = keysetMetaValue{Type: "float", Value: strconv.FormatFloat(d, 'g', -1, 64)}
+		case bool:
+			values[i] = keysetMetaValue{Type: "bool", Value: strconv.FormatBool(d)}
+		case string:
+			values[i] = keysetMetaValue{Type: "string", Value: d}
+		case time.Time:
+			values[i] = keysetMetaValue{Type: "time", Value: d.Format(time.RFC3339Nano)}
+		default:
+			return "", fmt.Errorf("keyset value %v of type %T is not supported", v, v)
+		}
+	}
+	data, err := jsoniter.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	// meta 中不能出现空白字符和 sqlOffsetConnector
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeKeysetCursor(encoded string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var values []keysetMetaValue
+	if err = jsoniter.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	cursor := make([]interface{}, len(values))
+	for i, v := range values {
+		switch v.Type {
+		case "int":
+			cursor[i], err = strconv.ParseInt(v.Value, 10, 64)
+		case "uint":
+			cursor[i], err = strconv.ParseUint(v.Value, 10, 64)
+		case "float":
+			cursor[i], err = strconv.ParseFloat(v.Value, 64)
+		case "bool":
+			cursor[i], err = strconv.ParseBool(v.Value)
+		case "string":
+			cursor[i] = v.Value
+		case "time":
+			cursor[i], err = time.Parse(time.RFC3339Nano, v.Value)
+		default:
+			err = fmt.Errorf("keyset value type %v is not supported", v.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cursor, nil
+}
+
 func (mr *SqlReader) checkExit(idx int, db *sql.DB) (bool, int64) {
 	if len(mr.offsetKey) <= 0 {
 		return true, -1
@@ -1031,7 +1320,15 @@ func (mr *SqlReader) SyncMeta() {
 	for _, sql := range mr.syncSQLs {
 		encodeSQLs = append(encodeSQLs, strings.Replace(sql, " ", "@", -1))
 	}
-	for _, offset := range mr.offsets {
+	for idx, offset := range mr.offsets {
+		if len(mr.keysetColumns) > 0 && idx < len(mr.cursors) && mr.cursors[idx] != nil {
+			cursor, err := encodeKeysetCursor(mr.cursors[idx])
+			if err == nil {
+				encodeSQLs = append(encodeSQLs, keysetMetaPrefix+cursor)
+				continue
+			}
+			log.Errorf("Runner[%v] %v encode keyset cursor %v error %v", mr.meta.RunnerName, mr.Name(), mr.cursors[idx], err)
+		}
 		encodeSQLs = append(encodeSQLs, strconv.FormatInt(offset, 10))
 	}
 	all := strings.Join(encodeSQLs, sqlOffsetConnector)
@@ -0,0 +1,10 @@
+// +build cgo
+
+package reader
+
+import (
+	_ "github.com/mattn/go-sqlite3" //sqlite 驱动，依赖 cgo
+)
+
+// sqliteSupported 表示是否编译了 sqlite 驱动
+const sqliteSupported = true
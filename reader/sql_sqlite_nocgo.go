@@ -0,0 +1,6 @@
+// +build !cgo
+
+package reader
+
+// 关闭 cgo 编译时不包含 sqlite 驱动，sqlite 模式不可用
+const sqliteSupported = false
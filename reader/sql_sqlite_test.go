@@ -0,0 +1,169 @@
+// +build cgo
+
+package reader
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteKeysetReader(t *testing.T) {
+	dir, err := filepath.Abs("./TestSQLiteKeysetReader")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	dbfile := filepath.Join(dir, "logs.db")
+	db, err := sql.Open("sqlite3", dbfile)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE logs (id TEXT NOT NULL, ts DATETIME NOT NULL, msg TEXT)")
+	require.NoError(t, err)
+	// 同一时间戳下有多行，id 不是连续整数
+	insert := func(id, ts, msg string) {
+		_, err := db.Exec("INSERT INTO logs (id, ts, msg) VALUES (?, ?, ?)", id, ts, msg)
+		require.NoError(t, err)
+	}
+	insert("c3", "2018-01-01 00:00:01", "m1")
+	insert("a1", "2018-01-01 00:00:01", "m2")
+	insert("b2", "2018-01-01 00:00:01", "m3")
+	insert("a9", "2018-01-01 00:00:00", "m0")
+	insert("z0", "2018-01-01 00:00:02", "m4")
+
+	c := conf.MapConf{
+		KeyMetaPath:         filepath.Join(dir, "meta"),
+		KeyFileDone:         filepath.Join(dir, "meta"),
+		KeyMode:             ModeSQLite,
+		KeyRunnerName:       "TestSQLiteKeysetReader",
+		KeySQLiteDataSource: dbfile,
+		KeySQLiteSQL:        "select id, msg, ts from logs",
+		KeySQLiteReadBatch:  "2",
+		KeySQLKeysetColumns: "ts,id",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewSQLReader(meta, c)
+	require.NoError(t, err)
+	mr := r.(*SqlReader)
+	assert.Equal(t, "SQLITE_Reader:logs.db_"+Hash(mr.rawsqls), mr.Name())
+
+	var msgs []string
+	for _, line := range readSQLLines(t, r, 5) {
+		msgs = append(msgs, line[strings.Index(line, `"msg":"`)+7:][:2])
+	}
+	assert.Equal(t, []string{"m0", "m2", "m3", "m1", "m4"}, msgs)
+	r.SyncMeta()
+	r.Close()
+
+	insert("a0", "2018-01-01 00:00:02", "m5")
+	insert("y9", "2018-01-01 00:00:01", "m6")
+	insert("b0", "2018-01-01 00:00:03", "m7")
+
+	// 重启后从 meta 中的游标继续，早于游标的新数据不会被读取
+	meta, err = NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err = NewSQLReader(meta, c)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, [][]interface{}{{time.Date(2018, 1, 1, 0, 0, 2, 0, time.UTC), "z0"}}, r.(*SqlReader).cursors)
+	msgs = msgs[:0]
+	for _, line := range readSQLLines(t, r, 1) {
+		msgs = append(msgs, line[strings.Index(line, `"msg":"`)+7:][:2])
+	}
+	assert.Equal(t, []string{"m7"}, msgs)
+}
+
+func TestSQLiteKeysetReaderNullColumn(t *testing.T) {
+	dir, err := filepath.Abs("./TestSQLiteKeysetReaderNullColumn")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	dbfile := filepath.Join(dir, "logs.db")
+	db, err := sql.Open("sqlite3", dbfile)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE logs (id INTEGER NOT NULL, ts DATETIME, msg TEXT)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO logs (id, ts, msg) VALUES (1, NULL, 'm0'), (2, '2018-01-01 00:00:00', 'm1')")
+	require.NoError(t, err)
+
+	c := conf.MapConf{
+		KeyMetaPath:         filepath.Join(dir, "meta"),
+		KeyFileDone:         filepath.Join(dir, "meta"),
+		KeyMode:             ModeSQLite,
+		KeyRunnerName:       "TestSQLiteKeysetReaderNullColumn",
+		KeySQLiteDataSource: dbfile,
+		KeySQLiteSQL:        "select id, msg, ts from logs",
+		KeySQLiteReadBatch:  "2",
+		KeySQLKeysetColumns: "ts,id",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewSQLReader(meta, c)
+	require.NoError(t, err)
+	defer r.Close()
+	mr := r.(*SqlReader)
+	mr.Start()
+
+	// sqlite 中 NULL 排在最前，读到 NULL 时停止读取并报错，不会生成 ts > NULL 的查询
+	for i := 0; i < 50 && mr.Status().LastError == ""; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Contains(t, mr.Status().LastError, "keyset column ts is NULL")
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	assert.Equal(t, []interface{}(nil), mr.cursors[0])
+}
+
+func TestSQLiteKeysetReaderMissingColumn(t *testing.T) {
+	dir, err := filepath.Abs("./TestSQLiteKeysetReaderMissingColumn")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	dbfile := filepath.Join(dir, "logs.db")
+	db, err := sql.Open("sqlite3", dbfile)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE logs (id INTEGER NOT NULL, ts DATETIME NOT NULL, msg TEXT)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO logs (id, ts, msg) VALUES (1, '2018-01-01 00:00:00', 'm0')")
+	require.NoError(t, err)
+
+	c := conf.MapConf{
+		KeyMetaPath:         filepath.Join(dir, "meta"),
+		KeyFileDone:         filepath.Join(dir, "meta"),
+		KeyMode:             ModeSQLite,
+		KeyRunnerName:       "TestSQLiteKeysetReaderMissingColumn",
+		KeySQLiteDataSource: dbfile,
+		KeySQLiteSQL:        "select id, msg, ts from logs",
+		KeySQLiteReadBatch:  "2",
+		KeySQLKeysetColumns: "TS,id",
+	}
+	meta, err := NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewSQLReader(meta, c)
+	require.NoError(t, err)
+	defer r.Close()
+	mr := r.(*SqlReader)
+	mr.Start()
+
+	// sqlite 的列名不区分大小写，查询可以执行，但是结果中找不到 TS 字段，
+	// 此时直接报错，不会发送无法记录位置的数据
+	for i := 0; i < 50 && mr.Status().LastError == ""; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Contains(t, mr.Status().LastError, "not all found in columns")
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+}
@@ -11,6 +11,7 @@ import (
 	. "github.com/qiniu/logkit/utils/models"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConvertMagic(t *testing.T) {
@@ -148,7 +149,7 @@ func TestSQLReader(t *testing.T) {
 
 	// 测试meta备份和恢复
 	mr.SyncMeta()
-	gotoffsets, gotsqls, omit := restoreMeta(meta, mr.rawsqls, 0)
+	gotoffsets, _, gotsqls, omit := restoreMeta(meta, mr.rawsqls, 0)
 	assert.EqualValues(t, mr.offsets, gotoffsets, "got offsets error")
 	assert.EqualValues(t, mr.syncSQLs, gotsqls, "got sqls error")
 	assert.EqualValues(t, false, omit)
@@ -190,3 +191,87 @@ func TestReflectTime(t *testing.T) {
 	fmt.Println(reflect.TypeOf([]byte{}))
 	fmt.Println(reflect.TypeOf(new(interface{})).Elem())
 }
+
+func TestKeysetSQL(t *testing.T) {
+	ts := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	mr := &SqlReader{
+		syncSQLs:      []string{"select * from logs where level = 'error';"},
+		readBatch:     100,
+		keysetColumns: []string{"ts", "id"},
+		cursors:       [][]interface{}{nil},
+	}
+	tests := []struct {
+		dbtype string
+		first  string
+		next   string
+		args   []interface{}
+	}{
+		{
+			dbtype: ModeMysql,
+			first:  "SELECT * FROM (select * from logs where level = 'error') AS logkit_keyset ORDER BY ts, id LIMIT 100;",
+			next:   "SELECT * FROM (select * from logs where level = 'error') AS logkit_keyset WHERE (ts, id) > (?, ?) ORDER BY ts, id LIMIT 100;",
+			args:   []interface{}{ts, int64(7)},
+		},
+		{
+			dbtype: ModePG,
+			first:  "SELECT * FROM (select * from logs where level = 'error') AS logkit_keyset ORDER BY ts, id LIMIT 100;",
+			next:   "SELECT * FROM (select * from logs where level = 'error') AS logkit_keyset WHERE (ts, id) > ($1, $2) ORDER BY ts, id LIMIT 100;",
+			args:   []interface{}{ts, int64(7)},
+		},
+		{
+			dbtype: ModeSQLite,
+			first:  "SELECT * FROM (select * from logs where level = 'error') AS logkit_keyset ORDER BY ts, id LIMIT 100;",
+			next:   "SELECT * FROM (select * from logs where level = 'error') AS logkit_keyset WHERE (ts, id) > (?, ?) ORDER BY ts, id LIMIT 100;",
+			args:   []interface{}{"2018-01-02 03:04:05", int64(7)},
+		},
+		{
+			dbtype: ModeMssql,
+			first:  "SELECT TOP(100) * FROM (select * from logs where level = 'error') AS logkit_keyset ORDER BY ts, id;",
+			next:   "SELECT TOP(100) * FROM (select * from logs where level = 'error') AS logkit_keyset WHERE (ts > @p1) OR (ts = @p2 AND id > @p3) ORDER BY ts, id;",
+			args:   []interface{}{ts, ts, int64(7)},
+		},
+	}
+	for _, ti := range tests {
+		mr.dbtype = ti.dbtype
+		mr.cursors[0] = nil
+		gotSQL, args := mr.getKeysetSQL(0)
+		assert.Equal(t, ti.first, gotSQL, ti.dbtype)
+		assert.Nil(t, args, ti.dbtype)
+
+		mr.cursors[0] = []interface{}{ts, int64(7)}
+		gotSQL, args = mr.getKeysetSQL(0)
+		assert.Equal(t, ti.next, gotSQL, ti.dbtype)
+		assert.Equal(t, ti.args, args, ti.dbtype)
+	}
+}
+
+func TestKeysetCursorMeta(t *testing.T) {
+	cursor := []interface{}{time.Date(2018, 1, 2, 3, 4, 5, 6, time.UTC), int64(-7), uint64(8), 1.5, true, "a b##c"}
+	encoded, err := encodeKeysetCursor(cursor)
+	require.NoError(t, err)
+	assert.NotContains(t, encoded, sqlOffsetConnector)
+	got, err := decodeKeysetCursor(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, cursor, got)
+
+	_, err = encodeKeysetCursor([]interface{}{int32(1)})
+	assert.Error(t, err)
+	// 排序列不能为 NULL，游标中不会出现 nil
+	_, err = encodeKeysetCursor([]interface{}{nil})
+	assert.Error(t, err)
+	_, err = decodeKeysetCursor("not base64!")
+	assert.Error(t, err)
+}
+
+func readSQLLines(t *testing.T, r Reader, n int) []string {
+	var lines []string
+	for i := 0; i < 10 && len(lines) < n; i++ {
+		line, err := r.ReadLine()
+		require.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+			i = 0
+		}
+	}
+	return lines
+}
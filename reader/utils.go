@@ -1,6 +1,7 @@
 package reader
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -144,3 +145,18 @@ func getTags(tagFile string) (tags map[string]interface{}, err error) {
 	}
 	return
 }
+
+// certIdentity 返回证书的身份标识，优先使用 CommonName，其次是 SAN 中的 DNS、Email 和 URI
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
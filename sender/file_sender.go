@@ -1,80 +1,558 @@
 package sender
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
 	"github.com/qiniu/logkit/conf"
 	. "github.com/qiniu/logkit/utils/models"
 	"github.com/qiniu/pandora-go-sdk/base/reqerr"
 
 	"github.com/json-iterator/go"
-	"github.com/utahta/go-cronowriter"
+	"github.com/klauspost/compress/zstd"
+	"github.com/lestrrat-go/strftime"
 )
 
-// FileSender write datas into local file
-// only for test
-type FileSender struct {
-	name        string
-	writer      *cronowriter.CronoWriter
-	marshalFunc func([]Data) ([]byte, error)
-}
-
 // 可选参数 当sender_type 为file 的时候
 const (
-	KeyFileSenderPath = "file_send_path"
+	KeyFileSenderPath           = "file_send_path"
+	KeyFileSenderFormat         = "file_send_format"
+	KeyFileSenderRawField       = "file_send_raw_field"
+	KeyFileSenderCSVFields      = "file_send_csv_fields"
+	KeyFileSenderCSVSplit       = "file_send_csv_split"
+	KeyFileSenderCSVHead        = "file_send_csv_head"
+	KeyFileSenderRotateSize     = "file_send_rotate_size"
+	KeyFileSenderRotateInterval = "file_send_rotate_interval"
+	KeyFileSenderCompress       = "file_send_compress"
+	KeyFileSenderIdleTimeout    = "file_send_idle_timeout"
+)
+
+// 文件的输出格式
+const (
+	FileFormatJSON   = "json"
+	FileFormatCSV    = "csv"
+	FileFormatRaw    = "raw"
+	FileFormatLogfmt = "logfmt"
+)
+
+// 文件关闭时的压缩方式
+const (
+	FileCompressNone = "none"
+	FileCompressGzip = "gzip"
+	FileCompressZstd = "zstd"
+)
+
+const (
+	defaultFileRawField    = "raw"
+	defaultFileIdleTimeout = 5 * time.Minute
+	// 路径中的字段不存在时使用的值
+	fileUnknownField = "unknown"
+)
+
+// 后台检查空闲文件的间隔范围
+const (
+	minFileIdleCheckInterval = 100 * time.Millisecond
+	maxFileIdleCheckInterval = time.Minute
 )
 
+var fileFieldPattern = regexp.MustCompile(`%\{([^}]+)\}`)
+
+// FileSender 将数据按选择的格式写入本地文件，路径支持时间魔法变量以及 %{field} 形式的字段，
+// 文件可以按大小和时间切分，关闭时按配置压缩
+type FileSender struct {
+	name        string
+	path        *filePathTemplate
+	format      string
+	rawField    string
+	csvFields   []string
+	csvSplit    rune
+	csvHead     bool
+	rotateSize  int64
+	rotateEvery time.Duration
+	compress    string
+	idleTimeout time.Duration
+
+	mux     sync.Mutex
+	writers map[string]*fileWriter
+	// compressing 记录正在后台压缩的目标文件，避免其他文件选用同样的文件名
+	compressing map[string]bool
+
+	stopChan   chan struct{}
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+	compressWg sync.WaitGroup
+}
+
 // NewFileSender construct
-func NewFileSender(conf conf.MapConf) (sender Sender, err error) {
-	var path string
-	path, err = conf.GetString(KeyFileSenderPath)
+func NewFileSender(c conf.MapConf) (Sender, error) {
+	path, err := c.GetString(KeyFileSenderPath)
 	if err != nil {
-		return
+		return nil, err
 	}
-	name, _ := conf.GetStringOr(KeyName, "fileSender:"+path)
-	sender, err = newFileSender(name, path, JSONLineMarshalFunc)
+	tmpl, err := newFilePathTemplate(path)
 	if err != nil {
-		return
+		return nil, err
 	}
-	return
-}
-
-func newFileSender(name, path string, marshalFunc func([]Data) ([]byte, error)) (*FileSender, error) {
-	f, err := cronowriter.New(path)
+	name, _ := c.GetStringOr(KeyName, "fileSender:"+path)
+	format, _ := c.GetStringOr(KeyFileSenderFormat, FileFormatJSON)
+	rawField, _ := c.GetStringOr(KeyFileSenderRawField, defaultFileRawField)
+	csvFields, _ := c.GetStringListOr(KeyFileSenderCSVFields, []string{})
+	csvSplit, _ := c.GetStringOr(KeyFileSenderCSVSplit, ",")
+	csvHead, _ := c.GetBoolOr(KeyFileSenderCSVHead, true)
+	switch format {
+	case FileFormatJSON, FileFormatRaw, FileFormatLogfmt:
+	case FileFormatCSV:
+		if len(csvFields) == 0 {
+			return nil, fmt.Errorf("%v is required when %v is %v", KeyFileSenderCSVFields, KeyFileSenderFormat, format)
+		}
+		if len([]rune(csvSplit)) != 1 {
+			return nil, fmt.Errorf("%v must be a single character, got %q", KeyFileSenderCSVSplit, csvSplit)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported %v %v", KeyFileSenderFormat, format)
+	}
+	rotateSize, _ := c.GetInt64Or(KeyFileSenderRotateSize, 0)
+	rotateEvery, err := getDurationOr(c, KeyFileSenderRotateInterval, 0)
 	if err != nil {
 		return nil, err
 	}
-	return &FileSender{
+	compress, _ := c.GetStringOr(KeyFileSenderCompress, FileCompressNone)
+	switch compress {
+	case FileCompressNone, FileCompressGzip, FileCompressZstd:
+	default:
+		return nil, fmt.Errorf("unsupported %v %v", KeyFileSenderCompress, compress)
+	}
+	idleTimeout, err := getDurationOr(c, KeyFileSenderIdleTimeout, defaultFileIdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FileSender{
 		name:        name,
-		writer:      f,
-		marshalFunc: marshalFunc,
-	}, nil
+		path:        tmpl,
+		format:      format,
+		rawField:    rawField,
+		csvFields:   csvFields,
+		csvSplit:    []rune(csvSplit)[0],
+		csvHead:     csvHead,
+		rotateSize:  rotateSize * 1024 * 1024,
+		rotateEvery: rotateEvery,
+		compress:    compress,
+		idleTimeout: idleTimeout,
+		writers:     make(map[string]*fileWriter),
+		compressing: make(map[string]bool),
+		stopChan:    make(chan struct{}),
+	}
+	fs.wg.Add(1)
+	go fs.checkIdle()
+	return fs, nil
+}
+
+func getDurationOr(c conf.MapConf, key string, deft time.Duration) (time.Duration, error) {
+	s, _ := c.GetStringOr(key, "")
+	if s == "" {
+		return deft, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse %v %v error %v", key, s, err)
+	}
+	return d, nil
 }
 
 // Send inherit from Sender
 func (fs *FileSender) Send(datas []Data) error {
-	bytes, err := fs.marshalFunc(datas)
-	if err != nil {
-		return reqerr.NewSendError(fs.Name()+" Cannot marshal data into file, error is "+err.Error(), ConvertDatasBack(datas), reqerr.TypeDefault)
+	now := time.Now()
+	// 按文件分组，保证每个文件中的数据顺序与发送顺序一致
+	var paths []string
+	groups := make(map[string][]Data)
+	for _, data := range datas {
+		path := fs.path.render(now, data)
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], data)
 	}
-	_, err = fs.writer.Write(bytes)
-	if err != nil {
-		return reqerr.NewSendError(fs.Name()+"Cannot write data into file, error is "+err.Error(), ConvertDatasBack(datas), reqerr.TypeDefault)
+
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	var failed []Data
+	var lastErr error
+	for _, path := range paths {
+		if err := fs.write(path, groups[path], now); err != nil {
+			log.Errorf("%v write data into %v error %v", fs.Name(), path, err)
+			failed = append(failed, groups[path]...)
+			lastErr = err
+		}
+	}
+	fs.closeIdle(now)
+	if lastErr != nil {
+		return reqerr.NewSendError(fs.Name()+" Cannot write data into file, error is "+lastErr.Error(), ConvertDatasBack(failed), reqerr.TypeDefault)
 	}
 	return nil
 }
 
+func (fs *FileSender) write(path string, datas []Data, now time.Time) error {
+	w, ok := fs.writers[path]
+	if ok && (fs.rotateSize > 0 && w.size >= fs.rotateSize || fs.rotateEvery > 0 && now.Sub(w.opened) >= fs.rotateEvery) {
+		delete(fs.writers, path)
+		if err := fs.finish(w, true); err != nil {
+			log.Errorf("%v rotate %v error %v", fs.Name(), path, err)
+		}
+		ok = false
+	}
+	if !ok {
+		var err error
+		if w, err = openFileWriter(path, now); err != nil {
+			return err
+		}
+		fs.writers[path] = w
+	}
+	buf, err := fs.marshal(datas, w.size == 0)
+	if err != nil {
+		return err
+	}
+	w.lastWrite = now
+	return w.write(buf)
+}
+
+// checkIdle 定期关闭空闲的文件，没有数据发送时文件也能及时关闭并压缩
+func (fs *FileSender) checkIdle() {
+	defer fs.wg.Done()
+	interval := fs.idleTimeout
+	if interval < minFileIdleCheckInterval {
+		interval = minFileIdleCheckInterval
+	} else if interval > maxFileIdleCheckInterval {
+		interval = maxFileIdleCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fs.stopChan:
+			return
+		case now := <-ticker.C:
+			fs.mux.Lock()
+			fs.closeIdle(now)
+			fs.mux.Unlock()
+		}
+	}
+}
+
+// closeIdle 关闭一段时间内没有写入的文件，如按日期切分的路径在日期变化后不会再写入
+func (fs *FileSender) closeIdle(now time.Time) {
+	for path, w := range fs.writers {
+		if now.Sub(w.lastWrite) < fs.idleTimeout {
+			continue
+		}
+		delete(fs.writers, path)
+		if err := fs.finish(w, false); err != nil {
+			log.Errorf("%v close %v error %v", fs.Name(), path, err)
+		}
+	}
+}
+
 func (fs *FileSender) Name() string {
 	return fs.name
 }
 
 func (fs *FileSender) Close() error {
-	return fs.writer.Close()
+	fs.closeOnce.Do(func() {
+		close(fs.stopChan)
+	})
+	fs.wg.Wait()
+	fs.mux.Lock()
+	var lastErr error
+	for path, w := range fs.writers {
+		delete(fs.writers, path)
+		if err := fs.finish(w, false); err != nil {
+			lastErr = err
+		}
+	}
+	fs.mux.Unlock()
+	fs.compressWg.Wait()
+	return lastErr
+}
+
+// finish 关闭文件，rotate 为 true 时将文件重命名为 path.N 以便继续写入 path，
+// 需要压缩时先将文件重命名，再在后台压缩为带有 .gz 或 .zst 后缀的文件并删除原文件，压缩时不持有 fs.mux
+func (fs *FileSender) finish(w *fileWriter, rotate bool) error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	ext := ""
+	switch fs.compress {
+	case FileCompressGzip:
+		ext = ".gz"
+	case FileCompressZstd:
+		ext = ".zst"
+	}
+	if !rotate && ext == "" {
+		return nil
+	}
+	target := availableFilePath(w.path, ext, !rotate, fs.compressing)
+	if ext == "" {
+		return os.Rename(w.path, target)
+	}
+	// 重命名后 path 可以立即重新打开写入
+	src := strings.TrimSuffix(target, ext) + ".compressing"
+	if err := os.Rename(w.path, src); err != nil {
+		return err
+	}
+	fs.compressing[target] = true
+	fs.compressWg.Add(1)
+	go fs.backgroundCompress(src, target)
+	return nil
+}
+
+func (fs *FileSender) backgroundCompress(src, dst string) {
+	defer fs.compressWg.Done()
+	err := compressFile(src, dst, fs.compress)
+	fs.mux.Lock()
+	delete(fs.compressing, dst)
+	fs.mux.Unlock()
+	if err != nil {
+		log.Errorf("%v compress %v into %v error %v", fs.Name(), src, dst, err)
+	}
+}
+
+// marshal 将一批数据按照输出格式序列化，head 为 true 时 csv 格式会先写入表头
+func (fs *FileSender) marshal(datas []Data, head bool) ([]byte, error) {
+	var buf bytes.Buffer
+	switch fs.format {
+	case FileFormatJSON:
+		b, err := JSONLineMarshalFunc(datas)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	case FileFormatCSV:
+		w := csv.NewWriter(&buf)
+		w.Comma = fs.csvSplit
+		if head && fs.csvHead {
+			w.Write(fs.csvFields)
+		}
+		record := make([]string, len(fs.csvFields))
+		for _, data := range datas {
+			for i, field := range fs.csvFields {
+				record[i] = fileFieldString(data[field])
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+	case FileFormatRaw:
+		for _, data := range datas {
+			v, ok := data[fs.rawField]
+			if !ok {
+				continue
+			}
+			buf.WriteString(fileFieldString(v))
+			buf.WriteByte('\n')
+		}
+	case FileFormatLogfmt:
+		for _, data := range datas {
+			buf.WriteString(logfmtLine(data))
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// fileFieldString 将字段转换为字符串，嵌套的 map 和数组转换为 json
+func fileFieldString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case map[string]interface{}, Data, []interface{}:
+		b, err := jsoniter.Marshal(value)
+		if err != nil {
+			return fmt.Sprintf("%v", value)
+		}
+		return string(b)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// logfmtLine 按 key 排序输出 key=value，value 为空或包含空格、等号、引号时加上引号
+func logfmtLine(data Data) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := fileFieldString(data[k])
+		if v == "" || strings.ContainsAny(v, " =\"\t\r\n") {
+			v = strconv.Quote(v)
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
 }
 
 // JSONLineMarshalFunc  将数据json并且按换行符分隔
 func JSONLineMarshalFunc(datas []Data) ([]byte, error) {
-	bytes, err := jsoniter.Marshal(datas)
+	var buf bytes.Buffer
+	for _, data := range datas {
+		b, err := jsoniter.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// filePathTemplate 是按 %{field} 切分后的路径，字段之间的部分按时间魔法变量渲染
+type filePathTemplate struct {
+	segments []*strftime.Strftime
+	fields   []string
+}
+
+func newFilePathTemplate(path string) (*filePathTemplate, error) {
+	tmpl := &filePathTemplate{}
+	start := 0
+	for _, loc := range fileFieldPattern.FindAllStringSubmatchIndex(path, -1) {
+		seg, err := strftime.New(path[start:loc[0]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %v: %v", KeyFileSenderPath, path, err)
+		}
+		tmpl.segments = append(tmpl.segments, seg)
+		tmpl.fields = append(tmpl.fields, path[loc[2]:loc[3]])
+		start = loc[1]
+	}
+	seg, err := strftime.New(path[start:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %v: %v", KeyFileSenderPath, path, err)
+	}
+	tmpl.segments = append(tmpl.segments, seg)
+	return tmpl, nil
+}
+
+// render 生成数据对应的文件路径，字段中的路径分隔符会被替换，避免写到预期之外的目录
+func (t *filePathTemplate) render(now time.Time, data Data) string {
+	var buf bytes.Buffer
+	for i, seg := range t.segments {
+		buf.WriteString(seg.FormatString(now))
+		if i >= len(t.fields) {
+			break
+		}
+		v := fileFieldString(data[t.fields[i]])
+		if v == "" || v == "." || v == ".." {
+			v = fileUnknownField
+		}
+		buf.WriteString(strings.NewReplacer("/", "_", "\\", "_").Replace(v))
+	}
+	return buf.String()
+}
+
+// fileWriter 是一个正在写入的文件
+type fileWriter struct {
+	path      string
+	file      *os.File
+	size      int64
+	opened    time.Time
+	lastWrite time.Time
+}
+
+func openFileWriter(path string, now time.Time) (*fileWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, err
 	}
-	return append(bytes, '\n'), nil
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileWriter{path: path, file: f, size: info.Size(), opened: now, lastWrite: now}, nil
+}
+
+func (w *fileWriter) write(buf []byte) error {
+	n, err := w.file.Write(buf)
+	w.size += int64(n)
+	return err
+}
+
+// availableFilePath 返回不存在且没有被占用的文件名，依次尝试 path+ext(允许时)、path.1+ext、path.2+ext...
+func availableFilePath(path, ext string, allowOrigin bool, reserved map[string]bool) string {
+	available := func(target string) bool {
+		if reserved[target] {
+			return false
+		}
+		_, err := os.Stat(target)
+		return os.IsNotExist(err)
+	}
+	if allowOrigin && available(path+ext) {
+		return path + ext
+	}
+	for i := 1; ; i++ {
+		target := path + "." + strconv.Itoa(i) + ext
+		if available(target) {
+			return target
+		}
+	}
+}
+
+func compressFile(src, dst, compress string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	var cw io.WriteCloser
+	switch compress {
+	case FileCompressGzip:
+		cw = gzip.NewWriter(out)
+	case FileCompressZstd:
+		if cw, err = zstd.NewWriter(out); err != nil {
+			out.Close()
+			os.Remove(dst)
+			return err
+		}
+	default:
+		out.Close()
+		os.Remove(dst)
+		return errors.New("unsupported compress " + compress)
+	}
+	_, err = io.Copy(cw, in)
+	if closeErr := cw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
 }
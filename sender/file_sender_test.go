@@ -1,16 +1,20 @@
 package sender
 
 import (
+	"compress/gzip"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/qiniu/logkit/conf"
 	. "github.com/qiniu/logkit/utils/models"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFileSender(t *testing.T) {
@@ -18,13 +22,181 @@ func TestFileSender(t *testing.T) {
 	defer os.RemoveAll(path)
 	fsender, err := NewFileSender(conf.MapConf{KeyFileSenderPath: filepath.Join(path, "%Y%m%d.log")})
 	assert.NoError(t, err)
-	err = fsender.Send([]Data{{"abc": 123}})
+	err = fsender.Send([]Data{{"abc": 123}, {"abc": 456}})
 	assert.NoError(t, err)
 	err = fsender.Close()
 	assert.NoError(t, err)
 	datet := time.Now().Format("20060102")
 	body, err := ioutil.ReadFile(filepath.Join(path, datet+".log"))
 	assert.NoError(t, err)
-	assert.Equal(t, `[{"abc":123}]
+	assert.Equal(t, `{"abc":123}
+{"abc":456}
 `, string(body))
 }
+
+func TestFileSenderPathTemplate(t *testing.T) {
+	path := "TestFileSenderPathTemplate"
+	defer os.RemoveAll(path)
+	fsender, err := NewFileSender(conf.MapConf{
+		KeyFileSenderPath:   filepath.Join(path, "%{service}", "%Y.log"),
+		KeyFileSenderFormat: FileFormatRaw,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, fsender.Send([]Data{
+		{"service": "web", "raw": "w1"},
+		{"service": "db", "raw": "d1"},
+		{"service": "../etc", "raw": "x1"},
+		{"raw": "u1"},
+		{"service": "web", "raw": "w2"},
+		{"service": "web"},
+	}))
+	assert.NoError(t, fsender.Close())
+	year := time.Now().Format("2006")
+	for dir, expect := range map[string]string{"web": "w1\nw2\n", "db": "d1\n", ".._etc": "x1\n", "unknown": "u1\n"} {
+		body, err := ioutil.ReadFile(filepath.Join(path, dir, year+".log"))
+		assert.NoError(t, err)
+		assert.Equal(t, expect, string(body), dir)
+	}
+
+	_, err = NewFileSender(conf.MapConf{KeyFileSenderPath: filepath.Join(path, "%Q.log")})
+	assert.Error(t, err)
+}
+
+func TestFileSenderFormats(t *testing.T) {
+	path := "TestFileSenderFormats"
+	defer os.RemoveAll(path)
+	datas := []Data{
+		{"level": "info", "msg": "hello world", "n": 1},
+		{"level": "warn", "msg": `say "hi"`, "tags": []interface{}{"a", "b"}},
+	}
+	tests := []struct {
+		conf   conf.MapConf
+		expect string
+	}{
+		{
+			conf: conf.MapConf{KeyFileSenderFormat: FileFormatCSV, KeyFileSenderCSVFields: "level,msg,tags"},
+			expect: "level,msg,tags\n" +
+				"info,hello world,\n" +
+				"warn,\"say \"\"hi\"\"\",\"[\"\"a\"\",\"\"b\"\"]\"\n",
+		},
+		{
+			conf:   conf.MapConf{KeyFileSenderFormat: FileFormatCSV, KeyFileSenderCSVFields: "level,n", KeyFileSenderCSVSplit: "\t", KeyFileSenderCSVHead: "false"},
+			expect: "info\t1\nwarn\t\n",
+		},
+		{
+			conf: conf.MapConf{KeyFileSenderFormat: FileFormatLogfmt},
+			expect: "level=info msg=\"hello world\" n=1\n" +
+				"level=warn msg=\"say \\\"hi\\\"\" tags=\"[\\\"a\\\",\\\"b\\\"]\"\n",
+		},
+	}
+	for i, test := range tests {
+		file := filepath.Join(path, strconv.Itoa(i)+".log")
+		test.conf[KeyFileSenderPath] = file
+		fsender, err := NewFileSender(test.conf)
+		require.NoError(t, err)
+		assert.NoError(t, fsender.Send(datas))
+		assert.NoError(t, fsender.Close())
+		body, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expect, string(body))
+	}
+
+	_, err := NewFileSender(conf.MapConf{KeyFileSenderPath: "a.log", KeyFileSenderFormat: FileFormatCSV})
+	assert.Error(t, err)
+	_, err = NewFileSender(conf.MapConf{KeyFileSenderPath: "a.log", KeyFileSenderFormat: "xml"})
+	assert.Error(t, err)
+}
+
+func TestFileSenderRotateAndCompress(t *testing.T) {
+	path := "TestFileSenderRotateAndCompress"
+	defer os.RemoveAll(path)
+	file := filepath.Join(path, "app.log")
+	s, err := NewFileSender(conf.MapConf{
+		KeyFileSenderPath:     file,
+		KeyFileSenderFormat:   FileFormatRaw,
+		KeyFileSenderCompress: FileCompressGzip,
+	})
+	require.NoError(t, err)
+	fsender := s.(*FileSender)
+	// 每次写入后都达到切分大小
+	fsender.rotateSize = 1
+	for _, raw := range []string{"a", "b", "c"} {
+		assert.NoError(t, fsender.Send([]Data{{"raw": raw}}))
+	}
+	assert.NoError(t, fsender.Close())
+
+	readGzip := func(name string) string {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+		gr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(gr)
+		assert.NoError(t, err)
+		return string(body)
+	}
+	assert.Equal(t, "a\n", readGzip(file+".1.gz"))
+	assert.Equal(t, "b\n", readGzip(file+".2.gz"))
+	assert.Equal(t, "c\n", readGzip(file+".gz"))
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	// 空闲的文件在下次发送时关闭并压缩，已经存在同名的压缩文件时使用新的序号
+	s, err = NewFileSender(conf.MapConf{
+		KeyFileSenderPath:        file,
+		KeyFileSenderFormat:      FileFormatRaw,
+		KeyFileSenderCompress:    FileCompressGzip,
+		KeyFileSenderIdleTimeout: "1ns",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"raw": "d"}}))
+	assert.NoError(t, s.Send(nil))
+	// 压缩在后台进行，Close 等待压缩完成
+	assert.NoError(t, s.Close())
+	assert.Equal(t, "d\n", readGzip(file+".3.gz"))
+
+	s, err = NewFileSender(conf.MapConf{
+		KeyFileSenderPath:     file,
+		KeyFileSenderFormat:   FileFormatRaw,
+		KeyFileSenderCompress: FileCompressZstd,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"raw": "e"}}))
+	assert.NoError(t, s.Close())
+	f, err := os.Open(file + ".zst")
+	require.NoError(t, err)
+	defer f.Close()
+	dec, err := zstd.NewReader(f)
+	require.NoError(t, err)
+	defer dec.Close()
+	body, err := ioutil.ReadAll(dec)
+	assert.NoError(t, err)
+	assert.Equal(t, "e\n", string(body))
+}
+
+func TestFileSenderCloseIdleInBackground(t *testing.T) {
+	path := "TestFileSenderCloseIdleInBackground"
+	defer os.RemoveAll(path)
+	file := filepath.Join(path, "app.log")
+	s, err := NewFileSender(conf.MapConf{
+		KeyFileSenderPath:        file,
+		KeyFileSenderFormat:      FileFormatRaw,
+		KeyFileSenderCompress:    FileCompressGzip,
+		KeyFileSenderIdleTimeout: "50ms",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Send([]Data{{"raw": "a"}}))
+
+	// 不再发送数据，空闲的文件也会被关闭并压缩
+	var compressed bool
+	for i := 0; i < 50 && !compressed; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err = os.Stat(file + ".gz")
+		compressed = err == nil
+	}
+	assert.True(t, compressed)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,355 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/protobuf"
+
+	"github.com/golang/snappy"
+	"github.com/json-iterator/go"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+)
+
+const (
+	KeyLokiSenderURL            = "loki_sender_url"
+	KeyLokiSenderLabels         = "loki_sender_labels"
+	KeyLokiSenderStaticLabels   = "loki_sender_static_labels"
+	KeyLokiSenderLineFormat     = "loki_sender_line_format"
+	KeyLokiSenderEncoding       = "loki_sender_encoding"
+	KeyLokiSenderTimestampField = "loki_sender_timestamp_field"
+	KeyLokiSenderTenantID       = "loki_sender_tenant_id"
+	KeyLokiSenderTimeout        = "loki_sender_timeout"
+	KeyLokiSenderClampTimestamp = "loki_sender_clamp_timestamp"
+)
+
+const (
+	LokiLineFormatJSON   = "json"
+	LokiLineFormatLogfmt = "logfmt"
+
+	LokiEncodingProtobuf = "protobuf"
+	LokiEncodingJSON     = "json"
+)
+
+const (
+	lokiPushPath       = "/loki/api/v1/push"
+	lokiTenantIDHeader = "X-Scope-OrgID"
+)
+
+// 超过 lokiLastTimeTTL 没有发送的 stream 不再记录最新时间，每隔 lokiExpireInterval 检查一次
+const (
+	lokiLastTimeTTL    = time.Hour
+	lokiExpireInterval = time.Minute
+)
+
+type LokiSender struct {
+	mux            sync.Mutex
+	url            string
+	labelFields    map[string]string
+	staticLabels   map[string]string
+	lineFormat     string
+	encoding       string
+	timestampField string
+	tenantID       string
+	client         *http.Client
+	runnerName     string
+	clampTime      bool
+
+	// lastTimes 记录每个 stream 已经发送成功的最新时间，仅在开启时间调整时使用
+	lastTimes  map[string]lokiLastTime
+	lastExpire time.Time
+}
+
+type lokiLastTime struct {
+	ts      time.Time
+	updated time.Time
+}
+
+type lokiEntry struct {
+	ts   time.Time
+	line string
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+// NewLokiSender 创建推送到 Loki 的 sender，url 不包含路径时使用默认的 /loki/api/v1/push
+func NewLokiSender(c conf.MapConf) (Sender, error) {
+	rawURL, err := c.GetString(KeyLokiSenderURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid loki url %v: %v", rawURL, err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = lokiPushPath
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	labels, _ := c.GetStringListOr(KeyLokiSenderLabels, []string{})
+	staticLabels, _ := c.GetStringOr(KeyLokiSenderStaticLabels, "")
+	lineFormat, _ := c.GetStringOr(KeyLokiSenderLineFormat, LokiLineFormatJSON)
+	encoding, _ := c.GetStringOr(KeyLokiSenderEncoding, LokiEncodingProtobuf)
+	timestampField, _ := c.GetStringOr(KeyLokiSenderTimestampField, "")
+	tenantID, _ := c.GetStringOr(KeyLokiSenderTenantID, "")
+	clampTime, _ := c.GetBoolOr(KeyLokiSenderClampTimestamp, false)
+	timeoutStr, _ := c.GetStringOr(KeyLokiSenderTimeout, "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %v: %v", KeyLokiSenderTimeout, timeoutStr, err)
+	}
+	if lineFormat != LokiLineFormatJSON && lineFormat != LokiLineFormatLogfmt {
+		return nil, fmt.Errorf("runner[%v] create sender error, loki line format %v is not support", runnerName, lineFormat)
+	}
+	if encoding != LokiEncodingProtobuf && encoding != LokiEncodingJSON {
+		return nil, fmt.Errorf("runner[%v] create sender error, loki encoding %v is not support", runnerName, encoding)
+	}
+
+	s := &LokiSender{
+		url:            u.String(),
+		labelFields:    make(map[string]string),
+		lineFormat:     lineFormat,
+		encoding:       encoding,
+		timestampField: timestampField,
+		tenantID:       tenantID,
+		client:         &http.Client{Timeout: timeout},
+		runnerName:     runnerName,
+		clampTime:      clampTime,
+		lastTimes:      make(map[string]lokiLastTime),
+	}
+	for _, field := range labels {
+		if field = strings.TrimSpace(field); field != "" {
+			s.labelFields[field] = labelName(field)
+		}
+	}
+	if s.staticLabels, err = parseStaticLabels(KeyLokiSenderStaticLabels, staticLabels); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *LokiSender) Name() string {
+	return "lokiSender<" + s.url + ">"
+}
+
+// Send 将数据按标签分为多个 stream 一次推送，网络错误、429 及 5xx 错误返回 SendError 重试，
+// 其余 4xx 错误（如日志乱序被拒绝）重试也无法成功，记录错误后丢弃
+func (s *LokiSender) Send(datas []Data) error {
+	if len(datas) == 0 {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	s.expireLastTimes(now)
+	streams := s.buildStreams(datas, now)
+	keys := make([]string, 0, len(streams))
+	for key := range streams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var body []byte
+	var contentType string
+	var err error
+	if s.encoding == LokiEncodingJSON {
+		body, err = s.encodeJSON(streams, keys)
+		contentType = ApplicationJson
+	} else {
+		body = snappy.Encode(nil, s.encodeProtobuf(streams, keys))
+		contentType = "application/x-protobuf"
+	}
+	if err != nil {
+		return err
+	}
+
+	ss := &StatsError{}
+	statusCode, err := s.push(body, contentType)
+	if err != nil && (statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500) {
+		ss.Errors = int64(len(datas))
+		ss.ErrorDetail = reqerr.NewSendError(fmt.Sprintf("runner[%v] sender[%v] push failure: %v", s.runnerName, s.Name(), err), ConvertDatasBack(datas), reqerr.TypeDefault)
+		return ss
+	}
+	// Loki 会接受请求中未被拒绝的日志，因此即使部分被拒绝也要更新各个 stream 的最新时间
+	if s.clampTime {
+		for _, key := range keys {
+			entries := streams[key].entries
+			s.lastTimes[key] = lokiLastTime{ts: entries[len(entries)-1].ts, updated: now}
+		}
+	}
+	if err != nil {
+		log.Errorf("Runner[%v] Sender[%v] push rejected and will not retry: %v", s.runnerName, s.Name(), err)
+		ss.Errors = int64(len(datas))
+		return ss
+	}
+	return nil
+}
+
+func (s *LokiSender) Close() error {
+	return nil
+}
+
+// expireLastTimes 移除长时间没有发送的 stream 的最新时间，避免 stream 不断变化时占用的内存持续增长
+func (s *LokiSender) expireLastTimes(now time.Time) {
+	if now.Sub(s.lastExpire) < lokiExpireInterval {
+		return
+	}
+	s.lastExpire = now
+	for key, last := range s.lastTimes {
+		if now.Sub(last.updated) >= lokiLastTimeTTL {
+			delete(s.lastTimes, key)
+		}
+	}
+}
+
+// buildStreams 按标签对数据分组，Loki 会拒绝同一个 stream 中早于已写入的最新时间的日志，
+// 因此组内按时间排序，开启时间调整时将早于上次发送的最新时间的日志调整为该时间
+func (s *LokiSender) buildStreams(datas []Data, now time.Time) map[string]*lokiStream {
+	streams := make(map[string]*lokiStream)
+	for _, d := range datas {
+		labels := make(map[string]string, len(s.staticLabels)+len(s.labelFields))
+		for name, value := range s.staticLabels {
+			labels[name] = value
+		}
+		line := make(Data, len(d))
+		for k, v := range d {
+			name, ok := s.labelFields[k]
+			if !ok {
+				line[k] = v
+				continue
+			}
+			if value := fileFieldString(v); value != "" {
+				labels[name] = value
+			}
+		}
+		if len(labels) == 0 {
+			// Loki 不接受没有标签的 stream
+			labels["job"] = "logkit"
+		}
+		key := lokiLabelString(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{labels: labels}
+			streams[key] = stream
+		}
+		stream.entries = append(stream.entries, lokiEntry{ts: dataTime(d, s.timestampField, now), line: s.line(line)})
+	}
+	for key, stream := range streams {
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].ts.Before(stream.entries[j].ts)
+		})
+		last, ok := s.lastTimes[key]
+		if !s.clampTime || !ok {
+			continue
+		}
+		for i := range stream.entries {
+			if stream.entries[i].ts.Before(last.ts) {
+				stream.entries[i].ts = last.ts
+			}
+		}
+	}
+	return streams
+}
+
+func (s *LokiSender) line(d Data) string {
+	if s.lineFormat == LokiLineFormatLogfmt {
+		return logfmtLine(d)
+	}
+	b, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(d)
+	if err != nil {
+		return fmt.Sprint(d)
+	}
+	return string(b)
+}
+
+// encodeProtobuf 编码 logproto.PushRequest，结构为
+// PushRequest{streams=1: StreamAdapter{labels=1, entries=2: EntryAdapter{timestamp=1: Timestamp{seconds=1, nanos=2}, line=2}}}
+func (s *LokiSender) encodeProtobuf(streams map[string]*lokiStream, keys []string) []byte {
+	var req protobuf.Buffer
+	for _, key := range keys {
+		var stream protobuf.Buffer
+		stream.String(1, key)
+		for _, entry := range streams[key].entries {
+			var ts protobuf.Buffer
+			ts.Varint(1, uint64(entry.ts.Unix()))
+			ts.Varint(2, uint64(entry.ts.Nanosecond()))
+			var e protobuf.Buffer
+			e.Bytes(1, ts)
+			e.String(2, entry.line)
+			stream.Bytes(2, e)
+		}
+		req.Bytes(1, stream)
+	}
+	return req
+}
+
+func (s *LokiSender) encodeJSON(streams map[string]*lokiStream, keys []string) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	req := struct {
+		Streams []jsonStream `json:"streams"`
+	}{Streams: make([]jsonStream, 0, len(keys))}
+	for _, key := range keys {
+		stream := jsonStream{Stream: streams[key].labels}
+		for _, entry := range streams[key].entries {
+			stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.ts.UnixNano(), 10), entry.line})
+		}
+		req.Streams = append(req.Streams, stream)
+	}
+	return jsoniter.Marshal(req)
+}
+
+// push 发送请求，返回的状态码为0表示请求没有得到响应
+func (s *LokiSender) push(body []byte, contentType string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(ContentTypeHeader, contentType)
+	if s.tenantID != "" {
+		req.Header.Set(lokiTenantIDHeader, s.tenantID)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, nil
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, fmt.Errorf("response code is %v, response body is %v", resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
+
+// lokiLabelString 返回按标签名排序的 {name="value", ...} 形式的字符串，同时作为 stream 的唯一标识
+func lokiLabelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+strconv.Quote(labels[name]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
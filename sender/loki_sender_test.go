@@ -0,0 +1,242 @@
+package sender
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/protobuf"
+
+	"github.com/golang/snappy"
+	"github.com/json-iterator/go"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// protoField 测试中解码出的 protobuf 字段，varint 和定长类型保存在 num 中，length-delimited 类型保存在 bytes 中
+type protoField struct {
+	field int
+	num   uint64
+	bytes []byte
+}
+
+func decodeProto(t *testing.T, b []byte) []protoField {
+	var fields []protoField
+	err := protobuf.Walk(b, func(num int, v uint64, data []byte) error {
+		fields = append(fields, protoField{field: num, num: v, bytes: data})
+		return nil
+	})
+	require.NoError(t, err)
+	return fields
+}
+
+type lokiTestEntry struct {
+	ts   time.Time
+	line string
+}
+
+// decodeLokiPush 解码 snappy 压缩的 PushRequest，返回每个 stream 的标签及日志
+func decodeLokiPush(t *testing.T, body []byte) map[string][]lokiTestEntry {
+	raw, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+	streams := make(map[string][]lokiTestEntry)
+	for _, s := range decodeProto(t, raw) {
+		require.Equal(t, 1, s.field)
+		var labels string
+		var entries []lokiTestEntry
+		for _, f := range decodeProto(t, s.bytes) {
+			switch f.field {
+			case 1:
+				labels = string(f.bytes)
+			case 2:
+				var entry lokiTestEntry
+				for _, ef := range decodeProto(t, f.bytes) {
+					if ef.field == 2 {
+						entry.line = string(ef.bytes)
+						continue
+					}
+					var sec, nsec uint64
+					for _, tf := range decodeProto(t, ef.bytes) {
+						if tf.field == 1 {
+							sec = tf.num
+						} else {
+							nsec = tf.num
+						}
+					}
+					entry.ts = time.Unix(int64(sec), int64(nsec)).UTC()
+				}
+				entries = append(entries, entry)
+			}
+		}
+		streams[labels] = entries
+	}
+	return streams
+}
+
+type fakeLoki struct {
+	mux      sync.Mutex
+	status   int
+	headers  []http.Header
+	bodies   [][]byte
+	response string
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if r.URL.Path != "/loki/api/v1/push" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	f.headers = append(f.headers, r.Header)
+	f.bodies = append(f.bodies, body)
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.response))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestLokiSenderProtobuf(t *testing.T) {
+	fake := &fakeLoki{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s, err := NewLokiSender(conf.MapConf{
+		KeyLokiSenderURL:            srv.URL,
+		KeyLokiSenderLabels:         "k8s_namespace,host.name",
+		KeyLokiSenderStaticLabels:   "job=logkit",
+		KeyLokiSenderTimestampField: "time",
+		KeyLokiSenderTenantID:       "team-a",
+		KeyLokiSenderClampTimestamp: "true",
+	})
+	require.NoError(t, err)
+	t1 := time.Date(2018, 1, 2, 3, 4, 5, 6, time.UTC)
+	t2 := t1.Add(time.Second)
+	assert.NoError(t, s.Send([]Data{
+		{"k8s_namespace": "prod", "host.name": "h1", "msg": "second", "time": t2.Format(time.RFC3339Nano)},
+		{"k8s_namespace": "prod", "host.name": "h1", "msg": "first", "time": t1.Format(time.RFC3339Nano)},
+		{"k8s_namespace": "dev", "msg": "dev", "time": t1.Format(time.RFC3339Nano)},
+	}))
+	require.Len(t, fake.bodies, 1)
+	assert.Equal(t, "application/x-protobuf", fake.headers[0].Get("Content-Type"))
+	assert.Equal(t, "team-a", fake.headers[0].Get("X-Scope-OrgID"))
+	assert.Equal(t, map[string][]lokiTestEntry{
+		`{host_name="h1", job="logkit", k8s_namespace="prod"}`: {
+			{ts: t1, line: `{"msg":"first","time":"2018-01-02T03:04:05.000000006Z"}`},
+			{ts: t2, line: `{"msg":"second","time":"2018-01-02T03:04:06.000000006Z"}`},
+		},
+		`{job="logkit", k8s_namespace="dev"}`: {
+			{ts: t1, line: `{"msg":"dev","time":"2018-01-02T03:04:05.000000006Z"}`},
+		},
+	}, decodeLokiPush(t, fake.bodies[0]))
+
+	// 早于已发送的最新时间的日志调整为该时间，避免被 Loki 作为乱序日志拒绝
+	assert.NoError(t, s.Send([]Data{{"k8s_namespace": "prod", "host.name": "h1", "msg": "late", "time": t1.Format(time.RFC3339Nano)}}))
+	require.Len(t, fake.bodies, 2)
+	assert.Equal(t, map[string][]lokiTestEntry{
+		`{host_name="h1", job="logkit", k8s_namespace="prod"}`: {
+			{ts: t2, line: `{"msg":"late","time":"2018-01-02T03:04:05.000000006Z"}`},
+		},
+	}, decodeLokiPush(t, fake.bodies[1]))
+
+	// 长时间没有发送的 stream 不再记录最新时间
+	ls := s.(*LokiSender)
+	assert.Len(t, ls.lastTimes, 2)
+	ls.expireLastTimes(time.Now().Add(lokiLastTimeTTL))
+	assert.Empty(t, ls.lastTimes)
+}
+
+func TestLokiSenderNoClamp(t *testing.T) {
+	fake := &fakeLoki{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s, err := NewLokiSender(conf.MapConf{
+		KeyLokiSenderURL:            srv.URL,
+		KeyLokiSenderTimestampField: "time",
+	})
+	require.NoError(t, err)
+	t1 := time.Date(2018, 1, 2, 3, 4, 5, 6, time.UTC)
+	t2 := t1.Add(time.Second)
+	assert.NoError(t, s.Send([]Data{{"msg": "second", "time": t2.Format(time.RFC3339Nano)}}))
+	// 默认不调整时间，乱序的日志使用原始时间发送
+	assert.NoError(t, s.Send([]Data{{"msg": "late", "time": t1.Format(time.RFC3339Nano)}}))
+	require.Len(t, fake.bodies, 2)
+	assert.Equal(t, map[string][]lokiTestEntry{
+		`{job="logkit"}`: {
+			{ts: t1, line: `{"msg":"late","time":"2018-01-02T03:04:05.000000006Z"}`},
+		},
+	}, decodeLokiPush(t, fake.bodies[1]))
+	assert.Empty(t, s.(*LokiSender).lastTimes)
+}
+
+func TestLokiSenderJSON(t *testing.T) {
+	fake := &fakeLoki{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s, err := NewLokiSender(conf.MapConf{
+		KeyLokiSenderURL:        srv.URL + "/loki/api/v1/push",
+		KeyLokiSenderLabels:     "hostname",
+		KeyLokiSenderLineFormat: LokiLineFormatLogfmt,
+		KeyLokiSenderEncoding:   LokiEncodingJSON,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"hostname": "h1", "level": "info", "msg": "hello world"}, {"msg": "no label"}}))
+	require.Len(t, fake.bodies, 1)
+	assert.Equal(t, ApplicationJson, fake.headers[0].Get("Content-Type"))
+	assert.Empty(t, fake.headers[0].Get("X-Scope-OrgID"))
+
+	var req struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	require.NoError(t, jsoniter.Unmarshal(fake.bodies[0], &req))
+	require.Len(t, req.Streams, 2)
+	assert.Equal(t, map[string]string{"hostname": "h1"}, req.Streams[0].Stream)
+	require.Len(t, req.Streams[0].Values, 1)
+	assert.Equal(t, `level=info msg="hello world"`, req.Streams[0].Values[0][1])
+	assert.Equal(t, map[string]string{"job": "logkit"}, req.Streams[1].Stream)
+	assert.Equal(t, `msg="no label"`, req.Streams[1].Values[0][1])
+
+	_, err = NewLokiSender(conf.MapConf{KeyLokiSenderURL: srv.URL, KeyLokiSenderEncoding: "xml"})
+	assert.Error(t, err)
+	_, err = NewLokiSender(conf.MapConf{KeyLokiSenderURL: srv.URL, KeyLokiSenderStaticLabels: "job"})
+	assert.Error(t, err)
+}
+
+func TestLokiSenderError(t *testing.T) {
+	fake := &fakeLoki{status: http.StatusServiceUnavailable, response: "ingester unavailable"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s, err := NewLokiSender(conf.MapConf{KeyLokiSenderURL: srv.URL})
+	require.NoError(t, err)
+
+	// 服务端错误返回 SendError，交给 ft sender 重试
+	datas := []Data{{"msg": "a"}, {"msg": "b"}}
+	err = s.Send(datas)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	sendErr, ok := se.ErrorDetail.(*reqerr.SendError)
+	require.True(t, ok)
+	assert.Equal(t, ConvertDatasBack(datas), sendErr.GetFailDatas())
+
+	// 乱序被拒绝时重试也无法成功，不再返回需要重试的数据
+	fake.mux.Lock()
+	fake.status, fake.response = http.StatusBadRequest, "entry out of order"
+	fake.mux.Unlock()
+	err = s.Send(datas)
+	se, ok = err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Nil(t, se.ErrorDetail)
+}
@@ -0,0 +1,186 @@
+package sender
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const promMetricNameLabel = "__name__"
+
+// promConverter 将 metric runner 采集的数据转换为 Prometheus 时间序列，每个数值字段作为一个指标，配置的 tag 字段作为标签
+type promConverter struct {
+	tags           map[string]string // key为tag的列名,value为标签名
+	fields         map[string]string // key为field的列名，value为指标名，为空时使用所有数值字段
+	staticLabels   map[string]string
+	metricPrefix   string
+	timestampField string
+}
+
+type promSample struct {
+	value float64
+	ts    int64
+}
+
+type promSeries struct {
+	key     string
+	labels  [][2]string
+	samples []promSample
+}
+
+func newPromConverter(c conf.MapConf, tagsKey, fieldsKey, staticLabelsKey, metricPrefixKey, timestampKey string) (*promConverter, error) {
+	tags, _ := c.GetAliasMapOr(tagsKey, make(map[string]string))
+	fields, _ := c.GetAliasMapOr(fieldsKey, make(map[string]string))
+	staticLabelStr, _ := c.GetStringOr(staticLabelsKey, "")
+	metricPrefix, _ := c.GetStringOr(metricPrefixKey, "")
+	// metric runner 会通过 KeyPandoraTSDBTimeStamp 指定时间字段
+	defaultTimestamp, _ := c.GetStringOr(KeyPandoraTSDBTimeStamp, "")
+	timestampField, _ := c.GetStringOr(timestampKey, defaultTimestamp)
+	staticLabels, err := parseStaticLabels(staticLabelsKey, staticLabelStr)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range tags {
+		tags[k] = labelName(v)
+	}
+	for k, v := range fields {
+		fields[k] = metricName(v)
+	}
+	return &promConverter{
+		tags:           tags,
+		fields:         fields,
+		staticLabels:   staticLabels,
+		metricPrefix:   metricName(metricPrefix),
+		timestampField: timestampField,
+	}, nil
+}
+
+// buildSeries 每条数据中的每个数值字段生成一个样本，标签相同的样本合并为一个时间序列并按时间排序
+func (p *promConverter) buildSeries(datas []Data, now time.Time) []*promSeries {
+	seriesMap := make(map[string]*promSeries)
+	var keys []string
+	for _, d := range datas {
+		ts := dataTime(d, p.timestampField, now).UnixNano() / int64(time.Millisecond)
+		labelMap := make(map[string]string, len(p.staticLabels)+len(p.tags))
+		for name, value := range p.staticLabels {
+			labelMap[name] = value
+		}
+		for field, name := range p.tags {
+			if v, ok := d[field]; ok {
+				if value := fileFieldString(v); value != "" {
+					labelMap[name] = value
+				}
+			}
+		}
+		for field, v := range d {
+			if field == p.timestampField {
+				continue
+			}
+			if _, ok := p.tags[field]; ok {
+				continue
+			}
+			name := metricName(field)
+			if len(p.fields) > 0 {
+				alias, ok := p.fields[field]
+				if !ok {
+					continue
+				}
+				name = alias
+			}
+			value, ok := sampleValue(v)
+			if !ok {
+				continue
+			}
+			labelMap[promMetricNameLabel] = p.metricPrefix + name
+			seriesLabels, key := sortedLabels(labelMap)
+			series, exist := seriesMap[key]
+			if !exist {
+				series = &promSeries{key: key, labels: seriesLabels}
+				seriesMap[key] = series
+				keys = append(keys, key)
+			}
+			series.samples = append(series.samples, promSample{value: value, ts: ts})
+		}
+	}
+	sort.Strings(keys)
+	ret := make([]*promSeries, 0, len(keys))
+	for _, key := range keys {
+		series := seriesMap[key]
+		sort.SliceStable(series.samples, func(i, j int) bool {
+			return series.samples[i].ts < series.samples[j].ts
+		})
+		ret = append(ret, series)
+	}
+	return ret
+}
+
+// sampleValue 将数值类型转换为样本值，bool 类型转换为 0 或 1，其余类型不作为指标发送
+func sampleValue(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int8:
+		return float64(value), true
+	case int16:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint:
+		return float64(value), true
+	case uint8:
+		return float64(value), true
+	case uint16:
+		return float64(value), true
+	case uint32:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	case json.Number:
+		f, err := strconv.ParseFloat(string(value), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// sortedLabels 返回按标签名排序的标签以及唯一标识时间序列的字符串
+func sortedLabels(labelMap map[string]string) ([][2]string, string) {
+	names := make([]string, 0, len(labelMap))
+	for name := range labelMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make([][2]string, 0, len(names))
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, [2]string{name, labelMap[name]})
+		parts = append(parts, name+"\xff"+labelMap[name])
+	}
+	return labels, strings.Join(parts, "\xff")
+}
+
+// metricName 指标名只能包含字母、数字、下划线和冒号，且不能以数字开头
+func metricName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,275 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	KeyPromExporterListen       = "prometheus_exporter_listen"
+	KeyPromExporterPath         = "prometheus_exporter_path"
+	KeyPromExporterTags         = "prometheus_exporter_tags"
+	KeyPromExporterFields       = "prometheus_exporter_fields"
+	KeyPromExporterStaticLabels = "prometheus_exporter_static_labels"
+	KeyPromExporterMetricPrefix = "prometheus_exporter_metric_prefix"
+	KeyPromExporterTimestamp    = "prometheus_exporter_timestamp"
+	KeyPromExporterExpire       = "prometheus_exporter_expire"
+)
+
+const promExpositionContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PromExporterSender 在内存中保存每个时间序列最新的值，通过 HTTP 接口以 Prometheus 文本格式暴露，
+// 超过 expire 没有更新的时间序列不再暴露
+type PromExporterSender struct {
+	*promConverter
+	mux        sync.Mutex
+	listen     string
+	path       string
+	expire     time.Duration
+	gauges     map[string]*promGauge
+	server     *promExporterServer
+	runnerName string
+}
+
+type promGauge struct {
+	labels  [][2]string
+	value   float64
+	updated time.Time
+}
+
+// NewPromExporterSender 创建 Prometheus exporter sender，监听地址相同的 sender 共用一个 HTTP 服务
+func NewPromExporterSender(c conf.MapConf) (Sender, error) {
+	listen, _ := c.GetStringOr(KeyPromExporterListen, ":9201")
+	path, _ := c.GetStringOr(KeyPromExporterPath, "/metrics")
+	expireStr, _ := c.GetStringOr(KeyPromExporterExpire, "5m")
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	expire, err := time.ParseDuration(expireStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %v: %v", KeyPromExporterExpire, expireStr, err)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	converter, err := newPromConverter(c, KeyPromExporterTags, KeyPromExporterFields, KeyPromExporterStaticLabels, KeyPromExporterMetricPrefix, KeyPromExporterTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	s := &PromExporterSender{
+		promConverter: converter,
+		listen:        listen,
+		path:          path,
+		expire:        expire,
+		gauges:        make(map[string]*promGauge),
+		runnerName:    runnerName,
+	}
+	if s.server, err = registerPromExporter(s); err != nil {
+		return nil, fmt.Errorf("runner[%v] create sender error, %v", runnerName, err)
+	}
+	return s, nil
+}
+
+func (s *PromExporterSender) Name() string {
+	return "promExporterSender<" + s.listen + s.path + ">"
+}
+
+// Send 更新每个时间序列的值，一批数据中同一个时间序列有多个样本时取时间最新的
+func (s *PromExporterSender) Send(datas []Data) error {
+	now := time.Now()
+	series := s.buildSeries(datas, now)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, ss := range series {
+		s.gauges[ss.key] = &promGauge{labels: ss.labels, value: ss.samples[len(ss.samples)-1].value, updated: now}
+	}
+	s.expireGauges(now)
+	return nil
+}
+
+func (s *PromExporterSender) Close() error {
+	unregisterPromExporter(s)
+	return nil
+}
+
+func (s *PromExporterSender) expireGauges(now time.Time) {
+	for key, gauge := range s.gauges {
+		if now.Sub(gauge.updated) > s.expire {
+			delete(s.gauges, key)
+		}
+	}
+}
+
+// collect 将未过期的时间序列加入 gauges 中
+func (s *PromExporterSender) collect(gauges map[string]*promGauge) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.expireGauges(time.Now())
+	for key, gauge := range s.gauges {
+		gauges[key] = gauge
+	}
+}
+
+// promExporterServer 监听一个地址，按照请求路径返回对应 sender 的指标
+type promExporterServer struct {
+	mux        sync.RWMutex
+	addrs      []string
+	listener   net.Listener
+	httpServer *http.Server
+	senders    map[string][]*PromExporterSender
+}
+
+var (
+	promExportersMux sync.Mutex
+	// promExporters 以实际监听的地址以及配置的监听地址为 key，监听地址相同的 sender 共用同一个服务
+	promExporters = make(map[string]*promExporterServer)
+)
+
+func registerPromExporter(s *PromExporterSender) (*promExporterServer, error) {
+	promExportersMux.Lock()
+	defer promExportersMux.Unlock()
+	server, ok := promExporters[s.listen]
+	if !ok {
+		ln, err := net.Listen("tcp", s.listen)
+		if err != nil {
+			return nil, fmt.Errorf("listen prometheus exporter address %v error %v", s.listen, err)
+		}
+		server = &promExporterServer{
+			addrs:    []string{ln.Addr().String()},
+			listener: ln,
+			senders:  make(map[string][]*PromExporterSender),
+		}
+		// 使用随机端口时其他 sender 只能通过实际的地址共用
+		if _, port, _ := net.SplitHostPort(s.listen); port != "0" && s.listen != server.addrs[0] {
+			server.addrs = append(server.addrs, s.listen)
+		}
+		for _, addr := range server.addrs {
+			promExporters[addr] = server
+		}
+		server.httpServer = &http.Server{Handler: server}
+		go func() {
+			if err := server.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Errorf("prometheus exporter %v stopped: %v", ln.Addr(), err)
+			}
+		}()
+		log.Infof("prometheus exporter listening on %v", ln.Addr())
+	}
+	server.mux.Lock()
+	server.senders[s.path] = append(server.senders[s.path], s)
+	server.mux.Unlock()
+	return server, nil
+}
+
+// unregisterPromExporter 移除 sender，没有 sender 使用时关闭监听
+func unregisterPromExporter(s *PromExporterSender) {
+	promExportersMux.Lock()
+	defer promExportersMux.Unlock()
+	server := s.server
+	server.mux.Lock()
+	senders := server.senders[s.path]
+	for i, sender := range senders {
+		if sender == s {
+			senders = append(senders[:i:i], senders[i+1:]...)
+			break
+		}
+	}
+	if len(senders) > 0 {
+		server.senders[s.path] = senders
+	} else {
+		delete(server.senders, s.path)
+	}
+	empty := len(server.senders) == 0
+	server.mux.Unlock()
+	if !empty {
+		return
+	}
+	for _, addr := range server.addrs {
+		if promExporters[addr] == server {
+			delete(promExporters, addr)
+		}
+	}
+	server.httpServer.Close()
+}
+
+func (p *promExporterServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.mux.RLock()
+	senders := p.senders[r.URL.Path]
+	p.mux.RUnlock()
+	if len(senders) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	gauges := make(map[string]*promGauge)
+	for _, s := range senders {
+		s.collect(gauges)
+	}
+	w.Header().Set(ContentTypeHeader, promExpositionContentType)
+	w.Write(promExposition(gauges))
+}
+
+var promLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promExposition 按照 Prometheus 文本格式输出，同名的指标放在一起并按标签排序
+func promExposition(gauges map[string]*promGauge) []byte {
+	type line struct {
+		name, labels string
+		value        float64
+	}
+	lines := make([]line, 0, len(gauges))
+	for _, gauge := range gauges {
+		var l line
+		var labels []string
+		for _, label := range gauge.labels {
+			if label[0] == promMetricNameLabel {
+				l.name = label[1]
+				continue
+			}
+			labels = append(labels, label[0]+`="`+promLabelValueEscaper.Replace(label[1])+`"`)
+		}
+		if len(labels) > 0 {
+			l.labels = "{" + strings.Join(labels, ",") + "}"
+		}
+		l.value = gauge.value
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].name != lines[j].name {
+			return lines[i].name < lines[j].name
+		}
+		return lines[i].labels < lines[j].labels
+	})
+	var buf bytes.Buffer
+	for i, l := range lines {
+		if i == 0 || lines[i-1].name != l.name {
+			buf.WriteString("# TYPE " + l.name + " gauge\n")
+		}
+		buf.WriteString(l.name + l.labels + " " + promValue(l.value) + "\n")
+	}
+	return buf.Bytes()
+}
+
+func promValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
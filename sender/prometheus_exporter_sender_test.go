@@ -0,0 +1,78 @@
+package sender
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestPromExporterSender(t *testing.T) {
+	s1, err := NewPromExporterSender(conf.MapConf{
+		KeyPromExporterListen:       "127.0.0.1:0",
+		KeyPromExporterTags:         "hostname,disk_path path",
+		KeyPromExporterMetricPrefix: "logkit_",
+		KeyPandoraTSDBTimeStamp:     "timestamp",
+	})
+	require.NoError(t, err)
+	addr := s1.(*PromExporterSender).server.listener.Addr().String()
+	url := "http://" + addr + "/metrics"
+
+	t1 := time.Now().Add(-time.Minute)
+	assert.NoError(t, s1.Send([]Data{
+		{"timestamp": t1.Add(time.Second).Format(time.RFC3339Nano), "hostname": "h1", "mem__used_percent": 50.5},
+		{"timestamp": t1.Format(time.RFC3339Nano), "hostname": "h1", "mem__used_percent": 40},
+		{"timestamp": t1.Format(time.RFC3339Nano), "hostname": "h\"1", "disk_path": "/data", "disk_free": int64(1024)},
+		{"timestamp": t1.Format(time.RFC3339Nano), "hostname": "h1", "disk_path": "/", "disk_free": int64(2048), "disk_fstype": "ext4"},
+	}))
+	code, body := scrape(t, url)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `# TYPE logkit_disk_free gauge
+logkit_disk_free{hostname="h1",path="/"} 2048
+logkit_disk_free{hostname="h\"1",path="/data"} 1024
+# TYPE logkit_mem__used_percent gauge
+logkit_mem__used_percent{hostname="h1"} 50.5
+`, body)
+
+	// 相同监听地址的 sender 共用服务，可以使用不同的路径，过期的指标不再暴露
+	s2, err := NewPromExporterSender(conf.MapConf{
+		KeyPromExporterListen:       addr,
+		KeyPromExporterPath:         "runner2",
+		KeyPromExporterStaticLabels: "job=logkit",
+		KeyPromExporterExpire:       "50ms",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, s2.Send([]Data{{"cpu__usage_user": 1.5}}))
+	code, body = scrape(t, "http://"+addr+"/runner2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "# TYPE cpu__usage_user gauge\ncpu__usage_user{job=\"logkit\"} 1.5\n", body)
+	time.Sleep(100 * time.Millisecond)
+	_, body = scrape(t, "http://"+addr+"/runner2")
+	assert.Equal(t, "", body)
+
+	code, _ = scrape(t, "http://"+addr+"/other")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// 所有 sender 关闭后停止监听
+	assert.NoError(t, s1.Close())
+	code, _ = scrape(t, url)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.NoError(t, s2.Close())
+	_, err = http.Get(url)
+	assert.Error(t, err)
+}
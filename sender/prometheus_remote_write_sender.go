@@ -0,0 +1,183 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/protobuf"
+	"github.com/qiniu/logkit/utils/tlsconfig"
+
+	"github.com/golang/snappy"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+)
+
+const (
+	KeyPromRemoteWriteURL           = "prometheus_remote_write_url"
+	KeyPromRemoteWriteTags          = "prometheus_remote_write_tags"
+	KeyPromRemoteWriteFields        = "prometheus_remote_write_fields"
+	KeyPromRemoteWriteStaticLabels  = "prometheus_remote_write_static_labels"
+	KeyPromRemoteWriteMetricPrefix  = "prometheus_remote_write_metric_prefix"
+	KeyPromRemoteWriteTimestamp     = "prometheus_remote_write_timestamp"
+	KeyPromRemoteWriteUsername      = "prometheus_remote_write_username"
+	KeyPromRemoteWritePassword      = "prometheus_remote_write_password"
+	KeyPromRemoteWriteBearerToken   = "prometheus_remote_write_bearer_token"
+	KeyPromRemoteWriteTimeout       = "prometheus_remote_write_timeout"
+	KeyPromRemoteWriteTLSCA         = "prometheus_remote_write_tls_ca"
+	KeyPromRemoteWriteTLSSkipVerify = "prometheus_remote_write_tls_insecure_skip_verify"
+)
+
+const (
+	promRemoteWriteVersion       = "0.1.0"
+	promRemoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+)
+
+// PromRemoteWriteSender 将 metric runner 采集的数据转换为 Prometheus remote write 请求发送
+type PromRemoteWriteSender struct {
+	*promConverter
+	url         string
+	username    string
+	password    string
+	bearerToken string
+	client      *http.Client
+	runnerName  string
+}
+
+// NewPromRemoteWriteSender 创建 Prometheus remote write sender
+func NewPromRemoteWriteSender(c conf.MapConf) (Sender, error) {
+	url, err := c.GetString(KeyPromRemoteWriteURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	username, _ := c.GetStringOr(KeyPromRemoteWriteUsername, "")
+	password, _ := c.GetStringOr(KeyPromRemoteWritePassword, "")
+	bearerToken, _ := c.GetStringOr(KeyPromRemoteWriteBearerToken, "")
+	timeoutStr, _ := c.GetStringOr(KeyPromRemoteWriteTimeout, "30s")
+	caFile, _ := c.GetStringOr(KeyPromRemoteWriteTLSCA, "")
+	skipVerify, _ := c.GetBoolOr(KeyPromRemoteWriteTLSSkipVerify, false)
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %v: %v", KeyPromRemoteWriteTimeout, timeoutStr, err)
+	}
+	if username != "" && bearerToken != "" {
+		return nil, fmt.Errorf("runner[%v] create sender error, basic auth and bearer token can not be both set", runnerName)
+	}
+	converter, err := newPromConverter(c, KeyPromRemoteWriteTags, KeyPromRemoteWriteFields, KeyPromRemoteWriteStaticLabels, KeyPromRemoteWriteMetricPrefix, KeyPromRemoteWriteTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := tlsconfig.NewClientConfig("", "", caFile, skipVerify)
+	if err != nil {
+		return nil, err
+	}
+	// 与 http.DefaultTransport 的配置一致，只替换 TLS 配置
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	return &PromRemoteWriteSender{
+		promConverter: converter,
+		url:           url,
+		username:      username,
+		password:      password,
+		bearerToken:   bearerToken,
+		client:        &http.Client{Timeout: timeout, Transport: transport},
+		runnerName:    runnerName,
+	}, nil
+}
+
+func (s *PromRemoteWriteSender) Name() string {
+	return "promRemoteWriteSender<" + s.url + ">"
+}
+
+// Send 网络错误、429 及 5xx 错误返回 SendError 重试，其余 4xx 错误（如样本乱序被拒绝）重试也无法成功，记录错误后丢弃
+func (s *PromRemoteWriteSender) Send(datas []Data) error {
+	series := s.buildSeries(datas, time.Now())
+	if len(series) == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+	ss := &StatsError{}
+	statusCode, err := s.write(body)
+	if err == nil {
+		return nil
+	}
+	ss.Errors = int64(len(datas))
+	if statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		ss.ErrorDetail = reqerr.NewSendError(fmt.Sprintf("runner[%v] sender[%v] remote write failure: %v", s.runnerName, s.Name(), err), ConvertDatasBack(datas), reqerr.TypeDefault)
+		return ss
+	}
+	log.Errorf("Runner[%v] Sender[%v] remote write rejected and will not retry: %v", s.runnerName, s.Name(), err)
+	return ss
+}
+
+func (s *PromRemoteWriteSender) Close() error {
+	return nil
+}
+
+func (s *PromRemoteWriteSender) write(body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(ContentTypeHeader, "application/x-protobuf")
+	req.Header.Set(ContentEncodingHeader, "snappy")
+	req.Header.Set(promRemoteWriteVersionHeader, promRemoteWriteVersion)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	} else if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, nil
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, fmt.Errorf("response code is %v, response body is %v", resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
+
+// encodeWriteRequest 编码 prompb.WriteRequest，结构为
+// WriteRequest{timeseries=1: TimeSeries{labels=1: Label{name=1, value=2}, samples=2: Sample{value=1, timestamp=2}}}
+func encodeWriteRequest(series []*promSeries) []byte {
+	var req protobuf.Buffer
+	for _, s := range series {
+		var ts protobuf.Buffer
+		for _, label := range s.labels {
+			var l protobuf.Buffer
+			l.String(1, label[0])
+			l.String(2, label[1])
+			ts.Bytes(1, l)
+		}
+		for _, sample := range s.samples {
+			var sp protobuf.Buffer
+			sp.Double(1, sample.value)
+			sp.Varint(2, uint64(sample.ts))
+			ts.Bytes(2, sp)
+		}
+		req.Bytes(1, ts)
+	}
+	return req
+}
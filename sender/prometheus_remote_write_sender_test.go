@@ -0,0 +1,157 @@
+package sender
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/golang/snappy"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeWriteRequest 解码 WriteRequest，返回 "name{k=v,...}" 形式的时间序列及其 [value, timestamp] 样本
+func decodeWriteRequest(t *testing.T, raw []byte) map[string][][2]float64 {
+	series := make(map[string][][2]float64)
+	for _, ts := range decodeProto(t, raw) {
+		require.Equal(t, 1, ts.field)
+		var name string
+		var labels []string
+		var samples [][2]float64
+		for _, f := range decodeProto(t, ts.bytes) {
+			if f.field == 1 {
+				var l [2]string
+				for _, lf := range decodeProto(t, f.bytes) {
+					l[lf.field-1] = string(lf.bytes)
+				}
+				if l[0] == "__name__" {
+					name = l[1]
+				} else {
+					labels = append(labels, l[0]+"="+l[1])
+				}
+				continue
+			}
+			var sample [2]float64
+			for _, sf := range decodeProto(t, f.bytes) {
+				if sf.field == 1 {
+					sample[0] = math.Float64frombits(sf.num)
+				} else {
+					sample[1] = float64(int64(sf.num))
+				}
+			}
+			samples = append(samples, sample)
+		}
+		assert.True(t, sort.StringsAreSorted(labels))
+		series[name+"{"+strings.Join(labels, ",")+"}"] = samples
+	}
+	return series
+}
+
+func TestPromRemoteWriteSender(t *testing.T) {
+	var bodies [][]byte
+	var headers []http.Header
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		headers = append(headers, r.Header)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s, err := NewPromRemoteWriteSender(conf.MapConf{
+		KeyPromRemoteWriteURL:          srv.URL + "/api/v1/write",
+		KeyPromRemoteWriteTags:         "hostname,disk_path path",
+		KeyPromRemoteWriteStaticLabels: "job=logkit",
+		KeyPromRemoteWriteMetricPrefix: "logkit_",
+		KeyPromRemoteWriteUsername:     "user",
+		KeyPromRemoteWritePassword:     "pass",
+		KeyPandoraTSDBTimeStamp:        "timestamp",
+	})
+	require.NoError(t, err)
+	t1 := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	t2 := t1.Add(10 * time.Second)
+	ms := func(t time.Time) float64 {
+		return float64(t.UnixNano() / int64(time.Millisecond))
+	}
+	assert.NoError(t, s.Send([]Data{
+		{"timestamp": t2.Format(time.RFC3339Nano), "hostname": "h1", "mem__used_percent": 50.5},
+		{"timestamp": t1.Format(time.RFC3339Nano), "hostname": "h1", "mem__used_percent": 40, "mem__swap": "ignored"},
+		{"timestamp": t1.Format(time.RFC3339Nano), "hostname": "h1", "disk_path": "/data", "disk.free": int64(1024), "disk_ok": true},
+	}))
+	require.Len(t, bodies, 1)
+	assert.Equal(t, "application/x-protobuf", headers[0].Get("Content-Type"))
+	assert.Equal(t, "snappy", headers[0].Get("Content-Encoding"))
+	assert.Equal(t, "0.1.0", headers[0].Get("X-Prometheus-Remote-Write-Version"))
+	user, pass, ok := (&http.Request{Header: headers[0]}).BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+	raw, err := snappy.Decode(nil, bodies[0])
+	require.NoError(t, err)
+	assert.Equal(t, map[string][][2]float64{
+		"logkit_mem__used_percent{hostname=h1,job=logkit}":    {{40, ms(t1)}, {50.5, ms(t2)}},
+		"logkit_disk_free{hostname=h1,job=logkit,path=/data}": {{1024, ms(t1)}},
+		"logkit_disk_ok{hostname=h1,job=logkit,path=/data}":   {{1, ms(t1)}},
+	}, decodeWriteRequest(t, raw))
+
+	// 只发送配置的字段，使用 bearer token 认证
+	s, err = NewPromRemoteWriteSender(conf.MapConf{
+		KeyPromRemoteWriteURL:         srv.URL,
+		KeyPromRemoteWriteFields:      "cpu__usage_user cpu_user",
+		KeyPromRemoteWriteBearerToken: "token",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"cpu__usage_user": 1.5, "cpu__usage_idle": 98.5}}))
+	require.Len(t, bodies, 2)
+	assert.Equal(t, "Bearer token", headers[1].Get("Authorization"))
+	raw, err = snappy.Decode(nil, bodies[1])
+	require.NoError(t, err)
+	series := decodeWriteRequest(t, raw)
+	require.Len(t, series, 1)
+	require.Len(t, series["cpu_user{}"], 1)
+	assert.Equal(t, 1.5, series["cpu_user{}"][0][0])
+
+	// 没有数值字段时不发送请求
+	assert.NoError(t, s.Send([]Data{{"msg": "no metric"}}))
+	assert.Len(t, bodies, 2)
+
+	// 服务端错误返回 SendError 重试，请求被拒绝时丢弃
+	datas := []Data{{"cpu__usage_user": 2}}
+	status = http.StatusInternalServerError
+	err = s.Send(datas)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	sendErr, ok := se.ErrorDetail.(*reqerr.SendError)
+	require.True(t, ok)
+	assert.Equal(t, ConvertDatasBack(datas), sendErr.GetFailDatas())
+	status = http.StatusBadRequest
+	err = s.Send(datas)
+	se, ok = err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Nil(t, se.ErrorDetail)
+
+	_, err = NewPromRemoteWriteSender(conf.MapConf{
+		KeyPromRemoteWriteURL:         srv.URL,
+		KeyPromRemoteWriteUsername:    "user",
+		KeyPromRemoteWriteBearerToken: "token",
+	})
+	assert.Error(t, err)
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "disk_free", metricName("disk.free"))
+	assert.Equal(t, "_xx:rate", metricName("1xx:rate"))
+	assert.Equal(t, "_xx", labelName("1xx"))
+	assert.Equal(t, "a_b_c", labelName("a-b:c"))
+}
@@ -14,6 +14,10 @@ var ModeUsages = []KeyValue{
 	{TypeElastic, "发送到 Elasticsearch 服务"},
 	{TypeKafka, "发送到 Kafka 服务"},
 	{TypeHttp, "通过 Http Post 发送"},
+	{TypeSyslogSender, "发送到 Syslog 服务"},
+	{TypeLoki, "发送到 Loki 服务"},
+	{TypePromRemoteWrite, "通过 Prometheus remote write 协议发送指标"},
+	{TypePromExporter, "通过 HTTP 接口供 Prometheus 抓取指标"},
 }
 
 var (
@@ -98,7 +102,94 @@ var ModeKeyOptions = map[string][]Option{
 			Placeholder:  "/home/john/mylogs/my-%Y-%m-%d.log",
 			DefaultNoUse: true,
 			Description:  "发送到指定文件(file_send_path)",
-			ToolTip:      `路径支持魔法变量，例如 "file_send_path":"data-%Y-%m-%d.txt" ，此时数据就会渲染出日期，存放为 data-2018-03-28.txt`,
+			ToolTip:      `路径支持时间魔法变量以及 %{字段名} 形式的字段，例如 "file_send_path":"/archive/%{service}/%Y%m%d.log" ，此时数据会按 service 字段和日期存放为 /archive/web/20180328.log，字段不存在时为 unknown`,
+		},
+		{
+			KeyName:       KeyFileSenderFormat,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{FileFormatJSON, FileFormatCSV, FileFormatRaw, FileFormatLogfmt},
+			Default:       FileFormatJSON,
+			DefaultNoUse:  false,
+			Description:   "输出格式(file_send_format)",
+			ToolTip:       "json 为每行一条 json 数据，csv 按指定字段输出，raw 只输出指定的字段，logfmt 按 key=value 输出",
+		},
+		{
+			KeyName:      KeyFileSenderRawField,
+			ChooseOnly:   false,
+			Default:      "raw",
+			DefaultNoUse: false,
+			Description:  "原始内容字段(file_send_raw_field)",
+			Advance:      true,
+			ToolTip:      "输出格式为 raw 时写入的字段，不包含该字段的数据会被忽略",
+		},
+		{
+			KeyName:      KeyFileSenderCSVFields,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "time,level,message",
+			DefaultNoUse: true,
+			Description:  "csv 字段(file_send_csv_fields)",
+			Advance:      true,
+			ToolTip:      "输出格式为 csv 时按顺序输出的字段，以逗号分隔",
+		},
+		{
+			KeyName:      KeyFileSenderCSVSplit,
+			ChooseOnly:   false,
+			Default:      ",",
+			DefaultNoUse: false,
+			Description:  "csv 分隔符(file_send_csv_split)",
+			Advance:      true,
+			ToolTip:      "输出格式为 csv 时的分隔符，只能为一个字符",
+		},
+		{
+			KeyName:       KeyFileSenderCSVHead,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "csv 表头(file_send_csv_head)",
+			Advance:       true,
+			ToolTip:       "输出格式为 csv 时，是否在新文件的第一行写入表头",
+		},
+		{
+			KeyName:      KeyFileSenderRotateSize,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			CheckRegex:   "\\d+",
+			Description:  "按大小切分(file_send_rotate_size)",
+			Advance:      true,
+			ToolTip:      "文件超过该大小后切分，单位MB，切分后的文件重命名为 文件名.1、文件名.2 等，不填则不按大小切分",
+		},
+		{
+			KeyName:      KeyFileSenderRotateInterval,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			CheckRegex:   "\\d+[hms]",
+			Description:  "按时间切分(file_send_rotate_interval)",
+			Advance:      true,
+			ToolTip:      "文件打开超过该时间后切分，如 1h，不填则不按时间切分；按日期等切分可以直接使用路径中的时间魔法变量",
+		},
+		{
+			KeyName:       KeyFileSenderCompress,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{FileCompressNone, FileCompressGzip, FileCompressZstd},
+			Default:       FileCompressNone,
+			DefaultNoUse:  false,
+			Description:   "压缩方式(file_send_compress)",
+			Advance:       true,
+			ToolTip:       "文件关闭或切分后压缩为 .gz 或 .zst 文件",
+		},
+		{
+			KeyName:      KeyFileSenderIdleTimeout,
+			ChooseOnly:   false,
+			Default:      "5m",
+			DefaultNoUse: false,
+			CheckRegex:   "\\d+[hms]",
+			Description:  "空闲关闭时间(file_send_idle_timeout)",
+			Advance:      true,
+			ToolTip:      "文件超过该时间没有写入时关闭，如按日期切分的文件在日期变化后关闭并压缩",
 		},
 	},
 	TypePandora: {
@@ -761,4 +852,458 @@ var ModeKeyOptions = map[string][]Option{
 		OptionFtMemoryChannel,
 		OptionFtMemoryChannelSize,
 	},
+	TypeSyslogSender: {
+		{
+			KeyName:      KeySyslogSenderHost,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "127.0.0.1:514",
+			DefaultNoUse: true,
+			Required:     true,
+			Description:  "syslog服务地址(syslog_sender_host)",
+		},
+		{
+			KeyName:       KeySyslogSenderProtocol,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{SyslogProtocolUDP, SyslogProtocolTCP, SyslogProtocolTLS},
+			Default:       SyslogProtocolUDP,
+			DefaultNoUse:  false,
+			Description:   "传输协议(syslog_sender_protocol)",
+		},
+		{
+			KeyName:       KeySyslogSenderRFC,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{SyslogRFC5424, SyslogRFC3164},
+			Default:       SyslogRFC5424,
+			DefaultNoUse:  false,
+			Description:   "消息格式(syslog_sender_rfc)",
+		},
+		{
+			KeyName:       KeySyslogSenderFraming,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{SyslogFramingOctetCounting, SyslogFramingNewline},
+			Default:       SyslogFramingOctetCounting,
+			DefaultNoUse:  false,
+			Description:   "TCP/TLS分帧方式(syslog_sender_framing)",
+			Advance:       true,
+			ToolTip:       "octet_counting 在每条消息前加上消息长度，newline 以换行分隔消息，UDP 不分帧",
+		},
+		{
+			KeyName:      KeySyslogSenderFacility,
+			ChooseOnly:   false,
+			Default:      "user",
+			DefaultNoUse: false,
+			Description:  "默认facility(syslog_sender_facility)",
+			Advance:      true,
+			ToolTip:      "支持数字或名称，如 user、local0",
+		},
+		{
+			KeyName:      KeySyslogSenderSeverity,
+			ChooseOnly:   false,
+			Default:      "info",
+			DefaultNoUse: false,
+			Description:  "默认severity(syslog_sender_severity)",
+			Advance:      true,
+			ToolTip:      "支持数字或名称，如 info、warning",
+		},
+		{
+			KeyName:      KeySyslogSenderHostname,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "默认hostname(syslog_sender_hostname)",
+			Advance:      true,
+			ToolTip:      "为空时使用本机的主机名",
+		},
+		{
+			KeyName:      KeySyslogSenderAppName,
+			ChooseOnly:   false,
+			Default:      "logkit",
+			DefaultNoUse: false,
+			Description:  "默认app-name(syslog_sender_app_name)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderFacilityField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "facility字段(syslog_sender_facility_field)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderSeverityField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "severity字段(syslog_sender_severity_field)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderHostnameField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "hostname字段(syslog_sender_hostname_field)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderAppNameField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "app-name字段(syslog_sender_app_name_field)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderMsgIDField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "msgid字段(syslog_sender_msgid_field)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderStructuredDataField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "structured-data字段(syslog_sender_structured_data_field)",
+			Advance:      true,
+			ToolTip:      "字段值可以是格式化好的结构化数据字符串，或者 {SD-ID: {参数名: 参数值}} 形式的 map",
+		},
+		{
+			KeyName:      KeySyslogSenderTimestampField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "时间字段(syslog_sender_timestamp_field)",
+			Advance:      true,
+			ToolTip:      "字段值为时间类型或 RFC3339 格式的字符串，为空时使用发送时间",
+		},
+		{
+			KeyName:      KeySyslogSenderMessageField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "消息字段(syslog_sender_message_field)",
+			Advance:      true,
+			ToolTip:      "为空或者数据中不存在该字段时发送整条数据的 json",
+		},
+		{
+			KeyName:      KeySyslogSenderTimeout,
+			ChooseOnly:   false,
+			Default:      "10s",
+			DefaultNoUse: false,
+			Description:  "连接及写入超时时间(syslog_sender_timeout)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderTLSCA,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "TLS CA证书路径(syslog_sender_tls_ca)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderTLSCert,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "TLS 客户端证书路径(syslog_sender_tls_cert)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeySyslogSenderTLSKey,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "TLS 客户端私钥路径(syslog_sender_tls_key)",
+			Advance:      true,
+		},
+		{
+			KeyName:       KeySyslogSenderTLSInsecureSkipVerify,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "跳过服务端证书校验(syslog_sender_tls_insecure_skip_verify)",
+			Advance:       true,
+		},
+		OptionSaveLogPath,
+		OptionFtWriteLimit,
+		OptionFtStrategy,
+		OptionFtProcs,
+		OptionFtMemoryChannel,
+		OptionFtMemoryChannelSize,
+	},
+	TypeLoki: {
+		{
+			KeyName:      KeyLokiSenderURL,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "http://127.0.0.1:3100",
+			DefaultNoUse: true,
+			Required:     true,
+			Description:  "Loki服务地址(loki_sender_url)",
+			ToolTip:      "不包含路径时推送到 /loki/api/v1/push",
+		},
+		{
+			KeyName:      KeyLokiSenderLabels,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "k8s_namespace,hostname",
+			DefaultNoUse: false,
+			Description:  "作为标签的字段(loki_sender_labels)",
+			ToolTip:      "逗号分隔，数据按这些字段的值分为不同的 stream，其余字段作为日志内容",
+		},
+		{
+			KeyName:      KeyLokiSenderStaticLabels,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "job=logkit,env=prod",
+			DefaultNoUse: false,
+			Description:  "固定标签(loki_sender_static_labels)",
+		},
+		{
+			KeyName:       KeyLokiSenderLineFormat,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{LokiLineFormatJSON, LokiLineFormatLogfmt},
+			Default:       LokiLineFormatJSON,
+			DefaultNoUse:  false,
+			Description:   "日志内容格式(loki_sender_line_format)",
+		},
+		{
+			KeyName:       KeyLokiSenderEncoding,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{LokiEncodingProtobuf, LokiEncodingJSON},
+			Default:       LokiEncodingProtobuf,
+			DefaultNoUse:  false,
+			Description:   "推送请求编码(loki_sender_encoding)",
+			Advance:       true,
+			ToolTip:       "protobuf 为 snappy 压缩的 protobuf 格式",
+		},
+		{
+			KeyName:      KeyLokiSenderTimestampField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "时间字段(loki_sender_timestamp_field)",
+			Advance:      true,
+			ToolTip:      "字段值为时间类型或 RFC3339 格式的字符串，为空时使用发送时间",
+		},
+		{
+			KeyName:      KeyLokiSenderTenantID,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "租户ID(loki_sender_tenant_id)",
+			Advance:      true,
+			ToolTip:      "多租户模式下通过 X-Scope-OrgID 请求头指定租户",
+		},
+		{
+			KeyName:      KeyLokiSenderTimeout,
+			ChooseOnly:   false,
+			Default:      "30s",
+			DefaultNoUse: false,
+			Description:  "请求超时时间(loki_sender_timeout)",
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyLokiSenderClampTimestamp,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "调整乱序时间(loki_sender_clamp_timestamp)",
+			Advance:       true,
+			ToolTip:       "开启后同一个 stream 中早于上次发送的最新时间的日志会使用该时间发送，避免被 Loki 作为乱序日志拒绝",
+		},
+		OptionSaveLogPath,
+		OptionFtWriteLimit,
+		OptionFtStrategy,
+		OptionFtProcs,
+		OptionFtMemoryChannel,
+		OptionFtMemoryChannelSize,
+	},
+	TypePromRemoteWrite: {
+		{
+			KeyName:      KeyPromRemoteWriteURL,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "http://127.0.0.1:9090/api/v1/write",
+			DefaultNoUse: true,
+			Required:     true,
+			Description:  "remote write地址(prometheus_remote_write_url)",
+		},
+		{
+			KeyName:      KeyPromRemoteWriteTags,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "hostname,disk_path path",
+			DefaultNoUse: false,
+			Description:  "作为标签的字段(prometheus_remote_write_tags)",
+			ToolTip:      "逗号分隔，字段名后可以用空格指定标签名",
+		},
+		{
+			KeyName:      KeyPromRemoteWriteFields,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "作为指标的字段(prometheus_remote_write_fields)",
+			Advance:      true,
+			ToolTip:      "逗号分隔，字段名后可以用空格指定指标名，为空时发送所有数值字段",
+		},
+		{
+			KeyName:      KeyPromRemoteWriteStaticLabels,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "job=logkit,env=prod",
+			DefaultNoUse: false,
+			Description:  "固定标签(prometheus_remote_write_static_labels)",
+		},
+		{
+			KeyName:      KeyPromRemoteWriteMetricPrefix,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "指标名前缀(prometheus_remote_write_metric_prefix)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyPromRemoteWriteTimestamp,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "时间字段(prometheus_remote_write_timestamp)",
+			Advance:      true,
+			ToolTip:      "字段值为时间类型或 RFC3339 格式的字符串，metric runner 中默认使用 timestamp 字段，为空时使用发送时间",
+		},
+		{
+			KeyName:      KeyPromRemoteWriteUsername,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "basic auth 用户名(prometheus_remote_write_username)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyPromRemoteWritePassword,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "basic auth 密码(prometheus_remote_write_password)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyPromRemoteWriteBearerToken,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "bearer token(prometheus_remote_write_bearer_token)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyPromRemoteWriteTimeout,
+			ChooseOnly:   false,
+			Default:      "30s",
+			DefaultNoUse: false,
+			Description:  "请求超时时间(prometheus_remote_write_timeout)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyPromRemoteWriteTLSCA,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "TLS CA证书路径(prometheus_remote_write_tls_ca)",
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyPromRemoteWriteTLSSkipVerify,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "跳过服务端证书校验(prometheus_remote_write_tls_insecure_skip_verify)",
+			Advance:       true,
+		},
+		OptionSaveLogPath,
+		OptionFtWriteLimit,
+		OptionFtStrategy,
+		OptionFtProcs,
+		OptionFtMemoryChannel,
+		OptionFtMemoryChannelSize,
+	},
+	TypePromExporter: {
+		{
+			KeyName:      KeyPromExporterListen,
+			ChooseOnly:   false,
+			Default:      ":9201",
+			DefaultNoUse: false,
+			Description:  "监听地址(prometheus_exporter_listen)",
+			ToolTip:      "监听地址相同的 sender 共用一个 HTTP 服务",
+		},
+		{
+			KeyName:      KeyPromExporterPath,
+			ChooseOnly:   false,
+			Default:      "/metrics",
+			DefaultNoUse: false,
+			Description:  "抓取路径(prometheus_exporter_path)",
+		},
+		{
+			KeyName:      KeyPromExporterTags,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "hostname,disk_path path",
+			DefaultNoUse: false,
+			Description:  "作为标签的字段(prometheus_exporter_tags)",
+			ToolTip:      "逗号分隔，字段名后可以用空格指定标签名",
+		},
+		{
+			KeyName:      KeyPromExporterFields,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "作为指标的字段(prometheus_exporter_fields)",
+			Advance:      true,
+			ToolTip:      "逗号分隔，字段名后可以用空格指定指标名，为空时暴露所有数值字段",
+		},
+		{
+			KeyName:      KeyPromExporterStaticLabels,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "env=prod",
+			DefaultNoUse: false,
+			Description:  "固定标签(prometheus_exporter_static_labels)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyPromExporterMetricPrefix,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "指标名前缀(prometheus_exporter_metric_prefix)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyPromExporterTimestamp,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "时间字段(prometheus_exporter_timestamp)",
+			Advance:      true,
+			ToolTip:      "同一批数据中一个指标有多个值时取该字段时间最新的值，metric runner 中默认使用 timestamp 字段",
+		},
+		{
+			KeyName:      KeyPromExporterExpire,
+			ChooseOnly:   false,
+			Default:      "5m",
+			DefaultNoUse: false,
+			Description:  "指标过期时间(prometheus_exporter_expire)",
+			Advance:      true,
+			ToolTip:      "超过该时间没有更新的指标不再暴露",
+		},
+	},
 }
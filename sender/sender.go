@@ -54,6 +54,10 @@ func NewSenderRegistry() *SenderRegistry {
 	ret.RegisterSender(TypeDiscard, NewDiscardSender)
 	ret.RegisterSender(TypeKafka, NewKafkaSender)
 	ret.RegisterSender(TypeHttp, NewHttpSender)
+	ret.RegisterSender(TypeSyslogSender, NewSyslogSender)
+	ret.RegisterSender(TypeLoki, NewLokiSender)
+	ret.RegisterSender(TypePromRemoteWrite, NewPromRemoteWriteSender)
+	ret.RegisterSender(TypePromExporter, NewPromExporterSender)
 	return ret
 }
 
@@ -0,0 +1,457 @@
+package sender
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlsconfig"
+
+	"github.com/json-iterator/go"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+)
+
+const (
+	KeySyslogSenderHost                  = "syslog_sender_host"
+	KeySyslogSenderProtocol              = "syslog_sender_protocol"
+	KeySyslogSenderFraming               = "syslog_sender_framing"
+	KeySyslogSenderRFC                   = "syslog_sender_rfc"
+	KeySyslogSenderTimeout               = "syslog_sender_timeout"
+	KeySyslogSenderFacility              = "syslog_sender_facility"
+	KeySyslogSenderSeverity              = "syslog_sender_severity"
+	KeySyslogSenderHostname              = "syslog_sender_hostname"
+	KeySyslogSenderAppName               = "syslog_sender_app_name"
+	KeySyslogSenderFacilityField         = "syslog_sender_facility_field"
+	KeySyslogSenderSeverityField         = "syslog_sender_severity_field"
+	KeySyslogSenderHostnameField         = "syslog_sender_hostname_field"
+	KeySyslogSenderAppNameField          = "syslog_sender_app_name_field"
+	KeySyslogSenderMsgIDField            = "syslog_sender_msgid_field"
+	KeySyslogSenderStructuredDataField   = "syslog_sender_structured_data_field"
+	KeySyslogSenderTimestampField        = "syslog_sender_timestamp_field"
+	KeySyslogSenderMessageField          = "syslog_sender_message_field"
+	KeySyslogSenderTLSCA                 = "syslog_sender_tls_ca"
+	KeySyslogSenderTLSCert               = "syslog_sender_tls_cert"
+	KeySyslogSenderTLSKey                = "syslog_sender_tls_key"
+	KeySyslogSenderTLSInsecureSkipVerify = "syslog_sender_tls_insecure_skip_verify"
+)
+
+const (
+	SyslogProtocolUDP = "udp"
+	SyslogProtocolTCP = "tcp"
+	SyslogProtocolTLS = "tls"
+
+	SyslogFramingOctetCounting = "octet_counting"
+	SyslogFramingNewline       = "newline"
+
+	SyslogRFC5424 = "rfc5424"
+	SyslogRFC3164 = "rfc3164"
+)
+
+const syslogNilValue = "-"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "panic": 0, "alert": 1, "crit": 2, "critical": 2, "err": 3, "error": 3,
+	"warning": 4, "warn": 4, "notice": 5, "info": 6, "informational": 6, "debug": 7,
+}
+
+type SyslogSender struct {
+	mux        sync.Mutex
+	conn       net.Conn
+	host       string
+	protocol   string
+	framing    string
+	rfc        string
+	timeout    time.Duration
+	tlsConfig  *tls.Config
+	runnerName string
+
+	facility int
+	severity int
+	hostname string
+	appName  string
+
+	facilityField       string
+	severityField       string
+	hostnameField       string
+	appNameField        string
+	msgIDField          string
+	structuredDataField string
+	timestampField      string
+	messageField        string
+}
+
+// NewSyslogSender 创建发送到 syslog 服务的 sender，连接在第一次发送时建立
+func NewSyslogSender(c conf.MapConf) (Sender, error) {
+	host, err := c.GetString(KeySyslogSenderHost)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = net.SplitHostPort(host); err != nil {
+		return nil, fmt.Errorf("invalid syslog host %v: %v", host, err)
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	protocol, _ := c.GetStringOr(KeySyslogSenderProtocol, SyslogProtocolUDP)
+	framing, _ := c.GetStringOr(KeySyslogSenderFraming, SyslogFramingOctetCounting)
+	rfc, _ := c.GetStringOr(KeySyslogSenderRFC, SyslogRFC5424)
+	timeoutStr, _ := c.GetStringOr(KeySyslogSenderTimeout, "10s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %v: %v", KeySyslogSenderTimeout, timeoutStr, err)
+	}
+	switch protocol {
+	case SyslogProtocolUDP, SyslogProtocolTCP, SyslogProtocolTLS:
+	default:
+		return nil, fmt.Errorf("runner[%v] create sender error, syslog protocol %v is not support", runnerName, protocol)
+	}
+	if framing != SyslogFramingOctetCounting && framing != SyslogFramingNewline {
+		return nil, fmt.Errorf("runner[%v] create sender error, syslog framing %v is not support", runnerName, framing)
+	}
+	if rfc != SyslogRFC5424 && rfc != SyslogRFC3164 {
+		return nil, fmt.Errorf("runner[%v] create sender error, syslog rfc %v is not support", runnerName, rfc)
+	}
+
+	facilityStr, _ := c.GetStringOr(KeySyslogSenderFacility, "user")
+	facility, ok := parseSyslogFacility(facilityStr)
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility %v", facilityStr)
+	}
+	severityStr, _ := c.GetStringOr(KeySyslogSenderSeverity, "info")
+	severity, ok := parseSyslogSeverity(severityStr)
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog severity %v", severityStr)
+	}
+	hostname, _ := c.GetStringOr(KeySyslogSenderHostname, "")
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	appName, _ := c.GetStringOr(KeySyslogSenderAppName, "logkit")
+
+	s := &SyslogSender{
+		host:       host,
+		protocol:   protocol,
+		framing:    framing,
+		rfc:        rfc,
+		timeout:    timeout,
+		runnerName: runnerName,
+		facility:   facility,
+		severity:   severity,
+		hostname:   hostname,
+		appName:    appName,
+	}
+	s.facilityField, _ = c.GetStringOr(KeySyslogSenderFacilityField, "")
+	s.severityField, _ = c.GetStringOr(KeySyslogSenderSeverityField, "")
+	s.hostnameField, _ = c.GetStringOr(KeySyslogSenderHostnameField, "")
+	s.appNameField, _ = c.GetStringOr(KeySyslogSenderAppNameField, "")
+	s.msgIDField, _ = c.GetStringOr(KeySyslogSenderMsgIDField, "")
+	s.structuredDataField, _ = c.GetStringOr(KeySyslogSenderStructuredDataField, "")
+	s.timestampField, _ = c.GetStringOr(KeySyslogSenderTimestampField, "")
+	s.messageField, _ = c.GetStringOr(KeySyslogSenderMessageField, "")
+
+	if protocol == SyslogProtocolTLS {
+		caFile, _ := c.GetStringOr(KeySyslogSenderTLSCA, "")
+		certFile, _ := c.GetStringOr(KeySyslogSenderTLSCert, "")
+		keyFile, _ := c.GetStringOr(KeySyslogSenderTLSKey, "")
+		skipVerify, _ := c.GetBoolOr(KeySyslogSenderTLSInsecureSkipVerify, false)
+		if s.tlsConfig, err = tlsconfig.NewClientConfig(certFile, keyFile, caFile, skipVerify); err != nil {
+			return nil, err
+		}
+		if !skipVerify && s.tlsConfig.ServerName == "" {
+			s.tlsConfig.ServerName, _, _ = net.SplitHostPort(host)
+		}
+	}
+	return s, nil
+}
+
+func (s *SyslogSender) Name() string {
+	return "syslogSender<" + s.protocol + "://" + s.host + ">"
+}
+
+// Send 依次发送每条数据，写入失败时使用新的连接重试一次，仍然失败则剩余的数据均作为失败数据返回
+func (s *SyslogSender) Send(datas []Data) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ss := &StatsError{}
+	var failure []Data
+	var lastErr error
+	// 每批数据只检查一次连接，逐条检查会因为读超时等待限制发送速度
+	if s.conn != nil && s.peerClosed() {
+		log.Warnf("runner[%v] sender[%v] connection closed by peer, reconnecting", s.runnerName, s.Name())
+		s.conn.Close()
+		s.conn = nil
+	}
+	for i, d := range datas {
+		if err := s.write(s.frame(s.format(d, time.Now()))); err != nil {
+			lastErr = err
+			failure = append(failure, datas[i:]...)
+			for range datas[i:] {
+				ss.AddErrors()
+			}
+			break
+		}
+		ss.AddSuccess()
+	}
+	if len(failure) > 0 {
+		ss.ErrorDetail = reqerr.NewSendError(fmt.Sprintf("runner[%v] sender[%v] write failure, last err is: %v", s.runnerName, s.Name(), lastErr), ConvertDatasBack(failure), reqerr.TypeDefault)
+		return ss
+	}
+	return nil
+}
+
+func (s *SyslogSender) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *SyslogSender) connect() (err error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	switch s.protocol {
+	case SyslogProtocolTLS:
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.host, s.tlsConfig)
+	default:
+		s.conn, err = dialer.Dial(s.protocol, s.host)
+	}
+	if err != nil {
+		s.conn = nil
+		return fmt.Errorf("connect to syslog %v error %v", s.host, err)
+	}
+	return nil
+}
+
+// peerClosed 检查流式连接是否已经被对端关闭，syslog 服务端不会发送数据，读到 EOF 说明连接已断开
+func (s *SyslogSender) peerClosed() bool {
+	if s.protocol == SyslogProtocolUDP {
+		return false
+	}
+	s.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var b [1]byte
+	_, err := s.conn.Read(b[:])
+	s.conn.SetReadDeadline(time.Time{})
+	if err == nil {
+		return false
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return false
+	}
+	return true
+}
+
+func (s *SyslogSender) write(msg []byte) error {
+	for retried := false; ; retried = true {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		n, err := s.conn.Write(msg)
+		if err == nil {
+			return nil
+		}
+		// 写入失败时连接上可能已经写入了部分消息，继续写入会破坏分帧，只能关闭连接后在新的连接上重试
+		s.conn.Close()
+		s.conn = nil
+		if retried {
+			return err
+		}
+		log.Warnf("runner[%v] sender[%v] write error %v after %v of %v bytes written, reconnecting", s.runnerName, s.Name(), err, n, len(msg))
+	}
+}
+
+// frame 按照 RFC 6587 对流式传输的消息分帧，UDP 每个报文即一条消息无需分帧
+func (s *SyslogSender) frame(msg string) []byte {
+	if s.protocol == SyslogProtocolUDP {
+		return []byte(msg)
+	}
+	if s.framing == SyslogFramingNewline {
+		// 换行分帧时消息内部的换行会被当作消息边界，替换为空格
+		return []byte(strings.Replace(msg, "\n", " ", -1) + "\n")
+	}
+	return []byte(strconv.Itoa(len(msg)) + " " + msg)
+}
+
+func (s *SyslogSender) format(d Data, now time.Time) string {
+	facility, severity := s.facility, s.severity
+	if v, ok := d[s.facilityField]; ok && s.facilityField != "" {
+		if f, ok := parseSyslogFacility(fmt.Sprint(v)); ok {
+			facility = f
+		}
+	}
+	if v, ok := d[s.severityField]; ok && s.severityField != "" {
+		if sev, ok := parseSyslogSeverity(fmt.Sprint(v)); ok {
+			severity = sev
+		}
+	}
+	pri := "<" + strconv.Itoa(facility*8+severity) + ">"
+	timestamp := dataTime(d, s.timestampField, now)
+	hostname := s.fieldOr(d, s.hostnameField, s.hostname)
+	appName := s.fieldOr(d, s.appNameField, s.appName)
+	msg := s.message(d)
+
+	if s.rfc == SyslogRFC3164 {
+		tag := syslogHeaderField(appName, 32)
+		if tag == syslogNilValue {
+			tag = "logkit"
+		}
+		return pri + timestamp.Format(time.Stamp) + " " + syslogHeaderField(hostname, 255) + " " + tag + ": " + msg
+	}
+	sd := syslogNilValue
+	if s.structuredDataField != "" {
+		if v, ok := d[s.structuredDataField]; ok {
+			sd = syslogStructuredData(v)
+		}
+	}
+	return pri + "1 " + timestamp.Format("2006-01-02T15:04:05.000000Z07:00") + " " +
+		syslogHeaderField(hostname, 255) + " " +
+		syslogHeaderField(appName, 48) + " " +
+		syslogNilValue + " " +
+		syslogHeaderField(s.fieldOr(d, s.msgIDField, ""), 32) + " " +
+		sd + " " + msg
+}
+
+func (s *SyslogSender) fieldOr(d Data, field, defaultValue string) string {
+	if field == "" {
+		return defaultValue
+	}
+	v, ok := d[field]
+	if !ok || v == nil {
+		return defaultValue
+	}
+	return fmt.Sprint(v)
+}
+
+// message 返回消息内容，未配置消息字段或者数据中不存在该字段时发送整条数据的 json
+func (s *SyslogSender) message(d Data) string {
+	if s.messageField != "" {
+		if v, ok := d[s.messageField]; ok {
+			if str, ok := v.(string); ok {
+				return str
+			}
+			if b, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(v); err == nil {
+				return string(b)
+			}
+		}
+	}
+	b, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(d)
+	if err != nil {
+		return fmt.Sprint(d)
+	}
+	return string(b)
+}
+
+func parseSyslogFacility(str string) (int, bool) {
+	if f, err := strconv.Atoi(str); err == nil {
+		return f, f >= 0 && f <= 23
+	}
+	f, ok := syslogFacilities[strings.ToLower(str)]
+	return f, ok
+}
+
+func parseSyslogSeverity(str string) (int, bool) {
+	if sev, err := strconv.Atoi(str); err == nil {
+		return sev, sev >= 0 && sev <= 7
+	}
+	sev, ok := syslogSeverities[strings.ToLower(str)]
+	return sev, ok
+}
+
+// syslogHeaderField 头部字段只能包含可打印的 ASCII 字符，超出长度的部分截断，空值使用 "-"
+func syslogHeaderField(str string, maxLen int) string {
+	b := make([]byte, 0, len(str))
+	for i := 0; i < len(str) && len(b) < maxLen; i++ {
+		if str[i] >= 33 && str[i] <= 126 {
+			b = append(b, str[i])
+		}
+	}
+	if len(b) == 0 {
+		return syslogNilValue
+	}
+	return string(b)
+}
+
+// syslogSDName SD-ID 和 PARAM-NAME 不能包含 '='、' '、']'、'"'，最长32个字符
+func syslogSDName(str string) string {
+	b := make([]byte, 0, len(str))
+	for i := 0; i < len(str) && len(b) < 32; i++ {
+		c := str[i]
+		if c >= 33 && c <= 126 && c != '=' && c != ']' && c != '"' {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogStructuredData 字符串以 '[' 开头时认为已经是格式化好的结构化数据，
+// map 类型则按 {SD-ID: {PARAM-NAME: PARAM-VALUE}} 排序后生成
+func syslogStructuredData(v interface{}) string {
+	var elements map[string]interface{}
+	switch sd := v.(type) {
+	case string:
+		if strings.HasPrefix(sd, "[") {
+			return sd
+		}
+		return syslogNilValue
+	case map[string]interface{}:
+		elements = sd
+	case Data:
+		elements = sd
+	default:
+		return syslogNilValue
+	}
+	ids := make([]string, 0, len(elements))
+	for id := range elements {
+		if syslogSDName(id) != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return syslogNilValue
+	}
+	sort.Strings(ids)
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.WriteString("[" + syslogSDName(id))
+		var params map[string]interface{}
+		switch p := elements[id].(type) {
+		case map[string]interface{}:
+			params = p
+		case Data:
+			params = p
+		}
+		names := make([]string, 0, len(params))
+		for name := range params {
+			if syslogSDName(name) != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buf.WriteString(" " + syslogSDName(name) + `="` + sdValueEscaper.Replace(fmt.Sprint(params[name])) + `"`)
+		}
+		buf.WriteString("]")
+	}
+	return buf.String()
+}
@@ -0,0 +1,316 @@
+package sender
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/utils/models"
+
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogSenderFormat(t *testing.T) {
+	s, err := NewSyslogSender(conf.MapConf{
+		KeySyslogSenderHost:                "127.0.0.1:514",
+		KeySyslogSenderHostname:            "host1",
+		KeySyslogSenderFacility:            "local0",
+		KeySyslogSenderSeverityField:       "level",
+		KeySyslogSenderAppNameField:        "app",
+		KeySyslogSenderMsgIDField:          "msgid",
+		KeySyslogSenderStructuredDataField: "sd",
+		KeySyslogSenderTimestampField:      "time",
+		KeySyslogSenderMessageField:        "msg",
+	})
+	require.NoError(t, err)
+	sender := s.(*SyslogSender)
+	now := time.Date(2018, 3, 4, 5, 6, 7, 8000, time.UTC)
+	assert.Equal(t, `<134>1 2018-03-04T05:06:07.000008Z host1 logkit - - - hello`, sender.format(Data{"msg": "hello"}, now))
+	assert.Equal(t, `<132>1 2018-01-02T03:04:05.000000Z host1 myapp - ID47 [a@1 x="1"][origin ip="10.0.0.1" note="\"q\" \\ \]"] hi`,
+		sender.format(Data{
+			"msg":   "hi",
+			"level": "warning",
+			"app":   "my app",
+			"msgid": "ID47",
+			"time":  "2018-01-02T03:04:05Z",
+			"sd": map[string]interface{}{
+				"origin": map[string]interface{}{"ip": "10.0.0.1", "note": `"q" \ ]`},
+				"a@1":    map[string]interface{}{"x": 1},
+			},
+		}, now))
+	assert.Equal(t, `<131>1 2018-03-04T05:06:07.000008Z host1 logkit - - [raw@1 k="v"] {"level":3,"sd":"[raw@1 k=\"v\"]"}`,
+		sender.format(Data{"level": 3, "sd": `[raw@1 k="v"]`}, now))
+
+	sender.rfc = SyslogRFC3164
+	assert.Equal(t, `<134>Mar  4 05:06:07 host1 logkit: hello`, sender.format(Data{"msg": "hello"}, now))
+
+	for _, c := range []conf.MapConf{
+		{},
+		{KeySyslogSenderHost: "127.0.0.1"},
+		{KeySyslogSenderHost: "127.0.0.1:514", KeySyslogSenderProtocol: "http"},
+		{KeySyslogSenderHost: "127.0.0.1:514", KeySyslogSenderFraming: "none"},
+		{KeySyslogSenderHost: "127.0.0.1:514", KeySyslogSenderRFC: "rfc1"},
+		{KeySyslogSenderHost: "127.0.0.1:514", KeySyslogSenderFacility: "24"},
+		{KeySyslogSenderHost: "127.0.0.1:514", KeySyslogSenderSeverity: "fatal"},
+	} {
+		_, err = NewSyslogSender(c)
+		assert.Error(t, err, c)
+	}
+}
+
+func TestSyslogSenderUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	s, err := NewSyslogSender(conf.MapConf{
+		KeySyslogSenderHost:         pc.LocalAddr().String(),
+		KeySyslogSenderRFC:          SyslogRFC3164,
+		KeySyslogSenderHostname:     "host1",
+		KeySyslogSenderMessageField: "msg",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Send([]Data{{"msg": "m1"}, {"msg": "m2"}}))
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, expect := range []string{"m1", "m2"} {
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(buf[:n]), " host1 logkit: "+expect), string(buf[:n]))
+	}
+}
+
+// readOctetCounting 读取一条按 octet counting 分帧的消息
+func readOctetCounting(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+func TestSyslogSenderTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	s, err := NewSyslogSender(conf.MapConf{
+		KeySyslogSenderHost:         ln.Addr().String(),
+		KeySyslogSenderProtocol:     SyslogProtocolTCP,
+		KeySyslogSenderMessageField: "msg",
+		KeySyslogSenderTimeout:      "1s",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Send([]Data{{"msg": "a\nb"}, {"msg": "c"}}))
+	conn := <-conns
+	r := bufio.NewReader(conn)
+	for _, expect := range []string{"a\nb", "c"} {
+		msg, err := readOctetCounting(r)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(msg, " - - - "+expect), msg)
+	}
+
+	// 服务端关闭连接后重新连接发送
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, s.Send([]Data{{"msg": "d"}}))
+	conn = <-conns
+	defer conn.Close()
+	msg, err := readOctetCounting(bufio.NewReader(conn))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(msg, " d"), msg)
+
+	// 服务不可用时返回包含失败数据的 SendError，供 ft sender 重试
+	ln.Close()
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	err = s.Send([]Data{{"msg": "e"}, {"msg": "f"}})
+	require.Error(t, err)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	sendErr, ok := se.ErrorDetail.(*reqerr.SendError)
+	require.True(t, ok)
+	assert.Equal(t, []map[string]interface{}{{"msg": "e"}, {"msg": "f"}}, sendErr.GetFailDatas())
+}
+
+func TestSyslogSenderTCPBatch(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	const total = 2000
+	received := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		n := 0
+		for ; n < total; n++ {
+			if _, err := readOctetCounting(r); err != nil {
+				break
+			}
+		}
+		received <- n
+	}()
+
+	s, err := NewSyslogSender(conf.MapConf{
+		KeySyslogSenderHost:         ln.Addr().String(),
+		KeySyslogSenderProtocol:     SyslogProtocolTCP,
+		KeySyslogSenderMessageField: "msg",
+		KeySyslogSenderTimeout:      "1s",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	datas := make([]Data, total)
+	for i := range datas {
+		datas[i] = Data{"msg": strconv.Itoa(i)}
+	}
+	assert.NoError(t, s.Send(datas[:1]))
+	// 每批数据只检查一次连接是否断开，发送速度不受检查时读超时的限制
+	start := time.Now()
+	assert.NoError(t, s.Send(datas[1:]))
+	assert.True(t, time.Since(start) < time.Second, time.Since(start).String())
+	assert.Equal(t, total, <-received)
+}
+
+func TestSyslogSenderTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	require.NoError(t, err)
+	defer ln.Close()
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	s, err := NewSyslogSender(conf.MapConf{
+		KeySyslogSenderHost:                  ln.Addr().String(),
+		KeySyslogSenderProtocol:              SyslogProtocolTLS,
+		KeySyslogSenderFraming:               SyslogFramingNewline,
+		KeySyslogSenderMessageField:          "msg",
+		KeySyslogSenderTLSInsecureSkipVerify: "true",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Send([]Data{{"msg": "a\nb"}, {"msg": "c"}}))
+	for _, expect := range []string{"a b", "c"} {
+		select {
+		case line := <-lines:
+			assert.True(t, strings.HasSuffix(line, " - - - "+expect), line)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for syslog message")
+		}
+	}
+}
+
+// partialConn 模拟只写入了部分数据就失败的连接
+type partialConn struct {
+	net.Conn
+	written []byte
+	closed  bool
+}
+
+func (c *partialConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, b[:len(b)/2]...)
+	return len(b) / 2, errors.New("broken pipe")
+}
+
+func (c *partialConn) Read(b []byte) (int, error) {
+	return 0, &net.OpError{Op: "read", Err: timeoutError{}}
+}
+
+func (c *partialConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *partialConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *partialConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestSyslogSenderPartialWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	s, err := NewSyslogSender(conf.MapConf{
+		KeySyslogSenderHost:         ln.Addr().String(),
+		KeySyslogSenderProtocol:     SyslogProtocolTCP,
+		KeySyslogSenderMessageField: "msg",
+		KeySyslogSenderTimeout:      "1s",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	broken := &partialConn{}
+	s.(*SyslogSender).conn = broken
+	assert.NoError(t, s.Send([]Data{{"msg": "hello"}}))
+	// 部分写入后关闭原连接，完整的消息在新的连接上重新发送
+	assert.True(t, broken.closed)
+	assert.NotEmpty(t, broken.written)
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	msg, err := readOctetCounting(bufio.NewReader(conn))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(msg, " hello"), msg)
+}
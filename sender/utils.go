@@ -0,0 +1,52 @@
+package sender
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// dataTime 返回数据中时间字段的值，支持时间类型和 RFC3339 格式的字符串，未配置或者无法解析时返回 now
+func dataTime(d Data, field string, now time.Time) time.Time {
+	if field == "" {
+		return now
+	}
+	switch v := d[field].(type) {
+	case time.Time:
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+	return now
+}
+
+// labelName 将字段名转换为 Loki 和 Prometheus 的标签名，只能包含字母、数字和下划线，且不能以数字开头
+func labelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// parseStaticLabels 解析 job=logkit,env=prod 形式的固定标签
+func parseStaticLabels(key, str string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(str, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid %v %v, should be like job=logkit,env=prod", key, str)
+		}
+		labels[labelName(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
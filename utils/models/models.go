@@ -71,6 +71,8 @@ type LagInfo struct {
 	Size     int64  `json:"size"`
 	SizeUnit string `json:"sizeunit"`
 	Ftlags   int64  `json:"ftlags"`
+	// Details 记录每个分区各自的lag，如kafka的 topic:partition
+	Details map[string]int64 `json:"details,omitempty"`
 }
 
 type StatsError struct {
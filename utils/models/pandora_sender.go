@@ -70,5 +70,10 @@ const (
 	TypeKafka             = "kafka"         // kafka
 	TypeHttp              = "http"          // http sender
 
+	TypeSyslogSender    = "syslog"                  // syslog sender
+	TypeLoki            = "loki"                    // loki
+	TypePromRemoteWrite = "prometheus_remote_write" // prometheus remote write
+	TypePromExporter    = "prometheus_exporter"     // prometheus exporter
+
 	InnerUserAgent = "_useragent"
 )
@@ -0,0 +1,100 @@
+// Package protobuf 实现 protobuf 编码格式的最小子集，供 Loki、Prometheus remote write 等请求的编解码使用，
+// 避免为几个固定的消息引入 protobuf 的代码生成
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Buffer 按照 protobuf 编码格式追加字段
+type Buffer []byte
+
+func (b *Buffer) appendVarint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *Buffer) appendTag(field int, wireType uint64) {
+	b.appendVarint(uint64(field)<<3 | wireType)
+}
+
+// Varint 写入 int32、int64、uint64 等 varint 类型的字段，值为0时省略
+func (b *Buffer) Varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.appendTag(field, 0)
+	b.appendVarint(v)
+}
+
+// Double 写入 double 类型的字段，repeated 的消息中需要保留0值
+func (b *Buffer) Double(field int, v float64) {
+	b.appendTag(field, 1)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	*b = append(*b, buf[:]...)
+}
+
+// Bytes 写入 bytes 类型或者嵌套消息的字段
+func (b *Buffer) Bytes(field int, v []byte) {
+	b.appendTag(field, 2)
+	b.appendVarint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+// String 写入 string 类型的字段
+func (b *Buffer) String(field int, v string) {
+	b.appendTag(field, 2)
+	b.appendVarint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+// Walk 遍历 protobuf 消息的字段，varint 与定长字段通过 v 返回，length-delimited 字段通过 data 返回
+func Walk(b []byte, fn func(num int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		b = b[n:]
+		num, typ := int(key>>3), key&7
+		var v uint64
+		var data []byte
+		switch typ {
+		case 0:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errors.New("invalid protobuf fixed64")
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.New("invalid protobuf length-delimited field")
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errors.New("invalid protobuf fixed32")
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %v", typ)
+		}
+		if err := fn(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
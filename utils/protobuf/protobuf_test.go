@@ -0,0 +1,95 @@
+package protobuf
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferWalk(t *testing.T) {
+	var inner Buffer
+	inner.String(1, "job")
+	inner.Varint(2, 300)
+
+	var b Buffer
+	b.Bytes(1, inner)
+	b.Double(2, 1.5)
+	b.Varint(3, 0)
+	b.Varint(4, math.MaxUint64)
+
+	type field struct {
+		num  int
+		v    uint64
+		data string
+	}
+	var got []field
+	err := Walk(b, func(num int, v uint64, data []byte) error {
+		got = append(got, field{num, v, string(data)})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []field{
+		{1, 0, string(inner)},
+		{2, math.Float64bits(1.5), ""},
+		{4, math.MaxUint64, ""},
+	}, got)
+
+	got = nil
+	err = Walk(inner, func(num int, v uint64, data []byte) error {
+		got = append(got, field{num, v, string(data)})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []field{{1, 0, "job"}, {2, 300, ""}}, got)
+}
+
+func TestWalk(t *testing.T) {
+	// {1: "job", 2: 300, 3: double 1.5, 4: fixed32 7, 5: {1: "a"}}
+	b := []byte{
+		0x0a, 0x03, 'j', 'o', 'b',
+		0x10, 0xac, 0x02,
+		0x19, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f,
+		0x25, 0x07, 0, 0, 0,
+		0x2a, 0x03, 0x0a, 0x01, 'a',
+	}
+	type field struct {
+		num  int
+		v    uint64
+		data string
+	}
+	var got []field
+	err := Walk(b, func(num int, v uint64, data []byte) error {
+		got = append(got, field{num, v, string(data)})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []field{
+		{1, 0, "job"},
+		{2, 300, ""},
+		{3, math.Float64bits(1.5), ""},
+		{4, 7, ""},
+		{5, 0, "\x0a\x01a"},
+	}, got)
+
+	stop := errors.New("stop")
+	err = Walk(b, func(num int, v uint64, data []byte) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+}
+
+func TestWalkInvalid(t *testing.T) {
+	noop := func(int, uint64, []byte) error { return nil }
+	for _, b := range [][]byte{
+		{0x0a, 0x05, 'a'},
+		{0x08, 0x80},
+		{0x09, 0x01, 0x02},
+		{0x0d, 0x01},
+		{0x0b},
+		{0x80},
+	} {
+		assert.Error(t, Walk(b, noop), "%x", b)
+	}
+}
@@ -0,0 +1,81 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// clientAuthTypes 服务端校验客户端证书的方式
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// NewClientConfig 根据证书、私钥以及CA文件构建客户端使用的 tls.Config，均为空时返回默认配置
+func NewClientConfig(certFile, keyFile, caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load x509 key pair %v %v error %v", certFile, keyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// NewServerConfig 构建服务端使用的 tls.Config，clientAuth 为空时配置了 CA 则要求并校验客户端证书
+func NewServerConfig(certFile, keyFile, caFile, clientAuth string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load x509 key pair %v %v error %v", certFile, keyFile, err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		if clientAuth == "" {
+			clientAuth = "require_and_verify"
+		}
+	}
+	if clientAuth == "" {
+		return tlsConfig, nil
+	}
+	authType, ok := clientAuthTypes[clientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unknown tls client auth type %v", clientAuth)
+	}
+	if (authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert) && tlsConfig.ClientCAs == nil {
+		return nil, fmt.Errorf("tls client auth type %v requires a ca file", clientAuth)
+	}
+	tlsConfig.ClientAuth = authType
+	return tlsConfig, nil
+}
+
+// LoadCertPool 读取 PEM 格式的 CA 文件
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	caCert, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read ca file %v error %v", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("ca file %v contains no valid certificate", caFile)
+	}
+	return pool, nil
+}
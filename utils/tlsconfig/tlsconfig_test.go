@@ -0,0 +1,61 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "logkit-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	badFile := filepath.Join(dir, "bad.crt")
+	require.NoError(t, ioutil.WriteFile(badFile, []byte("not a certificate"), 0644))
+
+	cfg, err := NewClientConfig("", "", "", true)
+	assert.NoError(t, err)
+	assert.True(t, cfg.InsecureSkipVerify)
+	assert.Nil(t, cfg.RootCAs)
+
+	cfg, err = NewClientConfig("", "", caFile, false)
+	assert.NoError(t, err)
+	assert.NotNil(t, cfg.RootCAs)
+
+	_, err = NewClientConfig("", "", badFile, false)
+	assert.Error(t, err)
+	_, err = NewClientConfig("", "", filepath.Join(dir, "missing.crt"), false)
+	assert.Error(t, err)
+	_, err = NewClientConfig(filepath.Join(dir, "missing.crt"), "", "", false)
+	assert.Error(t, err)
+	_, err = NewServerConfig(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key"), "", "")
+	assert.Error(t, err)
+}